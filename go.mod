@@ -7,6 +7,7 @@ require (
 	github.com/stretchr/testify v1.4.0
 	github.com/titan-data/titan-client-go v0.1.1
 	golang.org/x/crypto v0.0.0-20191227163750-53104e6ec876
+	gopkg.in/yaml.v2 v2.2.4
 	k8s.io/api v0.17.0
 	k8s.io/apimachinery v0.17.0
 	k8s.io/client-go v0.17.0
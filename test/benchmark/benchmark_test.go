@@ -0,0 +1,302 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package benchmark
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	titan "github.com/titan-data/titan-client-go"
+	endtoend "github.com/titan-data/titan-server/test/common"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+/*
+ * Benchmarks of core operations against a docker-zfs server, for each amount of data in TITAN_BENCH_DATA (see
+ * ParseBenchmarkData). The server is only started when benchmarks are run, such as with:
+ *
+ *	go test ./test/benchmark -run '^$' -bench . -benchtime 10x
+ *
+ * Results are written as JSON to TITAN_BENCH_OUTPUT, or within the artifacts directory if not set. If
+ * TITAN_BENCH_BASELINE names the results of an earlier run, typically against a different TITAN_IMAGE, each
+ * operation is compared against it, and the run fails if any got slower than TITAN_BENCH_THRESHOLD (0.2 by default).
+ */
+var (
+	e        *endtoend.EndToEndTest
+	report   *endtoend.BenchmarkReport
+	data     []endtoend.BenchmarkData
+	fixtures = map[string]*benchFixture{}
+	sshErr   error
+	ctx      = context.Background()
+)
+
+const defaultBenchData = "1x1M,1x256M,10000x4K"
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if flag.Lookup("test.bench").Value.String() == "" {
+		os.Exit(m.Run())
+	}
+
+	spec := os.Getenv("TITAN_BENCH_DATA")
+	if spec == "" {
+		spec = defaultBenchData
+	}
+	var err error
+	data, err = endtoend.ParseBenchmarkData(spec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	e = endtoend.NewEndToEndTest(&suite.Suite{}, "docker-zfs")
+	if image := os.Getenv("TITAN_IMAGE"); image != "" {
+		e.Image = image
+	}
+	e.SetupStandardDocker()
+	sshErr = e.StartSsh()
+	if sshErr == nil {
+		sshErr = e.WaitForSsh(ctx)
+	}
+	report = endtoend.NewBenchmarkReport(e)
+
+	code := m.Run()
+	if code == 0 && !writeReport() {
+		code = 1
+	}
+	e.TeardownStandardSsh()
+	e.TeardownStandardDocker()
+	os.Exit(code)
+}
+
+/*
+ * Write the results, comparing them against the baseline if there is one. Returns false if anything regressed.
+ */
+func writeReport() bool {
+	output := os.Getenv("TITAN_BENCH_OUTPUT")
+	if output == "" {
+		output = report.DefaultPath()
+	}
+	err := report.Write(output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write benchmark results: %v\n", err)
+		return false
+	}
+	fmt.Printf("benchmark results written to %s\n", output)
+
+	baseline := os.Getenv("TITAN_BENCH_BASELINE")
+	if baseline == "" {
+		return true
+	}
+	base, err := endtoend.LoadBenchmarkReport(baseline)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+	threshold := 0.2
+	if value, err := strconv.ParseFloat(os.Getenv("TITAN_BENCH_THRESHOLD"), 64); err == nil {
+		threshold = value
+	}
+	ok := true
+	fmt.Printf("compared to %s (%s):\n", baseline, base.Image)
+	for _, c := range endtoend.CompareBenchmarks(base, report, threshold) {
+		fmt.Printf("  %s\n", c)
+		ok = ok && !c.Regression
+	}
+	return ok
+}
+
+/*
+ * A repository with a single volume seeded with a particular amount of data, and a commit of it ("base"). It has a
+ * nop remote, and an ssh remote if the SSH server could be started. Fixtures are created the first time they are
+ * needed, and shared by every benchmark using the same amount of data.
+ */
+type benchFixture struct {
+	repo    string
+	volume  string
+	sshPath string
+	commits int
+}
+
+func fixture(b *testing.B, d endtoend.BenchmarkData) *benchFixture {
+	if f, ok := fixtures[d.String()]; ok {
+		return f
+	}
+	f := &benchFixture{repo: "bench-" + d.String(), volume: "data", sshPath: "/bench-" + d.String()}
+	b.Logf("seeding %s with %d bytes", f.repo, d.TotalSize())
+	builder := e.Fixture().Repo(f.repo).Volume(f.volume).Remote(titan.Remote{
+		Provider:   "nop",
+		Name:       "nop",
+		Properties: map[string]interface{}{},
+	})
+	if sshErr == nil {
+		builder = builder.Remote(titan.Remote{
+			Provider: "ssh",
+			Name:     "ssh",
+			Properties: map[string]interface{}{
+				"address":  e.SshHost,
+				"password": e.Ssh.Password,
+				"username": "test",
+				"port":     e.SshPort,
+				"path":     e.SshPath(f.sshPath),
+			},
+		})
+	}
+	_, err := builder.Build(ctx)
+	if err == nil && sshErr == nil {
+		err = e.MkdirSsh(f.sshPath)
+	}
+	if err == nil {
+		err = e.SeedVolume(f.repo, f.volume, d, 1)
+	}
+	if err == nil {
+		err = f.commit("base")
+	}
+	if err != nil {
+		b.Fatal(err)
+	}
+	fixtures[d.String()] = f
+	return f
+}
+
+func (f *benchFixture) nextCommit() string {
+	f.commits++
+	return fmt.Sprintf("c%d", f.commits)
+}
+
+func (f *benchFixture) commit(id string) error {
+	_, err := e.CreateCommit(ctx, f.repo, titan.Commit{
+		Id:         id,
+		Properties: map[string]interface{}{},
+	})
+	if err != nil {
+		return err
+	}
+	return e.WaitForCommit(ctx, f.repo, id)
+}
+
+func (f *benchFixture) push(remote string, id string) error {
+	op, err := e.Push(ctx, f.repo, remote, id,
+		titan.RemoteParameters{Provider: remote, Properties: map[string]interface{}{}}, nil)
+	if err != nil {
+		return err
+	}
+	_, err = e.WaitForOperation(ctx, op.Id)
+	return err
+}
+
+/*
+ * Run a benchmark for each amount of data, recording the time taken by each iteration of the operation. Setup and
+ * teardown for each iteration are excluded from both the recorded times and the standard ns/op.
+ */
+func benchmark(b *testing.B, operation string, setup func(f *benchFixture) (interface{}, error),
+	run func(f *benchFixture, arg interface{}) error, teardown func(f *benchFixture, arg interface{}) error) {
+	for _, d := range data {
+		d := d
+		b.Run(d.String(), func(b *testing.B) {
+			f := fixture(b, d)
+			timer := endtoend.BenchmarkTimer{}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				arg, err := setup(f)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				err = timer.Time(func() error {
+					return run(f, arg)
+				})
+				b.StopTimer()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err = teardown(f, arg); err != nil {
+					b.Fatal(err)
+				}
+			}
+			report.Add(endtoend.NewBenchmarkResult(operation, d, timer.Samples()))
+		})
+	}
+}
+
+func none(f *benchFixture) (interface{}, error) {
+	return nil, nil
+}
+
+func nothing(f *benchFixture, arg interface{}) error {
+	return nil
+}
+
+func deactivate(f *benchFixture) (interface{}, error) {
+	_, err := e.VolumeApi.DeactivateVolume(ctx, f.repo, f.volume)
+	return nil, err
+}
+
+func activate(f *benchFixture, arg interface{}) error {
+	_, err := e.VolumeApi.ActivateVolume(ctx, f.repo, f.volume)
+	return err
+}
+
+func BenchmarkCreateCommit(b *testing.B) {
+	benchmark(b, "CreateCommit",
+		func(f *benchFixture) (interface{}, error) {
+			return f.nextCommit(), nil
+		},
+		func(f *benchFixture, id interface{}) error {
+			return f.commit(id.(string))
+		},
+		func(f *benchFixture, id interface{}) error {
+			_, err := e.CommitApi.DeleteCommit(ctx, f.repo, id.(string))
+			return err
+		})
+}
+
+func BenchmarkCheckoutCommit(b *testing.B) {
+	benchmark(b, "CheckoutCommit", deactivate,
+		func(f *benchFixture, arg interface{}) error {
+			_, err := e.CommitApi.CheckoutCommit(ctx, f.repo, "base")
+			return err
+		}, activate)
+}
+
+func BenchmarkActivateVolume(b *testing.B) {
+	benchmark(b, "ActivateVolume", deactivate, activate, nothing)
+}
+
+func BenchmarkPushNop(b *testing.B) {
+	benchmark(b, "PushNop", none,
+		func(f *benchFixture, arg interface{}) error {
+			return f.push("nop", "base")
+		}, nothing)
+}
+
+/*
+ * Each iteration pushes a new commit, since the ssh remote refuses to overwrite one, and removes it from both sides
+ * afterwards.
+ */
+func BenchmarkPushSsh(b *testing.B) {
+	if sshErr != nil {
+		b.Skipf("SSH server not available: %v", sshErr)
+	}
+	benchmark(b, "PushSsh",
+		func(f *benchFixture) (interface{}, error) {
+			id := f.nextCommit()
+			return id, f.commit(id)
+		},
+		func(f *benchFixture, id interface{}) error {
+			return f.push("ssh", id.(string))
+		},
+		func(f *benchFixture, id interface{}) error {
+			_, err := e.CommitApi.DeleteCommit(ctx, f.repo, id.(string))
+			if err != nil {
+				return err
+			}
+			return os.RemoveAll(e.SshPath(path.Join(f.sshPath, id.(string))))
+		})
+}
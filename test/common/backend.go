@@ -0,0 +1,93 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+/*
+ * A server backend encapsulates how titan-server is run for a particular context. The shared harness only ever
+ * talks to the backend, so that a new way of running the server can be added by implementing this interface and
+ * registering it with RegisterBackend(), without touching EndToEndTest.
+ */
+type ServerBackend interface {
+	// Start the server, passing through any context-specific configuration parameters
+	Start(parameters ...string) error
+
+	// Stop the server and remove any persistent state
+	Stop(ignoreErrors bool) error
+
+	// Restart the server process, preserving any persistent state
+	Restart() error
+
+	// Execute a command within the server container and return its output
+	Exec(args ...string) (string, error)
+
+	// Wait for the server to be ready to accept API requests
+	Wait(ctx context.Context) error
+
+	// Get the logs of the primary container
+	Logs() (string, error)
+
+	// Name of the container that is responsible for running the server
+	PrimaryContainer() string
+
+	// Path within the server container where persistent data is stored
+	DataPath() string
+
+	// Path within the server container under which volumes are mounted, if any
+	MountPath() string
+
+	// Containers whose logs and inspect output are included in diagnostics
+	Containers() []string
+
+	// Commands run within the server container when collecting diagnostics, keyed by the name of their artifact
+	DiagnosticCommands() map[string][]string
+}
+
+type BackendFactory func(e *EndToEndTest) ServerBackend
+
+var backends = map[string]BackendFactory{}
+
+/*
+ * Register a new backend by context name. This is typically invoked from an init() function.
+ */
+func RegisterBackend(name string, factory BackendFactory) {
+	backends[name] = factory
+}
+
+/*
+ * Create a new instance of the backend registered for the given context.
+ */
+func NewBackend(name string, e *EndToEndTest) (ServerBackend, error) {
+	factory, ok := backends[name]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("no such server backend '%s'", name))
+	}
+	return factory(e), nil
+}
+
+/*
+ * Wait for the titan API to respond, dumping the logs of the backend if it fails to do so in time. This is the
+ * default implementation of Wait() for backends that have nothing more specific to check.
+ */
+func waitForApi(ctx context.Context, e *EndToEndTest, b ServerBackend) error {
+	ctx, cancel := withDefaultDeadline(ctx, serverWaitTimeout)
+	defer cancel()
+	err := Poll(ctx, "server to start", waitInterval, func(ctx context.Context) (bool, interface{}, error) {
+		_, _, err := e.Client.RepositoriesApi.ListRepositories(ctx)
+		return err == nil, err, nil
+	})
+	if timeout, ok := err.(*WaitTimeoutError); ok {
+		logs, logErr := b.Logs()
+		if logErr != nil {
+			return logErr
+		}
+		timeout.Details = logs
+	}
+	return err
+}
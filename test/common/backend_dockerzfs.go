@@ -0,0 +1,98 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+ * Backend for the docker-zfs context. The server is started through the "launch" entry point, which sets up the ZFS
+ * pool and then runs the server in a separate "server" container.
+ */
+type dockerZfsBackend struct {
+	e *EndToEndTest
+}
+
+func init() {
+	RegisterBackend("docker-zfs", func(e *EndToEndTest) ServerBackend {
+		return &dockerZfsBackend{e: e}
+	})
+}
+
+func (b *dockerZfsBackend) Start(parameters ...string) error {
+	err := b.e.Runtime.CreateVolume(fmt.Sprintf("%s-data", b.e.Identity))
+	if err != nil {
+		return err
+	}
+	return b.e.RunTitanDocker("launch", true)
+}
+
+/*
+ * Stop the server completely, including the launch container, and tear down the ZFS pool.
+ */
+func (b *dockerZfsBackend) Stop(ignoreErrors bool) error {
+	err := b.e.Runtime.Remove(b.e.GetContainer("launch"))
+	if err != nil && !ignoreErrors {
+		return err
+	}
+	err = b.e.Runtime.Remove(b.e.GetContainer("server"))
+	if err != nil && !ignoreErrors {
+		return err
+	}
+
+	err = b.e.RunTitanDocker("teardown", false)
+	if err != nil && !ignoreErrors {
+		return err
+	}
+
+	err = b.e.Runtime.RemoveVolume(fmt.Sprintf("%s-data", b.e.Identity))
+	if err != nil && !ignoreErrors {
+		return err
+	}
+	return nil
+}
+
+/*
+ * Restart the server. The launch container is responsible for re-creating the server container, so all we need to do
+ * is remove it.
+ */
+func (b *dockerZfsBackend) Restart() error {
+	return b.e.Runtime.Remove(b.e.GetContainer("server"))
+}
+
+func (b *dockerZfsBackend) Exec(args ...string) (string, error) {
+	return b.e.Runtime.Exec(b.e.GetContainer("server"), args...)
+}
+
+func (b *dockerZfsBackend) Wait(ctx context.Context) error {
+	return waitForApi(ctx, b.e, b)
+}
+
+func (b *dockerZfsBackend) Logs() (string, error) {
+	return b.e.Runtime.Logs(b.PrimaryContainer())
+}
+
+func (b *dockerZfsBackend) PrimaryContainer() string {
+	return b.e.GetContainer("launch")
+}
+
+func (b *dockerZfsBackend) Containers() []string {
+	return []string{b.e.GetContainer("launch"), b.e.GetContainer("server")}
+}
+
+func (b *dockerZfsBackend) DiagnosticCommands() map[string][]string {
+	return map[string][]string{
+		"zfs-list.txt": {"zfs", "list", "-t", "all", "-o", "name,used,referenced,mountpoint,origin"},
+	}
+}
+
+func (b *dockerZfsBackend) DataPath() string {
+	return fmt.Sprintf("/var/lib/%s/data", b.e.Identity)
+}
+
+func (b *dockerZfsBackend) MountPath() string {
+	return fmt.Sprintf("/var/lib/%s/mnt", b.e.Identity)
+}
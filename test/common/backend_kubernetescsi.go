@@ -0,0 +1,91 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+ * Backend for the kubernetes-csi context. The server runs directly within a single "server" container, managing
+ * storage within the current kubernetes cluster.
+ */
+type kubernetesCsiBackend struct {
+	e *EndToEndTest
+}
+
+func init() {
+	RegisterBackend("kubernetes-csi", func(e *EndToEndTest) ServerBackend {
+		return &kubernetesCsiBackend{e: e}
+	})
+}
+
+func (b *kubernetesCsiBackend) Start(parameters ...string) error {
+	err := b.e.Runtime.CreateVolume(fmt.Sprintf("%s-data", b.e.Identity))
+	if err != nil {
+		return err
+	}
+	return b.e.RunTitanKubernetes("run", parameters...)
+}
+
+func (b *kubernetesCsiBackend) Stop(ignoreErrors bool) error {
+	err := b.e.Runtime.Remove(b.e.GetContainer("server"))
+	if err != nil && !ignoreErrors {
+		return err
+	}
+
+	err = b.e.Runtime.RemoveVolume(fmt.Sprintf("%s-data", b.e.Identity))
+	if err != nil && !ignoreErrors {
+		return err
+	}
+	return nil
+}
+
+/*
+ * Restart the server. There is no launch container to re-create the server, so we restart it in place.
+ */
+func (b *kubernetesCsiBackend) Restart() error {
+	return b.e.Runtime.Restart(b.e.GetContainer("server"))
+}
+
+func (b *kubernetesCsiBackend) Exec(args ...string) (string, error) {
+	return b.e.Runtime.Exec(b.e.GetContainer("server"), args...)
+}
+
+func (b *kubernetesCsiBackend) Wait(ctx context.Context) error {
+	return waitForApi(ctx, b.e, b)
+}
+
+func (b *kubernetesCsiBackend) Logs() (string, error) {
+	return b.e.Runtime.Logs(b.PrimaryContainer())
+}
+
+func (b *kubernetesCsiBackend) PrimaryContainer() string {
+	return b.e.GetContainer("server")
+}
+
+func (b *kubernetesCsiBackend) Containers() []string {
+	return []string{b.e.GetContainer("server")}
+}
+
+/*
+ * There's no ZFS pool to list, so capture the cluster's persistent volumes and claims instead.
+ */
+func (b *kubernetesCsiBackend) DiagnosticCommands() map[string][]string {
+	return map[string][]string{
+		"kubectl-volumes.txt": {"kubectl", "get", "persistentvolumeclaims,persistentvolumes", "-o", "wide"},
+	}
+}
+
+func (b *kubernetesCsiBackend) DataPath() string {
+	return fmt.Sprintf("/var/lib/%s", b.e.Identity)
+}
+
+/*
+ * Volumes are persistent volume claims within the cluster, and are never mounted within the server.
+ */
+func (b *kubernetesCsiBackend) MountPath() string {
+	return ""
+}
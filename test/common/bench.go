@@ -0,0 +1,304 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Amount of data to seed a volume with for a benchmark: a number of files, each of the given size in bytes.
+ */
+type BenchmarkData struct {
+	Files    int   `json:"files"`
+	FileSize int64 `json:"fileSize"`
+}
+
+var benchmarkUnits = []struct {
+	suffix string
+	size   int64
+}{{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"", 1}}
+
+/*
+ * Parse a comma separated list of data sizes, each written as files x size, where the size can have a K, M, or G
+ * suffix. For example, "1x64M,1000x4K" is a single 64 MiB file followed by a thousand 4 KiB files.
+ */
+func ParseBenchmarkData(spec string) ([]BenchmarkData, error) {
+	ret := []BenchmarkData{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		parts := strings.Split(item, "x")
+		if len(parts) != 2 {
+			return nil, errors.New(fmt.Sprintf("invalid benchmark data '%s', expected files x size", item))
+		}
+		files, err := strconv.Atoi(parts[0])
+		if err != nil || files < 0 {
+			return nil, errors.New(fmt.Sprintf("invalid file count in benchmark data '%s'", item))
+		}
+		size := strings.ToUpper(parts[1])
+		multiplier := int64(1)
+		for _, unit := range benchmarkUnits {
+			if unit.suffix != "" && strings.HasSuffix(size, unit.suffix) {
+				size = strings.TrimSuffix(size, unit.suffix)
+				multiplier = unit.size
+				break
+			}
+		}
+		fileSize, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || fileSize < 0 {
+			return nil, errors.New(fmt.Sprintf("invalid file size in benchmark data '%s'", item))
+		}
+		ret = append(ret, BenchmarkData{Files: files, FileSize: fileSize * multiplier})
+	}
+	return ret, nil
+}
+
+/*
+ * Format as files x size, using the largest unit that divides the size exactly, such that it can be parsed again
+ * and used in benchmark names.
+ */
+func (d BenchmarkData) String() string {
+	for _, unit := range benchmarkUnits {
+		if d.FileSize >= unit.size && d.FileSize%unit.size == 0 {
+			return fmt.Sprintf("%dx%d%s", d.Files, d.FileSize/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dx0", d.Files)
+}
+
+func (d BenchmarkData) TotalSize() int64 {
+	return int64(d.Files) * d.FileSize
+}
+
+/*
+ * Fill the root of a volume with the given data. File contents are random, so that they don't compress, but are
+ * generated from the seed so that the same seed always produces the same data. Everything is streamed through a
+ * single tar archive, so large amounts of data never need to fit in memory.
+ */
+func (e *EndToEndTest) SeedVolume(repo string, volume string, data BenchmarkData, seed int64) error {
+	mountpoint, err := e.GetVolumePath(repo, volume)
+	if err != nil {
+		return err
+	}
+	return e.copyIn(e.GetContainer("server"), mountpoint, func(w io.Writer) error {
+		return writeBenchmarkData(w, data, seed)
+	})
+}
+
+func writeBenchmarkData(w io.Writer, data BenchmarkData, seed int64) error {
+	r := rand.New(rand.NewSource(seed))
+	tw := tar.NewWriter(w)
+	for i := 0; i < data.Files; i++ {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     fmt.Sprintf("data/file-%06d", i),
+			Size:     data.FileSize,
+			Mode:     0644,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(tw, r, data.FileSize)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+/*
+ * Records how long each iteration of an operation takes. Only the time within Time counts, so setup between
+ * iterations can be done without stopping the benchmark timer, although callers should usually stop it as well so
+ * that the standard ns/op figure agrees.
+ */
+type BenchmarkTimer struct {
+	samples []time.Duration
+}
+
+func (t *BenchmarkTimer) Time(fn func() error) error {
+	start := time.Now()
+	err := fn()
+	if err == nil {
+		t.samples = append(t.samples, time.Since(start))
+	}
+	return err
+}
+
+func (t *BenchmarkTimer) Samples() []time.Duration {
+	return t.samples
+}
+
+/*
+ * Summary of the time taken by a single operation, for a particular amount of data. Durations are in nanoseconds.
+ */
+type BenchmarkResult struct {
+	Name      string        `json:"name"`
+	Operation string        `json:"operation"`
+	Data      BenchmarkData `json:"data"`
+	Samples   int           `json:"samples"`
+	Min       time.Duration `json:"minNs"`
+	Mean      time.Duration `json:"meanNs"`
+	Median    time.Duration `json:"medianNs"`
+	P95       time.Duration `json:"p95Ns"`
+	Max       time.Duration `json:"maxNs"`
+}
+
+func NewBenchmarkResult(operation string, data BenchmarkData, samples []time.Duration) BenchmarkResult {
+	ret := BenchmarkResult{
+		Name:      fmt.Sprintf("%s/%s", operation, data),
+		Operation: operation,
+		Data:      data,
+		Samples:   len(samples),
+	}
+	if len(samples) == 0 {
+		return ret
+	}
+	sorted := append([]time.Duration{}, samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	total := time.Duration(0)
+	for _, s := range sorted {
+		total += s
+	}
+	ret.Min = sorted[0]
+	ret.Max = sorted[len(sorted)-1]
+	ret.Mean = total / time.Duration(len(sorted))
+	ret.Median = percentile(sorted, 50)
+	ret.P95 = percentile(sorted, 95)
+	return ret
+}
+
+/*
+ * Nearest rank percentile of sorted samples.
+ */
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+/*
+ * Results of a benchmark run, along with the server image they were run against, so that runs against different
+ * image tags can be compared.
+ */
+type BenchmarkReport struct {
+	Image   string            `json:"image"`
+	Context string            `json:"context"`
+	Started time.Time         `json:"started"`
+	Results []BenchmarkResult `json:"results"`
+}
+
+func NewBenchmarkReport(e *EndToEndTest) *BenchmarkReport {
+	return &BenchmarkReport{Image: e.Image, Context: e.Context, Started: time.Now().UTC(), Results: []BenchmarkResult{}}
+}
+
+/*
+ * Add a result, replacing any previous result with the same name. Go runs each benchmark more than once while it
+ * works out how many iterations to use, and only the last, longest, run should be kept.
+ */
+func (r *BenchmarkReport) Add(result BenchmarkResult) {
+	for i := range r.Results {
+		if r.Results[i].Name == result.Name {
+			r.Results[i] = result
+			return
+		}
+	}
+	r.Results = append(r.Results, result)
+}
+
+func (r *BenchmarkReport) Write(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(content, '\n'), 0644)
+}
+
+func LoadBenchmarkReport(path string) (*BenchmarkReport, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := BenchmarkReport{}
+	err = json.Unmarshal(content, &report)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+	return &report, nil
+}
+
+/*
+ * Default location of a benchmark report: within the artifacts directory, named after the image and start time.
+ */
+func (r *BenchmarkReport) DefaultPath() string {
+	image := strings.NewReplacer("/", "_", ":", "_").Replace(r.Image)
+	return filepath.Join(ArtifactsDir(), "benchmarks",
+		fmt.Sprintf("%s-%s.json", image, r.Started.Format("20060102T150405Z")))
+}
+
+/*
+ * Change in the median time of an operation between two reports. Change is the relative difference, so 0.1 means
+ * the operation got 10% slower.
+ */
+type BenchmarkComparison struct {
+	Name       string
+	Base       time.Duration
+	Current    time.Duration
+	Change     float64
+	Regression bool
+}
+
+func (c BenchmarkComparison) String() string {
+	ret := fmt.Sprintf("%s: %v -> %v (%+.1f%%)", c.Name, c.Base, c.Current, c.Change*100)
+	if c.Regression {
+		ret += " REGRESSION"
+	}
+	return ret
+}
+
+/*
+ * Compare the median times of every result present in both reports, flagging those that got slower by more than
+ * the given fraction.
+ */
+func CompareBenchmarks(base *BenchmarkReport, current *BenchmarkReport, threshold float64) []BenchmarkComparison {
+	baseResults := map[string]BenchmarkResult{}
+	for _, res := range base.Results {
+		baseResults[res.Name] = res
+	}
+	ret := []BenchmarkComparison{}
+	for _, res := range current.Results {
+		old, ok := baseResults[res.Name]
+		if !ok || old.Median == 0 {
+			continue
+		}
+		change := float64(res.Median-old.Median) / float64(old.Median)
+		ret = append(ret, BenchmarkComparison{
+			Name:       res.Name,
+			Base:       old.Median,
+			Current:    res.Median,
+			Change:     change,
+			Regression: change > threshold,
+		})
+	}
+	return ret
+}
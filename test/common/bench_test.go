@@ -0,0 +1,146 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseBenchmarkData(t *testing.T) {
+	data, err := ParseBenchmarkData("1x64M, 1000x4k,2x1G,3x100")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []BenchmarkData{
+			{Files: 1, FileSize: 64 << 20},
+			{Files: 1000, FileSize: 4 << 10},
+			{Files: 2, FileSize: 1 << 30},
+			{Files: 3, FileSize: 100},
+		}, data)
+		assert.Equal(t, "1x64M", data[0].String())
+		assert.Equal(t, "1000x4K", data[1].String())
+		assert.Equal(t, "3x100", data[3].String())
+		assert.Equal(t, int64(4000<<10), data[1].TotalSize())
+	}
+	assert.Equal(t, "1x1025", BenchmarkData{Files: 1, FileSize: 1025}.String())
+
+	for _, bad := range []string{"", "10", "ax4K", "10x", "10x4Q", "-1x4K"} {
+		_, err = ParseBenchmarkData(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestWriteBenchmarkData(t *testing.T) {
+	data := BenchmarkData{Files: 3, FileSize: 5000}
+	first := bytes.Buffer{}
+	assert.NoError(t, writeBenchmarkData(&first, data, 1))
+	second := bytes.Buffer{}
+	assert.NoError(t, writeBenchmarkData(&second, data, 1))
+	assert.Equal(t, first.Bytes(), second.Bytes())
+
+	tr := tar.NewReader(&first)
+	names := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		names = append(names, hdr.Name)
+		content, _ := ioutil.ReadAll(tr)
+		assert.Len(t, content, 5000)
+	}
+	assert.Equal(t, []string{"data/file-000000", "data/file-000001", "data/file-000002"}, names)
+}
+
+func TestBenchmarkResult(t *testing.T) {
+	samples := []time.Duration{}
+	for i := 20; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	res := NewBenchmarkResult("CreateCommit", BenchmarkData{Files: 1, FileSize: 1 << 20}, samples)
+	assert.Equal(t, "CreateCommit/1x1M", res.Name)
+	assert.Equal(t, 20, res.Samples)
+	assert.Equal(t, time.Millisecond, res.Min)
+	assert.Equal(t, 20*time.Millisecond, res.Max)
+	assert.Equal(t, 10500*time.Microsecond, res.Mean)
+	assert.Equal(t, 10*time.Millisecond, res.Median)
+	assert.Equal(t, 19*time.Millisecond, res.P95)
+
+	empty := NewBenchmarkResult("CreateCommit", BenchmarkData{}, nil)
+	assert.Equal(t, 0, empty.Samples)
+	assert.Equal(t, time.Duration(0), empty.Median)
+}
+
+func TestBenchmarkTimer(t *testing.T) {
+	timer := BenchmarkTimer{}
+	assert.NoError(t, timer.Time(func() error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}))
+	assert.Error(t, timer.Time(func() error {
+		return os.ErrNotExist
+	}))
+	if assert.Len(t, timer.Samples(), 1) {
+		assert.True(t, timer.Samples()[0] >= 10*time.Millisecond)
+	}
+}
+
+func TestBenchmarkReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bench")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	e, _ := newRecordingTest("docker-zfs")
+	e.Image = "titan:0.5.0"
+	report := NewBenchmarkReport(e)
+	data := BenchmarkData{Files: 1, FileSize: 1024}
+	report.Add(NewBenchmarkResult("CreateCommit", data, []time.Duration{time.Second}))
+	report.Add(NewBenchmarkResult("CreateCommit", data, []time.Duration{time.Second, 2 * time.Second}))
+	report.Add(NewBenchmarkResult("Checkout", data, []time.Duration{time.Second}))
+	assert.Len(t, report.Results, 2)
+	assert.Equal(t, 2, report.Results[0].Samples)
+	assert.Contains(t, report.DefaultPath(), "titan_0.5.0-")
+
+	path := filepath.Join(dir, "nested", "report.json")
+	if assert.NoError(t, report.Write(path)) {
+		loaded, err := LoadBenchmarkReport(path)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "titan:0.5.0", loaded.Image)
+			assert.Equal(t, report.Results, loaded.Results)
+			assert.True(t, report.Started.Equal(loaded.Started))
+		}
+	}
+}
+
+func TestCompareBenchmarks(t *testing.T) {
+	data := BenchmarkData{Files: 1, FileSize: 1024}
+	base := &BenchmarkReport{Results: []BenchmarkResult{
+		NewBenchmarkResult("CreateCommit", data, []time.Duration{100 * time.Millisecond}),
+		NewBenchmarkResult("Checkout", data, []time.Duration{100 * time.Millisecond}),
+		NewBenchmarkResult("Removed", data, []time.Duration{100 * time.Millisecond}),
+	}}
+	current := &BenchmarkReport{Results: []BenchmarkResult{
+		NewBenchmarkResult("CreateCommit", data, []time.Duration{105 * time.Millisecond}),
+		NewBenchmarkResult("Checkout", data, []time.Duration{150 * time.Millisecond}),
+		NewBenchmarkResult("Added", data, []time.Duration{100 * time.Millisecond}),
+	}}
+	comparisons := CompareBenchmarks(base, current, 0.1)
+	if assert.Len(t, comparisons, 2) {
+		assert.False(t, comparisons[0].Regression)
+		assert.InDelta(t, 0.05, comparisons[0].Change, 0.001)
+		assert.True(t, comparisons[1].Regression)
+		assert.Equal(t, "Checkout/1x1K: 100ms -> 150ms (+50.0%) REGRESSION", comparisons[1].String())
+	}
+}
@@ -0,0 +1,218 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	titan "github.com/titan-data/titan-client-go"
+	"strings"
+)
+
+/*
+ * Inverse of an action taken against the server, registered when the object is created so that it can be undone
+ * regardless of how far a test gets.
+ */
+type cleanupAction struct {
+	key         string
+	description string
+	undo        func(ctx context.Context) error
+}
+
+/*
+ * Register an action to be run by Cleanup(). Actions are run in the reverse order in which they were registered, so
+ * objects are always removed before the objects that contain them.
+ */
+func (e *EndToEndTest) RegisterCleanup(description string, undo func(ctx context.Context) error) {
+	e.cleanupLock.Lock()
+	defer e.cleanupLock.Unlock()
+	e.cleanups = append(e.cleanups, cleanupAction{description: description, undo: undo})
+}
+
+/*
+ * Register an action that can later be replaced through its key, such as when the object it removes is renamed.
+ */
+func (e *EndToEndTest) registerKeyedCleanup(key string, description string, undo func(ctx context.Context) error) {
+	e.cleanupLock.Lock()
+	defer e.cleanupLock.Unlock()
+	e.cleanups = append(e.cleanups, cleanupAction{key: key, description: description, undo: undo})
+}
+
+/*
+ * Replace the most recently registered action with the given key, keeping its place in the order. Returns false if
+ * there is no such action.
+ */
+func (e *EndToEndTest) replaceCleanup(key string, action cleanupAction) bool {
+	e.cleanupLock.Lock()
+	defer e.cleanupLock.Unlock()
+	for i := len(e.cleanups) - 1; i >= 0; i-- {
+		if e.cleanups[i].key == key {
+			e.cleanups[i] = action
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Undo everything created through the harness, in reverse order. Objects that no longer exist (such as those
+ * deleted by the test itself) are ignored. All actions are attempted even if some fail, and the registry is empty
+ * afterwards, so that the next set of tests can share the same server.
+ */
+func (e *EndToEndTest) Cleanup(ctx context.Context) error {
+	e.cleanupLock.Lock()
+	actions := e.cleanups
+	e.cleanups = nil
+	e.cleanupLock.Unlock()
+
+	failures := []string{}
+	for i := len(actions) - 1; i >= 0; i-- {
+		err := actions[i].undo(ctx)
+		if err != nil && !isNoSuchObject(err) {
+			failures = append(failures, fmt.Sprintf("%s: %v", actions[i].description, err))
+		}
+	}
+	if len(failures) != 0 {
+		return errors.New(fmt.Sprintf("cleanup failed:\n%s", strings.Join(failures, "\n")))
+	}
+	return nil
+}
+
+func isNoSuchObject(err error) bool {
+	return apiErrorCode(err) == "NoSuchObjectException"
+}
+
+/*
+ * Get the code of an error returned by the titan API, or an empty string if it isn't an API error.
+ */
+func apiErrorCode(err error) string {
+	if openApiError, ok := err.(titan.GenericOpenAPIError); ok {
+		if titanApiError, ok := openApiError.Model().(titan.ApiError); ok {
+			return titanApiError.Code
+		}
+	}
+	return ""
+}
+
+/*
+ * Create a repository, registering its deletion.
+ */
+func (e *EndToEndTest) CreateRepository(ctx context.Context, repo titan.Repository) (titan.Repository, error) {
+	res, _, err := e.RepoApi.CreateRepository(ctx, repo)
+	if err != nil {
+		return res, err
+	}
+	e.RegisterCleanup(fmt.Sprintf("delete repository %s", repo.Name), func(ctx context.Context) error {
+		_, err := e.RepoApi.DeleteRepository(ctx, repo.Name)
+		return err
+	})
+	return res, nil
+}
+
+/*
+ * Create a volume, registering its deletion. The volume is deactivated first in case it was mounted, ignoring any
+ * failure to do so since it may never have been activated.
+ */
+func (e *EndToEndTest) CreateVolume(ctx context.Context, repo string, volume titan.Volume) (titan.Volume, error) {
+	res, _, err := e.VolumeApi.CreateVolume(ctx, repo, volume)
+	if err != nil {
+		return res, err
+	}
+	e.RegisterCleanup(fmt.Sprintf("delete volume %s/%s", repo, volume.Name), func(ctx context.Context) error {
+		_, _ = e.VolumeApi.DeactivateVolume(ctx, repo, volume.Name)
+		_, err := e.VolumeApi.DeleteVolume(ctx, repo, volume.Name)
+		return err
+	})
+	return res, nil
+}
+
+/*
+ * Create a commit, registering its deletion.
+ */
+func (e *EndToEndTest) CreateCommit(ctx context.Context, repo string, commit titan.Commit) (titan.Commit, error) {
+	res, _, err := e.CommitApi.CreateCommit(ctx, repo, commit)
+	if err != nil {
+		return res, err
+	}
+	e.RegisterCleanup(fmt.Sprintf("delete commit %s/%s", repo, commit.Id), func(ctx context.Context) error {
+		_, err := e.CommitApi.DeleteCommit(ctx, repo, commit.Id)
+		return err
+	})
+	return res, nil
+}
+
+func remoteCleanup(e *EndToEndTest, repo string, name string) cleanupAction {
+	return cleanupAction{
+		key:         fmt.Sprintf("remote %s/%s", repo, name),
+		description: fmt.Sprintf("delete remote %s/%s", repo, name),
+		undo: func(ctx context.Context) error {
+			_, err := e.RemoteApi.DeleteRemote(ctx, repo, name)
+			return err
+		},
+	}
+}
+
+/*
+ * Add a remote, registering its removal.
+ */
+func (e *EndToEndTest) CreateRemote(ctx context.Context, repo string, remote titan.Remote) (titan.Remote, error) {
+	res, _, err := e.RemoteApi.CreateRemote(ctx, repo, remote)
+	if err != nil {
+		return res, err
+	}
+	action := remoteCleanup(e, repo, remote.Name)
+	e.registerKeyedCleanup(action.key, action.description, action.undo)
+	return res, nil
+}
+
+/*
+ * Update a remote. If it's renamed, its registered removal is updated to the new name, or registered if the remote
+ * wasn't created through the harness.
+ */
+func (e *EndToEndTest) UpdateRemote(ctx context.Context, repo string, name string,
+	remote titan.Remote) (titan.Remote, error) {
+	res, _, err := e.RemoteApi.UpdateRemote(ctx, repo, name, remote)
+	if err != nil || remote.Name == name {
+		return res, err
+	}
+	action := remoteCleanup(e, repo, remote.Name)
+	if !e.replaceCleanup(remoteCleanup(e, repo, name).key, action) {
+		e.registerKeyedCleanup(action.key, action.description, action.undo)
+	}
+	return res, nil
+}
+
+func (e *EndToEndTest) registerAbort(op titan.Operation) {
+	e.RegisterCleanup(fmt.Sprintf("abort operation %s", op.Id), func(ctx context.Context) error {
+		_, err := e.OperationsApi.AbortOperation(ctx, op.Id)
+		return err
+	})
+}
+
+/*
+ * Start a push operation, registering it to be aborted if it is still running at cleanup time.
+ */
+func (e *EndToEndTest) Push(ctx context.Context, repo string, remote string, commit string,
+	params titan.RemoteParameters, opts *titan.PushOpts) (titan.Operation, error) {
+	res, _, err := e.OperationsApi.Push(ctx, repo, remote, commit, params, opts)
+	if err != nil {
+		return res, err
+	}
+	e.registerAbort(res)
+	return res, nil
+}
+
+/*
+ * Start a pull operation, registering it to be aborted if it is still running at cleanup time.
+ */
+func (e *EndToEndTest) Pull(ctx context.Context, repo string, remote string, commit string,
+	params titan.RemoteParameters, opts *titan.PullOpts) (titan.Operation, error) {
+	res, _, err := e.OperationsApi.Pull(ctx, repo, remote, commit, params, opts)
+	if err != nil {
+		return res, err
+	}
+	e.registerAbort(res)
+	return res, nil
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	titan "github.com/titan-data/titan-client-go"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCleanupReverseOrder(t *testing.T) {
+	api := &fakeApi{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	e, _ := newRecordingTest("docker-zfs")
+	e.SetApiHost(strings.TrimPrefix(server.URL, "http://"))
+
+	_, err := e.Fixture().Repo("foo").
+		Volume("vol").
+		Commit("id").
+		Remote(titan.Remote{Provider: "nop", Name: "origin", Properties: map[string]interface{}{}}).
+		Build(context.Background())
+	if assert.NoError(t, err) {
+		api.requests = nil
+		if assert.NoError(t, e.Cleanup(context.Background())) {
+			assert.Equal(t, []string{
+				"DELETE /v1/repositories/foo/remotes/origin",
+				"DELETE /v1/repositories/foo/commits/id",
+				"POST /v1/repositories/foo/volumes/vol/deactivate",
+				"DELETE /v1/repositories/foo/volumes/vol",
+				"DELETE /v1/repositories/foo",
+			}, api.requests)
+		}
+
+		// The registry is emptied by cleanup
+		api.requests = nil
+		assert.NoError(t, e.Cleanup(context.Background()))
+		assert.Empty(t, api.requests)
+	}
+}
+
+func TestCleanupContinuesOnError(t *testing.T) {
+	e, _ := newRecordingTest("docker-zfs")
+	ran := []string{}
+	e.RegisterCleanup("first", func(ctx context.Context) error {
+		ran = append(ran, "first")
+		return nil
+	})
+	e.RegisterCleanup("second", func(ctx context.Context) error {
+		ran = append(ran, "second")
+		return errors.New("failed")
+	})
+	err := e.Cleanup(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "second: failed")
+	}
+	assert.Equal(t, []string{"second", "first"}, ran)
+}
+
+/*
+ * A renamed remote is removed under its new name, in the place of the original.
+ */
+func TestCleanupRenamedRemote(t *testing.T) {
+	api := &fakeApi{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	e, _ := newRecordingTest("docker-zfs")
+	e.SetApiHost(strings.TrimPrefix(server.URL, "http://"))
+	ctx := context.Background()
+	remote := func(name string) titan.Remote {
+		return titan.Remote{Provider: "nop", Name: name, Properties: map[string]interface{}{}}
+	}
+
+	_, err := e.CreateRepository(ctx, titan.Repository{Name: "foo", Properties: map[string]interface{}{}})
+	assert.NoError(t, err)
+	_, err = e.CreateRemote(ctx, "foo", remote("a"))
+	assert.NoError(t, err)
+	_, err = e.CreateRemote(ctx, "foo", remote("x"))
+	assert.NoError(t, err)
+	_, err = e.UpdateRemote(ctx, "foo", "a", remote("b"))
+	assert.NoError(t, err)
+	_, err = e.UpdateRemote(ctx, "foo", "b", remote("b"))
+	assert.NoError(t, err)
+	_, _, err = e.RemoteApi.CreateRemote(ctx, "foo", remote("y"))
+	assert.NoError(t, err)
+	_, err = e.UpdateRemote(ctx, "foo", "y", remote("z"))
+	assert.NoError(t, err)
+
+	api.requests = nil
+	if assert.NoError(t, e.Cleanup(ctx)) {
+		assert.Equal(t, []string{
+			"DELETE /v1/repositories/foo/remotes/z",
+			"DELETE /v1/repositories/foo/remotes/x",
+			"DELETE /v1/repositories/foo/remotes/b",
+			"DELETE /v1/repositories/foo",
+		}, api.requests)
+	}
+}
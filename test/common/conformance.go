@@ -0,0 +1,410 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/antihax/optional"
+	"github.com/stretchr/testify/suite"
+	titan "github.com/titan-data/titan-client-go"
+	"sort"
+)
+
+/*
+ * Everything the conformance suite needs to know about a remote provider. Some providers read and write through
+ * different remotes (s3web can only be read, so commits are pushed to it through an s3 remote over the same storage),
+ * which is why the remotes used for pushing and pulling are named separately.
+ */
+type RemoteFixture interface {
+	/*
+	 * Start anything the remote depends on. This is called once the titan server is running.
+	 */
+	Setup(e *EndToEndTest) error
+
+	Teardown() error
+
+	/*
+	 * All remotes to add to the repository, which must include the push and pull remotes.
+	 */
+	Remotes() []titan.Remote
+
+	PushRemote() string
+
+	PullRemote() string
+
+	/*
+	 * Parameters for operations against the given remote.
+	 */
+	Parameters(remote string) titan.RemoteParameters
+
+	/*
+	 * Inspect remote storage directly (not through titan) to get the IDs of all commits it holds.
+	 */
+	StoredCommits() ([]string, error)
+
+	/*
+	 * Remove all commits from remote storage.
+	 */
+	Reset() error
+
+	/*
+	 * Whether the remote actually stores commits. The nop remote accepts every operation but stores nothing, so only
+	 * the parts of the suite that don't depend on reading commits back are run against it.
+	 */
+	Persistent() bool
+}
+
+/*
+ * The standard push, list, filter, duplicate, metadata update, pull, and checkout sequence, run against any remote
+ * provider. It works with a repository "foo" containing a volume "vol", and a commit "id" tagged a=b and c=d, that is
+ * pushed, deleted locally, and then pulled back.
+ *
+ * Provider specific tests are added through Extend, which is called with the standard steps already added so that
+ * extra steps can depend on them. They are run before the repository is deleted.
+ */
+type ConformanceSuite struct {
+	suite.Suite
+	Fixture RemoteFixture
+	Extend  func(steps *Steps)
+
+	E        *EndToEndTest
+	Ctx      context.Context
+	Manifest Manifest
+}
+
+func (s *ConformanceSuite) SetupSuite() {
+	s.E = NewEndToEndTest(&s.Suite, "docker-zfs")
+	s.E.SetupStandardDocker()
+	s.Ctx = context.Background()
+
+	/*
+	 * TearDownSuite isn't called when SetupSuite fails, so stop the server here before failing rather than leaving it
+	 * running for the suites that follow.
+	 */
+	err := s.setupFixture()
+	if err != nil {
+		s.Fail("failed to set up remote", err.Error())
+		s.E.TeardownStandardDocker()
+		_ = s.Fixture.Teardown()
+		s.T().FailNow()
+	}
+}
+
+/*
+ * Set up the fixture and reset remote storage. Fixtures use helpers such as SetupStandardSsh that panic on failure,
+ * so panics are returned as errors too.
+ */
+func (s *ConformanceSuite) setupFixture() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.New(fmt.Sprintf("%v", r))
+		}
+	}()
+	err = s.Fixture.Setup(s.E)
+	if err != nil {
+		return err
+	}
+	return s.Fixture.Reset()
+}
+
+func (s *ConformanceSuite) TearDownSuite() {
+	s.E.TeardownStandardDocker()
+	_ = s.Fixture.Teardown()
+}
+
+func (s *ConformanceSuite) TestConformance() {
+	steps := s.E.Steps().
+		Add("CreateRepository", s.createRepository).
+		Add("CreateVolume", s.createVolume, "CreateRepository").
+		Add("CreateFile", s.createFile, "CreateVolume").
+		Add("CreateCommit", s.createCommit, "CreateFile").
+		Add("AddRemotes", s.addRemotes, "CreateRepository").
+		Add("RemoteStorageEmpty", s.remoteStorageEmpty).
+		Add("ListEmptyRemoteCommits", s.listEmptyRemoteCommits, "AddRemotes").
+		Add("GetBadRemoteCommit", s.getBadRemoteCommit, "AddRemotes").
+		Add("PushCommit", s.pushCommit, "CreateCommit", "AddRemotes").
+		Add("RemoteStorageContains", s.remoteStorageContains, "PushCommit").
+		Add("ListRemoteCommit", s.listRemoteCommit, "PushCommit").
+		Add("ListRemoteFilterOut", s.listRemoteFilterOut, "PushCommit").
+		Add("ListRemoteFilterInclude", s.listRemoteFilterInclude, "PushCommit").
+		Add("PushDuplicateCommit", s.pushDuplicateCommit, "PushCommit").
+		Add("UpdateCommit", s.updateCommit, "CreateCommit").
+		Add("PushMetadata", s.pushMetadata, "PushCommit", "UpdateCommit").
+		Add("RemoteMetadataUpdated", s.remoteMetadataUpdated, "PushMetadata").
+		Add("DeleteLocalCommit", s.deleteLocalCommit, "PushCommit").
+		Add("ListEmptyCommits", s.listEmptyCommits, "DeleteLocalCommit").
+		Add("UpdateFile", s.updateFile, "CreateFile").
+		Add("PullCommit", s.pullCommit, "DeleteLocalCommit").
+		Add("PullDuplicate", s.pullDuplicate, "PullCommit").
+		Add("PullMetadata", s.pullMetadata, "PullCommit").
+		Add("CheckoutCommit", s.checkoutCommit, "PullCommit", "UpdateFile").
+		Add("OriginalContents", s.originalContents, "CheckoutCommit")
+	if s.Extend != nil {
+		s.Extend(steps)
+	}
+	steps.
+		Add("DeleteVolume", s.deleteVolume, "CreateVolume").
+		Add("DeleteRepository", s.deleteRepository, "CreateRepository").
+		Run()
+}
+
+/*
+ * Skip the current step if the remote doesn't store commits.
+ */
+func (s *ConformanceSuite) RequirePersistent() {
+	if !s.Fixture.Persistent() {
+		s.T().Skip("remote does not store commits")
+	}
+}
+
+/*
+ * Wait for an operation to complete, checking that the provider's progress reports are well formed along the way.
+ */
+func (s *ConformanceSuite) waitForOperation(id string) {
+	w := NewProgressWatcher()
+	s.E.NoError(s.E.WatchOperation(s.Ctx, id, w))
+	s.Empty(w.Violations())
+}
+
+func (s *ConformanceSuite) pushParameters() titan.RemoteParameters {
+	return s.Fixture.Parameters(s.Fixture.PushRemote())
+}
+
+func (s *ConformanceSuite) pullParameters() titan.RemoteParameters {
+	return s.Fixture.Parameters(s.Fixture.PullRemote())
+}
+
+func (s *ConformanceSuite) createRepository() {
+	_, err := s.E.CreateRepository(s.Ctx, titan.Repository{
+		Name:       "foo",
+		Properties: map[string]interface{}{},
+	})
+	s.E.NoError(err)
+}
+
+func (s *ConformanceSuite) createVolume() {
+	_, err := s.E.CreateVolume(s.Ctx, "foo", titan.Volume{
+		Name:       "vol",
+		Properties: map[string]interface{}{},
+	})
+	if s.E.NoError(err) {
+		_, err := s.E.VolumeApi.ActivateVolume(s.Ctx, "foo", "vol")
+		s.E.NoError(err)
+	}
+}
+
+func (s *ConformanceSuite) createFile() {
+	err := s.E.WriteFile("foo", "vol", "testfile", "Hello")
+	if s.E.NoError(err) {
+		res, err := s.E.ReadFile("foo", "vol", "testfile")
+		if s.E.NoError(err) {
+			s.Equal("Hello", res)
+			s.Manifest, err = s.E.VolumeManifest("foo", "vol")
+			s.E.NoError(err)
+		}
+	}
+}
+
+func (s *ConformanceSuite) createCommit() {
+	res, err := s.E.CreateCommit(s.Ctx, "foo", titan.Commit{
+		Id: "id",
+		Properties: map[string]interface{}{"tags": map[string]string{
+			"a": "b",
+			"c": "d",
+		}},
+	})
+	if s.E.NoError(err) {
+		s.Equal("id", res.Id)
+		s.Equal("b", s.E.GetTag(res, "a"))
+	}
+}
+
+func (s *ConformanceSuite) addRemotes() {
+	for _, remote := range s.Fixture.Remotes() {
+		_, err := s.E.CreateRemote(s.Ctx, "foo", remote)
+		if s.E.NoError(err) {
+			res, _, err := s.E.RemoteApi.GetRemote(s.Ctx, "foo", remote.Name)
+			if s.E.NoError(err) {
+				s.Equal(remote.Provider, res.Provider)
+				s.Equal(remote.Name, res.Name)
+			}
+		}
+	}
+}
+
+func (s *ConformanceSuite) remoteStorageEmpty() {
+	s.RequirePersistent()
+	commits, err := s.Fixture.StoredCommits()
+	if s.NoError(err) {
+		s.Empty(commits)
+	}
+}
+
+func (s *ConformanceSuite) listEmptyRemoteCommits() {
+	res, _, err := s.E.RemoteApi.ListRemoteCommits(s.Ctx, "foo", s.Fixture.PullRemote(), s.pullParameters(), nil)
+	if s.E.NoError(err) {
+		s.Len(res, 0)
+	}
+}
+
+func (s *ConformanceSuite) getBadRemoteCommit() {
+	s.RequirePersistent()
+	_, _, err := s.E.RemoteApi.GetRemoteCommit(s.Ctx, "foo", s.Fixture.PullRemote(), "id2", s.pullParameters())
+	s.E.APIError(err, "NoSuchObjectException")
+}
+
+func (s *ConformanceSuite) pushCommit() {
+	res, err := s.E.Push(s.Ctx, "foo", s.Fixture.PushRemote(), "id", s.pushParameters(), nil)
+	if s.E.NoError(err) {
+		s.waitForOperation(res.Id)
+	}
+}
+
+func (s *ConformanceSuite) remoteStorageContains() {
+	s.RequirePersistent()
+	commits, err := s.Fixture.StoredCommits()
+	if s.NoError(err) {
+		sort.Strings(commits)
+		s.Equal([]string{"id"}, commits)
+	}
+}
+
+func (s *ConformanceSuite) listRemoteCommit() {
+	s.RequirePersistent()
+	res, _, err := s.E.RemoteApi.ListRemoteCommits(s.Ctx, "foo", s.Fixture.PullRemote(), s.pullParameters(), nil)
+	if s.E.NoError(err) && s.Len(res, 1) {
+		s.Equal("id", res[0].Id)
+		s.Equal("b", s.E.GetTag(res[0], "a"))
+	}
+}
+
+func (s *ConformanceSuite) listRemoteFilterOut() {
+	res, _, err := s.E.RemoteApi.ListRemoteCommits(s.Ctx, "foo", s.Fixture.PullRemote(), s.pullParameters(),
+		&titan.ListRemoteCommitsOpts{Tag: optional.NewInterface([]string{"e"})})
+	if s.E.NoError(err) {
+		s.Len(res, 0)
+	}
+}
+
+func (s *ConformanceSuite) listRemoteFilterInclude() {
+	s.RequirePersistent()
+	res, _, err := s.E.RemoteApi.ListRemoteCommits(s.Ctx, "foo", s.Fixture.PullRemote(), s.pullParameters(),
+		&titan.ListRemoteCommitsOpts{Tag: optional.NewInterface([]string{"a=b", "c=d"})})
+	if s.E.NoError(err) && s.Len(res, 1) {
+		s.Equal("id", res[0].Id)
+	}
+}
+
+func (s *ConformanceSuite) pushDuplicateCommit() {
+	s.RequirePersistent()
+	_, err := s.E.Push(s.Ctx, "foo", s.Fixture.PushRemote(), "id", s.pushParameters(), nil)
+	s.E.APIError(err, "ObjectExistsException")
+}
+
+func (s *ConformanceSuite) updateCommit() {
+	res, _, err := s.E.CommitApi.UpdateCommit(s.Ctx, "foo", "id", titan.Commit{
+		Id: "id",
+		Properties: map[string]interface{}{"tags": map[string]string{
+			"a": "B",
+			"c": "e",
+		}},
+	})
+	if s.E.NoError(err) {
+		s.Equal("B", s.E.GetTag(res, "a"))
+	}
+}
+
+func (s *ConformanceSuite) pushMetadata() {
+	res, err := s.E.Push(s.Ctx, "foo", s.Fixture.PushRemote(), "id", s.pushParameters(),
+		&titan.PushOpts{MetadataOnly: optional.NewBool(true)})
+	if s.E.NoError(err) {
+		s.waitForOperation(res.Id)
+	}
+}
+
+func (s *ConformanceSuite) remoteMetadataUpdated() {
+	s.RequirePersistent()
+	res, _, err := s.E.RemoteApi.GetRemoteCommit(s.Ctx, "foo", s.Fixture.PullRemote(), "id", s.pullParameters())
+	if s.E.NoError(err) {
+		s.Equal("id", res.Id)
+		s.Equal("B", s.E.GetTag(res, "a"))
+	}
+}
+
+func (s *ConformanceSuite) deleteLocalCommit() {
+	_, err := s.E.CommitApi.DeleteCommit(s.Ctx, "foo", "id")
+	s.E.NoError(err)
+}
+
+func (s *ConformanceSuite) listEmptyCommits() {
+	res, _, err := s.E.CommitApi.ListCommits(s.Ctx, "foo", nil)
+	if s.E.NoError(err) {
+		s.Len(res, 0)
+	}
+}
+
+func (s *ConformanceSuite) updateFile() {
+	err := s.E.WriteFile("foo", "vol", "testfile", "Goodbye")
+	if s.E.NoError(err) {
+		res, err := s.E.ReadFile("foo", "vol", "testfile")
+		if s.E.NoError(err) {
+			s.Equal("Goodbye", res)
+		}
+	}
+}
+
+func (s *ConformanceSuite) pullCommit() {
+	res, err := s.E.Pull(s.Ctx, "foo", s.Fixture.PullRemote(), "id", s.pullParameters(), nil)
+	if s.E.NoError(err) {
+		s.waitForOperation(res.Id)
+	}
+}
+
+func (s *ConformanceSuite) pullDuplicate() {
+	_, err := s.E.Pull(s.Ctx, "foo", s.Fixture.PullRemote(), "id", s.pullParameters(), nil)
+	s.E.APIError(err, "ObjectExistsException")
+}
+
+func (s *ConformanceSuite) pullMetadata() {
+	res, err := s.E.Pull(s.Ctx, "foo", s.Fixture.PullRemote(), "id", s.pullParameters(),
+		&titan.PullOpts{MetadataOnly: optional.NewBool(true)})
+	if s.E.NoError(err) {
+		s.waitForOperation(res.Id)
+	}
+}
+
+func (s *ConformanceSuite) checkoutCommit() {
+	s.RequirePersistent()
+	_, err := s.E.VolumeApi.DeactivateVolume(s.Ctx, "foo", "vol")
+	if s.E.NoError(err) {
+		_, err := s.E.CommitApi.CheckoutCommit(s.Ctx, "foo", "id")
+		if s.E.NoError(err) {
+			_, err = s.E.VolumeApi.ActivateVolume(s.Ctx, "foo", "vol")
+			s.E.NoError(err)
+		}
+	}
+}
+
+func (s *ConformanceSuite) originalContents() {
+	res, err := s.E.ReadFile("foo", "vol", "testfile")
+	if s.E.NoError(err) {
+		s.Equal("Hello", res)
+	}
+	s.E.AssertVolumeMatchesManifest("foo", "vol", s.Manifest)
+}
+
+func (s *ConformanceSuite) deleteVolume() {
+	_, err := s.E.VolumeApi.DeactivateVolume(s.Ctx, "foo", "vol")
+	if s.E.NoError(err) {
+		_, err = s.E.VolumeApi.DeleteVolume(s.Ctx, "foo", "vol")
+		s.E.NoError(err)
+	}
+}
+
+func (s *ConformanceSuite) deleteRepository() {
+	_, err := s.E.RepoApi.DeleteRepository(s.Ctx, "foo")
+	s.E.NoError(err)
+}
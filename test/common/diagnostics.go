@@ -0,0 +1,215 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	titan "github.com/titan-data/titan-client-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const diagnosticsTimeout = 30 * time.Second
+
+/*
+ * Full state of the server as seen through the API, captured as part of the diagnostics bundle. Anything that can't
+ * be fetched is recorded in the Errors list rather than aborting collection.
+ */
+type ApiState struct {
+	Repositories []RepositoryState
+	Operations   []OperationState
+	Errors       []string
+}
+
+type RepositoryState struct {
+	Repository titan.Repository
+	Status     *titan.RepositoryStatus
+	Volumes    []VolumeState
+	Commits    []titan.Commit
+	Remotes    []titan.Remote
+}
+
+type VolumeState struct {
+	Volume titan.Volume
+	Status *titan.VolumeStatus
+}
+
+type OperationState struct {
+	Operation titan.Operation
+	Progress  []titan.ProgressEntry
+}
+
+/*
+ * Get the directory where diagnostics are collected. This can be set through TITAN_TEST_ARTIFACTS so that CI can
+ * upload it, and otherwise defaults to a directory within the system temporary directory. Each suite gets its own
+ * subdirectory named after its identity.
+ */
+func ArtifactsDir() string {
+	dir := os.Getenv("TITAN_TEST_ARTIFACTS")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "titan-test-artifacts")
+	}
+	return dir
+}
+
+/*
+ * Returns true if any test within the suite has failed. Suites that were never run (such as those created for unit
+ * tests of the harness itself) never report failure.
+ */
+func (e *EndToEndTest) Failed() bool {
+	return e.Suite != nil && e.Suite.T() != nil && e.Suite.T().Failed()
+}
+
+/*
+ * Collect diagnostics only if the suite has failed. This is invoked as part of the standard teardown, and should be
+ * called by any suite with its own teardown before the server is stopped.
+ */
+func (e *EndToEndTest) CollectDiagnosticsOnFailure() {
+	if !e.Failed() {
+		return
+	}
+	dir, err := e.CollectDiagnostics()
+	if err != nil {
+		e.Suite.T().Logf("failed to collect diagnostics: %v", err)
+	} else {
+		e.Suite.T().Logf("diagnostics for %s written to %s", e.Identity, dir)
+	}
+}
+
+/*
+ * Collect a diagnostics bundle into the artifacts directory, returning the path of the bundle. This includes the
+ * logs and inspect output of the backend's containers, any commands run on the SSH server, the output of the
+ * backend's diagnostic commands (such as the ZFS datasets), and the full API state. Collection is best effort: failure to capture one piece is recorded in place of its output, and doesn't
+ * prevent the rest from being captured.
+ */
+func (e *EndToEndTest) CollectDiagnostics() (string, error) {
+	dir := filepath.Join(ArtifactsDir(), e.Identity)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	for _, container := range e.Backend.Containers() {
+		logs, err := e.Runtime.Logs(container)
+		err = writeArtifact(dir, fmt.Sprintf("%s.log", container), logs, err)
+		if err != nil {
+			return dir, err
+		}
+		inspect, err := e.Runtime.Inspect(container, "{{json .}}")
+		err = writeArtifact(dir, fmt.Sprintf("%s.inspect.json", container), inspect, err)
+		if err != nil {
+			return dir, err
+		}
+	}
+
+	if e.Ssh != nil {
+		commands := ""
+		for _, c := range e.Ssh.Commands() {
+			commands += fmt.Sprintf("[%d] %s\n", c.ExitStatus, c.Command)
+		}
+		err = writeArtifact(dir, "ssh-commands.txt", commands, nil)
+		if err != nil {
+			return dir, err
+		}
+	}
+
+	commands := e.Backend.DiagnosticCommands()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		out, err := e.ExecServer(commands[name]...)
+		err = writeArtifact(dir, name, out, err)
+		if err != nil {
+			return dir, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagnosticsTimeout)
+	defer cancel()
+	state, err := json.MarshalIndent(e.GetApiState(ctx), "", "  ")
+	err = writeArtifact(dir, "api-state.json", string(state), err)
+	return dir, err
+}
+
+/*
+ * Write a single artifact, or the error that occurred when trying to gather it.
+ */
+func writeArtifact(dir string, name string, content string, err error) error {
+	if err != nil {
+		content = fmt.Sprintf("%s\nerror: %v\n", content, err)
+	}
+	return ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+}
+
+/*
+ * Walk the API to capture all repositories (along with their volumes, commits, and remotes) and all operations with
+ * their complete progress.
+ */
+func (e *EndToEndTest) GetApiState(ctx context.Context) ApiState {
+	state := ApiState{}
+	addError := func(what string, err error) {
+		state.Errors = append(state.Errors, fmt.Sprintf("%s: %v", what, err))
+	}
+
+	repos, _, err := e.RepoApi.ListRepositories(ctx)
+	if err != nil {
+		addError("list repositories", err)
+	}
+	for _, repo := range repos {
+		rs := RepositoryState{Repository: repo}
+		status, _, err := e.RepoApi.GetRepositoryStatus(ctx, repo.Name)
+		if err != nil {
+			addError(fmt.Sprintf("get repository status %s", repo.Name), err)
+		} else {
+			rs.Status = &status
+		}
+
+		volumes, _, err := e.VolumeApi.ListVolumes(ctx, repo.Name)
+		if err != nil {
+			addError(fmt.Sprintf("list volumes %s", repo.Name), err)
+		}
+		for _, vol := range volumes {
+			vs := VolumeState{Volume: vol}
+			status, _, err := e.VolumeApi.GetVolumeStatus(ctx, repo.Name, vol.Name)
+			if err != nil {
+				addError(fmt.Sprintf("get volume status %s/%s", repo.Name, vol.Name), err)
+			} else {
+				vs.Status = &status
+			}
+			rs.Volumes = append(rs.Volumes, vs)
+		}
+
+		rs.Commits, _, err = e.CommitApi.ListCommits(ctx, repo.Name, nil)
+		if err != nil {
+			addError(fmt.Sprintf("list commits %s", repo.Name), err)
+		}
+		rs.Remotes, _, err = e.RemoteApi.ListRemotes(ctx, repo.Name)
+		if err != nil {
+			addError(fmt.Sprintf("list remotes %s", repo.Name), err)
+		}
+		state.Repositories = append(state.Repositories, rs)
+	}
+
+	operations, _, err := e.OperationsApi.ListOperations(ctx, nil)
+	if err != nil {
+		addError("list operations", err)
+	}
+	for _, op := range operations {
+		opState := OperationState{Operation: op}
+		opState.Progress, _, err = e.OperationsApi.GetOperationProgress(ctx, op.Id, nil)
+		if err != nil {
+			addError(fmt.Sprintf("get operation progress %s", op.Id), err)
+		}
+		state.Operations = append(state.Operations, opState)
+	}
+	return state
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectDiagnostics(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "titan-artifacts")
+	defer os.RemoveAll(dir)
+	previous, present := os.LookupEnv("TITAN_TEST_ARTIFACTS")
+	_ = os.Setenv("TITAN_TEST_ARTIFACTS", dir)
+	defer func() {
+		if present {
+			_ = os.Setenv("TITAN_TEST_ARTIFACTS", previous)
+		} else {
+			_ = os.Unsetenv("TITAN_TEST_ARTIFACTS")
+		}
+	}()
+
+	e, runtime := newRecordingTest("docker-zfs")
+	runtime.Outputs["Logs"] = "log output"
+	runtime.Outputs["Exec"] = "test/repo"
+
+	bundle, err := e.CollectDiagnostics()
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(dir, "test"), bundle)
+		for _, name := range []string{"test-launch.log", "test-server.log",
+			"test-launch.inspect.json", "test-server.inspect.json"} {
+			assert.FileExists(t, filepath.Join(bundle, name))
+		}
+		logs, _ := ioutil.ReadFile(filepath.Join(bundle, "test-server.log"))
+		assert.Equal(t, "log output", string(logs))
+		zfs, _ := ioutil.ReadFile(filepath.Join(bundle, "zfs-list.txt"))
+		assert.Equal(t, "test/repo", string(zfs))
+
+		// There's no server listening, so the API errors should be recorded in the state
+		content, _ := ioutil.ReadFile(filepath.Join(bundle, "api-state.json"))
+		state := ApiState{}
+		if assert.NoError(t, json.Unmarshal(content, &state)) {
+			assert.NotEmpty(t, state.Errors)
+		}
+	}
+}
+
+/*
+ * The kubernetes-csi backend has no launch container or ZFS pool, so neither is part of its bundle.
+ */
+func TestCollectDiagnosticsKubernetes(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "titan-artifacts")
+	defer os.RemoveAll(dir)
+	previous, present := os.LookupEnv("TITAN_TEST_ARTIFACTS")
+	_ = os.Setenv("TITAN_TEST_ARTIFACTS", dir)
+	defer func() {
+		if present {
+			_ = os.Setenv("TITAN_TEST_ARTIFACTS", previous)
+		} else {
+			_ = os.Unsetenv("TITAN_TEST_ARTIFACTS")
+		}
+	}()
+
+	e, runtime := newRecordingTest("kubernetes-csi")
+	runtime.Outputs["Exec"] = "pvc/vol"
+
+	bundle, err := e.CollectDiagnostics()
+	if assert.NoError(t, err) {
+		assert.FileExists(t, filepath.Join(bundle, "test-server.log"))
+		assert.FileExists(t, filepath.Join(bundle, "test-server.inspect.json"))
+		for _, name := range []string{"test-launch.log", "zfs-list.txt"} {
+			_, err := os.Stat(filepath.Join(bundle, name))
+			assert.True(t, os.IsNotExist(err), name)
+		}
+		volumes, _ := ioutil.ReadFile(filepath.Join(bundle, "kubectl-volumes.txt"))
+		assert.Equal(t, "pvc/vol", string(volumes))
+	}
+	for _, call := range runtime.CallsTo("Exec") {
+		assert.Equal(t, "kubectl", call.Args[0])
+	}
+	for _, call := range append(runtime.CallsTo("Logs"), runtime.CallsTo("Inspect")...) {
+		assert.Equal(t, "test-server", call.Container)
+	}
+}
+
+func TestFailedWithoutRun(t *testing.T) {
+	e, _ := newRecordingTest("docker-zfs")
+	assert.False(t, e.Failed())
+}
@@ -7,23 +7,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/antihax/optional"
 	"github.com/stretchr/testify/suite"
 	titan "github.com/titan-data/titan-client-go"
 	"golang.org/x/crypto/ssh"
+	"net"
 	"os"
-	"os/exec"
 	"os/user"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 )
 
 /*
- * Utility class for managing endtoend tests of titan-server. There are two types of
- * containers we care about: the titan server container and a remote SSH container. The titan
- * server is run on an alternate pool and port so as not to conflict with the running titan-server.
- * For the remote SSH server, we use 'rastasheep/ubuntu-sshd', which comes pre-built for remote access
- * over SSH.
+ * Utility class for managing endtoend tests of titan-server. The titan server is run in a container on
+ * an alternate pool and port so as not to conflict with the running titan-server. Each instance gets its
+ * own identity and ports, so that multiple suites can run in parallel. For the remote SSH server, we run
+ * an in-process server (see SshServer) that the titan server reaches through its network gateway.
  */
 type EndToEndTest struct {
 	*suite.Suite
@@ -35,39 +34,45 @@ type EndToEndTest struct {
 	SshHost  string
 	HomeDir  string
 
-	Client *titan.APIClient
+	Backend ServerBackend
+	Runtime ContainerRuntime
+	Client  *titan.APIClient
+	Ssh     *SshServer
 
 	RepoApi       *titan.RepositoriesApiService
 	RemoteApi     *titan.RemotesApiService
 	VolumeApi     *titan.VolumesApiService
 	CommitApi     *titan.CommitsApiService
 	OperationsApi *titan.OperationsApiService
+
+	cleanupLock sync.Mutex
+	cleanups    []cleanupAction
 }
 
-const waitTimeout = 1
-const waitRetries = 60
 const sshUser = "test"
-const sshPassword = "test"
 
 func NewEndToEndTest(s *suite.Suite, context string) *EndToEndTest {
 	ret := EndToEndTest{
-		Suite:    s,
-		Context:  context,
-		Identity: "test",
-		Port:     6001,
-		Image:    "titan:latest",
-		SshPort:  6003,
+		Suite:   s,
+		Context: context,
+		Image:   "titan:latest",
 	}
 
-	cfg := titan.NewConfiguration()
-	cfg.Host = fmt.Sprintf("localhost:%d", ret.Port)
-	ret.Client = titan.NewAPIClient(cfg)
+	var err error
+	ret.Identity, err = newIdentity()
+	if err != nil {
+		panic(err)
+	}
+	ret.Port, err = freePort()
+	if err != nil {
+		panic(err)
+	}
+	ret.SshPort, err = freePort()
+	if err != nil {
+		panic(err)
+	}
 
-	ret.RepoApi = ret.Client.RepositoriesApi
-	ret.VolumeApi = ret.Client.VolumesApi
-	ret.RemoteApi = ret.Client.RemotesApi
-	ret.CommitApi = ret.Client.CommitsApi
-	ret.OperationsApi = ret.Client.OperationsApi
+	ret.SetApiHost(fmt.Sprintf("localhost:%d", ret.Port))
 
 	usr, err := user.Current()
 	if err != nil {
@@ -78,31 +83,66 @@ func NewEndToEndTest(s *suite.Suite, context string) *EndToEndTest {
 		panic("failed to determine user home directory")
 	}
 
+	ret.Runtime, err = defaultContainerRuntime()
+	if err != nil {
+		panic(err)
+	}
+
+	ret.Backend, err = NewBackend(context, &ret)
+	if err != nil {
+		panic(err)
+	}
+
 	return &ret
 }
 
+/*
+ * Point the API client at the given host and port. By default this is the server's published port on localhost, but
+ * tests can redirect it elsewhere, such as to a proxy in front of the server.
+ */
+func (e *EndToEndTest) SetApiHost(host string) {
+	cfg := titan.NewConfiguration()
+	cfg.Host = host
+	e.Client = titan.NewAPIClient(cfg)
+
+	e.RepoApi = e.Client.RepositoriesApi
+	e.VolumeApi = e.Client.VolumesApi
+	e.RemoteApi = e.Client.RemotesApi
+	e.CommitApi = e.Client.CommitsApi
+	e.OperationsApi = e.Client.OperationsApi
+}
+
 /*
  * Run a specific entry point within titan-server. This can either run a full-fledged launch, or can be used to
  * run other entry points (like teardown).
  */
 func (e *EndToEndTest) RunTitanDocker(entryPoint string, daemon bool) error {
-	args := []string{"run", "--privileged", "--pid=host", "--network=host",
-		"-v", "/var/lib:/var/lib", "-v", "/run/docker:/run/docker"}
+	opts := RunOptions{
+		Privileged: true,
+		Pid:        "host",
+		Network:    "host",
+		Volumes:    []string{"/var/lib:/var/lib", "/run/docker:/run/docker"},
+	}
 	if daemon {
-		args = append(args, "-d", "--restart", "always", "--name", e.GetPrimaryContainer(),
-			"-v", fmt.Sprintf("/lib:/var/lib/%s/system", e.Identity))
+		opts.Detach = true
+		opts.Restart = "always"
+		opts.Name = e.GetPrimaryContainer()
+		opts.Volumes = append(opts.Volumes, fmt.Sprintf("/lib:/var/lib/%s/system", e.Identity))
 	} else {
-		args = append(args, "--rm")
+		opts.Remove = true
+	}
+	opts.Volumes = append(opts.Volumes,
+		fmt.Sprintf("%s-data:/var/lib/%s/data", e.Identity, e.Identity),
+		fmt.Sprintf("%s:%s", dockerSocket, dockerSocket))
+	opts.Env = []string{
+		fmt.Sprintf("TITAN_IDENTITY=%s", e.Identity),
+		fmt.Sprintf("TITAN_IMAGE=%s", e.Image),
+		fmt.Sprintf("TITAN_PORT=%d", e.Port),
 	}
-	args = append(args,
-		"-v", fmt.Sprintf("%s-data:/var/lib/%s/data", e.Identity, e.Identity),
-		"-v", "/var/run/docker.sock:/var/run/docker.sock",
-		"-e", fmt.Sprintf("TITAN_IDENTITY=%s", e.Identity),
-		"-e", fmt.Sprintf("TITAN_IMAGE=%s", e.Image),
-		"-e", fmt.Sprintf("TITAN_PORT=%d", e.Port),
-		e.Image, "/bin/bash", fmt.Sprintf("/titan/%s", entryPoint))
+	opts.Image = e.Image
+	opts.Command = []string{"/bin/bash", fmt.Sprintf("/titan/%s", entryPoint)}
 
-	return exec.Command("docker", args...).Run()
+	return e.Runtime.Run(opts)
 }
 
 /*
@@ -125,33 +165,30 @@ func (e *EndToEndTest) RunTitanKubernetes(entryPoint string, parameters ...strin
 		parameters = append(parameters, fmt.Sprintf("titanImage=%s", image))
 	}
 
-	args := []string{
-		"run", "-d", "--restart", "always", "--name", e.GetPrimaryContainer(),
-		"-v", fmt.Sprintf("%s/.kube:/root/.kube", e.HomeDir),
-		"-v", fmt.Sprintf("%s-data:/var/lib/%s", e.Identity, e.Identity),
-		"-e", "TITAN_CONTEXT=kubernetes-csi",
-		"-e", fmt.Sprintf("TITAN_IDENTITY=%s", e.Identity),
-		"-e", fmt.Sprintf("TITAN_CONFIG=%s", strings.Join(parameters, ",")),
-		"-p", fmt.Sprintf("%d:5001", e.Port), e.Image, "/bin/bash",
-		fmt.Sprintf("/titan/%s", entryPoint),
-	}
-
-	return exec.Command("docker", args...).Run()
+	return e.Runtime.Run(RunOptions{
+		Name:    e.GetPrimaryContainer(),
+		Detach:  true,
+		Restart: "always",
+		Volumes: []string{
+			fmt.Sprintf("%s/.kube:/root/.kube", e.HomeDir),
+			fmt.Sprintf("%s-data:/var/lib/%s", e.Identity, e.Identity),
+		},
+		Env: []string{
+			"TITAN_CONTEXT=kubernetes-csi",
+			fmt.Sprintf("TITAN_IDENTITY=%s", e.Identity),
+			fmt.Sprintf("TITAN_CONFIG=%s", strings.Join(parameters, ",")),
+		},
+		Ports:   []string{fmt.Sprintf("%d:5001", e.Port)},
+		Image:   e.Image,
+		Command: []string{"/bin/bash", fmt.Sprintf("/titan/%s", entryPoint)},
+	})
 }
 
 /*
- * Start the server depending on the context type.
+ * Start the server using the backend for the current context.
  */
 func (e *EndToEndTest) StartServer(parameters ...string) error {
-	err := exec.Command("docker", "volume", "create", fmt.Sprintf("%s-data", e.Identity)).Run()
-	if err != nil {
-		return err
-	}
-	if e.Context == "docker-zfs" {
-		return e.RunTitanDocker("launch", true)
-	} else {
-		return e.RunTitanKubernetes("run", parameters...)
-	}
+	return e.Backend.Start(parameters...)
 }
 
 /*
@@ -162,78 +199,47 @@ func (e *EndToEndTest) GetContainer(t string) string {
 }
 
 /*
- * Get the primary running container, either "launch" for docker-zfs or "server" to kubernetes.
+ * Get the primary running container, such as "launch" for docker-zfs or "server" to kubernetes.
  */
 func (e *EndToEndTest) GetPrimaryContainer() string {
-	var containerType string
-	if e.Context == "docker-zfs" {
-		containerType = "launch"
-	} else {
-		containerType = "server"
-	}
-	return e.GetContainer(containerType)
+	return e.Backend.PrimaryContainer()
 }
 
 /*
- * Wait for the server to be ready.
+ * Get the address at which the server container can reach services running on the local host, such as the fakes
+ * started by the tests. This is the gateway of the network the server container is attached to.
  */
-func (e *EndToEndTest) WaitForServer() error {
-	success := false
-	tried := 1
-	for ok := true; ok; ok = !success {
-		_, _, err := e.Client.RepositoriesApi.ListRepositories(context.Background())
-		if err == nil {
-			success = true
-		} else {
-			tried++
-			if tried == waitRetries {
-				logs, err := exec.Command("docker", "logs", e.GetPrimaryContainer()).CombinedOutput()
-				if err != nil {
-					return err
-				}
-				return errors.New(fmt.Sprintf("timeoed out waiting for server to start: %s", logs))
-			}
-			time.Sleep(time.Duration(waitTimeout) * time.Second)
-		}
+func (e *EndToEndTest) HostAddress() (string, error) {
+	out, err := e.Runtime.Inspect(e.GetContainer("server"), "{{range .NetworkSettings.Networks}}{{.Gateway}} {{end}}")
+	if err != nil {
+		return "", err
 	}
-	return nil
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", errors.New(fmt.Sprintf("no network gateway found for container %s", e.GetContainer("server")))
+	}
+	return fields[0], nil
+}
+
+/*
+ * Wait for the server to be ready. If the context has no deadline, we give up after a default timeout.
+ */
+func (e *EndToEndTest) WaitForServer(ctx context.Context) error {
+	return e.Backend.Wait(ctx)
 }
 
 /*
- * Restart the server. This only works for docker-zfs.
+ * Restart the server, preserving any persistent state.
  */
 func (e *EndToEndTest) RestartServer() error {
-	return exec.Command("docker", "rm", "-f", e.GetContainer("server")).Run()
+	return e.Backend.Restart()
 }
 
 /*
- * Stop the server completely, including the launch container for docker-zfs.
+ * Stop the server completely, including any persistent state.
  */
 func (e *EndToEndTest) StopServer(ignoreErrors bool) error {
-	if e.Context == "docker-zfs" {
-		err := exec.Command("docker", "rm", "-f", e.GetContainer("launch")).Run()
-		if err != nil && !ignoreErrors {
-			return err
-		}
-	}
-	err := exec.Command("docker", "rm", "-f", e.GetContainer("server")).Run()
-	if err != nil && !ignoreErrors {
-		return err
-	}
-
-	if e.Context == "docker-zfs" {
-		err = e.RunTitanDocker("teardown", false)
-		if err != nil && !ignoreErrors {
-			return err
-		}
-	}
-
-	err = exec.Command("docker", "volume", "rm", fmt.Sprintf("%s-data", e.Identity)).Run()
-	if err != nil && !ignoreErrors {
-		return err
-	}
-
-	return nil
+	return e.Backend.Stop(ignoreErrors)
 }
 
 /*
@@ -251,39 +257,21 @@ func (e *EndToEndTest) GetVolumePath(repo string, volume string) (string, error)
  * Execute a command on the server container.
  */
 func (e *EndToEndTest) ExecServer(args ...string) (string, error) {
-	fullArgs := []string{"exec", e.GetContainer("server")}
-	fullArgs = append(fullArgs, args...)
-	out, err := exec.Command("docker", fullArgs...).Output()
-	if err != nil {
-		return "", err
-	}
-	return string(out), nil
+	return e.Backend.Exec(args...)
 }
 
 /*
- * Write to a file, relative to a particular volume. This uses a very simplistic "echo", so it will fail for
- * complex inputs (such as those with quotes).
+ * Write to a file, relative to a particular volume.
  */
 func (e *EndToEndTest) WriteFile(repo string, volume string, filename string, content string) error {
-	mountpoint, err := e.GetVolumePath(repo, volume)
-	if err != nil {
-		return err
-	}
-	path := fmt.Sprintf("%s/%s", mountpoint, filename)
-	return exec.Command("docker", "exec", e.GetContainer("server"), "sh", "-c",
-		fmt.Sprintf("echo -n \"%s\" > %s", content, path)).Run()
+	return e.WriteFileBytes(repo, volume, filename, []byte(content))
 }
 
 /*
  * Read the contents of a file on the server.
  */
 func (e *EndToEndTest) ReadFile(repo string, volume string, filename string) (string, error) {
-	mountpoint, err := e.GetVolumePath(repo, volume)
-	if err != nil {
-		return "", err
-	}
-	path := fmt.Sprintf("%s/%s", mountpoint, filename)
-	out, err := exec.Command("docker", "exec", e.GetContainer("server"), "cat", path).Output()
+	out, err := e.ReadFileBytes(repo, volume, filename)
 	if err != nil {
 		return "", err
 	}
@@ -291,23 +279,23 @@ func (e *EndToEndTest) ReadFile(repo string, volume string, filename string) (st
 }
 
 /*
- * Check to see whether the given path exists on the server
+ * Check to see whether the given path exists on the server. Any failure to list it, including failing to run the
+ * command at all, is treated as the path not existing.
  */
 func (e *EndToEndTest) PathExists(path string) bool {
-	err := exec.Command("docker", "exec", e.GetContainer("server"), "ls", path).Run()
-	return err != nil
+	_, err := e.ExecServer("ls", path)
+	return err == nil
 }
 
 /*
  * Write to a file on the SSH server.
  */
 func (e *EndToEndTest) WriteFileSsh(path string, content string) error {
-	return exec.Command("docker", "exec", e.GetContainer("ssh"), "sh", "-c",
-		fmt.Sprintf("echo \"%s\" > %s", content, path)).Run()
+	return e.WriteFileBytesSsh(path, []byte(content))
 }
 
 func (e *EndToEndTest) ReadFileSsh(path string) (string, error) {
-	out, err := exec.Command("docker", "exec", e.GetContainer("ssh"), "cat", path).Output()
+	out, err := e.ReadFileBytesSsh(path)
 	if err != nil {
 		return "", err
 	}
@@ -315,78 +303,107 @@ func (e *EndToEndTest) ReadFileSsh(path string) (string, error) {
 }
 
 func (e *EndToEndTest) MkdirSsh(path string) error {
-	err := exec.Command("docker", "exec", e.GetContainer("ssh"), "mkdir", "-p", path).Run()
-	if err != nil {
-		return err
+	return os.MkdirAll(e.Ssh.Path(path), 0755)
+}
+
+/*
+ * Get the real path of a path on the SSH server, for use in remote definitions. The SSH server runs commands on the
+ * local host within its root directory, so the paths given to the ssh provider must include that root.
+ */
+func (e *EndToEndTest) SshPath(path string) string {
+	return e.Ssh.Path(path)
+}
+
+/*
+ * Addresses for services run by the tests that the server container needs to reach: loopback, for the tests
+ * themselves, and the gateway address through which the server container reaches the host if the server is running.
+ * Nothing run by the tests should listen on other interfaces, since that would expose it beyond this host.
+ */
+func (e *EndToEndTest) localAddresses(port int) []string {
+	addrs := []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}
+	if host, err := e.HostAddress(); err == nil && host != "127.0.0.1" {
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(port)))
 	}
-	return exec.Command("docker", "exec", e.GetContainer("ssh"), "chown", sshUser, path).Run()
+	return addrs
 }
 
+/*
+ * Start the SSH server on loopback, and on the gateway address through which the server container reaches the host if
+ * the server is running. It is never exposed on other interfaces, since it runs commands on this host. The password
+ * is generated for each run, and is available as e.Ssh.Password.
+ */
 func (e *EndToEndTest) StartSsh() error {
-	return exec.Command("docker", "run", "-p", fmt.Sprintf("%d:22", e.SshPort), "-d", "--name", e.GetContainer("ssh"),
-		"--network", e.Identity, "titandata/ssh-test-server:latest").Run()
+	password, err := NewSshPassword()
+	if err != nil {
+		return err
+	}
+	server, err := NewSshServer(sshUser, password)
+	if err != nil {
+		return err
+	}
+	err = server.Start(e.localAddresses(e.SshPort)...)
+	if err != nil {
+		_ = server.Stop()
+		return err
+	}
+	e.Ssh = server
+	return nil
 }
 
 func (e *EndToEndTest) StopSsh() error {
-	return exec.Command("docker", "rm", "-f", e.GetContainer("ssh")).Run()
+	if e.Ssh == nil {
+		return nil
+	}
+	err := e.Ssh.Stop()
+	e.Ssh = nil
+	return err
 }
 
-func (e *EndToEndTest) WaitForSsh() error {
-	success := false
-	tried := 1
-	for ok := true; ok; ok = !success {
+func (e *EndToEndTest) WaitForSsh(ctx context.Context) error {
+	ctx, cancel := withDefaultDeadline(ctx, serverWaitTimeout)
+	defer cancel()
+	err := Poll(ctx, "SSH server to start", waitInterval, func(ctx context.Context) (bool, interface{}, error) {
 		sshConfig := &ssh.ClientConfig{
 			User:            sshUser,
 			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 			Auth: []ssh.AuthMethod{
-				ssh.Password(sshPassword),
+				ssh.Password(e.Ssh.Password),
 			},
 		}
-		connection, err := ssh.Dial("tcp", fmt.Sprintf("localhost:%d", e.SshPort), sshConfig)
-		if err == nil {
-			session, err := connection.NewSession()
-			if err == nil {
-				success = true
-				_ = session.Close()
-			}
-			_ = connection.Close()
+		connection, err := ssh.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", e.SshPort), sshConfig)
+		if err != nil {
+			return false, err, nil
 		}
-
-		if !success {
-			tried++
-			if tried == waitRetries {
-				logs, err := exec.Command("docker", "logs", e.GetContainer("test-ssh")).CombinedOutput()
-				if err != nil {
-					return err
-				}
-				return errors.New(fmt.Sprintf("timed out waiting for SSH server to start: %s", logs))
-			}
-			time.Sleep(time.Duration(waitTimeout) * time.Second)
+		defer connection.Close()
+		session, err := connection.NewSession()
+		if err != nil {
+			return false, err, nil
 		}
-	}
-
-	out, err := exec.Command("docker", "inspect", "-f", "{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}",
-		e.GetContainer("ssh")).Output()
+		_ = session.Close()
+		return true, nil, nil
+	})
 	if err != nil {
 		return err
 	}
-	e.SshHost = string(out)
-	return nil
+
+	e.SshHost, err = e.HostAddress()
+	return err
 }
 
 func (e *EndToEndTest) SetupStandardDocker() {
-	_ = e.StopServer(true)
 	err := e.StartServer()
 	if err != nil {
 		panic(err)
 	}
-	err = e.WaitForServer()
+	err = e.WaitForServer(context.Background())
 	if err != nil {
 		panic(err)
 	}
 }
 
 func (e *EndToEndTest) TeardownStandardDocker() {
+	e.CollectDiagnosticsOnFailure()
+	_ = e.Cleanup(context.Background())
 	_ = e.StopServer(false)
 }
 
@@ -396,7 +413,7 @@ func (e *EndToEndTest) SetupStandardSsh() {
 	if err != nil {
 		panic(err)
 	}
-	err = e.WaitForSsh()
+	err = e.WaitForSsh(context.Background())
 	if err != nil {
 		panic(err)
 	}
@@ -433,69 +450,51 @@ func (e *EndToEndTest) GetTag(commit titan.Commit, tag string) string {
 	return ""
 }
 
-func (e *EndToEndTest) WaitForOperation(id string) ([]titan.ProgressEntry, error) {
-	completed := false
-	var lastEntry int32 = 0
-	result := []titan.ProgressEntry{}
-	for ok := true; ok; ok = !completed {
-		progress, _, err := e.Client.OperationsApi.GetOperationProgress(context.Background(), id,
-			&titan.GetOperationProgressOpts{LastId: optional.NewInt32(lastEntry)})
-		if err != nil {
-			return nil, err
-		}
-		result = append(result, progress...)
-		for _, p := range progress {
-			switch p.Type {
-			case "COMPLETE":
-				completed = true
-			case "ABORT":
-				return result, errors.New(fmt.Sprintf("operation aborted: %s", p.Message))
-			case "FAILED":
-				return result, errors.New(fmt.Sprintf("operation failed: %s", p.Message))
-			}
-			if p.Id > lastEntry {
-				lastEntry = p.Id
-			}
-		}
-		if !completed {
-			time.Sleep(time.Duration(500) * time.Millisecond)
-		}
-	}
-	return result, nil
+/*
+ * Wait for an operation to complete, returning all progress entries seen. An error is returned if the operation
+ * fails or is aborted, in which case the progress up to that point is still returned. Use WatchOperation to track
+ * phases and percentages as well.
+ */
+func (e *EndToEndTest) WaitForOperation(ctx context.Context, id string) ([]titan.ProgressEntry, error) {
+	w := NewProgressWatcher()
+	err := e.WatchOperation(ctx, id, w)
+	return w.Entries(), err
 }
 
-func (e *EndToEndTest) WaitForVolume(repo string, volume string) error {
-	ready := false
-	for ok := true; ok; ok = !ready {
-		res, _, err := e.VolumeApi.GetVolumeStatus(context.Background(), repo, volume)
-		if err != nil {
-			return err
-		}
-		if res.Error != "" {
-			return errors.New(res.Error)
-		}
-		ready = res.Ready
-		if !ready {
-			time.Sleep(time.Duration(1) * time.Second)
-		}
-	}
-	return nil
+/*
+ * Wait for a volume to be ready, failing if the server reports an error creating it.
+ */
+func (e *EndToEndTest) WaitForVolume(ctx context.Context, repo string, volume string) error {
+	ctx, cancel := withDefaultDeadline(ctx, defaultWaitTimeout)
+	defer cancel()
+	return Poll(ctx, fmt.Sprintf("volume %s/%s", repo, volume), waitInterval,
+		func(ctx context.Context) (bool, interface{}, error) {
+			res, _, err := e.VolumeApi.GetVolumeStatus(ctx, repo, volume)
+			if err != nil {
+				return false, nil, err
+			}
+			if res.Error != "" {
+				return false, res, errors.New(res.Error)
+			}
+			return res.Ready, res, nil
+		})
 }
 
-func (e *EndToEndTest) WaitForCommit(repo string, id string) error {
-	ready := false
-	for ok := true; ok; ok = !ready {
-		res, _, err := e.CommitApi.GetCommitStatus(context.Background(), repo, id)
-		if err != nil {
-			return err
-		}
-		if res.Error != "" {
-			return errors.New(res.Error)
-		}
-		ready = res.Ready
-		if !ready {
-			time.Sleep(time.Duration(1) * time.Second)
-		}
-	}
-	return nil
+/*
+ * Wait for a commit to be ready, failing if the server reports an error creating it.
+ */
+func (e *EndToEndTest) WaitForCommit(ctx context.Context, repo string, id string) error {
+	ctx, cancel := withDefaultDeadline(ctx, defaultWaitTimeout)
+	defer cancel()
+	return Poll(ctx, fmt.Sprintf("commit %s/%s", repo, id), waitInterval,
+		func(ctx context.Context) (bool, interface{}, error) {
+			res, _, err := e.CommitApi.GetCommitStatus(ctx, repo, id)
+			if err != nil {
+				return false, nil, err
+			}
+			if res.Error != "" {
+				return false, res, errors.New(res.Error)
+			}
+			return res.Ready, res, nil
+		})
 }
@@ -0,0 +1,1030 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fakeS3Region = "us-east-1"
+
+/*
+ * In-process stand-in for S3, so that the s3 remote can be tested without AWS credentials or network access. It
+ * supports the subset of the API used by the s3 provider and by the tests themselves: bucket creation, object
+ * get/head/put/copy/delete (with user metadata), multi-object delete, multipart uploads, and V1 and V2 listing.
+ *
+ * Both path-style (http://host/bucket/key) and virtual-host style (http://bucket.host/key) requests are accepted.
+ * Requests must be signed with the fake's access key, though signatures themselves aren't verified. Chunked
+ * (streaming) payload signing, as used by the Java SDK over plain HTTP, is decoded transparently.
+ */
+type FakeS3 struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+
+	lock      sync.Mutex
+	requests  int
+	buckets   map[string]map[string]*fakeS3Object
+	uploads   map[string]*fakeS3Upload
+	listeners []net.Listener
+	server    *http.Server
+}
+
+type fakeS3Object struct {
+	data         []byte
+	etag         string
+	contentType  string
+	metadata     map[string]string
+	lastModified time.Time
+}
+
+type fakeS3Upload struct {
+	bucket      string
+	key         string
+	contentType string
+	metadata    map[string]string
+	parts       map[int][]byte
+}
+
+type fakeS3Error struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *fakeS3Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.code, e.message)
+}
+
+func NewFakeS3() *FakeS3 {
+	secret := make([]byte, 20)
+	_, _ = rand.Read(secret)
+	return &FakeS3{
+		AccessKey: "TITANFAKEACCESSKEY",
+		SecretKey: hex.EncodeToString(secret),
+		Region:    fakeS3Region,
+		buckets:   map[string]map[string]*fakeS3Object{},
+		uploads:   map[string]*fakeS3Upload{},
+	}
+}
+
+/*
+ * Start serving on the given addresses, such as "127.0.0.1:0" for a dynamically allocated port on loopback. If there
+ * are several, the port picked for the first is used for all of them (see listenTcp).
+ */
+func (f *FakeS3) Start(addrs ...string) error {
+	listeners, err := listenTcp(addrs...)
+	if err != nil {
+		return err
+	}
+	f.listeners = listeners
+	f.server = &http.Server{Handler: f}
+	for _, l := range listeners {
+		l := l
+		go func() {
+			_ = f.server.Serve(l)
+		}()
+	}
+	return nil
+}
+
+func (f *FakeS3) Stop() error {
+	if f.server == nil {
+		return nil
+	}
+	return f.server.Close()
+}
+
+func (f *FakeS3) Port() int {
+	return f.listeners[0].Addr().(*net.TCPAddr).Port
+}
+
+/*
+ * Endpoint for use by clients running on the local host, such as the tests themselves.
+ */
+func (f *FakeS3) URL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", f.Port())
+}
+
+/*
+ * Start a fake S3 server with the given bucket, returning the endpoint at which the titan server can reach it. The
+ * server must already be running so that its network gateway can be determined, and the fake only listens on that
+ * and loopback.
+ */
+func (e *EndToEndTest) StartFakeS3(bucket string) (*FakeS3, string, error) {
+	host, err := e.HostAddress()
+	if err != nil {
+		return nil, "", err
+	}
+	fake := NewFakeS3()
+	err = fake.Start(e.localAddresses(0)...)
+	if err != nil {
+		return nil, "", err
+	}
+	fake.CreateBucket(bucket)
+	return fake, fmt.Sprintf("http://%s:%d", host, fake.Port()), nil
+}
+
+func (f *FakeS3) CreateBucket(name string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.buckets[name]; !ok {
+		f.buckets[name] = map[string]*fakeS3Object{}
+	}
+}
+
+/*
+ * Get the contents of an object directly, bypassing the API.
+ */
+func (f *FakeS3) GetObject(bucket string, key string) ([]byte, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if b, ok := f.buckets[bucket]; ok {
+		if obj, ok := b[key]; ok {
+			return obj.data, true
+		}
+	}
+	return nil, false
+}
+
+/*
+ * Store an object directly, bypassing the API. The bucket is created if it doesn't exist.
+ */
+func (f *FakeS3) PutObject(bucket string, key string, data []byte, metadata map[string]string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.buckets[bucket]; !ok {
+		f.buckets[bucket] = map[string]*fakeS3Object{}
+	}
+	f.buckets[bucket][key] = &fakeS3Object{
+		data:         data,
+		etag:         md5Hex(data),
+		contentType:  "application/octet-stream",
+		metadata:     metadata,
+		lastModified: time.Now(),
+	}
+}
+
+/*
+ * Get all keys within a bucket, in sorted order.
+ */
+func (f *FakeS3) Keys(bucket string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.sortedKeys(bucket)
+}
+
+func (f *FakeS3) sortedKeys(bucket string) []string {
+	ret := []string{}
+	for k := range f.buckets[bucket] {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+/*
+ * Number of requests received, whether or not they succeeded.
+ */
+func (f *FakeS3) Requests() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.requests
+}
+
+func (f *FakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	f.requests++
+	f.lock.Unlock()
+	err := f.authorize(r)
+	if err == nil {
+		bucket, key := f.parseTarget(r)
+		err = f.handle(w, r, bucket, key)
+	}
+	if err != nil {
+		s3err, ok := err.(*fakeS3Error)
+		if !ok {
+			s3err = &fakeS3Error{http.StatusInternalServerError, "InternalError", err.Error()}
+		}
+		writeS3Error(w, r, s3err)
+	}
+}
+
+/*
+ * Check that the request was signed with our access key, either through the Authorization header or through the
+ * query parameters of a presigned URL.
+ */
+func (f *FakeS3) authorize(r *http.Request) error {
+	credential := ""
+	auth := r.Header.Get("Authorization")
+	if idx := strings.Index(auth, "Credential="); idx != -1 {
+		credential = auth[idx+len("Credential="):]
+	} else if strings.HasPrefix(auth, "AWS ") {
+		credential = strings.TrimPrefix(auth, "AWS ")
+		if idx := strings.Index(credential, ":"); idx != -1 {
+			credential = credential[:idx]
+		}
+	} else {
+		credential = r.URL.Query().Get("X-Amz-Credential")
+	}
+	if credential == "" {
+		return &fakeS3Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	}
+	if idx := strings.Index(credential, "/"); idx != -1 {
+		credential = credential[:idx]
+	}
+	if credential != f.AccessKey {
+		return &fakeS3Error{http.StatusForbidden, "InvalidAccessKeyId",
+			"The AWS Access Key Id you provided does not exist in our records."}
+	}
+	return nil
+}
+
+/*
+ * Determine the bucket and key of a request, trying virtual-host style first (if the host name starts with the name
+ * of an existing bucket), and falling back to path-style.
+ */
+func (f *FakeS3) parseTarget(r *http.Request) (string, string) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	f.lock.Lock()
+	for name := range f.buckets {
+		if strings.HasPrefix(host, name+".") {
+			f.lock.Unlock()
+			return name, strings.TrimPrefix(r.URL.Path, "/")
+		}
+	}
+	f.lock.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if idx := strings.Index(path, "/"); idx != -1 {
+		return path[:idx], path[idx+1:]
+	}
+	return path, ""
+}
+
+func (f *FakeS3) handle(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	query := r.URL.Query()
+	if bucket == "" {
+		return &fakeS3Error{http.StatusNotImplemented, "NotImplemented", "listing buckets is not supported"}
+	}
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodPut:
+			f.CreateBucket(bucket)
+			w.WriteHeader(http.StatusOK)
+			return nil
+		case http.MethodHead:
+			f.lock.Lock()
+			_, err := f.bucket(bucket)
+			f.lock.Unlock()
+			return err
+		case http.MethodGet:
+			if _, ok := query["location"]; ok {
+				return writeS3Xml(w, http.StatusOK, &s3LocationConstraint{})
+			}
+			if query.Get("list-type") == "2" {
+				return f.listObjectsV2(w, bucket, query)
+			}
+			return f.listObjectsV1(w, bucket, query)
+		case http.MethodPost:
+			if _, ok := query["delete"]; ok {
+				return f.deleteObjects(w, r, bucket)
+			}
+		}
+		return &fakeS3Error{http.StatusNotImplemented, "NotImplemented", "unsupported bucket operation"}
+	}
+
+	uploadId := query.Get("uploadId")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return f.getObject(w, r, bucket, key)
+	case http.MethodPut:
+		if uploadId != "" {
+			return f.uploadPart(w, r, uploadId, query.Get("partNumber"))
+		}
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			return f.copyObject(w, r, bucket, key)
+		}
+		return f.putObject(w, r, bucket, key)
+	case http.MethodPost:
+		if _, ok := query["uploads"]; ok {
+			return f.createMultipartUpload(w, r, bucket, key)
+		}
+		if uploadId != "" {
+			return f.completeMultipartUpload(w, r, uploadId)
+		}
+	case http.MethodDelete:
+		if uploadId != "" {
+			return f.abortMultipartUpload(w, uploadId)
+		}
+		return f.deleteObject(w, bucket, key)
+	}
+	return &fakeS3Error{http.StatusNotImplemented, "NotImplemented", "unsupported object operation"}
+}
+
+/*
+ * Get the objects in a bucket. Must be called with the lock held.
+ */
+func (f *FakeS3) bucket(name string) (map[string]*fakeS3Object, error) {
+	b, ok := f.buckets[name]
+	if !ok {
+		return nil, &fakeS3Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	}
+	return b, nil
+}
+
+func (f *FakeS3) getObject(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	f.lock.Lock()
+	b, err := f.bucket(bucket)
+	var obj *fakeS3Object
+	if err == nil {
+		obj = b[key]
+	}
+	f.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return &fakeS3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	}
+
+	writeObjectHeaders(w, obj)
+	data := obj.data
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, end, ok := parseRange(rng, int64(len(data)))
+		if !ok {
+			return &fakeS3Error{http.StatusRequestedRangeNotSatisfiable, "InvalidRange",
+				"The requested range is not satisfiable"}
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+	return nil
+}
+
+func writeObjectHeaders(w http.ResponseWriter, obj *fakeS3Object) {
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", obj.etag))
+	w.Header().Set("Last-Modified", obj.lastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if obj.contentType != "" {
+		w.Header().Set("Content-Type", obj.contentType)
+	}
+	for k, v := range obj.metadata {
+		w.Header()["x-amz-meta-"+k] = []string{v}
+	}
+}
+
+/*
+ * Parse a single byte range of the form "bytes=start-end", "bytes=start-", or "bytes=-suffix".
+ */
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	var start, end int64
+	var err error
+	if parts[0] == "" {
+		suffix, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size - 1, size > 0
+	}
+	start, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end = size - 1
+	if parts[1] != "" {
+		end, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return start, end, start <= end && start < size
+}
+
+/*
+ * Read the body of a request, decoding it if it uses streaming (aws-chunked) signatures.
+ */
+func readS3Body(r *http.Request) ([]byte, error) {
+	if r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" &&
+		!strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return ioutil.ReadAll(r.Body)
+	}
+	return decodeAwsChunked(r.Body)
+}
+
+/*
+ * Decode a body of the form "<hex size>;chunk-signature=<sig>\r\n<data>\r\n", terminated by a zero-length chunk.
+ */
+func decodeAwsChunked(r io.Reader) ([]byte, error) {
+	reader := bufio.NewReader(r)
+	var ret bytes.Buffer
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		header = strings.TrimSpace(header)
+		if idx := strings.Index(header, ";"); idx != -1 {
+			header = header[:idx]
+		}
+		size, err := strconv.ParseInt(header, 16, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid chunk header '%s'", header))
+		}
+		if size == 0 {
+			return ret.Bytes(), nil
+		}
+		_, err = io.CopyN(&ret, reader, size)
+		if err != nil {
+			return nil, err
+		}
+		_, err = reader.Discard(2)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func requestMetadata(r *http.Request) map[string]string {
+	ret := map[string]string{}
+	for k, v := range r.Header {
+		lower := strings.ToLower(k)
+		if strings.HasPrefix(lower, "x-amz-meta-") && len(v) != 0 {
+			ret[strings.TrimPrefix(lower, "x-amz-meta-")] = v[0]
+		}
+	}
+	return ret
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (f *FakeS3) putObject(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	data, err := readS3Body(r)
+	if err != nil {
+		return err
+	}
+	obj := &fakeS3Object{
+		data:         data,
+		etag:         md5Hex(data),
+		contentType:  r.Header.Get("Content-Type"),
+		metadata:     requestMetadata(r),
+		lastModified: time.Now(),
+	}
+	f.lock.Lock()
+	b, err := f.bucket(bucket)
+	if err == nil {
+		b[key] = obj
+	}
+	f.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", obj.etag))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (f *FakeS3) copyObject(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		return err
+	}
+	if idx := strings.Index(source, "?"); idx != -1 {
+		source = source[:idx]
+	}
+	idx := strings.Index(source, "/")
+	if idx == -1 {
+		return &fakeS3Error{http.StatusBadRequest, "InvalidArgument", "invalid copy source"}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	src, err := f.bucket(source[:idx])
+	if err != nil {
+		return err
+	}
+	srcObj, ok := src[source[idx+1:]]
+	if !ok {
+		return &fakeS3Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist."}
+	}
+	dst, err := f.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	obj := &fakeS3Object{
+		data:         srcObj.data,
+		etag:         srcObj.etag,
+		contentType:  srcObj.contentType,
+		metadata:     srcObj.metadata,
+		lastModified: time.Now(),
+	}
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		obj.metadata = requestMetadata(r)
+		obj.contentType = r.Header.Get("Content-Type")
+	}
+	dst[key] = obj
+	return writeS3Xml(w, http.StatusOK, &s3CopyObjectResult{
+		LastModified: obj.lastModified.UTC().Format(s3TimeFormat),
+		ETag:         fmt.Sprintf("\"%s\"", obj.etag),
+	})
+}
+
+func (f *FakeS3) deleteObject(w http.ResponseWriter, bucket string, key string) error {
+	f.lock.Lock()
+	b, err := f.bucket(bucket)
+	if err == nil {
+		delete(b, key)
+	}
+	f.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (f *FakeS3) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	req := s3Delete{}
+	err = xml.Unmarshal(body, &req)
+	if err != nil {
+		return &fakeS3Error{http.StatusBadRequest, "MalformedXML", err.Error()}
+	}
+	if len(req.Objects) > 1000 {
+		return &fakeS3Error{http.StatusBadRequest, "MalformedXML", "too many objects to delete"}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	b, err := f.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	res := s3DeleteResult{}
+	for _, obj := range req.Objects {
+		delete(b, obj.Key)
+		if !req.Quiet {
+			res.Deleted = append(res.Deleted, s3DeletedObject{Key: obj.Key})
+		}
+	}
+	return writeS3Xml(w, http.StatusOK, &res)
+}
+
+func (f *FakeS3) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) error {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	uploadId := hex.EncodeToString(id)
+
+	f.lock.Lock()
+	_, err := f.bucket(bucket)
+	if err == nil {
+		f.uploads[uploadId] = &fakeS3Upload{
+			bucket:      bucket,
+			key:         key,
+			contentType: r.Header.Get("Content-Type"),
+			metadata:    requestMetadata(r),
+			parts:       map[int][]byte{},
+		}
+	}
+	f.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeS3Xml(w, http.StatusOK, &s3InitiateMultipartUploadResult{Bucket: bucket, Key: key, UploadId: uploadId})
+}
+
+func (f *FakeS3) uploadPart(w http.ResponseWriter, r *http.Request, uploadId string, partNumber string) error {
+	number, err := strconv.Atoi(partNumber)
+	if err != nil || number < 1 || number > 10000 {
+		return &fakeS3Error{http.StatusBadRequest, "InvalidArgument", "invalid part number"}
+	}
+	data, err := readS3Body(r)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	upload, ok := f.uploads[uploadId]
+	if ok {
+		upload.parts[number] = data
+	}
+	f.lock.Unlock()
+	if !ok {
+		return &fakeS3Error{http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist."}
+	}
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", md5Hex(data)))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+/*
+ * Complete a multipart upload, assembling the listed parts in order. As with S3, the ETag of the resulting object is
+ * the MD5 of the concatenated part digests, followed by the number of parts.
+ */
+func (f *FakeS3) completeMultipartUpload(w http.ResponseWriter, r *http.Request, uploadId string) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	req := s3CompleteMultipartUpload{}
+	err = xml.Unmarshal(body, &req)
+	if err != nil {
+		return &fakeS3Error{http.StatusBadRequest, "MalformedXML", err.Error()}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	upload, ok := f.uploads[uploadId]
+	if !ok {
+		return &fakeS3Error{http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist."}
+	}
+	b, err := f.bucket(upload.bucket)
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	var digests bytes.Buffer
+	last := 0
+	for _, part := range req.Parts {
+		content, ok := upload.parts[part.PartNumber]
+		if !ok || part.PartNumber <= last || strings.Trim(part.ETag, "\"") != md5Hex(content) {
+			return &fakeS3Error{http.StatusBadRequest, "InvalidPart",
+				fmt.Sprintf("part %d is missing, out of order, or has the wrong ETag", part.PartNumber)}
+		}
+		last = part.PartNumber
+		data.Write(content)
+		sum := md5.Sum(content)
+		digests.Write(sum[:])
+	}
+
+	obj := &fakeS3Object{
+		data:         data.Bytes(),
+		etag:         fmt.Sprintf("%s-%d", md5Hex(digests.Bytes()), len(req.Parts)),
+		contentType:  upload.contentType,
+		metadata:     upload.metadata,
+		lastModified: time.Now(),
+	}
+	b[upload.key] = obj
+	delete(f.uploads, uploadId)
+	return writeS3Xml(w, http.StatusOK, &s3CompleteMultipartUploadResult{
+		Location: fmt.Sprintf("/%s/%s", upload.bucket, upload.key),
+		Bucket:   upload.bucket,
+		Key:      upload.key,
+		ETag:     fmt.Sprintf("\"%s\"", obj.etag),
+	})
+}
+
+func (f *FakeS3) abortMultipartUpload(w http.ResponseWriter, uploadId string) error {
+	f.lock.Lock()
+	_, ok := f.uploads[uploadId]
+	delete(f.uploads, uploadId)
+	f.lock.Unlock()
+	if !ok {
+		return &fakeS3Error{http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist."}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+/*
+ * Common listing logic for both versions of the API. Keys after 'after' that match the prefix are returned in order,
+ * with those containing the delimiter (after the prefix) rolled up into common prefixes. Both keys and common
+ * prefixes count towards the maximum. Returns the objects, the common prefixes, whether the results were truncated,
+ * and if so the last key or prefix returned. As with S3, a maximum of zero returns nothing, but is truncated if any
+ * keys match.
+ */
+func (f *FakeS3) list(bucket string, prefix string, delimiter string, after string,
+	maxKeys int) ([]s3ListObject, []string, bool, string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	b, err := f.bucket(bucket)
+	if err != nil {
+		return nil, nil, false, "", err
+	}
+
+	objects := []s3ListObject{}
+	prefixes := []string{}
+	seen := map[string]bool{}
+	last := ""
+	for _, key := range f.sortedKeys(bucket) {
+		if key <= after || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		common := ""
+		if delimiter != "" {
+			if idx := strings.Index(key[len(prefix):], delimiter); idx != -1 {
+				common = key[:len(prefix)+idx+len(delimiter)]
+			}
+		}
+		// A page that ends on a common prefix uses it as the marker, and the keys beneath it have been rolled up
+		if common != "" && (seen[common] || common == after) {
+			continue
+		}
+		if len(objects)+len(prefixes) == maxKeys {
+			return objects, prefixes, true, last, nil
+		}
+		if common != "" {
+			seen[common] = true
+			prefixes = append(prefixes, common)
+			last = common
+		} else {
+			obj := b[key]
+			objects = append(objects, s3ListObject{
+				Key:          key,
+				LastModified: obj.lastModified.UTC().Format(s3TimeFormat),
+				ETag:         fmt.Sprintf("\"%s\"", obj.etag),
+				Size:         int64(len(obj.data)),
+				StorageClass: "STANDARD",
+			})
+			last = key
+		}
+	}
+	return objects, prefixes, false, "", nil
+}
+
+func maxKeysParameter(query url.Values) (int, error) {
+	maxKeys := 1000
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, &fakeS3Error{http.StatusBadRequest, "InvalidArgument", "invalid max-keys"}
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	return maxKeys, nil
+}
+
+/*
+ * Encode a key in list results if the client asked for URL encoding, as the Java SDK does by default.
+ */
+func listEncoder(query url.Values) func(string) string {
+	if query.Get("encoding-type") != "url" {
+		return func(s string) string { return s }
+	}
+	return func(s string) string {
+		return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+	}
+}
+
+func commonPrefixes(prefixes []string, encode func(string) string) []s3CommonPrefix {
+	ret := []s3CommonPrefix{}
+	for _, p := range prefixes {
+		ret = append(ret, s3CommonPrefix{Prefix: encode(p)})
+	}
+	return ret
+}
+
+func (f *FakeS3) listObjectsV1(w http.ResponseWriter, bucket string, query url.Values) error {
+	maxKeys, err := maxKeysParameter(query)
+	if err != nil {
+		return err
+	}
+	prefix, delimiter, marker := query.Get("prefix"), query.Get("delimiter"), query.Get("marker")
+	objects, prefixes, truncated, next, err := f.list(bucket, prefix, delimiter, marker, maxKeys)
+	if err != nil {
+		return err
+	}
+	encode := listEncoder(query)
+	for i := range objects {
+		objects[i].Key = encode(objects[i].Key)
+	}
+	res := s3ListBucketResult{
+		Name:           bucket,
+		Prefix:         encode(prefix),
+		Marker:         encode(marker),
+		MaxKeys:        maxKeys,
+		Delimiter:      encode(delimiter),
+		IsTruncated:    truncated,
+		Contents:       objects,
+		CommonPrefixes: commonPrefixes(prefixes, encode),
+		EncodingType:   query.Get("encoding-type"),
+	}
+	if next != "" {
+		res.NextMarker = encode(next)
+	}
+	return writeS3Xml(w, http.StatusOK, &res)
+}
+
+/*
+ * List objects using the V2 API. Continuation tokens are simply the last key returned, hex encoded so that clients
+ * treat them as opaque.
+ */
+func (f *FakeS3) listObjectsV2(w http.ResponseWriter, bucket string, query url.Values) error {
+	maxKeys, err := maxKeysParameter(query)
+	if err != nil {
+		return err
+	}
+	after := query.Get("start-after")
+	token := query.Get("continuation-token")
+	if token != "" {
+		decoded, err := hex.DecodeString(token)
+		if err != nil {
+			return &fakeS3Error{http.StatusBadRequest, "InvalidArgument", "invalid continuation token"}
+		}
+		after = string(decoded)
+	}
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	objects, prefixes, truncated, next, err := f.list(bucket, prefix, delimiter, after, maxKeys)
+	if err != nil {
+		return err
+	}
+	encode := listEncoder(query)
+	for i := range objects {
+		objects[i].Key = encode(objects[i].Key)
+	}
+	res := s3ListBucketV2Result{
+		Name:              bucket,
+		Prefix:            encode(prefix),
+		StartAfter:        encode(query.Get("start-after")),
+		ContinuationToken: token,
+		MaxKeys:           maxKeys,
+		KeyCount:          len(objects) + len(prefixes),
+		Delimiter:         encode(delimiter),
+		IsTruncated:       truncated,
+		Contents:          objects,
+		CommonPrefixes:    commonPrefixes(prefixes, encode),
+		EncodingType:      query.Get("encoding-type"),
+	}
+	if next != "" {
+		res.NextContinuationToken = hex.EncodeToString([]byte(next))
+	}
+	return writeS3Xml(w, http.StatusOK, &res)
+}
+
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+type s3ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestId string   `xml:"RequestId"`
+}
+
+type s3LocationConstraint struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+}
+
+type s3ListObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3ListBucketResult struct {
+	XMLName        xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name           string           `xml:"Name"`
+	Prefix         string           `xml:"Prefix"`
+	Marker         string           `xml:"Marker"`
+	NextMarker     string           `xml:"NextMarker,omitempty"`
+	MaxKeys        int              `xml:"MaxKeys"`
+	Delimiter      string           `xml:"Delimiter,omitempty"`
+	EncodingType   string           `xml:"EncodingType,omitempty"`
+	IsTruncated    bool             `xml:"IsTruncated"`
+	Contents       []s3ListObject   `xml:"Contents"`
+	CommonPrefixes []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type s3ListBucketV2Result struct {
+	XMLName               xml.Name         `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int              `xml:"KeyCount"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Contents              []s3ListObject   `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type s3CopyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+type s3Delete struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type s3DeletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type s3DeleteResult struct {
+	XMLName xml.Name          `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []s3DeletedObject `xml:"Deleted"`
+}
+
+type s3InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadId string   `xml:"UploadId"`
+}
+
+type s3CompleteMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type s3CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func writeS3Xml(w http.ResponseWriter, status int, v interface{}) error {
+	content, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(content)))
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(content)
+	return nil
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, err *fakeS3Error) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(err.status)
+		return
+	}
+	_ = writeS3Xml(w, err.status, &s3ErrorResponse{
+		Code:      err.code,
+		Message:   err.message,
+		Resource:  r.URL.Path,
+		RequestId: "titan-fake-s3",
+	})
+}
@@ -0,0 +1,291 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func startFakeS3(t *testing.T) (*FakeS3, *s3.S3) {
+	fake := NewFakeS3()
+	if !assert.NoError(t, fake.Start("127.0.0.1:0")) {
+		t.FailNow()
+	}
+	fake.CreateBucket("bucket")
+	return fake, fakeS3Client(fake, fake.AccessKey)
+}
+
+func fakeS3Client(fake *FakeS3, accessKey string) *s3.S3 {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials(accessKey, fake.SecretKey, ""),
+		Endpoint:         aws.String(fake.URL()),
+		Region:           aws.String(fake.Region),
+		S3ForcePathStyle: aws.Bool(true),
+	}))
+	return s3.New(sess)
+}
+
+func TestFakeS3PutGet(t *testing.T) {
+	fake, svc := startFakeS3(t)
+	defer fake.Stop()
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("path/id"),
+		Body:     bytes.NewReader(binaryContent),
+		Metadata: map[string]*string{"Io.titan-Data": aws.String("{\"a\":\"b\"}")},
+	})
+	if assert.NoError(t, err) {
+		res, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("path/id")})
+		if assert.NoError(t, err) {
+			content, _ := ioutil.ReadAll(res.Body)
+			assert.Equal(t, binaryContent, content)
+			assert.Equal(t, "{\"a\":\"b\"}", aws.StringValue(res.Metadata["Io.titan-Data"]))
+		}
+
+		res, err = svc.GetObject(&s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("path/id"),
+			Range: aws.String("bytes=0-4")})
+		if assert.NoError(t, err) {
+			content, _ := ioutil.ReadAll(res.Body)
+			assert.Equal(t, binaryContent[:5], content)
+		}
+	}
+
+	_, err = svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("path/none")})
+	assert.Error(t, err)
+	_, err = svc.GetObject(&s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("path/none")})
+	if assert.Error(t, err) {
+		assert.Equal(t, "NoSuchKey", err.(awserr.Error).Code())
+	}
+}
+
+func TestFakeS3CopyReplaceMetadata(t *testing.T) {
+	fake, svc := startFakeS3(t)
+	defer fake.Stop()
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("id"),
+		Body:     strings.NewReader(""),
+		Metadata: map[string]*string{"Tags": aws.String("old")},
+	})
+	if assert.NoError(t, err) {
+		_, err = svc.CopyObject(&s3.CopyObjectInput{
+			Bucket:            aws.String("bucket"),
+			Key:               aws.String("id"),
+			CopySource:        aws.String("bucket/id"),
+			Metadata:          map[string]*string{"Tags": aws.String("new")},
+			MetadataDirective: aws.String("REPLACE"),
+		})
+		if assert.NoError(t, err) {
+			res, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("id")})
+			if assert.NoError(t, err) {
+				assert.Equal(t, "new", aws.StringValue(res.Metadata["Tags"]))
+			}
+		}
+	}
+}
+
+func TestFakeS3ListPagination(t *testing.T) {
+	fake, svc := startFakeS3(t)
+	defer fake.Stop()
+
+	for i := 0; i < 25; i++ {
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String(fmt.Sprintf("path/%02d/data", i)),
+			Body:   strings.NewReader("x"),
+		})
+		assert.NoError(t, err)
+	}
+	_, _ = svc.PutObject(&s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("other"),
+		Body: strings.NewReader("x")})
+
+	keys := []string{}
+	pages := 0
+	err := svc.ListObjectsPages(&s3.ListObjectsInput{Bucket: aws.String("bucket"), Prefix: aws.String("path/"),
+		MaxKeys: aws.Int64(10)}, func(res *s3.ListObjectsOutput, last bool) bool {
+		pages++
+		for _, obj := range res.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, pages)
+		assert.Len(t, keys, 25)
+		assert.Equal(t, "path/00/data", keys[0])
+	}
+
+	keys = []string{}
+	pages = 0
+	err = svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String("bucket"), Prefix: aws.String("path/"),
+		MaxKeys: aws.Int64(10)}, func(res *s3.ListObjectsV2Output, last bool) bool {
+		pages++
+		for _, obj := range res.Contents {
+			keys = append(keys, aws.StringValue(obj.Key))
+		}
+		return true
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, pages)
+		assert.Len(t, keys, 25)
+	}
+
+	res, err := svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String("bucket"), Prefix: aws.String("path/"),
+		Delimiter: aws.String("/")})
+	if assert.NoError(t, err) {
+		assert.Len(t, res.Contents, 0)
+		assert.Len(t, res.CommonPrefixes, 25)
+		assert.Equal(t, "path/00/", aws.StringValue(res.CommonPrefixes[0].Prefix))
+	}
+
+	// A maximum of zero returns nothing, but is truncated only if there is something to return
+	res, err = svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String("bucket"), Prefix: aws.String("path/"),
+		MaxKeys: aws.Int64(0)})
+	if assert.NoError(t, err) {
+		assert.Len(t, res.Contents, 0)
+		assert.True(t, aws.BoolValue(res.IsTruncated))
+	}
+	v2, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("bucket"), Prefix: aws.String("path/"),
+		MaxKeys: aws.Int64(0)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(0), aws.Int64Value(v2.KeyCount))
+		assert.True(t, aws.BoolValue(v2.IsTruncated))
+	}
+	res, err = svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String("bucket"), Prefix: aws.String("missing/"),
+		MaxKeys: aws.Int64(0)})
+	if assert.NoError(t, err) {
+		assert.False(t, aws.BoolValue(res.IsTruncated))
+	}
+}
+
+/*
+ * Pages of a delimited listing can end on a common prefix, which the next page must continue after rather than
+ * repeating. Listing stops after a fixed number of pages so that a regression fails rather than looping forever.
+ */
+func TestFakeS3ListDelimitedPagination(t *testing.T) {
+	fake, svc := startFakeS3(t)
+	defer fake.Stop()
+
+	for _, key := range []string{"path/a/1", "path/a/2", "path/b", "path/c/1", "path/c/d/1", "path/e"} {
+		_, err := svc.PutObject(&s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key),
+			Body: strings.NewReader("x")})
+		assert.NoError(t, err)
+	}
+	expected := []string{"path/a/", "path/b", "path/c/", "path/e"}
+
+	entries := []string{}
+	pages := 0
+	err := svc.ListObjectsPages(&s3.ListObjectsInput{Bucket: aws.String("bucket"), Prefix: aws.String("path/"),
+		Delimiter: aws.String("/"), MaxKeys: aws.Int64(1)}, func(res *s3.ListObjectsOutput, last bool) bool {
+		pages++
+		for _, p := range res.CommonPrefixes {
+			entries = append(entries, aws.StringValue(p.Prefix))
+		}
+		for _, obj := range res.Contents {
+			entries = append(entries, aws.StringValue(obj.Key))
+		}
+		return pages < 10
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, expected, entries)
+		assert.Equal(t, 4, pages)
+	}
+
+	entries = []string{}
+	pages = 0
+	err = svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String("bucket"), Prefix: aws.String("path/"),
+		Delimiter: aws.String("/"), MaxKeys: aws.Int64(1)}, func(res *s3.ListObjectsV2Output, last bool) bool {
+		pages++
+		for _, p := range res.CommonPrefixes {
+			entries = append(entries, aws.StringValue(p.Prefix))
+		}
+		for _, obj := range res.Contents {
+			entries = append(entries, aws.StringValue(obj.Key))
+		}
+		return pages < 10
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, expected, entries)
+		assert.Equal(t, 4, pages)
+	}
+}
+
+func TestFakeS3DeleteObjects(t *testing.T) {
+	fake, svc := startFakeS3(t)
+	defer fake.Stop()
+
+	for _, key := range []string{"a", "b", "c"} {
+		_, _ = svc.PutObject(&s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key),
+			Body: strings.NewReader(key)})
+	}
+	_, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String("bucket"),
+		Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String("a")}, {Key: aws.String("c")}}},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"b"}, fake.Keys("bucket"))
+	}
+	_, err = svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("b")})
+	if assert.NoError(t, err) {
+		assert.Empty(t, fake.Keys("bucket"))
+	}
+}
+
+func TestFakeS3Multipart(t *testing.T) {
+	fake, svc := startFakeS3(t)
+	defer fake.Stop()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 11*1024*1024/16)
+	uploader := s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
+		u.PartSize = 5 * 1024 * 1024
+	})
+	_, err := uploader.Upload(&s3manager.UploadInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("archive.tar.gz"),
+		Body:     bytes.NewReader(data),
+		Metadata: map[string]*string{"Size": aws.String("large")},
+	})
+	if assert.NoError(t, err) {
+		content, ok := fake.GetObject("bucket", "archive.tar.gz")
+		assert.True(t, ok)
+		assert.Equal(t, data, content)
+		res, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("archive.tar.gz")})
+		if assert.NoError(t, err) {
+			assert.True(t, strings.HasSuffix(aws.StringValue(res.ETag), "-3\""))
+			assert.Equal(t, "large", aws.StringValue(res.Metadata["Size"]))
+		}
+	}
+}
+
+func TestFakeS3AccessKey(t *testing.T) {
+	fake, _ := startFakeS3(t)
+	defer fake.Stop()
+
+	svc := fakeS3Client(fake, "WRONG")
+	_, err := svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String("bucket")})
+	if assert.Error(t, err) {
+		assert.Equal(t, "InvalidAccessKeyId", err.(awserr.Error).Code())
+	}
+}
+
+func TestDecodeAwsChunked(t *testing.T) {
+	body := "5;chunk-signature=abc\r\nhello\r\n6;chunk-signature=def\r\n world\r\n0;chunk-signature=ghi\r\n\r\n"
+	content, err := decodeAwsChunked(strings.NewReader(body))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "hello world", string(content))
+	}
+}
@@ -0,0 +1,315 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Fault to inject into responses from a FakeWeb server. Only one kind of fault is applied per request, checked in the
+ * order of the fields below, except for Delay which is applied before any of the others. A zero Fault serves the
+ * request normally.
+ *
+ *   Status    Respond with this HTTP status (such as 404 or 500) and no content
+ *   Redirect  Respond with a 302 to the same path with the matched prefix replaced by this one
+ *   Truncate  Declare the full content length, but close the connection after this many bytes of the body
+ *   Delay     Wait this long before responding
+ *
+ * Count limits the fault to the next Count matching requests, after which it is removed. Zero means forever.
+ */
+type Fault struct {
+	Status   int
+	Redirect string
+	Truncate int
+	Delay    time.Duration
+	Count    int
+}
+
+func NotFoundFault() Fault {
+	return Fault{Status: http.StatusNotFound}
+}
+
+func ServerErrorFault() Fault {
+	return Fault{Status: http.StatusInternalServerError}
+}
+
+func SlowFault(delay time.Duration) Fault {
+	return Fault{Delay: delay}
+}
+
+func TruncateFault(bytes int) Fault {
+	return Fault{Truncate: bytes}
+}
+
+func RedirectFault(prefix string) Fault {
+	return Fault{Redirect: prefix}
+}
+
+type faultRule struct {
+	prefix string
+	fault  Fault
+}
+
+/*
+ * Static HTTP server standing in for an S3 bucket configured for website hosting, as used by the s3web remote. It
+ * serves the objects of a single bucket in a FakeS3, so anything pushed through the s3 remote to the fake can be
+ * read back through the s3web remote at http://<host>:<port>/<path>.
+ *
+ * Faults can be injected for any request whose path (including the leading '/') starts with a given prefix, in order
+ * to test how the remote handles missing objects, server errors, slow or truncated responses, and redirects.
+ */
+type FakeWeb struct {
+	source *FakeS3
+	bucket string
+
+	lock      sync.Mutex
+	faults    []*faultRule
+	requests  []string
+	listeners []net.Listener
+	server    *http.Server
+}
+
+func NewFakeWeb(source *FakeS3, bucket string) *FakeWeb {
+	return &FakeWeb{source: source, bucket: bucket}
+}
+
+/*
+ * Start a web server over the given bucket of a fake S3 server, returning the base URL at which the titan server can
+ * reach it. As with StartFakeS3, it only listens on loopback and the server's network gateway.
+ */
+func (e *EndToEndTest) StartFakeWeb(source *FakeS3, bucket string) (*FakeWeb, string, error) {
+	host, err := e.HostAddress()
+	if err != nil {
+		return nil, "", err
+	}
+	web := NewFakeWeb(source, bucket)
+	err = web.Start(e.localAddresses(0)...)
+	if err != nil {
+		return nil, "", err
+	}
+	return web, fmt.Sprintf("http://%s:%d", host, web.Port()), nil
+}
+
+/*
+ * Start serving on the given addresses, in the same way as FakeS3.
+ */
+func (w *FakeWeb) Start(addrs ...string) error {
+	listeners, err := listenTcp(addrs...)
+	if err != nil {
+		return err
+	}
+	w.listeners = listeners
+	w.server = &http.Server{Handler: w}
+	for _, l := range listeners {
+		l := l
+		go func() {
+			_ = w.server.Serve(l)
+		}()
+	}
+	return nil
+}
+
+func (w *FakeWeb) Stop() error {
+	if w.server == nil {
+		return nil
+	}
+	return w.server.Close()
+}
+
+func (w *FakeWeb) Port() int {
+	return w.listeners[0].Addr().(*net.TCPAddr).Port
+}
+
+/*
+ * Base URL for use by clients running on the local host.
+ */
+func (w *FakeWeb) URL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", w.Port())
+}
+
+/*
+ * Inject a fault for all requests whose path starts with the given prefix. Faults are matched in the order in which
+ * they were added.
+ */
+func (w *FakeWeb) InjectFault(prefix string, fault Fault) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.faults = append(w.faults, &faultRule{prefix: prefix, fault: fault})
+}
+
+func (w *FakeWeb) ClearFaults() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.faults = nil
+}
+
+/*
+ * Store a commit beneath a path in the same layout as the s3 provider, so that it can be read through the s3web remote
+ * without pushing it through the s3 provider first. That layout is:
+ *
+ *   <path>/<id>                 Empty object with the commit properties as JSON in the io.titan-data metadata
+ *   <path>/<id>/<volume>.tar.gz Gzipped tar archive of the contents of each volume
+ *   <path>/titan                The commits beneath the path, one {"id": ..., "properties": ...} object per line
+ *
+ * Volumes map each volume name to the files it contains, keyed by their path within the volume.
+ */
+func (w *FakeWeb) PutCommit(path string, id string, properties map[string]interface{},
+	volumes map[string]map[string]string) error {
+	metadata, err := json.Marshal(properties)
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(map[string]interface{}{"id": id, "properties": properties})
+	if err != nil {
+		return err
+	}
+	for volume, files := range volumes {
+		archive, err := gzipTarFiles(files)
+		if err != nil {
+			return err
+		}
+		w.source.PutObject(w.bucket, fmt.Sprintf("%s/%s/%s.tar.gz", path, id, volume), archive, nil)
+	}
+	w.source.PutObject(w.bucket, fmt.Sprintf("%s/%s", path, id), []byte{},
+		map[string]string{"io.titan-data": string(metadata)})
+
+	index, _ := w.source.GetObject(w.bucket, path+"/titan")
+	index = append(append(append([]byte{}, index...), entry...), '\n')
+	w.source.PutObject(w.bucket, path+"/titan", index, nil)
+	return nil
+}
+
+/*
+ * Create a gzipped tar archive of the given files, keyed by their relative path.
+ */
+func gzipTarFiles(files map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "./" + name,
+			Size:     int64(len(files[name])),
+			Mode:     0644,
+			ModTime:  time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = tw.Write([]byte(files[name]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	err = gz.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+/*
+ * Get the method and path of every request received so far, such as "GET /path/titan".
+ */
+func (w *FakeWeb) Requests() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]string{}, w.requests...)
+}
+
+/*
+ * Record the request and find the fault that applies to it, if any, consuming one use of a counted fault.
+ */
+func (w *FakeWeb) matchFault(r *http.Request) (string, Fault) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.requests = append(w.requests, fmt.Sprintf("%s %s", r.Method, r.URL.Path))
+	for i, rule := range w.faults {
+		if strings.HasPrefix(r.URL.Path, rule.prefix) {
+			fault := rule.fault
+			if rule.fault.Count > 0 {
+				rule.fault.Count--
+				if rule.fault.Count == 0 {
+					w.faults = append(w.faults[:i], w.faults[i+1:]...)
+				}
+			}
+			return rule.prefix, fault
+		}
+	}
+	return "", Fault{}
+}
+
+func (w *FakeWeb) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefix, fault := w.matchFault(r)
+	if fault.Delay != 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fault.Status != 0 {
+		http.Error(rw, http.StatusText(fault.Status), fault.Status)
+		return
+	}
+	if fault.Redirect != "" {
+		http.Redirect(rw, r, fault.Redirect+strings.TrimPrefix(r.URL.Path, prefix), http.StatusFound)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	w.source.lock.Lock()
+	var obj *fakeS3Object
+	if objects, ok := w.source.buckets[w.bucket]; ok {
+		obj = objects[key]
+	}
+	w.source.lock.Unlock()
+	if obj == nil {
+		http.NotFound(rw, r)
+		return
+	}
+
+	writeObjectHeaders(rw, obj)
+	rw.Header().Set("Content-Length", fmt.Sprintf("%d", len(obj.data)))
+	rw.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if fault.Truncate != 0 && fault.Truncate < len(obj.data) {
+		/*
+		 * Writing less than the declared content length causes the server to close the connection once the handler
+		 * returns, so the client sees a premature end of the body rather than a short but complete response.
+		 */
+		_, _ = rw.Write(obj.data[:fault.Truncate])
+		return
+	}
+	_, _ = rw.Write(obj.data)
+}
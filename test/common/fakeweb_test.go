@@ -0,0 +1,175 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startFakeWeb(t *testing.T) (*FakeS3, *FakeWeb) {
+	fake, svc := startFakeS3(t)
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("path/titan"),
+		Body:     strings.NewReader("0123456789"),
+		Metadata: map[string]*string{"Titan": aws.String("yes")},
+	})
+	assert.NoError(t, err)
+	web := NewFakeWeb(fake, "bucket")
+	if !assert.NoError(t, web.Start("127.0.0.1:0")) {
+		t.FailNow()
+	}
+	return fake, web
+}
+
+func getFakeWeb(web *FakeWeb, path string) (int, string, error) {
+	res, err := http.Get(web.URL() + path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	content, err := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(content), err
+}
+
+func TestFakeWebServesObjects(t *testing.T) {
+	fake, web := startFakeWeb(t)
+	defer fake.Stop()
+	defer web.Stop()
+
+	res, err := http.Get(web.URL() + "/path/titan")
+	if assert.NoError(t, err) {
+		content, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, "0123456789", string(content))
+		assert.Equal(t, "yes", res.Header.Get("x-amz-meta-titan"))
+	}
+
+	status, _, err := getFakeWeb(web, "/path/missing")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 404, status)
+	}
+	assert.Equal(t, []string{"GET /path/titan", "GET /path/missing"}, web.Requests())
+}
+
+func TestFakeWebStatusFaults(t *testing.T) {
+	fake, web := startFakeWeb(t)
+	defer fake.Stop()
+	defer web.Stop()
+
+	web.InjectFault("/path/", ServerErrorFault())
+	status, _, err := getFakeWeb(web, "/path/titan")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 500, status)
+	}
+
+	web.ClearFaults()
+	notFound := NotFoundFault()
+	notFound.Count = 1
+	web.InjectFault("/path/", notFound)
+	status, _, _ = getFakeWeb(web, "/path/titan")
+	assert.Equal(t, 404, status)
+	status, content, _ := getFakeWeb(web, "/path/titan")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "0123456789", content)
+}
+
+func TestFakeWebRedirect(t *testing.T) {
+	fake, web := startFakeWeb(t)
+	defer fake.Stop()
+	defer web.Stop()
+
+	web.InjectFault("/moved/", RedirectFault("/"))
+	status, content, err := getFakeWeb(web, "/moved/path/titan")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "0123456789", content)
+	}
+	assert.Equal(t, []string{"GET /moved/path/titan", "GET /path/titan"}, web.Requests())
+}
+
+func TestFakeWebTruncate(t *testing.T) {
+	fake, web := startFakeWeb(t)
+	defer fake.Stop()
+	defer web.Stop()
+
+	web.InjectFault("/path/titan", TruncateFault(4))
+	_, content, err := getFakeWeb(web, "/path/titan")
+	assert.Error(t, err)
+	assert.Equal(t, "0123", content)
+}
+
+func TestFakeWebSlow(t *testing.T) {
+	fake, web := startFakeWeb(t)
+	defer fake.Stop()
+	defer web.Stop()
+
+	web.InjectFault("/", SlowFault(200*time.Millisecond))
+	start := time.Now()
+	status, _, err := getFakeWeb(web, "/path/titan")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 200, status)
+		assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	}
+}
+
+/*
+ * Commits can be stored directly for the s3web remote, without a running S3 server or the s3 provider.
+ */
+func TestFakeWebPutCommit(t *testing.T) {
+	fake := NewFakeS3()
+	web := NewFakeWeb(fake, "bucket")
+	if !assert.NoError(t, web.Start("127.0.0.1:0")) {
+		return
+	}
+	defer web.Stop()
+
+	assert.NoError(t, web.PutCommit("path", "one", map[string]interface{}{"a": "b"},
+		map[string]map[string]string{"vol": {"testfile": "Hello", "dir/other": "World"}}))
+	assert.NoError(t, web.PutCommit("path", "two", map[string]interface{}{}, nil))
+
+	status, content, err := getFakeWeb(web, "/path/titan")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "{\"id\":\"one\",\"properties\":{\"a\":\"b\"}}\n{\"id\":\"two\",\"properties\":{}}\n", content)
+	}
+	assert.Equal(t, []string{"path/one", "path/one/vol.tar.gz", "path/titan", "path/two"}, fake.Keys("bucket"))
+
+	res, err := http.Get(web.URL() + "/path/one")
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, "{\"a\":\"b\"}", res.Header.Get("x-amz-meta-io.titan-data"))
+	}
+
+	res, err = http.Get(web.URL() + "/path/one/vol.tar.gz")
+	if assert.NoError(t, err) {
+		defer res.Body.Close()
+		gz, err := gzip.NewReader(res.Body)
+		if assert.NoError(t, err) {
+			files := map[string]string{}
+			tr := tar.NewReader(gz)
+			for {
+				hdr, err := tr.Next()
+				if err != nil {
+					assert.Equal(t, io.EOF, err)
+					break
+				}
+				content, _ := ioutil.ReadAll(tr)
+				files[hdr.Name] = string(content)
+			}
+			assert.Equal(t, map[string]string{"./testfile": "Hello", "./dir/other": "World"}, files)
+		}
+	}
+}
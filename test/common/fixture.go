@@ -0,0 +1,204 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	titan "github.com/titan-data/titan-client-go"
+	"strings"
+)
+
+/*
+ * Builder for the standard set of objects that most tests start from. Each call adds a step, and nothing is created
+ * until Build() is called. Volumes, commits, and remotes are always created within the most recently added
+ * repository, so that a complete scenario can be described in a single chain:
+ *
+ *	f, err := e.Fixture().Repo("foo").Volume("vol", File("testfile", "Hello")).Commit("id", "a=b").Build(ctx)
+ *
+ * Once built, the objects returned by the server are available through the Repos map. Everything is created through
+ * the harness, so it is all removed again by Cleanup().
+ */
+type Fixture struct {
+	e       *EndToEndTest
+	steps   []fixtureStep
+	current string
+	err     error
+
+	Repos map[string]*RepoFixture
+}
+
+/*
+ * Objects created within a single repository, keyed by name (or commit ID).
+ */
+type RepoFixture struct {
+	Repository titan.Repository
+	Volumes    map[string]titan.Volume
+	Commits    map[string]titan.Commit
+	Remotes    map[string]titan.Remote
+}
+
+/*
+ * A file to be written to a volume as part of a fixture.
+ */
+type FixtureFile struct {
+	Path    string
+	Content []byte
+}
+
+type fixtureStep func(ctx context.Context, f *Fixture) error
+
+func File(path string, content string) FixtureFile {
+	return FixtureFile{Path: path, Content: []byte(content)}
+}
+
+func (e *EndToEndTest) Fixture() *Fixture {
+	return &Fixture{e: e, Repos: map[string]*RepoFixture{}}
+}
+
+func (f *Fixture) add(step fixtureStep) *Fixture {
+	if f.current == "" && f.err == nil {
+		f.err = errors.New("fixture must start with a repository")
+	}
+	f.steps = append(f.steps, step)
+	return f
+}
+
+/*
+ * Create a repository with the given name, which becomes the target of all subsequent steps.
+ */
+func (f *Fixture) Repo(name string) *Fixture {
+	f.current = name
+	return f.add(func(ctx context.Context, f *Fixture) error {
+		res, err := f.e.CreateRepository(ctx, titan.Repository{
+			Name:       name,
+			Properties: map[string]interface{}{},
+		})
+		if err != nil {
+			return err
+		}
+		f.Repos[name] = &RepoFixture{
+			Repository: res,
+			Volumes:    map[string]titan.Volume{},
+			Commits:    map[string]titan.Commit{},
+			Remotes:    map[string]titan.Remote{},
+		}
+		return nil
+	})
+}
+
+/*
+ * Create a volume, wait for it to be ready, activate it, and then write the given files to it.
+ */
+func (f *Fixture) Volume(name string, files ...FixtureFile) *Fixture {
+	repo := f.current
+	return f.add(func(ctx context.Context, f *Fixture) error {
+		res, err := f.e.CreateVolume(ctx, repo, titan.Volume{
+			Name:       name,
+			Properties: map[string]interface{}{},
+		})
+		if err != nil {
+			return err
+		}
+		err = f.e.WaitForVolume(ctx, repo, name)
+		if err != nil {
+			return err
+		}
+		_, err = f.e.VolumeApi.ActivateVolume(ctx, repo, name)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			err = f.e.WriteFileBytes(repo, name, file.Path, file.Content)
+			if err != nil {
+				return err
+			}
+		}
+		f.Repos[repo].Volumes[name] = res
+		return nil
+	})
+}
+
+/*
+ * Create a commit with the given tags, each of the form "key=value" (or just "key" for an empty value), and wait
+ * for it to be ready.
+ */
+func (f *Fixture) Commit(id string, tags ...string) *Fixture {
+	repo := f.current
+	return f.add(func(ctx context.Context, f *Fixture) error {
+		res, err := f.e.CreateCommit(ctx, repo, titan.Commit{
+			Id:         id,
+			Properties: map[string]interface{}{"tags": ParseTags(tags...)},
+		})
+		if err != nil {
+			return err
+		}
+		err = f.e.WaitForCommit(ctx, repo, id)
+		if err != nil {
+			return err
+		}
+		f.Repos[repo].Commits[id] = res
+		return nil
+	})
+}
+
+/*
+ * Add a remote to the repository.
+ */
+func (f *Fixture) Remote(remote titan.Remote) *Fixture {
+	repo := f.current
+	return f.add(func(ctx context.Context, f *Fixture) error {
+		res, err := f.e.CreateRemote(ctx, repo, remote)
+		if err != nil {
+			return err
+		}
+		f.Repos[repo].Remotes[remote.Name] = res
+		return nil
+	})
+}
+
+/*
+ * Create everything described by the fixture, in order. Creation stops at the first error, which is returned
+ * along with whatever was created up to that point.
+ */
+func (f *Fixture) Build(ctx context.Context) (*Fixture, error) {
+	if f.err != nil {
+		return f, f.err
+	}
+	for _, step := range f.steps {
+		err := step(ctx, f)
+		if err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+/*
+ * Build the fixture, failing the current test if any step fails.
+ */
+func (f *Fixture) MustBuild(ctx context.Context) *Fixture {
+	_, err := f.Build(ctx)
+	if err != nil {
+		f.e.Suite.FailNow(fmt.Sprintf("failed to build fixture: %v", err))
+	}
+	return f
+}
+
+/*
+ * Parse tags of the form "key=value" into the map expected by the commit properties.
+ */
+func ParseTags(tags ...string) map[string]string {
+	ret := map[string]string{}
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) == 2 {
+			ret[parts[0]] = parts[1]
+		} else {
+			ret[parts[0]] = ""
+		}
+	}
+	return ret
+}
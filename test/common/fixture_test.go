@@ -0,0 +1,93 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	titan "github.com/titan-data/titan-client-go"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*
+ * Minimal stand-in for the titan API, just enough to drive the fixture builder. Objects are echoed back as created,
+ * and every volume and commit is immediately ready.
+ */
+type fakeApi struct {
+	lock     sync.Mutex
+	requests []string
+}
+
+func (f *fakeApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/status"):
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"ready": true})
+	case strings.HasSuffix(r.URL.Path, "/activate"), strings.HasSuffix(r.URL.Path, "/deactivate"):
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(titan.Volume{Config: map[string]interface{}{"mountpoint": "/mnt/vol"}})
+	default:
+		body, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(body)
+	}
+}
+
+func TestFixtureBuild(t *testing.T) {
+	api := &fakeApi{}
+	server := httptest.NewServer(api)
+	defer server.Close()
+	e, runtime := newRecordingTest("docker-zfs")
+	e.SetApiHost(strings.TrimPrefix(server.URL, "http://"))
+
+	f, err := e.Fixture().Repo("foo").
+		Volume("vol", File("testfile", "Hello")).
+		Commit("id", "a=b", "c").
+		Remote(titan.Remote{Provider: "nop", Name: "origin", Properties: map[string]interface{}{}}).
+		Build(context.Background())
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{
+			"POST /v1/repositories",
+			"POST /v1/repositories/foo/volumes",
+			"GET /v1/repositories/foo/volumes/vol/status",
+			"POST /v1/repositories/foo/volumes/vol/activate",
+			"GET /v1/repositories/foo/volumes/vol",
+			"POST /v1/repositories/foo/commits",
+			"GET /v1/repositories/foo/commits/id/status",
+			"POST /v1/repositories/foo/remotes",
+		}, api.requests)
+
+		repo := f.Repos["foo"]
+		assert.Equal(t, "foo", repo.Repository.Name)
+		assert.Equal(t, "vol", repo.Volumes["vol"].Name)
+		assert.Equal(t, map[string]interface{}{"a": "b", "c": ""}, repo.Commits["id"].Properties["tags"])
+		assert.Equal(t, "nop", repo.Remotes["origin"].Provider)
+
+		call := runtime.CallsTo("ExecStream")[0]
+		assert.Equal(t, []string{"tar", "-x", "-f", "-", "-C", "/mnt/vol"}, call.Args)
+	}
+}
+
+func TestFixtureRequiresRepo(t *testing.T) {
+	e, _ := newRecordingTest("docker-zfs")
+	_, err := e.Fixture().Volume("vol").Build(context.Background())
+	assert.Error(t, err)
+}
+
+func TestParseTags(t *testing.T) {
+	assert.Equal(t, map[string]string{"a": "b", "c": "", "d": "e=f"}, ParseTags("a=b", "c", "d=e=f"))
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+)
+
+/*
+ * Generate a unique identity for a test suite. Everything the harness creates (ZFS pool, docker volumes, networks,
+ * and containers) is namespaced by identity, so that multiple suites can run at the same time. The identity can be
+ * fixed by setting TITAN_TEST_IDENTITY in the environment, which is useful when debugging a single suite.
+ */
+func newIdentity() (string, error) {
+	identity := os.Getenv("TITAN_TEST_IDENTITY")
+	if identity != "" {
+		return identity, nil
+	}
+	suffix := make([]byte, 3)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return "", err
+	}
+	return "test-" + hex.EncodeToString(suffix), nil
+}
+
+/*
+ * Find a free TCP port on the local host. There is an inherent race between closing the listener and the port
+ * being used, but the window is small enough in practice.
+ */
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+/*
+ * Listen on each of the given addresses. If the first has port 0, a free port is picked and used for any later ones
+ * with port 0, so that a single port reaches the same service on every address.
+ */
+func listenTcp(addrs ...string) ([]net.Listener, error) {
+	listeners := []net.Listener{}
+	for _, addr := range addrs {
+		if len(listeners) != 0 {
+			host, port, err := net.SplitHostPort(addr)
+			if err == nil && port == "0" {
+				addr = net.JoinHostPort(host, strconv.Itoa(listeners[0].Addr().(*net.TCPAddr).Port))
+			}
+		}
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
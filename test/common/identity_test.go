@@ -0,0 +1,51 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"testing"
+)
+
+/*
+ * Set TITAN_TEST_IDENTITY for the duration of a test, or clear it if the value is empty.
+ */
+func withIdentity(identity string, fn func()) {
+	previous, present := os.LookupEnv("TITAN_TEST_IDENTITY")
+	if identity == "" {
+		_ = os.Unsetenv("TITAN_TEST_IDENTITY")
+	} else {
+		_ = os.Setenv("TITAN_TEST_IDENTITY", identity)
+	}
+	defer func() {
+		if present {
+			_ = os.Setenv("TITAN_TEST_IDENTITY", previous)
+		} else {
+			_ = os.Unsetenv("TITAN_TEST_IDENTITY")
+		}
+	}()
+	fn()
+}
+
+func TestUniqueIdentity(t *testing.T) {
+	withIdentity("", func() {
+		a := NewEndToEndTest(&suite.Suite{}, "docker-zfs")
+		b := NewEndToEndTest(&suite.Suite{}, "docker-zfs")
+		assert.NotEqual(t, a.Identity, b.Identity)
+		assert.Regexp(t, "^test-[0-9a-f]{6}$", a.Identity)
+		assert.Equal(t, fmt.Sprintf("%s-server", a.Identity), a.GetContainer("server"))
+		assert.Equal(t, fmt.Sprintf("/var/lib/%s/mnt", a.Identity), a.Backend.MountPath())
+	})
+}
+
+func TestPinnedIdentity(t *testing.T) {
+	withIdentity("test-pinned", func() {
+		e := NewEndToEndTest(&suite.Suite{}, "docker-zfs")
+		assert.Equal(t, "test-pinned", e.Identity)
+		assert.Equal(t, "test-pinned-server", e.GetContainer("server"))
+	})
+}
@@ -0,0 +1,256 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	titan "github.com/titan-data/titan-client-go"
+	"golang.org/x/crypto/blowfish"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+type KeyType string
+
+const (
+	KeyRSA     KeyType = "rsa"
+	KeyECDSA   KeyType = "ecdsa"
+	KeyEd25519 KeyType = "ed25519"
+)
+
+var KeyTypes = []KeyType{KeyRSA, KeyECDSA, KeyEd25519}
+
+/*
+ * An SSH keypair generated for a single test run. The private key is PEM encoded in the format that OpenSSH itself
+ * would write: PKCS#1 for RSA and SEC 1 for ECDSA (both encrypted with a PEM header if there's a passphrase), and the
+ * OpenSSH format for ed25519 (encrypted with bcrypt and aes256-ctr if there's a passphrase). The public key is a single
+ * line in authorized_keys format.
+ */
+type KeyPair struct {
+	Type       KeyType
+	Passphrase string
+	PrivateKey string
+	PublicKey  string
+	Signer     ssh.Signer
+}
+
+/*
+ * Generate a new keypair of the given type, encrypting the private key with the passphrase if it is non-empty.
+ */
+func GenerateKeyPair(keyType KeyType, passphrase string) (*KeyPair, error) {
+	var key interface{}
+	var block *pem.Block
+	switch keyType {
+	case KeyRSA:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		key = rsaKey
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}
+	case KeyECDSA:
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		if err != nil {
+			return nil, err
+		}
+		key = ecKey
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case KeyEd25519:
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := marshalOpenSshEd25519(edKey, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		key = edKey
+		block = &pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: der}
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown key type '%s'", keyType))
+	}
+
+	if passphrase != "" && keyType != KeyEd25519 {
+		var err error
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES128)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{
+		Type:       keyType,
+		Passphrase: passphrase,
+		PrivateKey: string(pem.EncodeToMemory(block)),
+		PublicKey:  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+		Signer:     signer,
+	}, nil
+}
+
+/*
+ * Number of bcrypt_pbkdf rounds used to encrypt OpenSSH format keys, which is what ssh-keygen uses by default.
+ */
+const openSshKdfRounds = 16
+
+/*
+ * Encode an ed25519 key in the OpenSSH private key format (see PROTOCOL.key in the OpenSSH source). If there is a
+ * passphrase, the private section is encrypted with aes256-ctr, using a key and IV derived through bcrypt_pbkdf.
+ */
+func marshalOpenSshEd25519(key ed25519.PrivateKey, passphrase string) ([]byte, error) {
+	pub := key.Public().(ed25519.PublicKey)
+	pubBlob := ssh.Marshal(struct {
+		KeyType string
+		Pub     []byte
+	}{ssh.KeyAlgoED25519, pub})
+
+	check := make([]byte, 4)
+	_, _ = rand.Read(check)
+	checkValue := binary.BigEndian.Uint32(check)
+	private := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		KeyType string
+		Pub     []byte
+		Priv    []byte
+		Comment string
+	}{checkValue, checkValue, ssh.KeyAlgoED25519, pub, key, "titan-test"})
+
+	cipherName, kdfName, kdfOpts, blockSize := "none", "none", "", 8
+	var salt []byte
+	if passphrase != "" {
+		salt = make([]byte, 16)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return nil, err
+		}
+		cipherName, kdfName, blockSize = "aes256-ctr", "bcrypt", aes.BlockSize
+		kdfOpts = string(ssh.Marshal(struct {
+			Salt   []byte
+			Rounds uint32
+		}{salt, openSshKdfRounds}))
+	}
+	for i := 1; len(private)%blockSize != 0; i++ {
+		private = append(private, byte(i))
+	}
+	if passphrase != "" {
+		keyIv := bcryptPbkdf([]byte(passphrase), salt, openSshKdfRounds, 32+aes.BlockSize)
+		block, err := aes.NewCipher(keyIv[:32])
+		if err != nil {
+			return nil, err
+		}
+		cipher.NewCTR(block, keyIv[32:]).XORKeyStream(private, private)
+	}
+
+	body := ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{cipherName, kdfName, kdfOpts, 1, pubBlob, private})
+	return append([]byte("openssh-key-v1\x00"), body...), nil
+}
+
+/*
+ * Derive a key from a passphrase with bcrypt_pbkdf, as OpenSSH does for encrypted private keys. This is PBKDF2 with
+ * a bcrypt based hash in place of HMAC, and with the output bytes spread across blocks rather than concatenated, as
+ * implemented in OpenBSD's bcrypt_pbkdf.c.
+ */
+func bcryptPbkdf(password []byte, salt []byte, rounds int, keyLen int) []byte {
+	const blockSize = 32
+	numBlocks := (keyLen + blockSize - 1) / blockSize
+	key := make([]byte, numBlocks*blockSize)
+
+	h := sha512.New()
+	h.Write(password)
+	shaPass := h.Sum(nil)
+	tmp := make([]byte, blockSize)
+	count := make([]byte, 4)
+	for block := 1; block <= numBlocks; block++ {
+		binary.BigEndian.PutUint32(count, uint32(block))
+		h.Reset()
+		h.Write(salt)
+		h.Write(count)
+		bcryptHash(tmp, shaPass, h.Sum(nil))
+		out := make([]byte, blockSize)
+		copy(out, tmp)
+		for i := 1; i < rounds; i++ {
+			h.Reset()
+			h.Write(tmp)
+			bcryptHash(tmp, shaPass, h.Sum(nil))
+			for j := range out {
+				out[j] ^= tmp[j]
+			}
+		}
+		for i, v := range out {
+			key[i*numBlocks+block-1] = v
+		}
+	}
+	return key[:keyLen]
+}
+
+func bcryptHash(out []byte, shaPass []byte, shaSalt []byte) {
+	c, _ := blowfish.NewSaltedCipher(shaPass, shaSalt)
+	for i := 0; i < 64; i++ {
+		blowfish.ExpandKey(shaSalt, c)
+		blowfish.ExpandKey(shaPass, c)
+	}
+	copy(out, "OxychromaticBlowfishSwatDynamite")
+	for i := 0; i < len(out); i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(out[i:i+8], out[i:i+8])
+		}
+	}
+	// Each 32 bit word is little endian
+	for i := 0; i < len(out); i += 4 {
+		out[i], out[i+1], out[i+2], out[i+3] = out[i+3], out[i+2], out[i+1], out[i]
+	}
+}
+
+/*
+ * Get the properties to pass in RemoteParameters to authenticate to an ssh remote with this key.
+ */
+func (k *KeyPair) Properties() map[string]interface{} {
+	return map[string]interface{}{"key": k.PrivateKey}
+}
+
+func (k *KeyPair) RemoteParameters() titan.RemoteParameters {
+	return titan.RemoteParameters{Provider: "ssh", Properties: k.Properties()}
+}
+
+/*
+ * Generate a keypair and install its public key on the SSH server.
+ */
+func (e *EndToEndTest) GenerateSshKey(keyType KeyType, passphrase string) (*KeyPair, error) {
+	key, err := GenerateKeyPair(keyType, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	err = e.Ssh.AuthorizeKey(key.Signer.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,161 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGenerateKeyPairs(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+
+	for _, keyType := range KeyTypes {
+		t.Run(string(keyType), func(t *testing.T) {
+			key, err := GenerateKeyPair(keyType, "")
+			if !assert.NoError(t, err) {
+				return
+			}
+			signer, err := ssh.ParsePrivateKey([]byte(key.PrivateKey))
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, key.PublicKey, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))))
+
+			_, err = runSsh(server, ssh.PublicKeys(signer), "ls", "")
+			assert.Error(t, err)
+			if assert.NoError(t, server.AuthorizeKey(signer.PublicKey())) {
+				_, err = runSsh(server, ssh.PublicKeys(signer), "ls", "")
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(server.Root, "home", "test", ".ssh", "authorized_keys"))
+	assert.Len(t, strings.Split(strings.TrimSpace(string(content)), "\n"), len(KeyTypes))
+}
+
+func TestGenerateKeyPairPassphrase(t *testing.T) {
+	for _, keyType := range []KeyType{KeyRSA, KeyECDSA} {
+		key, err := GenerateKeyPair(keyType, "secret")
+		if assert.NoError(t, err) {
+			assert.Contains(t, key.PrivateKey, "ENCRYPTED")
+			_, err = ssh.ParsePrivateKey([]byte(key.PrivateKey))
+			assert.Error(t, err)
+			signer, err := ssh.ParsePrivateKeyWithPassphrase([]byte(key.PrivateKey), []byte("secret"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, key.Signer.PublicKey().Marshal(), signer.PublicKey().Marshal())
+			}
+		}
+	}
+
+	key, err := GenerateKeyPair(KeyEd25519, "secret")
+	if assert.NoError(t, err) {
+		block, _ := pem.Decode([]byte(key.PrivateKey))
+		if assert.NotNil(t, block) {
+			assert.Equal(t, "OPENSSH PRIVATE KEY", block.Type)
+			assert.Contains(t, string(block.Bytes), "aes256-ctr")
+			assert.Contains(t, string(block.Bytes), "bcrypt")
+		}
+		_, err = ssh.ParsePrivateKey([]byte(key.PrivateKey))
+		assert.Error(t, err)
+	}
+}
+
+/*
+ * Protected keys of every type can be used by OpenSSH itself, which is what the ssh provider runs, to push and pull
+ * data through the SSH server with the correct passphrase, and only with the correct passphrase.
+ */
+func TestProtectedKeysOpenSsh(t *testing.T) {
+	for _, command := range []string{"ssh", "ssh-keygen"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("%s not installed", command)
+		}
+	}
+	server := startSshServer(t)
+	defer server.Stop()
+	dir, err := ioutil.TempDir("", "titan-keys")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	for _, keyType := range KeyTypes {
+		t.Run(string(keyType), func(t *testing.T) {
+			key, err := GenerateKeyPair(keyType, "secret")
+			if !assert.NoError(t, err) || !assert.NoError(t, server.AuthorizeKey(key.Signer.PublicKey())) {
+				return
+			}
+			keyFile := filepath.Join(dir, string(keyType))
+			if !assert.NoError(t, ioutil.WriteFile(keyFile, []byte(key.PrivateKey), 0600)) {
+				return
+			}
+
+			out, err := exec.Command("ssh-keygen", "-y", "-P", "secret", "-f", keyFile).Output()
+			if assert.NoError(t, err) {
+				assert.True(t, strings.HasPrefix(string(out), key.PublicKey))
+			}
+			_, err = exec.Command("ssh-keygen", "-y", "-P", "wrong", "-f", keyFile).Output()
+			assert.Error(t, err)
+
+			file := "home/test/" + string(keyType)
+			content := "pushed with " + string(keyType)
+			_, err = runOpenSsh(server, keyFile, "secret", "sh -c 'cat > "+file+"'", content)
+			if assert.NoError(t, err) {
+				out, err := runOpenSsh(server, keyFile, "secret", "cat "+file, "")
+				if assert.NoError(t, err) {
+					assert.Equal(t, content, out)
+				}
+			}
+			_, err = runOpenSsh(server, keyFile, "wrong", "cat "+file, "")
+			assert.Error(t, err)
+		})
+	}
+}
+
+/*
+ * Run a command through the OpenSSH client with the given key, supplying its passphrase through SSH_ASKPASS. The
+ * in-process server can't verify rsa-sha2 signatures, so the older ssh-rsa signatures are allowed for RSA keys.
+ */
+func runOpenSsh(server *SshServer, keyFile string, passphrase string, command string, stdin string) (string, error) {
+	askpass := keyFile + ".askpass"
+	err := ioutil.WriteFile(askpass, []byte(fmt.Sprintf("#!/bin/sh\necho '%s'\n", passphrase)), 0700)
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.Command("ssh", "-i", keyFile, "-p", strconv.Itoa(server.Port()),
+		"-o", "IdentitiesOnly=yes", "-o", "PasswordAuthentication=no", "-o", "PubkeyAcceptedKeyTypes=+ssh-rsa",
+		"-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null", "-o", "LogLevel=ERROR",
+		"test@127.0.0.1", command)
+	cmd.Env = append(os.Environ(), "SSH_ASKPASS="+askpass, "SSH_ASKPASS_REQUIRE=force", "DISPLAY=none")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return string(out), errors.New(fmt.Sprintf("%v: %s", err, strings.TrimSpace(stderr.String())))
+	}
+	return string(out), nil
+}
+
+func TestKeyPairRemoteParameters(t *testing.T) {
+	key, err := GenerateKeyPair(KeyEd25519, "")
+	if assert.NoError(t, err) {
+		params := key.RemoteParameters()
+		assert.Equal(t, "ssh", params.Provider)
+		assert.Equal(t, key.PrivateKey, params.Properties["key"])
+	}
+}
@@ -0,0 +1,305 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+ * Maximum number of distinct (calls, state) pairs explored when checking a single repository. Histories from a
+ * handful of clients are normally checked in far fewer, so hitting this means the history is too long to check.
+ */
+const stressSearchLimit = 1000000
+
+/*
+ * Sequential specification of a single repository, as seen by the stress harness: the commits that exist, with their
+ * tags, and which volumes are active. Checking out a commit switches to a new volume set, so every volume becomes
+ * inactive. States are never modified, each call produces a new one.
+ */
+type stressState struct {
+	commits map[string]string
+	active  map[string]bool
+}
+
+func newStressState(volumes []string) stressState {
+	s := stressState{commits: map[string]string{}, active: map[string]bool{}}
+	for _, v := range volumes {
+		s.active[v] = false
+	}
+	return s
+}
+
+func (s stressState) copy() stressState {
+	ret := stressState{commits: map[string]string{}, active: map[string]bool{}}
+	for k, v := range s.commits {
+		ret.commits[k] = v
+	}
+	for k, v := range s.active {
+		ret.active[k] = v
+	}
+	return ret
+}
+
+func (s stressState) withCommit(id string, tags string) stressState {
+	ret := s.copy()
+	ret.commits[id] = tags
+	return ret
+}
+
+func (s stressState) withoutCommit(id string) stressState {
+	ret := s.copy()
+	delete(ret.commits, id)
+	return ret
+}
+
+func (s stressState) withActive(volume string, active bool) stressState {
+	ret := s.copy()
+	ret.active[volume] = active
+	return ret
+}
+
+func (s stressState) withNewVolumeSet() stressState {
+	ret := s.copy()
+	for k := range ret.active {
+		ret.active[k] = false
+	}
+	return ret
+}
+
+func (s stressState) String() string {
+	commits := []string{}
+	for _, id := range sortedModelKeys(s.commits) {
+		commits = append(commits, id+s.commits[id])
+	}
+	active := []string{}
+	for _, v := range sortedModelKeys(s.active) {
+		if s.active[v] {
+			active = append(active, v)
+		}
+	}
+	return fmt.Sprintf("commits [%s], active volumes [%s]", strings.Join(commits, ", "), strings.Join(active, ", "))
+}
+
+/*
+ * States that could follow a call, given its outcome. No states means the outcome is impossible in this state. A
+ * call with an unknown outcome could have either taken effect or not.
+ */
+func (s stressState) step(c StressCall) []stressState {
+	tags := formatModelTags(c.Tags)
+	_, exists := s.commits[c.Commit]
+	active := s.active[c.Volume]
+	var changed *stressState
+	ok := false
+	switch c.Kind {
+	case StressCommit:
+		if !exists {
+			next := s.withCommit(c.Commit, tags)
+			changed = &next
+		}
+		ok = (c.Code == "" && !exists) || (c.Code == modelObjectExists && exists)
+	case StressDeleteCommit, StressUpdateCommit, StressCheckout:
+		if exists {
+			var next stressState
+			switch c.Kind {
+			case StressDeleteCommit:
+				next = s.withoutCommit(c.Commit)
+			case StressUpdateCommit:
+				next = s.withCommit(c.Commit, tags)
+			default:
+				next = s.withNewVolumeSet()
+			}
+			changed = &next
+		}
+		ok = (c.Code == "" && exists) || (c.Code == modelNoSuchObject && !exists)
+	case StressGetCommit:
+		ok = (c.Code == "" && exists && s.commits[c.Commit] == tags) || (c.Code == modelNoSuchObject && !exists)
+	case StressListCommits:
+		ok = c.Code == "" && compareModelNames("commits", sortedModelKeys(s.commits), c.Commits) == ""
+	case StressActivate:
+		if !active {
+			next := s.withActive(c.Volume, true)
+			changed = &next
+		}
+		ok = (c.Code == "" && !active) || (c.Code != "" && c.Code != modelNoSuchObject && active)
+	case StressDeactivate:
+		next := s.withActive(c.Volume, false)
+		changed = &next
+		ok = c.Code == ""
+	}
+
+	if c.Code == StressUnknown {
+		if changed != nil {
+			return []stressState{s, *changed}
+		}
+		return []stressState{s}
+	}
+	if !ok {
+		return nil
+	}
+	if c.Code == "" && changed != nil {
+		return []stressState{*changed}
+	}
+	return []stressState{s}
+}
+
+/*
+ * Check that a stress history is consistent with the calls having been made one at a time, each taking effect at
+ * some point between when it was made and when it returned. Each repository is independent, so is checked on its
+ * own. Returns a description of each problem found.
+ */
+func CheckStressHistory(history *StressHistory) []string {
+	problems := []string{}
+	for _, c := range history.Calls {
+		counts := map[string]int{}
+		for _, id := range c.Commits {
+			counts[id]++
+		}
+		for _, id := range sortedModelKeys(counts) {
+			if counts[id] > 1 {
+				problems = append(problems, fmt.Sprintf("duplicate commit %s: listed %d times by %s", id, counts[id],
+					c))
+			}
+		}
+	}
+	for _, repo := range history.Config.Repos {
+		calls := []StressCall{}
+		for _, c := range history.Calls {
+			if c.Repo == repo {
+				calls = append(calls, c)
+			}
+		}
+		if p := checkLinearizable(repo, newStressState(history.Config.Volumes), calls); p != "" {
+			problems = append(problems, p)
+		}
+	}
+	return problems
+}
+
+/*
+ * Search for an order of the calls that respects real time (a call that returned before another started must come
+ * first) and in which every outcome is explained by the sequential specification, pruning states already seen. If
+ * there is none, the longest order found is reported, along with the calls that couldn't come next.
+ */
+type linearSearch struct {
+	calls     []StressCall
+	done      []bool
+	order     []int
+	seen      map[string]bool
+	best      []int
+	bestState stressState
+}
+
+func checkLinearizable(repo string, initial stressState, calls []StressCall) string {
+	s := &linearSearch{calls: calls, done: make([]bool, len(calls)), seen: map[string]bool{}}
+	s.best = []int{}
+	s.bestState = initial
+	if s.search(initial) {
+		return ""
+	}
+	if len(s.seen) >= stressSearchLimit {
+		return fmt.Sprintf("repository %s: gave up checking %d calls after exploring %d states", repo, len(calls),
+			len(s.seen))
+	}
+
+	done := make([]bool, len(calls))
+	for _, i := range s.best {
+		done[i] = true
+	}
+	stuck := []string{}
+	hints := map[string]bool{}
+	for _, i := range s.candidates(done) {
+		stuck = append(stuck, "  "+calls[i].String())
+		if s.bestState.step(calls[i]) == nil {
+			hints[stressHint(calls[i].Kind)] = true
+		}
+	}
+	summary := fmt.Sprintf("repository %s: calls are not linearizable", repo)
+	if len(hints) != 0 {
+		summary += fmt.Sprintf(" (possible %s)", strings.Join(sortedModelKeys(hints), ", "))
+	}
+	lines := []string{summary, fmt.Sprintf("after %d of %d calls, ending with:", len(s.best), len(calls))}
+	from := len(s.best) - 5
+	if from < 0 {
+		from = 0
+	}
+	for _, i := range s.best[from:] {
+		lines = append(lines, "  "+calls[i].String())
+	}
+	lines = append(lines, fmt.Sprintf("none of these can come next with %s:", s.bestState))
+	return strings.Join(append(lines, stuck...), "\n")
+}
+
+func stressHint(kind string) string {
+	switch kind {
+	case StressCommit, StressListCommits:
+		return "duplicate commits"
+	case StressUpdateCommit, StressGetCommit:
+		return "lost tag updates"
+	case StressDeleteCommit:
+		return "duplicate deletes"
+	}
+	return "volume state races"
+}
+
+/*
+ * Calls that could come next: those not yet ordered that started before every other such call returned. Calls with
+ * an unknown outcome never returned as far as the order is concerned.
+ */
+func (s *linearSearch) candidates(done []bool) []int {
+	end := time.Duration(1<<63 - 1)
+	for i, c := range s.calls {
+		if !done[i] && c.Code != StressUnknown && c.End < end {
+			end = c.End
+		}
+	}
+	ret := []int{}
+	for i, c := range s.calls {
+		if !done[i] && c.Start <= end {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+func (s *linearSearch) search(state stressState) bool {
+	if len(s.order) == len(s.calls) {
+		return true
+	}
+	if len(s.order) > len(s.best) {
+		s.best = append([]int{}, s.order...)
+		s.bestState = state
+	}
+	for _, i := range s.candidates(s.done) {
+		for _, next := range state.step(s.calls[i]) {
+			if len(s.seen) >= stressSearchLimit {
+				return false
+			}
+			s.done[i] = true
+			key := s.key(next)
+			if !s.seen[key] {
+				s.seen[key] = true
+				s.order = append(s.order, i)
+				if s.search(next) {
+					return true
+				}
+				s.order = s.order[:len(s.order)-1]
+			}
+			s.done[i] = false
+		}
+	}
+	return false
+}
+
+func (s *linearSearch) key(state stressState) string {
+	bits := make([]byte, (len(s.done)+7)/8)
+	for i, d := range s.done {
+		if d {
+			bits[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return string(bits) + state.String()
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+ * A single entry within a content manifest. Timestamps are intentionally not included, as they aren't expected to
+ * survive every kind of remote round trip.
+ */
+type ManifestEntry struct {
+	Path   string
+	Size   int64
+	Mode   os.FileMode
+	Uid    int
+	Gid    int
+	Link   string
+	Sha256 string
+}
+
+/*
+ * Content manifest of a directory tree, keyed by path relative to the root of the tree.
+ */
+type Manifest map[string]ManifestEntry
+
+/*
+ * Difference between two manifests. Missing entries are those in the expected manifest but not the actual one,
+ * while extra entries are those that only appear in the actual manifest.
+ */
+type ManifestDiff struct {
+	Missing []string
+	Extra   []string
+	Changed []ManifestChange
+}
+
+type ManifestChange struct {
+	Path     string
+	Fields   []string
+	Expected ManifestEntry
+	Actual   ManifestEntry
+}
+
+/*
+ * Build a manifest from a tar stream, hashing the contents of every regular file as we go.
+ */
+func BuildManifest(r io.Reader) (Manifest, error) {
+	manifest := Manifest{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return manifest, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		path := manifestPath(hdr.Name)
+		if path == "" {
+			continue
+		}
+		entry := ManifestEntry{
+			Path: path,
+			Mode: hdr.FileInfo().Mode(),
+			Uid:  hdr.Uid,
+			Gid:  hdr.Gid,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			hash := sha256.New()
+			entry.Size, err = io.Copy(hash, tr)
+			if err != nil {
+				return nil, err
+			}
+			entry.Sha256 = hex.EncodeToString(hash.Sum(nil))
+		case tar.TypeLink:
+			// Hard links are recorded as a copy of the file they refer to
+			target, ok := manifest[manifestPath(hdr.Linkname)]
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("hard link '%s' to unknown file '%s'", hdr.Name, hdr.Linkname))
+			}
+			entry.Size = target.Size
+			entry.Mode = target.Mode
+			entry.Sha256 = target.Sha256
+		case tar.TypeSymlink:
+			entry.Link = hdr.Linkname
+		}
+		manifest[path] = entry
+	}
+}
+
+/*
+ * Build a manifest of a local directory.
+ */
+func ManifestFromDirectory(dir string) (Manifest, error) {
+	r, w := io.Pipe()
+	go func() {
+		_ = w.CloseWithError(tarDirectory(dir, w))
+	}()
+	defer r.Close()
+	return BuildManifest(r)
+}
+
+func manifestPath(name string) string {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+/*
+ * Get all paths in the manifest, in sorted order.
+ */
+func (m Manifest) Paths() []string {
+	ret := make([]string, 0, len(m))
+	for p := range m {
+		ret = append(ret, p)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+/*
+ * Compare two manifests, returning all differences between them.
+ */
+func DiffManifests(expected Manifest, actual Manifest) ManifestDiff {
+	diff := ManifestDiff{}
+	for _, p := range expected.Paths() {
+		a, ok := actual[p]
+		if !ok {
+			diff.Missing = append(diff.Missing, p)
+			continue
+		}
+		e := expected[p]
+		fields := []string{}
+		if e.Mode != a.Mode {
+			fields = append(fields, "mode")
+		}
+		if e.Size != a.Size {
+			fields = append(fields, "size")
+		}
+		if e.Sha256 != a.Sha256 {
+			fields = append(fields, "sha256")
+		}
+		if e.Uid != a.Uid || e.Gid != a.Gid {
+			fields = append(fields, "owner")
+		}
+		if e.Link != a.Link {
+			fields = append(fields, "link")
+		}
+		if len(fields) != 0 {
+			diff.Changed = append(diff.Changed, ManifestChange{Path: p, Fields: fields, Expected: e, Actual: a})
+		}
+	}
+	for _, p := range actual.Paths() {
+		if _, ok := expected[p]; !ok {
+			diff.Extra = append(diff.Extra, p)
+		}
+	}
+	return diff
+}
+
+func (d ManifestDiff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Changed) == 0
+}
+
+func (d ManifestDiff) String() string {
+	var b strings.Builder
+	for _, p := range d.Missing {
+		fmt.Fprintf(&b, "missing: %s\n", p)
+	}
+	for _, p := range d.Extra {
+		fmt.Fprintf(&b, "extra:   %s\n", p)
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(&b, "changed: %s (%s)\n    expected: %+v\n    actual:   %+v\n", c.Path,
+			strings.Join(c.Fields, ", "), c.Expected, c.Actual)
+	}
+	return b.String()
+}
+
+/*
+ * Build a manifest of the complete contents of a volume.
+ */
+func (e *EndToEndTest) VolumeManifest(repo string, volume string) (Manifest, error) {
+	mountpoint, err := e.GetVolumePath(repo, volume)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	err = e.copyOut(e.GetContainer("server"), mountpoint, func(r io.Reader) error {
+		var err error
+		manifest, err = BuildManifest(r)
+		return err
+	}, ".")
+	return manifest, err
+}
+
+/*
+ * Compare the contents of two volumes, which may be in different repositories.
+ */
+func (e *EndToEndTest) DiffVolumes(repoA string, volA string, repoB string, volB string) (ManifestDiff, error) {
+	a, err := e.VolumeManifest(repoA, volA)
+	if err != nil {
+		return ManifestDiff{}, err
+	}
+	b, err := e.VolumeManifest(repoB, volB)
+	if err != nil {
+		return ManifestDiff{}, err
+	}
+	return DiffManifests(a, b), nil
+}
+
+/*
+ * Assert that the contents of a volume exactly match the given manifest, reporting every difference if not.
+ */
+func (e *EndToEndTest) AssertVolumeMatchesManifest(repo string, volume string, expected Manifest) bool {
+	actual, err := e.VolumeManifest(repo, volume)
+	if !e.NoError(err) {
+		return false
+	}
+	diff := DiffManifests(expected, actual)
+	if !diff.Empty() {
+		return e.Suite.Fail(fmt.Sprintf("volume %s/%s does not match manifest", repo, volume), diff.String())
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestFromDirectory(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "titan-manifest")
+	defer os.RemoveAll(dir)
+	_ = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(dir, "sub", "file"), []byte("hello"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(dir, ".hidden"), []byte{}, 0600)
+	_ = os.Symlink("sub/file", filepath.Join(dir, "link"))
+
+	m, err := ManifestFromDirectory(dir)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{".hidden", "link", "sub", "sub/file"}, m.Paths())
+		assert.Equal(t, int64(5), m["sub/file"].Size)
+		assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", m["sub/file"].Sha256)
+		assert.Equal(t, "sub/file", m["link"].Link)
+		assert.True(t, m["sub"].Mode.IsDir())
+	}
+}
+
+func TestDiffManifests(t *testing.T) {
+	expected := Manifest{
+		"a": {Path: "a", Size: 1, Mode: 0644, Sha256: "aaa"},
+		"b": {Path: "b", Size: 1, Mode: 0644, Sha256: "bbb"},
+		"c": {Path: "c", Size: 1, Mode: 0644, Sha256: "ccc"},
+	}
+	actual := Manifest{
+		"a": {Path: "a", Size: 1, Mode: 0644, Sha256: "aaa"},
+		"b": {Path: "b", Size: 2, Mode: 0600, Sha256: "BBB"},
+		"d": {Path: "d", Size: 1, Mode: 0644, Sha256: "ddd"},
+	}
+	diff := DiffManifests(expected, actual)
+	assert.False(t, diff.Empty())
+	assert.Equal(t, []string{"c"}, diff.Missing)
+	assert.Equal(t, []string{"d"}, diff.Extra)
+	if assert.Len(t, diff.Changed, 1) {
+		assert.Equal(t, "b", diff.Changed[0].Path)
+		assert.Equal(t, []string{"mode", "size", "sha256"}, diff.Changed[0].Fields)
+	}
+	assert.True(t, DiffManifests(expected, expected).Empty())
+}
@@ -0,0 +1,783 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ * Kinds of actions issued by the model based tester. They follow the names of the equivalent scenario actions where
+ * there is one.
+ */
+const (
+	ModelCreateRepo   = "createRepo"
+	ModelDeleteRepo   = "deleteRepo"
+	ModelUpdateRepo   = "updateRepo"
+	ModelCreateVolume = "createVolume"
+	ModelDeleteVolume = "deleteVolume"
+	ModelCommit       = "commit"
+	ModelDeleteCommit = "deleteCommit"
+	ModelUpdateCommit = "updateCommit"
+	ModelCheckout     = "checkout"
+	ModelAddRemote    = "addRemote"
+	ModelDeleteRemote = "deleteRemote"
+	ModelUpdateRemote = "updateRemote"
+	ModelPush         = "push"
+	ModelPull         = "pull"
+)
+
+const (
+	modelObjectExists = "ObjectExistsException"
+	modelNoSuchObject = "NoSuchObjectException"
+)
+
+/*
+ * A single API call made by the model based tester. Tags are the commit tags for commit actions, and the
+ * repository properties for repository actions. NewName is the new remote name when renaming a remote.
+ */
+type ModelAction struct {
+	Kind    string
+	Repo    string
+	Volume  string
+	Commit  string
+	Remote  string
+	NewName string
+	Tags    map[string]string
+}
+
+func (a ModelAction) String() string {
+	ret := fmt.Sprintf("%s %s", a.Kind, a.Repo)
+	switch a.Kind {
+	case ModelCreateVolume, ModelDeleteVolume:
+		ret += "/" + a.Volume
+	case ModelCommit, ModelDeleteCommit, ModelUpdateCommit, ModelCheckout:
+		ret += "/" + a.Commit
+	case ModelAddRemote, ModelDeleteRemote:
+		ret += "/" + a.Remote
+	case ModelUpdateRemote:
+		ret += fmt.Sprintf("/%s -> %s", a.Remote, a.NewName)
+	case ModelPush:
+		ret += fmt.Sprintf("/%s -> %s", a.Commit, a.Remote)
+	case ModelPull:
+		ret += fmt.Sprintf("/%s <- %s", a.Commit, a.Remote)
+	}
+	if a.Tags != nil {
+		ret += " " + formatModelTags(a.Tags)
+	}
+	return ret
+}
+
+/*
+ * Outcome of running an action. Code is the API error code, or empty if the action succeeded. Commits created or
+ * pulled also report their timestamp, and pulled commits their tags, since the model can't predict them.
+ */
+type ModelResult struct {
+	Code      string
+	Timestamp string
+	Tags      map[string]string
+}
+
+/*
+ * Observed state of a single repository.
+ */
+type ModelRepoState struct {
+	Properties   map[string]string
+	Volumes      []string
+	Commits      map[string]map[string]string
+	Remotes      []string
+	LastCommit   string
+	SourceCommit string
+	TagQueries   map[string][]string
+}
+
+type ModelState map[string]*ModelRepoState
+
+/*
+ * The system under test. Reset removes everything, Run performs an action, returning an error only if the action
+ * couldn't be run at all (anything other than an API error), and State reads back every repository along with the
+ * commits matching each of the given tag queries.
+ */
+type ModelSystem interface {
+	Reset(ctx context.Context) error
+	Run(ctx context.Context, action ModelAction) (ModelResult, error)
+	State(ctx context.Context, tagQueries []string) (ModelState, error)
+}
+
+/*
+ * In-memory model of what the server should contain. Where the server is allowed to behave in more than one way,
+ * typically because the reaper runs asynchronously, the model accepts any of them:
+ *
+ *   - Deleted volumes are only marked for deletion, and continue to be listed (and to block the name from being
+ *     reused) until reaped. The model tracks these as volumes that may or may not be present.
+ *   - The source commit is the newest commit in the active volume set, even if it has been deleted but not yet
+ *     reaped, falling back to the commit that was checked out. Any commit that could be reported is accepted.
+ *   - Commits with identical timestamps can be reported as the last commit in either order.
+ *
+ * Checkout is expected to restore the volumes as they were when the commit was made. Pulled commits are never
+ * checked out, since their volumes depend on the remote.
+ */
+type ApiModel struct {
+	repos map[string]*modelRepo
+	names int
+}
+
+type modelRepo struct {
+	properties map[string]string
+	volumes    map[string]bool
+	commits    map[string]*modelCommit
+	remotes    map[string]bool
+	active     *modelVolumeSet
+}
+
+type modelVolumeSet struct {
+	source  string
+	commits []*modelCommit
+}
+
+type modelCommit struct {
+	id        string
+	timestamp string
+	tags      map[string]string
+	volumes   map[string]bool
+	pulled    bool
+	deleted   bool
+}
+
+/*
+ * Repositories are drawn from a small fixed set, so that names are regularly deleted and reused.
+ */
+var modelRepoNames = []string{"alpha", "beta"}
+
+var modelTagQueries = []string{"a", "a=1", "b=2"}
+
+func NewApiModel() *ApiModel {
+	return &ApiModel{repos: map[string]*modelRepo{}}
+}
+
+func (m *ApiModel) TagQueries() []string {
+	return modelTagQueries
+}
+
+/*
+ * Acceptable outcomes of an action in the current state, as API error codes, with an empty string for success.
+ */
+func (m *ApiModel) Expect(a ModelAction) []string {
+	repo, exists := m.repos[a.Repo]
+	if a.Kind == ModelCreateRepo {
+		if exists {
+			return []string{modelObjectExists}
+		}
+		return []string{""}
+	}
+	if !exists {
+		return []string{modelNoSuchObject}
+	}
+	switch a.Kind {
+	case ModelCreateVolume:
+		present, known := repo.volumes[a.Volume]
+		if !known {
+			return []string{""}
+		} else if present {
+			return []string{modelObjectExists}
+		}
+		return []string{"", modelObjectExists}
+	case ModelDeleteVolume:
+		if !repo.volumes[a.Volume] {
+			return []string{modelNoSuchObject}
+		}
+	case ModelCommit:
+		if repo.commits[a.Commit] != nil {
+			return []string{modelObjectExists}
+		}
+	case ModelDeleteCommit, ModelUpdateCommit, ModelCheckout:
+		if repo.commits[a.Commit] == nil {
+			return []string{modelNoSuchObject}
+		}
+	case ModelAddRemote:
+		if repo.remotes[a.Remote] {
+			return []string{modelObjectExists}
+		}
+	case ModelDeleteRemote:
+		if !repo.remotes[a.Remote] {
+			return []string{modelNoSuchObject}
+		}
+	case ModelUpdateRemote:
+		if !repo.remotes[a.Remote] {
+			return []string{modelNoSuchObject}
+		}
+		if a.NewName != a.Remote && repo.remotes[a.NewName] {
+			return []string{modelObjectExists}
+		}
+	case ModelPush:
+		if !repo.remotes[a.Remote] || repo.commits[a.Commit] == nil {
+			return []string{modelNoSuchObject}
+		}
+	case ModelPull:
+		if !repo.remotes[a.Remote] {
+			return []string{modelNoSuchObject}
+		}
+		if repo.commits[a.Commit] != nil {
+			return []string{modelObjectExists}
+		}
+	}
+	return []string{""}
+}
+
+/*
+ * Update the model with the outcome of an action, which must be one of those returned by Expect.
+ */
+func (m *ApiModel) Apply(a ModelAction, res ModelResult) {
+	if res.Code != "" {
+		return
+	}
+	if a.Kind == ModelCreateRepo {
+		m.repos[a.Repo] = &modelRepo{
+			properties: copyModelTags(a.Tags),
+			volumes:    map[string]bool{},
+			commits:    map[string]*modelCommit{},
+			remotes:    map[string]bool{},
+			active:     &modelVolumeSet{},
+		}
+		return
+	}
+	repo := m.repos[a.Repo]
+	switch a.Kind {
+	case ModelDeleteRepo:
+		delete(m.repos, a.Repo)
+	case ModelUpdateRepo:
+		repo.properties = copyModelTags(a.Tags)
+	case ModelCreateVolume:
+		repo.volumes[a.Volume] = true
+	case ModelDeleteVolume:
+		repo.volumes[a.Volume] = false
+	case ModelCommit:
+		commit := &modelCommit{
+			id:        a.Commit,
+			timestamp: res.Timestamp,
+			tags:      copyModelTags(a.Tags),
+			volumes:   map[string]bool{},
+		}
+		for v, present := range repo.volumes {
+			commit.volumes[v] = present
+		}
+		repo.commits[a.Commit] = commit
+		repo.active.commits = append(repo.active.commits, commit)
+	case ModelDeleteCommit:
+		repo.commits[a.Commit].deleted = true
+		delete(repo.commits, a.Commit)
+	case ModelUpdateCommit:
+		repo.commits[a.Commit].tags = copyModelTags(a.Tags)
+	case ModelCheckout:
+		repo.active = &modelVolumeSet{source: a.Commit}
+		repo.volumes = map[string]bool{}
+		for v, present := range repo.commits[a.Commit].volumes {
+			repo.volumes[v] = present
+		}
+	case ModelAddRemote:
+		repo.remotes[a.Remote] = true
+	case ModelDeleteRemote:
+		delete(repo.remotes, a.Remote)
+	case ModelUpdateRemote:
+		delete(repo.remotes, a.Remote)
+		repo.remotes[a.NewName] = true
+	case ModelPull:
+		repo.commits[a.Commit] = &modelCommit{
+			id:        a.Commit,
+			timestamp: res.Timestamp,
+			tags:      copyModelTags(res.Tags),
+			pulled:    true,
+		}
+	}
+}
+
+/*
+ * Compare the observed state against the model, returning a description of each difference.
+ */
+func (m *ApiModel) Verify(state ModelState) []string {
+	problems := []string{}
+	expectedRepos := []string{}
+	for name := range m.repos {
+		expectedRepos = append(expectedRepos, name)
+	}
+	actualRepos := []string{}
+	for name := range state {
+		actualRepos = append(actualRepos, name)
+	}
+	if p := compareModelNames("repositories", expectedRepos, actualRepos); p != "" {
+		return []string{p}
+	}
+
+	for _, name := range sortedModelKeys(m.repos) {
+		repo := m.repos[name]
+		actual := state[name]
+		fail := func(format string, args ...interface{}) {
+			problems = append(problems, fmt.Sprintf("%s: ", name)+fmt.Sprintf(format, args...))
+		}
+
+		if !reflect.DeepEqual(repo.properties, nonNilModelTags(actual.Properties)) {
+			fail("expected properties %s, got %s", formatModelTags(repo.properties),
+				formatModelTags(actual.Properties))
+		}
+
+		listed := map[string]bool{}
+		for _, v := range actual.Volumes {
+			listed[v] = true
+			if _, known := repo.volumes[v]; !known {
+				fail("unexpected volume %s", v)
+			}
+		}
+		for v, present := range repo.volumes {
+			if present && !listed[v] {
+				fail("missing volume %s", v)
+			}
+		}
+
+		if p := compareModelNames("commits", sortedModelKeys(repo.commits), sortedModelKeys(actual.Commits)); p != "" {
+			fail("%s", p)
+		}
+		for id, commit := range repo.commits {
+			if tags, ok := actual.Commits[id]; ok && !reflect.DeepEqual(commit.tags, nonNilModelTags(tags)) {
+				fail("commit %s: expected tags %s, got %s", id, formatModelTags(commit.tags), formatModelTags(tags))
+			}
+		}
+		for _, query := range modelTagQueries {
+			expected := []string{}
+			for id, commit := range repo.commits {
+				if matchModelTags(commit.tags, query) {
+					expected = append(expected, id)
+				}
+			}
+			if p := compareModelNames("commits", expected, actual.TagQueries[query]); p != "" {
+				fail("tag query '%s': %s", query, p)
+			}
+		}
+
+		if p := compareModelNames("remotes", sortedModelKeys(repo.remotes), actual.Remotes); p != "" {
+			fail("%s", p)
+		}
+
+		if last := repo.lastCommits(); !containsModelName(last, actual.LastCommit) {
+			fail("expected last commit %s, got '%s'", formatModelCandidates(last), actual.LastCommit)
+		}
+		if source := repo.sourceCommits(); !containsModelName(source, actual.SourceCommit) {
+			fail("expected source commit %s, got '%s'", formatModelCandidates(source), actual.SourceCommit)
+		}
+	}
+	return problems
+}
+
+/*
+ * Commits that could be reported as the last commit: the live commits with the newest timestamp.
+ */
+func (r *modelRepo) lastCommits() []string {
+	commits := []*modelCommit{}
+	for _, c := range r.commits {
+		commits = append(commits, c)
+	}
+	groups := groupModelCommits(commits)
+	if len(groups) == 0 {
+		return []string{""}
+	}
+	ret := []string{}
+	for _, c := range groups[0] {
+		ret = append(ret, c.id)
+	}
+	return ret
+}
+
+/*
+ * Commits that could be reported as the source commit. Starting from the newest, each commit in the active volume
+ * set could be the source, until one is reached that hasn't been deleted and so can't have been reaped. If every
+ * commit could have been reaped, the commit the volume set was checked out from could be the source.
+ */
+func (r *modelRepo) sourceCommits() []string {
+	ret := []string{}
+	for _, group := range groupModelCommits(r.active.commits) {
+		live := false
+		for _, c := range group {
+			ret = append(ret, c.id)
+			live = live || !c.deleted
+		}
+		if live {
+			return ret
+		}
+	}
+	return append(ret, r.active.source)
+}
+
+/*
+ * Group commits with identical timestamps, newest first.
+ */
+func groupModelCommits(commits []*modelCommit) [][]*modelCommit {
+	sorted := append([]*modelCommit{}, commits...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return parseModelTimestamp(sorted[i].timestamp).After(parseModelTimestamp(sorted[j].timestamp))
+	})
+	ret := [][]*modelCommit{}
+	for i, c := range sorted {
+		if i > 0 && parseModelTimestamp(c.timestamp).Equal(parseModelTimestamp(sorted[i-1].timestamp)) {
+			ret[len(ret)-1] = append(ret[len(ret)-1], c)
+		} else {
+			ret = append(ret, []*modelCommit{c})
+		}
+	}
+	return ret
+}
+
+func parseModelTimestamp(timestamp string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, timestamp)
+	return t
+}
+
+/*
+ * Generate a random action. Most actions target objects that exist, so that sequences get somewhere, but some
+ * deliberately target objects that don't in order to exercise error handling. Likewise, new objects usually get new
+ * names but occasionally reuse an existing one. Repositories are drawn from a small fixed set of names, and pulled
+ * commits have their own names so that they are never checked out.
+ */
+func (m *ApiModel) Generate(r *rand.Rand) ModelAction {
+	kinds := []struct {
+		kind   string
+		weight int
+	}{
+		{ModelCreateRepo, 4}, {ModelDeleteRepo, 1}, {ModelUpdateRepo, 2},
+		{ModelCreateVolume, 8}, {ModelDeleteVolume, 4},
+		{ModelCommit, 10}, {ModelDeleteCommit, 4}, {ModelUpdateCommit, 4}, {ModelCheckout, 5},
+		{ModelAddRemote, 4}, {ModelDeleteRemote, 2}, {ModelUpdateRemote, 2},
+		{ModelPush, 4}, {ModelPull, 4},
+	}
+	total := 0
+	for _, k := range kinds {
+		total += k.weight
+	}
+	n := r.Intn(total)
+	kind := ""
+	for _, k := range kinds {
+		if n < k.weight {
+			kind = k.kind
+			break
+		}
+		n -= k.weight
+	}
+
+	a := ModelAction{Kind: kind, Repo: modelRepoNames[r.Intn(len(modelRepoNames))]}
+	if kind != ModelCreateRepo && len(m.repos) != 0 && r.Intn(10) != 0 {
+		existing := sortedModelKeys(m.repos)
+		a.Repo = existing[r.Intn(len(existing))]
+	}
+	repo := m.repos[a.Repo]
+	if repo == nil {
+		repo = &modelRepo{volumes: map[string]bool{}, commits: map[string]*modelCommit{}, remotes: map[string]bool{}}
+	}
+
+	pick := func(existing []string, prefix string) string {
+		if len(existing) != 0 && r.Intn(5) != 0 {
+			return existing[r.Intn(len(existing))]
+		}
+		m.names++
+		return fmt.Sprintf("%s%d", prefix, m.names)
+	}
+	fresh := func(existing []string, prefix string) string {
+		if len(existing) != 0 && r.Intn(5) == 0 {
+			return existing[r.Intn(len(existing))]
+		}
+		m.names++
+		return fmt.Sprintf("%s%d", prefix, m.names)
+	}
+	volumes := []string{}
+	for v, present := range repo.volumes {
+		if present {
+			volumes = append(volumes, v)
+		}
+	}
+	sort.Strings(volumes)
+	commits := []string{}
+	pulled := []string{}
+	for _, id := range sortedModelKeys(repo.commits) {
+		if repo.commits[id].pulled {
+			pulled = append(pulled, id)
+		} else {
+			commits = append(commits, id)
+		}
+	}
+	remotes := sortedModelKeys(repo.remotes)
+
+	switch kind {
+	case ModelCreateRepo, ModelUpdateRepo:
+		a.Tags = randomModelTags(r)
+	case ModelCreateVolume:
+		a.Volume = fresh(sortedModelKeys(repo.volumes), "v")
+	case ModelDeleteVolume:
+		a.Volume = pick(volumes, "v")
+	case ModelCommit:
+		a.Commit = fresh(commits, "c")
+		a.Tags = randomModelTags(r)
+	case ModelDeleteCommit, ModelUpdateCommit:
+		a.Commit = pick(append(commits, pulled...), "c")
+		if kind == ModelUpdateCommit {
+			a.Tags = randomModelTags(r)
+		}
+	case ModelCheckout, ModelPush:
+		a.Commit = pick(commits, "c")
+		if kind == ModelPush {
+			a.Remote = pick(remotes, "r")
+		}
+	case ModelAddRemote:
+		a.Remote = fresh(remotes, "r")
+	case ModelDeleteRemote:
+		a.Remote = pick(remotes, "r")
+	case ModelUpdateRemote:
+		a.Remote = pick(remotes, "r")
+		a.NewName = fresh(remotes, "r")
+	case ModelPull:
+		a.Commit = fresh(pulled, "p")
+		a.Remote = pick(remotes, "r")
+	}
+	return a
+}
+
+func randomModelTags(r *rand.Rand) map[string]string {
+	tags := map[string]string{}
+	for _, k := range []string{"a", "b"} {
+		if r.Intn(2) == 0 {
+			tags[k] = fmt.Sprintf("%d", r.Intn(2)+1)
+		}
+	}
+	return tags
+}
+
+/*
+ * Run an action against the system and check both its outcome and the resulting state against the model,
+ * returning a description of each problem found.
+ */
+func (m *ApiModel) Step(ctx context.Context, sys ModelSystem, a ModelAction) []string {
+	res, err := sys.Run(ctx, a)
+	if err != nil {
+		return []string{fmt.Sprintf("%s failed: %v", a, err)}
+	}
+	expected := m.Expect(a)
+	if !containsModelName(expected, res.Code) {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", a, formatModelOutcomes(expected),
+			formatModelOutcomes([]string{res.Code}))}
+	}
+	m.Apply(a, res)
+	state, err := sys.State(ctx, modelTagQueries)
+	if err != nil {
+		return []string{fmt.Sprintf("failed to get state after %s: %v", a, err)}
+	}
+	return m.Verify(state)
+}
+
+/*
+ * A sequence of actions that fails, along with the problems found after its last action.
+ */
+type ModelFailure struct {
+	Seed     int64
+	Actions  []ModelAction
+	Problems []string
+}
+
+func (f *ModelFailure) Error() string {
+	lines := []string{fmt.Sprintf("model check failed with seed %d after %d actions:", f.Seed, len(f.Actions))}
+	for i, a := range f.Actions {
+		lines = append(lines, fmt.Sprintf("  %d. %s", i+1, a))
+	}
+	lines = append(lines, "problems:")
+	for _, p := range f.Problems {
+		lines = append(lines, "  "+p)
+	}
+	return strings.Join(lines, "\n")
+}
+
+/*
+ * Run a fixed sequence of actions from a clean system, returning the number of actions run and the problems found
+ * by the last of them, or no problems if the whole sequence passed.
+ */
+func CheckModelActions(ctx context.Context, sys ModelSystem, actions []ModelAction) (int, []string) {
+	err := sys.Reset(ctx)
+	if err != nil {
+		return 0, []string{fmt.Sprintf("failed to reset: %v", err)}
+	}
+	m := NewApiModel()
+	for i, a := range actions {
+		if problems := m.Step(ctx, sys, a); len(problems) != 0 {
+			return i + 1, problems
+		}
+	}
+	return len(actions), nil
+}
+
+/*
+ * Run a random sequence of up to the given number of actions from a clean system. If it fails, the sequence is
+ * shrunk to a minimal one that still fails, which is returned.
+ */
+func RunRandomModel(ctx context.Context, sys ModelSystem, seed int64, steps int, maxShrinks int) *ModelFailure {
+	r := rand.New(rand.NewSource(seed))
+	err := sys.Reset(ctx)
+	if err != nil {
+		return &ModelFailure{Seed: seed, Problems: []string{fmt.Sprintf("failed to reset: %v", err)}}
+	}
+	m := NewApiModel()
+	actions := []ModelAction{}
+	for i := 0; i < steps; i++ {
+		a := m.Generate(r)
+		actions = append(actions, a)
+		if problems := m.Step(ctx, sys, a); len(problems) != 0 {
+			actions, problems = ShrinkModelActions(ctx, sys, actions, problems, maxShrinks)
+			return &ModelFailure{Seed: seed, Actions: actions, Problems: problems}
+		}
+	}
+	return nil
+}
+
+/*
+ * Shrink a failing sequence by repeatedly removing chunks of actions, starting with large chunks and moving on to
+ * smaller ones, keeping any removal after which the sequence still fails in the same way (see
+ * modelFailureSignature), so that a shorter sequence failing for an unrelated reason isn't reported instead. Each
+ * attempt replays the sequence from a clean system, so the number of attempts is limited. Failures that can't be
+ * reproduced are left as they are.
+ */
+func ShrinkModelActions(ctx context.Context, sys ModelSystem, actions []ModelAction, problems []string,
+	maxAttempts int) ([]ModelAction, []string) {
+	if len(actions) == 0 {
+		return actions, problems
+	}
+	signature := modelFailureSignature(actions[len(actions)-1], problems)
+	attempts := 0
+	for chunk := len(actions) / 2; chunk >= 1 && attempts < maxAttempts; {
+		removed := false
+		for start := 0; start+chunk <= len(actions) && attempts < maxAttempts; {
+			candidate := append(append([]ModelAction{}, actions[:start]...), actions[start+chunk:]...)
+			attempts++
+			n, p := CheckModelActions(ctx, sys, candidate)
+			if len(p) != 0 && n != 0 && modelFailureSignature(candidate[n-1], p) == signature {
+				actions, problems = candidate[:n], p
+				removed = true
+			} else {
+				start += chunk
+			}
+		}
+		if !removed {
+			chunk /= 2
+		} else if chunk > len(actions)/2 {
+			chunk = len(actions) / 2
+		}
+	}
+	return actions, problems
+}
+
+var (
+	modelQuotedPattern = regexp.MustCompile(`'[^']*'|\[[^\]]*\]|\{[^}]*\}`)
+	modelNamePattern   = regexp.MustCompile(`\b[A-Za-z]*[0-9]+\b`)
+)
+
+/*
+ * Identify a failure by the kind of action that failed and the first problem found, with the names and values it
+ * mentions removed. Removing actions while shrinking can change which objects are involved, or what was listed, but
+ * a different kind of action failing, or failing with a different problem, means a different bug.
+ */
+func modelFailureSignature(a ModelAction, problems []string) string {
+	first := ""
+	if len(problems) != 0 {
+		first = modelQuotedPattern.ReplaceAllStringFunc(problems[0], func(s string) string {
+			return s[:1] + s[len(s)-1:]
+		})
+		first = modelNamePattern.ReplaceAllString(first, "#")
+	}
+	return fmt.Sprintf("%s: %s", a.Kind, first)
+}
+
+func copyModelTags(tags map[string]string) map[string]string {
+	ret := map[string]string{}
+	for k, v := range tags {
+		ret[k] = v
+	}
+	return ret
+}
+
+func nonNilModelTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return map[string]string{}
+	}
+	return tags
+}
+
+/*
+ * Match tags against a query, which is either a key that must be present, or key=value.
+ */
+func matchModelTags(tags map[string]string, query string) bool {
+	if idx := strings.Index(query, "="); idx != -1 {
+		value, ok := tags[query[:idx]]
+		return ok && value == query[idx+1:]
+	}
+	_, ok := tags[query]
+	return ok
+}
+
+func formatModelTags(tags map[string]string) string {
+	pairs := []string{}
+	for _, k := range sortedModelKeys(tags) {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, tags[k]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func formatModelOutcomes(codes []string) string {
+	ret := []string{}
+	for _, c := range codes {
+		if c == "" {
+			c = "success"
+		}
+		ret = append(ret, c)
+	}
+	return strings.Join(ret, " or ")
+}
+
+func formatModelCandidates(names []string) string {
+	ret := []string{}
+	for _, n := range names {
+		ret = append(ret, fmt.Sprintf("'%s'", n))
+	}
+	return strings.Join(ret, " or ")
+}
+
+func compareModelNames(what string, expected []string, actual []string) string {
+	expected = append([]string{}, expected...)
+	actual = append([]string{}, actual...)
+	sort.Strings(expected)
+	sort.Strings(actual)
+	if reflect.DeepEqual(expected, actual) {
+		return ""
+	}
+	return fmt.Sprintf("expected %s [%s], got [%s]", what, strings.Join(expected, ", "), strings.Join(actual, ", "))
+}
+
+func containsModelName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * Sorted keys of a map with string keys, so that generation and reporting are deterministic.
+ */
+func sortedModelKeys(m interface{}) []string {
+	ret := []string{}
+	for _, k := range reflect.ValueOf(m).MapKeys() {
+		ret = append(ret, k.String())
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"fmt"
+	"github.com/antihax/optional"
+	titan "github.com/titan-data/titan-client-go"
+)
+
+/*
+ * Model system backed by the titan server. Volumes are activated when created, and deactivated before being deleted
+ * or checked out, the same way the CLI does it. Push and pull use the nop provider, and wait for the operation to
+ * complete. Nothing is registered for cleanup, since everything is removed on each reset.
+ */
+type apiModelSystem struct {
+	e *EndToEndTest
+}
+
+func (e *EndToEndTest) ModelSystem() ModelSystem {
+	return &apiModelSystem{e: e}
+}
+
+/*
+ * Delete every repository on the server.
+ */
+func (s *apiModelSystem) Reset(ctx context.Context) error {
+	repos, _, err := s.e.RepoApi.ListRepositories(ctx)
+	if err != nil {
+		return err
+	}
+	for _, repo := range repos {
+		s.deactivateVolumes(ctx, repo.Name)
+		_, err = s.e.RepoApi.DeleteRepository(ctx, repo.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *apiModelSystem) Run(ctx context.Context, a ModelAction) (ModelResult, error) {
+	res := ModelResult{}
+	err := s.run(ctx, a, &res)
+	if err != nil {
+		res.Code = apiErrorCode(err)
+		if res.Code == "" {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func (s *apiModelSystem) run(ctx context.Context, a ModelAction, res *ModelResult) error {
+	var err error
+	switch a.Kind {
+	case ModelCreateRepo:
+		_, _, err = s.e.RepoApi.CreateRepository(ctx, titan.Repository{Name: a.Repo, Properties: modelProperties(a.Tags)})
+	case ModelDeleteRepo:
+		s.deactivateVolumes(ctx, a.Repo)
+		_, err = s.e.RepoApi.DeleteRepository(ctx, a.Repo)
+	case ModelUpdateRepo:
+		_, _, err = s.e.RepoApi.UpdateRepository(ctx, a.Repo, titan.Repository{Name: a.Repo,
+			Properties: modelProperties(a.Tags)})
+	case ModelCreateVolume:
+		err = s.createVolume(ctx, a.Repo, a.Volume)
+	case ModelDeleteVolume:
+		_, err = s.e.VolumeApi.DeactivateVolume(ctx, a.Repo, a.Volume)
+		if err == nil {
+			_, err = s.e.VolumeApi.DeleteVolume(ctx, a.Repo, a.Volume)
+		}
+	case ModelCommit:
+		err = s.commit(ctx, a, res)
+	case ModelDeleteCommit:
+		_, err = s.e.CommitApi.DeleteCommit(ctx, a.Repo, a.Commit)
+	case ModelUpdateCommit:
+		err = s.updateCommit(ctx, a)
+	case ModelCheckout:
+		err = s.checkout(ctx, a.Repo, a.Commit)
+	case ModelAddRemote:
+		_, _, err = s.e.RemoteApi.CreateRemote(ctx, a.Repo, titan.Remote{Provider: "nop", Name: a.Remote,
+			Properties: map[string]interface{}{}})
+	case ModelDeleteRemote:
+		_, err = s.e.RemoteApi.DeleteRemote(ctx, a.Repo, a.Remote)
+	case ModelUpdateRemote:
+		_, _, err = s.e.RemoteApi.UpdateRemote(ctx, a.Repo, a.Remote, titan.Remote{Provider: "nop", Name: a.NewName,
+			Properties: map[string]interface{}{}})
+	case ModelPush:
+		var op titan.Operation
+		op, _, err = s.e.OperationsApi.Push(ctx, a.Repo, a.Remote, a.Commit, modelParameters(), nil)
+		if err == nil {
+			_, err = s.e.WaitForOperation(ctx, op.Id)
+		}
+	case ModelPull:
+		err = s.pull(ctx, a, res)
+	default:
+		panic(fmt.Sprintf("unknown model action '%s'", a.Kind))
+	}
+	return err
+}
+
+func (s *apiModelSystem) createVolume(ctx context.Context, repo string, volume string) error {
+	_, _, err := s.e.VolumeApi.CreateVolume(ctx, repo, titan.Volume{Name: volume,
+		Properties: map[string]interface{}{}})
+	if err != nil {
+		return err
+	}
+	err = s.e.WaitForVolume(ctx, repo, volume)
+	if err != nil {
+		return err
+	}
+	_, err = s.e.VolumeApi.ActivateVolume(ctx, repo, volume)
+	return err
+}
+
+func (s *apiModelSystem) commit(ctx context.Context, a ModelAction, res *ModelResult) error {
+	commit, _, err := s.e.CommitApi.CreateCommit(ctx, a.Repo, titan.Commit{Id: a.Commit,
+		Properties: map[string]interface{}{"tags": modelProperties(a.Tags)}})
+	if err != nil {
+		return err
+	}
+	res.Timestamp = fmt.Sprintf("%v", commit.Properties["timestamp"])
+	return s.e.WaitForCommit(ctx, a.Repo, a.Commit)
+}
+
+/*
+ * Replace the tags of a commit, keeping its original timestamp so that it doesn't become the newest commit.
+ */
+func (s *apiModelSystem) updateCommit(ctx context.Context, a ModelAction) error {
+	commit, _, err := s.e.CommitApi.GetCommit(ctx, a.Repo, a.Commit)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.e.CommitApi.UpdateCommit(ctx, a.Repo, a.Commit, titan.Commit{Id: a.Commit,
+		Properties: map[string]interface{}{
+			"tags":      modelProperties(a.Tags),
+			"timestamp": commit.Properties["timestamp"],
+		}})
+	return err
+}
+
+/*
+ * Check out a commit, deactivating the current volumes first and activating the new ones afterwards. Volumes that
+ * have been deleted but not yet reaped are still listed, and can't be deactivated or activated, so are skipped.
+ */
+func (s *apiModelSystem) checkout(ctx context.Context, repo string, commit string) error {
+	s.deactivateVolumes(ctx, repo)
+	_, checkoutErr := s.e.CommitApi.CheckoutCommit(ctx, repo, commit)
+	volumes, _, err := s.e.VolumeApi.ListVolumes(ctx, repo)
+	if err == nil {
+		for _, v := range volumes {
+			_, err = s.e.VolumeApi.ActivateVolume(ctx, repo, v.Name)
+			if err != nil && !isNoSuchObject(err) {
+				break
+			}
+			err = nil
+		}
+	}
+	if checkoutErr != nil {
+		return checkoutErr
+	}
+	return err
+}
+
+func (s *apiModelSystem) deactivateVolumes(ctx context.Context, repo string) {
+	volumes, _, err := s.e.VolumeApi.ListVolumes(ctx, repo)
+	if err == nil {
+		for _, v := range volumes {
+			_, _ = s.e.VolumeApi.DeactivateVolume(ctx, repo, v.Name)
+		}
+	}
+}
+
+func (s *apiModelSystem) pull(ctx context.Context, a ModelAction, res *ModelResult) error {
+	op, _, err := s.e.OperationsApi.Pull(ctx, a.Repo, a.Remote, a.Commit, modelParameters(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.e.WaitForOperation(ctx, op.Id)
+	if err != nil {
+		return err
+	}
+	commit, _, err := s.e.CommitApi.GetCommit(ctx, a.Repo, a.Commit)
+	if err != nil {
+		return err
+	}
+	res.Timestamp = fmt.Sprintf("%v", commit.Properties["timestamp"])
+	res.Tags = modelTags(commit.Properties["tags"])
+	return nil
+}
+
+/*
+ * Read back every repository, along with its volumes, commits, remotes, status, and the result of each tag query.
+ */
+func (s *apiModelSystem) State(ctx context.Context, tagQueries []string) (ModelState, error) {
+	repos, _, err := s.e.RepoApi.ListRepositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	state := ModelState{}
+	for _, repo := range repos {
+		r := &ModelRepoState{
+			Properties: modelTags(repo.Properties),
+			Commits:    map[string]map[string]string{},
+			TagQueries: map[string][]string{},
+		}
+		state[repo.Name] = r
+
+		volumes, _, err := s.e.VolumeApi.ListVolumes(ctx, repo.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range volumes {
+			r.Volumes = append(r.Volumes, v.Name)
+		}
+
+		commits, _, err := s.e.CommitApi.ListCommits(ctx, repo.Name, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range commits {
+			r.Commits[c.Id] = modelTags(c.Properties["tags"])
+		}
+		for _, query := range tagQueries {
+			commits, _, err := s.e.CommitApi.ListCommits(ctx, repo.Name,
+				&titan.ListCommitsOpts{Tag: optional.NewInterface([]string{query})})
+			if err != nil {
+				return nil, err
+			}
+			r.TagQueries[query] = []string{}
+			for _, c := range commits {
+				r.TagQueries[query] = append(r.TagQueries[query], c.Id)
+			}
+		}
+
+		remotes, _, err := s.e.RemoteApi.ListRemotes(ctx, repo.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, remote := range remotes {
+			r.Remotes = append(r.Remotes, remote.Name)
+		}
+
+		status, _, err := s.e.RepoApi.GetRepositoryStatus(ctx, repo.Name)
+		if err != nil {
+			return nil, err
+		}
+		r.LastCommit = status.LastCommit
+		r.SourceCommit = status.SourceCommit
+	}
+	return state, nil
+}
+
+func modelParameters() titan.RemoteParameters {
+	return titan.RemoteParameters{Provider: "nop", Properties: map[string]interface{}{}}
+}
+
+func modelProperties(tags map[string]string) map[string]interface{} {
+	ret := map[string]interface{}{}
+	for k, v := range tags {
+		ret[k] = v
+	}
+	return ret
+}
+
+func modelTags(value interface{}) map[string]string {
+	ret := map[string]string{}
+	if m, ok := value.(map[string]interface{}); ok {
+		for k, v := range m {
+			ret[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	return ret
+}
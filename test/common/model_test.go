@@ -0,0 +1,335 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+ * In-memory implementation of the API semantics, in which deletes take effect immediately as if the reaper ran right
+ * away. With staleLastCommit set, deleting a commit doesn't update the last commit, to check that the tester finds
+ * and shrinks the bug.
+ */
+type memorySystem struct {
+	repos           map[string]*memoryRepo
+	clock           int
+	staleLastCommit bool
+}
+
+type memoryRepo struct {
+	properties map[string]string
+	volumes    map[string]bool
+	commits    map[string]*memoryCommit
+	remotes    map[string]bool
+	active     *memoryVolumeSet
+	last       string
+}
+
+type memoryVolumeSet struct {
+	source  string
+	commits []*memoryCommit
+}
+
+type memoryCommit struct {
+	id        string
+	timestamp string
+	tags      map[string]string
+	volumes   map[string]bool
+	vs        *memoryVolumeSet
+}
+
+func (s *memorySystem) Reset(ctx context.Context) error {
+	s.repos = map[string]*memoryRepo{}
+	return nil
+}
+
+func (s *memorySystem) now() string {
+	s.clock++
+	return time.Date(2020, 1, 1, 0, 0, s.clock, 0, time.UTC).Format(time.RFC3339Nano)
+}
+
+func (s *memorySystem) Run(ctx context.Context, a ModelAction) (ModelResult, error) {
+	repo := s.repos[a.Repo]
+	if a.Kind == ModelCreateRepo {
+		if repo != nil {
+			return ModelResult{Code: modelObjectExists}, nil
+		}
+		s.repos[a.Repo] = &memoryRepo{properties: copyModelTags(a.Tags), volumes: map[string]bool{},
+			commits: map[string]*memoryCommit{}, remotes: map[string]bool{}, active: &memoryVolumeSet{}}
+		return ModelResult{}, nil
+	}
+	if repo == nil {
+		return ModelResult{Code: modelNoSuchObject}, nil
+	}
+	commit := repo.commits[a.Commit]
+	res := ModelResult{}
+	switch a.Kind {
+	case ModelDeleteRepo:
+		delete(s.repos, a.Repo)
+	case ModelUpdateRepo:
+		repo.properties = copyModelTags(a.Tags)
+	case ModelCreateVolume:
+		if repo.volumes[a.Volume] {
+			return ModelResult{Code: modelObjectExists}, nil
+		}
+		repo.volumes[a.Volume] = true
+	case ModelDeleteVolume:
+		if !repo.volumes[a.Volume] {
+			return ModelResult{Code: modelNoSuchObject}, nil
+		}
+		delete(repo.volumes, a.Volume)
+	case ModelAddRemote:
+		if repo.remotes[a.Remote] {
+			return ModelResult{Code: modelObjectExists}, nil
+		}
+		repo.remotes[a.Remote] = true
+	case ModelDeleteRemote:
+		if !repo.remotes[a.Remote] {
+			return ModelResult{Code: modelNoSuchObject}, nil
+		}
+		delete(repo.remotes, a.Remote)
+	case ModelUpdateRemote:
+		if !repo.remotes[a.Remote] {
+			return ModelResult{Code: modelNoSuchObject}, nil
+		} else if a.NewName != a.Remote && repo.remotes[a.NewName] {
+			return ModelResult{Code: modelObjectExists}, nil
+		}
+		delete(repo.remotes, a.Remote)
+		repo.remotes[a.NewName] = true
+	case ModelCommit, ModelPull:
+		if a.Kind == ModelPull && !repo.remotes[a.Remote] {
+			return ModelResult{Code: modelNoSuchObject}, nil
+		} else if commit != nil {
+			return ModelResult{Code: modelObjectExists}, nil
+		}
+		commit = &memoryCommit{id: a.Commit, timestamp: s.now(), tags: copyModelTags(a.Tags),
+			volumes: copyModelVolumes(repo.volumes), vs: &memoryVolumeSet{}}
+		if a.Kind == ModelCommit {
+			commit.vs = repo.active
+		}
+		commit.vs.commits = append(commit.vs.commits, commit)
+		repo.commits[a.Commit] = commit
+		repo.last = a.Commit
+		res = ModelResult{Timestamp: commit.timestamp, Tags: commit.tags}
+	case ModelDeleteCommit, ModelUpdateCommit, ModelCheckout, ModelPush:
+		if commit == nil || (a.Kind == ModelPush && !repo.remotes[a.Remote]) {
+			return ModelResult{Code: modelNoSuchObject}, nil
+		}
+		switch a.Kind {
+		case ModelDeleteCommit:
+			delete(repo.commits, a.Commit)
+			for i, c := range commit.vs.commits {
+				if c == commit {
+					commit.vs.commits = append(commit.vs.commits[:i], commit.vs.commits[i+1:]...)
+					break
+				}
+			}
+		case ModelUpdateCommit:
+			commit.tags = copyModelTags(a.Tags)
+		case ModelCheckout:
+			repo.active = &memoryVolumeSet{source: a.Commit}
+			repo.volumes = copyModelVolumes(commit.volumes)
+		}
+	}
+	return res, nil
+}
+
+func (s *memorySystem) State(ctx context.Context, tagQueries []string) (ModelState, error) {
+	state := ModelState{}
+	for name, repo := range s.repos {
+		r := &ModelRepoState{
+			Properties: copyModelTags(repo.properties),
+			Volumes:    sortedModelKeys(repo.volumes),
+			Commits:    map[string]map[string]string{},
+			Remotes:    sortedModelKeys(repo.remotes),
+			TagQueries: map[string][]string{},
+		}
+		for id, c := range repo.commits {
+			r.Commits[id] = copyModelTags(c.tags)
+			if r.LastCommit == "" || c.timestamp > repo.commits[r.LastCommit].timestamp {
+				r.LastCommit = id
+			}
+			for _, query := range tagQueries {
+				if matchModelTags(c.tags, query) {
+					r.TagQueries[query] = append(r.TagQueries[query], id)
+				}
+			}
+		}
+		if s.staleLastCommit {
+			r.LastCommit = repo.last
+		}
+		r.SourceCommit = repo.active.source
+		if n := len(repo.active.commits); n != 0 {
+			r.SourceCommit = repo.active.commits[n-1].id
+		}
+		state[name] = r
+	}
+	return state, nil
+}
+
+func copyModelVolumes(volumes map[string]bool) map[string]bool {
+	ret := map[string]bool{}
+	for k, v := range volumes {
+		ret[k] = v
+	}
+	return ret
+}
+
+func TestModelCorrectSystem(t *testing.T) {
+	for seed := int64(1); seed <= 20; seed++ {
+		failure := RunRandomModel(context.Background(), &memorySystem{}, seed, 200, 100)
+		if failure != nil {
+			assert.Fail(t, failure.Error())
+		}
+	}
+}
+
+func TestModelShrink(t *testing.T) {
+	sys := &memorySystem{staleLastCommit: true}
+	var failure *ModelFailure
+	for seed := int64(1); seed <= 20 && failure == nil; seed++ {
+		failure = RunRandomModel(context.Background(), sys, seed, 200, 500)
+	}
+	if !assert.NotNil(t, failure) {
+		return
+	}
+	// The shrunk sequence should be minimal, in that removing any one action makes it pass.
+	if assert.True(t, len(failure.Actions) <= 4, failure.Error()) {
+		assert.Equal(t, ModelDeleteCommit, failure.Actions[len(failure.Actions)-1].Kind)
+	}
+	for i := range failure.Actions {
+		candidate := append(append([]ModelAction{}, failure.Actions[:i]...), failure.Actions[i+1:]...)
+		_, problems := CheckModelActions(context.Background(), sys, candidate)
+		assert.Empty(t, problems, "without action %d", i+1)
+	}
+	if assert.Len(t, failure.Problems, 1) {
+		assert.Contains(t, failure.Problems[0], "expected last commit ''")
+	}
+
+	n, problems := CheckModelActions(context.Background(), sys, failure.Actions)
+	assert.Equal(t, len(failure.Actions), n)
+	assert.Equal(t, failure.Problems, problems)
+	assert.True(t, strings.HasPrefix(failure.Error(), fmt.Sprintf("model check failed with seed %d", failure.Seed)))
+}
+
+/*
+ * Also fails, differently, when a commit is made right after creating a repository, as an unrelated flake would.
+ */
+type driftingSystem struct {
+	memorySystem
+	runs int
+}
+
+func (s *driftingSystem) Reset(ctx context.Context) error {
+	s.runs = 0
+	return s.memorySystem.Reset(ctx)
+}
+
+func (s *driftingSystem) Run(ctx context.Context, a ModelAction) (ModelResult, error) {
+	s.runs++
+	if s.runs == 2 && a.Kind == ModelCommit {
+		return ModelResult{}, errors.New("unrelated failure")
+	}
+	return s.memorySystem.Run(ctx, a)
+}
+
+/*
+ * Shrinking must keep the original failure, rather than drifting to a shorter sequence that fails for another reason.
+ * Removing the volume here makes the commit fail instead, which is a different bug.
+ */
+func TestModelShrinkKeepsFailure(t *testing.T) {
+	sys := &driftingSystem{memorySystem: memorySystem{staleLastCommit: true}}
+	actions := []ModelAction{
+		{Kind: ModelCreateRepo, Repo: "foo"},
+		{Kind: ModelCreateVolume, Repo: "foo", Volume: "v1"},
+		{Kind: ModelCommit, Repo: "foo", Commit: "c1"},
+		{Kind: ModelDeleteCommit, Repo: "foo", Commit: "c1"},
+	}
+	n, problems := CheckModelActions(context.Background(), sys, actions)
+	if !assert.Equal(t, len(actions), n) || !assert.NotEmpty(t, problems) {
+		return
+	}
+	shrunk, shrunkProblems := ShrinkModelActions(context.Background(), sys, actions, problems, 100)
+	assert.Equal(t, actions, shrunk)
+	assert.Equal(t, problems, shrunkProblems)
+}
+
+func TestModelFailureSignature(t *testing.T) {
+	a := ModelAction{Kind: ModelDeleteCommit, Repo: "repo1", Commit: "commit3"}
+	b := ModelAction{Kind: ModelDeleteCommit, Repo: "repo2", Commit: "commit7"}
+	assert.Equal(t,
+		modelFailureSignature(a, []string{"repo1: expected last commit '', got 'commit3'"}),
+		modelFailureSignature(b, []string{"repo2: expected last commit '', got 'commit5'", "other"}))
+	assert.NotEqual(t,
+		modelFailureSignature(a, []string{"repo1: expected last commit '', got 'commit3'"}),
+		modelFailureSignature(a, []string{"repo1: unexpected volume volume4"}))
+	assert.NotEqual(t,
+		modelFailureSignature(a, []string{"repo1: unexpected volume volume4"}),
+		modelFailureSignature(ModelAction{Kind: ModelCommit}, []string{"repo1: unexpected volume volume4"}))
+}
+
+/*
+ * Deleted volumes may still be listed until reaped, and may or may not block the name from being reused.
+ */
+func TestModelDeletedVolume(t *testing.T) {
+	m := NewApiModel()
+	for _, a := range []ModelAction{
+		{Kind: ModelCreateRepo, Repo: "foo"},
+		{Kind: ModelCreateVolume, Repo: "foo", Volume: "v1"},
+		{Kind: ModelCreateVolume, Repo: "foo", Volume: "v2"},
+		{Kind: ModelDeleteVolume, Repo: "foo", Volume: "v1"},
+	} {
+		assert.Equal(t, []string{""}, m.Expect(a))
+		m.Apply(a, ModelResult{})
+	}
+	assert.Equal(t, []string{"", modelObjectExists}, m.Expect(ModelAction{Kind: ModelCreateVolume, Repo: "foo",
+		Volume: "v1"}))
+	assert.Equal(t, []string{modelNoSuchObject}, m.Expect(ModelAction{Kind: ModelDeleteVolume, Repo: "foo",
+		Volume: "v1"}))
+
+	state := func(volumes ...string) ModelState {
+		return ModelState{"foo": &ModelRepoState{Volumes: volumes, TagQueries: map[string][]string{}}}
+	}
+	assert.Empty(t, m.Verify(state("v1", "v2")))
+	assert.Empty(t, m.Verify(state("v2")))
+	assert.Equal(t, []string{"foo: missing volume v2"}, m.Verify(state("v1")))
+	assert.Equal(t, []string{"foo: unexpected volume v3"}, m.Verify(state("v2", "v3")))
+}
+
+/*
+ * Deleted commits can remain the source commit until reaped, and commits with the same timestamp can be reported in
+ * either order.
+ */
+func TestModelStatusCandidates(t *testing.T) {
+	m := NewApiModel()
+	for _, step := range []struct {
+		action    ModelAction
+		timestamp string
+	}{
+		{ModelAction{Kind: ModelCreateRepo, Repo: "foo"}, ""},
+		{ModelAction{Kind: ModelCommit, Repo: "foo", Commit: "c1"}, "2020-01-01T00:00:01Z"},
+		{ModelAction{Kind: ModelCheckout, Repo: "foo", Commit: "c1"}, ""},
+		{ModelAction{Kind: ModelCommit, Repo: "foo", Commit: "c2"}, "2020-01-01T00:00:02Z"},
+		{ModelAction{Kind: ModelCommit, Repo: "foo", Commit: "c3"}, "2020-01-01T00:00:02Z"},
+		{ModelAction{Kind: ModelDeleteCommit, Repo: "foo", Commit: "c2"}, ""},
+		{ModelAction{Kind: ModelDeleteCommit, Repo: "foo", Commit: "c3"}, ""},
+	} {
+		m.Apply(step.action, ModelResult{Timestamp: step.timestamp})
+	}
+	repo := m.repos["foo"]
+	assert.Equal(t, []string{"c1"}, repo.lastCommits())
+	assert.ElementsMatch(t, []string{"c2", "c3", "c1"}, repo.sourceCommits())
+
+	m.Apply(ModelAction{Kind: ModelCommit, Repo: "foo", Commit: "c4"}, ModelResult{Timestamp: "2020-01-01T00:00:03Z"})
+	m.Apply(ModelAction{Kind: ModelCommit, Repo: "foo", Commit: "c5"}, ModelResult{Timestamp: "2020-01-01T00:00:03Z"})
+	assert.ElementsMatch(t, []string{"c4", "c5"}, repo.lastCommits())
+	assert.ElementsMatch(t, []string{"c4", "c5"}, repo.sourceCommits())
+}
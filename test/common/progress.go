@@ -0,0 +1,365 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/antihax/optional"
+	titan "github.com/titan-data/titan-client-go"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Progress entry types, as serialized by the server. Aborted operations end with an "ABORT" entry. "ABORTED", the
+ * name of the corresponding operation state, is only accepted defensively.
+ */
+const (
+	ProgressMessage  = "MESSAGE"
+	ProgressStart    = "START"
+	ProgressPercent  = "PROGRESS"
+	ProgressEnd      = "END"
+	ProgressError    = "ERROR"
+	ProgressAbort    = "ABORT"
+	ProgressFailed   = "FAILED"
+	ProgressComplete = "COMPLETE"
+
+	progressAbortAlternate = "ABORTED"
+)
+
+/*
+ * Final state of a watcher whose operation was aborted. This matches the operation state rather than the type of
+ * the ABORT entry.
+ */
+const ProgressAborted = "ABORTED"
+
+/*
+ * Message added by the server when it retries an operation that was running when it was restarted.
+ */
+const ProgressRetryMessage = "Retrying operation after restart"
+
+/*
+ * Interval at which operation progress is polled.
+ */
+const progressInterval = 500 * time.Millisecond
+
+/*
+ * A phase of an operation, delimited by START and END entries. The message of the START entry names the phase, and
+ * any PROGRESS entries seen while it is active are recorded against it. If the server is restarted while the phase
+ * is active, it is marked as interrupted rather than ended, since the retried operation starts over.
+ */
+type ProgressPhase struct {
+	Name        string
+	Percents    []int32
+	Ended       bool
+	Interrupted bool
+}
+
+/*
+ * Notification of a single progress entry, along with a snapshot of the phase that was active when it was seen (nil
+ * if none).
+ */
+type ProgressEvent struct {
+	Entry titan.ProgressEntry
+	Phase *ProgressPhase
+}
+
+/*
+ * Tracks the progress of an operation as entries are added. In addition to recording the entries, it keeps track of
+ * phases and their percentages, the final state of the operation, and any violations of the progress protocol
+ * (unbalanced START/END entries, percentages that go backwards or out of range, or entries after the operation has
+ * finished). Callbacks registered through OnEvent are invoked synchronously for each entry.
+ */
+type ProgressWatcher struct {
+	lock       sync.Mutex
+	entries    []titan.ProgressEntry
+	phases     []*ProgressPhase
+	current    *ProgressPhase
+	errors     []string
+	violations []string
+	state      string
+	message    string
+	restarts   int
+	callbacks  []func(ProgressEvent)
+}
+
+func NewProgressWatcher() *ProgressWatcher {
+	return &ProgressWatcher{}
+}
+
+/*
+ * Register a callback to be invoked for every subsequent entry.
+ */
+func (w *ProgressWatcher) OnEvent(callback func(ProgressEvent)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.callbacks = append(w.callbacks, callback)
+}
+
+/*
+ * Get a channel that receives every subsequent entry. The channel is buffered with the given size, and sends block
+ * when it's full, so callers must keep up with the operation.
+ */
+func (w *ProgressWatcher) Events(size int) <-chan ProgressEvent {
+	ch := make(chan ProgressEvent, size)
+	w.OnEvent(func(event ProgressEvent) {
+		ch <- event
+	})
+	return ch
+}
+
+/*
+ * Process a single progress entry, returning true if it indicates that the operation has finished.
+ */
+func (w *ProgressWatcher) Add(entry titan.ProgressEntry) bool {
+	w.lock.Lock()
+	if w.state != "" {
+		w.violation("%s entry %d after operation finished with %s", entry.Type, entry.Id, w.state)
+	}
+	if len(w.entries) != 0 && entry.Id <= w.entries[len(w.entries)-1].Id {
+		w.violation("entry %d out of order after entry %d", entry.Id, w.entries[len(w.entries)-1].Id)
+	}
+	w.entries = append(w.entries, entry)
+
+	switch entry.Type {
+	case ProgressMessage:
+		if entry.Message == ProgressRetryMessage {
+			w.restarts++
+			if w.current != nil {
+				w.current.Interrupted = true
+				w.current = nil
+			}
+		}
+	case ProgressStart:
+		if w.current != nil {
+			w.violation("phase '%s' started before phase '%s' ended", entry.Message, w.current.Name)
+		}
+		w.current = &ProgressPhase{Name: entry.Message, Percents: []int32{}}
+		w.phases = append(w.phases, w.current)
+	case ProgressPercent:
+		if entry.Percent < 0 || entry.Percent > 100 {
+			w.violation("percentage %d out of range", entry.Percent)
+		}
+		if w.current == nil {
+			w.violation("percentage %d outside of any phase", entry.Percent)
+		} else {
+			if n := len(w.current.Percents); n != 0 && entry.Percent < w.current.Percents[n-1] {
+				w.violation("percentage for phase '%s' went from %d to %d", w.current.Name,
+					w.current.Percents[n-1], entry.Percent)
+			}
+			w.current.Percents = append(w.current.Percents, entry.Percent)
+		}
+	case ProgressEnd:
+		if w.current == nil {
+			w.violation("END entry %d without a matching START", entry.Id)
+		} else {
+			w.current.Ended = true
+		}
+	case ProgressError:
+		w.errors = append(w.errors, entry.Message)
+	case ProgressAbort, progressAbortAlternate:
+		w.finish(ProgressAborted, entry.Message)
+	case ProgressFailed, ProgressComplete:
+		w.finish(entry.Type, entry.Message)
+	default:
+		w.violation("unknown progress type %s", entry.Type)
+	}
+
+	event := ProgressEvent{Entry: entry}
+	if w.current != nil {
+		phase := *w.current
+		phase.Percents = append([]int32{}, w.current.Percents...)
+		event.Phase = &phase
+	}
+	if entry.Type == ProgressEnd {
+		w.current = nil
+	}
+	callbacks := append([]func(ProgressEvent){}, w.callbacks...)
+	done := w.state != ""
+	w.lock.Unlock()
+
+	for _, callback := range callbacks {
+		callback(event)
+	}
+	return done
+}
+
+func (w *ProgressWatcher) finish(state string, message string) {
+	if w.state != "" {
+		return
+	}
+	w.state = state
+	w.message = message
+	if state == ProgressComplete && w.current != nil {
+		w.violation("operation completed with phase '%s' still active", w.current.Name)
+	}
+}
+
+func (w *ProgressWatcher) violation(format string, args ...interface{}) {
+	w.violations = append(w.violations, fmt.Sprintf(format, args...))
+}
+
+/*
+ * Final state of the operation (ProgressComplete, ProgressFailed, or ProgressAborted), or empty if it hasn't finished.
+ */
+func (w *ProgressWatcher) State() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.state
+}
+
+func (w *ProgressWatcher) Done() bool {
+	return w.State() != ""
+}
+
+/*
+ * Error describing how the operation finished, or nil if it completed successfully (or hasn't finished). ERROR
+ * entries seen along the way are included in the message.
+ */
+func (w *ProgressWatcher) Err() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	var msg string
+	switch w.state {
+	case ProgressAborted:
+		msg = fmt.Sprintf("operation aborted: %s", w.message)
+	case ProgressFailed:
+		msg = fmt.Sprintf("operation failed: %s", w.message)
+	default:
+		return nil
+	}
+	if len(w.errors) != 0 {
+		msg = fmt.Sprintf("%s (errors: %s)", msg, strings.Join(w.errors, "; "))
+	}
+	return errors.New(msg)
+}
+
+func (w *ProgressWatcher) Entries() []titan.ProgressEntry {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]titan.ProgressEntry{}, w.entries...)
+}
+
+func (w *ProgressWatcher) Phases() []ProgressPhase {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	ret := make([]ProgressPhase, len(w.phases))
+	for i, p := range w.phases {
+		ret[i] = *p
+		ret[i].Percents = append([]int32{}, p.Percents...)
+	}
+	return ret
+}
+
+/*
+ * Names of all phases, in the order they were started.
+ */
+func (w *ProgressWatcher) PhaseNames() []string {
+	phases := w.Phases()
+	ret := make([]string, len(phases))
+	for i, p := range phases {
+		ret[i] = p.Name
+	}
+	return ret
+}
+
+/*
+ * Number of times the operation was retried after the server restarted.
+ */
+func (w *ProgressWatcher) Restarts() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.restarts
+}
+
+/*
+ * Messages from any ERROR entries.
+ */
+func (w *ProgressWatcher) Errors() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]string{}, w.errors...)
+}
+
+/*
+ * Descriptions of any violations of the progress protocol. Suites should generally assert that this is empty.
+ */
+func (w *ProgressWatcher) Violations() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]string{}, w.violations...)
+}
+
+/*
+ * Poll an operation's progress until it finishes, feeding every entry to the given watcher. Polling starts after the
+ * last entry the watcher has already seen, so the same watcher can be used across multiple calls. If the server reports
+ * that the operation has finished without a corresponding progress entry, the watcher is finished with the
+ * operation's state so that the wait doesn't continue forever. The returned error is that of the watcher, or a
+ * WaitTimeoutError if the context expires first.
+ */
+func (e *EndToEndTest) WatchOperation(ctx context.Context, id string, w *ProgressWatcher) error {
+	return e.watchOperation(ctx, id, w, nil)
+}
+
+/*
+ * Implementation of WatchOperation that can also stop before the operation finishes, as soon as the given function
+ * returns true after a round of progress has been processed.
+ */
+func (e *EndToEndTest) watchOperation(ctx context.Context, id string, w *ProgressWatcher, stop func() bool) error {
+	ctx, cancel := withDefaultDeadline(ctx, defaultWaitTimeout)
+	defer cancel()
+	var lastEntry int32 = 0
+	if entries := w.Entries(); len(entries) != 0 {
+		lastEntry = entries[len(entries)-1].Id
+	}
+	fetch := func(ctx context.Context) (bool, interface{}, int, error) {
+		progress, _, err := e.Client.OperationsApi.GetOperationProgress(ctx, id,
+			&titan.GetOperationProgressOpts{LastId: optional.NewInt32(lastEntry)})
+		if err != nil {
+			return false, nil, 0, err
+		}
+		var last interface{}
+		for _, p := range progress {
+			last = p
+			if p.Id > lastEntry {
+				lastEntry = p.Id
+			}
+			if w.Add(p) {
+				return true, last, len(progress), nil
+			}
+		}
+		return false, last, len(progress), nil
+	}
+	err := Poll(ctx, fmt.Sprintf("operation %s", id), progressInterval,
+		func(ctx context.Context) (bool, interface{}, error) {
+			done, last, count, err := fetch(ctx)
+			if !done && err == nil && stop != nil && stop() {
+				return true, last, nil
+			}
+			if done || err != nil || count != 0 {
+				return done, last, err
+			}
+			op, _, err := e.Client.OperationsApi.GetOperation(ctx, id)
+			if err != nil || op.State == "RUNNING" {
+				return false, nil, err
+			}
+			// The final entry may have been added after we last looked, so check once more before giving up on it
+			done, last, _, err = fetch(ctx)
+			if done || err != nil {
+				return done, last, err
+			}
+			w.lock.Lock()
+			w.violation("operation finished in state %s without a final progress entry", op.State)
+			w.finish(op.State, "")
+			w.lock.Unlock()
+			return true, op, nil
+		})
+	if err != nil {
+		return err
+	}
+	return w.Err()
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	titan "github.com/titan-data/titan-client-go"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+ * Serves the progress of a single operation, releasing one more entry on every request so that the watcher has to
+ * poll repeatedly. The operation is reported as running until the given state is reached.
+ */
+type fakeOperationApi struct {
+	lock     sync.Mutex
+	entries  []titan.ProgressEntry
+	released int
+	state    string
+}
+
+func (f *fakeOperationApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if strings.HasSuffix(r.URL.Path, "/progress") {
+		lastId, _ := strconv.Atoi(r.URL.Query().Get("lastId"))
+		if f.released < len(f.entries) {
+			f.released++
+		}
+		ret := []titan.ProgressEntry{}
+		for _, p := range f.entries[:f.released] {
+			if int(p.Id) > lastId {
+				ret = append(ret, p)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(ret)
+	} else {
+		state := "RUNNING"
+		if f.released == len(f.entries) {
+			state = f.state
+		}
+		_ = json.NewEncoder(w).Encode(titan.Operation{Id: "op", State: state})
+	}
+}
+
+func progressEntries(entries ...titan.ProgressEntry) []titan.ProgressEntry {
+	for i := range entries {
+		entries[i].Id = int32(i + 1)
+	}
+	return entries
+}
+
+func watchFakeOperation(t *testing.T, api *fakeOperationApi, w *ProgressWatcher) error {
+	server := httptest.NewServer(api)
+	defer server.Close()
+	e, _ := newRecordingTest("docker-zfs")
+	e.SetApiHost(strings.TrimPrefix(server.URL, "http://"))
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return e.WatchOperation(ctx, "op", w)
+}
+
+func TestProgressPhases(t *testing.T) {
+	w := NewProgressWatcher()
+	events := w.Events(10)
+	for _, p := range progressEntries(
+		titan.ProgressEntry{Type: ProgressMessage, Message: "Pushing id"},
+		titan.ProgressEntry{Type: ProgressStart, Message: "data"},
+		titan.ProgressEntry{Type: ProgressPercent, Percent: 10},
+		titan.ProgressEntry{Type: ProgressPercent, Percent: 100},
+		titan.ProgressEntry{Type: ProgressEnd},
+		titan.ProgressEntry{Type: ProgressStart, Message: "metadata"},
+		titan.ProgressEntry{Type: ProgressEnd},
+	) {
+		assert.False(t, w.Add(p))
+	}
+	assert.True(t, w.Add(titan.ProgressEntry{Id: 8, Type: ProgressComplete}))
+
+	assert.Equal(t, ProgressComplete, w.State())
+	assert.NoError(t, w.Err())
+	assert.Empty(t, w.Violations())
+	assert.Equal(t, []string{"data", "metadata"}, w.PhaseNames())
+	phases := w.Phases()
+	assert.Equal(t, []int32{10, 100}, phases[0].Percents)
+	assert.True(t, phases[0].Ended)
+	assert.Len(t, w.Entries(), 8)
+
+	assert.Len(t, events, 8)
+	assert.Nil(t, (<-events).Phase)
+	assert.Equal(t, "data", (<-events).Phase.Name)
+	assert.Equal(t, []int32{10}, (<-events).Phase.Percents)
+}
+
+func TestProgressViolations(t *testing.T) {
+	w := NewProgressWatcher()
+	for _, p := range progressEntries(
+		titan.ProgressEntry{Type: ProgressPercent, Percent: 5},
+		titan.ProgressEntry{Type: ProgressStart, Message: "data"},
+		titan.ProgressEntry{Type: ProgressPercent, Percent: 50},
+		titan.ProgressEntry{Type: ProgressPercent, Percent: 40},
+		titan.ProgressEntry{Type: ProgressPercent, Percent: 140},
+		titan.ProgressEntry{Type: ProgressStart, Message: "metadata"},
+		titan.ProgressEntry{Type: ProgressEnd},
+		titan.ProgressEntry{Type: ProgressEnd},
+		titan.ProgressEntry{Type: "BOGUS"},
+		titan.ProgressEntry{Type: ProgressComplete},
+		titan.ProgressEntry{Type: ProgressMessage},
+	) {
+		w.Add(p)
+	}
+	assert.Equal(t, []string{
+		"percentage 5 outside of any phase",
+		"percentage for phase 'data' went from 50 to 40",
+		"percentage 140 out of range",
+		"phase 'metadata' started before phase 'data' ended",
+		"END entry 8 without a matching START",
+		"unknown progress type BOGUS",
+		"MESSAGE entry 11 after operation finished with COMPLETE",
+	}, w.Violations())
+}
+
+func TestProgressFailures(t *testing.T) {
+	w := NewProgressWatcher()
+	w.Add(titan.ProgressEntry{Id: 1, Type: ProgressError, Message: "disk full"})
+	assert.False(t, w.Done())
+	assert.True(t, w.Add(titan.ProgressEntry{Id: 2, Type: ProgressFailed, Message: "failed"}))
+	if assert.Error(t, w.Err()) {
+		assert.Equal(t, "operation failed: failed (errors: disk full)", w.Err().Error())
+	}
+	assert.Equal(t, []string{"disk full"}, w.Errors())
+
+	for _, abort := range []string{ProgressAbort, "ABORTED"} {
+		w = NewProgressWatcher()
+		assert.True(t, w.Add(titan.ProgressEntry{Id: 1, Type: abort}))
+		assert.Equal(t, ProgressAborted, w.State())
+		assert.Error(t, w.Err())
+	}
+}
+
+func TestWatchOperation(t *testing.T) {
+	api := &fakeOperationApi{entries: progressEntries(
+		titan.ProgressEntry{Type: ProgressMessage, Message: "Pushing id"},
+		titan.ProgressEntry{Type: ProgressStart, Message: "data"},
+		titan.ProgressEntry{Type: ProgressPercent, Percent: 50},
+		titan.ProgressEntry{Type: ProgressEnd},
+		titan.ProgressEntry{Type: ProgressAbort},
+	), state: "ABORTED"}
+	w := NewProgressWatcher()
+	err := watchFakeOperation(t, api, w)
+	if assert.Error(t, err) {
+		assert.Equal(t, "operation aborted: ", err.Error())
+	}
+	assert.Len(t, w.Entries(), 5)
+	assert.Equal(t, []string{"data"}, w.PhaseNames())
+	assert.Empty(t, w.Violations())
+}
+
+func TestWatchOperationWithoutFinalEntry(t *testing.T) {
+	api := &fakeOperationApi{entries: progressEntries(
+		titan.ProgressEntry{Type: ProgressMessage, Message: "Pushing id"},
+	), state: "COMPLETE"}
+	w := NewProgressWatcher()
+	assert.NoError(t, watchFakeOperation(t, api, w))
+	assert.Equal(t, ProgressComplete, w.State())
+	assert.Equal(t, []string{"operation finished in state COMPLETE without a final progress entry"}, w.Violations())
+}
+
+func TestProgressRestart(t *testing.T) {
+	w := NewProgressWatcher()
+	for _, p := range progressEntries(
+		titan.ProgressEntry{Type: ProgressStart, Message: "data"},
+		titan.ProgressEntry{Type: ProgressPercent, Percent: 60},
+		titan.ProgressEntry{Type: ProgressMessage, Message: ProgressRetryMessage},
+		titan.ProgressEntry{Type: ProgressStart, Message: "data"},
+		titan.ProgressEntry{Type: ProgressPercent, Percent: 10},
+		titan.ProgressEntry{Type: ProgressEnd},
+		titan.ProgressEntry{Type: ProgressComplete},
+	) {
+		w.Add(p)
+	}
+	assert.Empty(t, w.Violations())
+	assert.Equal(t, 1, w.Restarts())
+	phases := w.Phases()
+	if assert.Len(t, phases, 2) {
+		assert.True(t, phases[0].Interrupted)
+		assert.False(t, phases[0].Ended)
+		assert.Equal(t, []int32{10}, phases[1].Percents)
+		assert.True(t, phases[1].Ended)
+	}
+}
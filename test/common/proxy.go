@@ -0,0 +1,398 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+ * Fault to inject into connections passing through a FaultProxy. Unlike the FakeWeb faults, these operate at the TCP
+ * level, so they apply to any protocol. Faults are picked up by connections as data flows through them, including
+ * existing (keep-alive) connections, and byte counts are measured from when a connection picks up the fault rather
+ * than from when it was opened. A zero ProxyFault forwards data normally.
+ *
+ *   Latency    Wait this long before forwarding each chunk of data, in either direction
+ *   Reset      Reset the connection as soon as any data arrives, without forwarding it
+ *   Truncate   Forward only this many bytes from the target back to the client, then reset the connection
+ *   Blackhole  Forward nothing in either direction for this long after the fault is injected, holding connections
+ *              open, after which data flows again
+ *   Cut        Forward only this many bytes in total, in either direction, then reset the connection
+ *   Bandwidth  Limit each direction of the connection to this many bytes per second
+ *   Stall      Once StallAfter bytes have been forwarded in total, forward nothing in either direction for this
+ *              long. Each direction still reads its next chunk, but holds it until the stall ends
+ *
+ * Count limits the fault to the next Count connections, after which it is removed. Zero means forever.
+ */
+type ProxyFault struct {
+	Latency    time.Duration
+	Reset      bool
+	Truncate   int64
+	Blackhole  time.Duration
+	Cut        int64
+	Bandwidth  int64
+	Stall      time.Duration
+	StallAfter int64
+	Count      int
+}
+
+func ProxyLatency(latency time.Duration) ProxyFault {
+	return ProxyFault{Latency: latency}
+}
+
+func ProxyReset() ProxyFault {
+	return ProxyFault{Reset: true}
+}
+
+func ProxyTruncate(bytes int64) ProxyFault {
+	return ProxyFault{Truncate: bytes}
+}
+
+func ProxyBlackhole(duration time.Duration) ProxyFault {
+	return ProxyFault{Blackhole: duration}
+}
+
+func ProxyCut(bytes int64) ProxyFault {
+	return ProxyFault{Cut: bytes}
+}
+
+func ProxyThrottle(bytesPerSecond int64) ProxyFault {
+	return ProxyFault{Bandwidth: bytesPerSecond}
+}
+
+func ProxyStall(after int64, duration time.Duration) ProxyFault {
+	return ProxyFault{Stall: duration, StallAfter: after}
+}
+
+/*
+ * TCP proxy that forwards connections to a target address, injecting faults along the way. It can be placed in front
+ * of the titan server (see StartApiProxy) to test how API clients behave when the server is flaky, or in front of the
+ * SSH server (see StartSshProxy) to test how the ssh remote handles a bad network.
+ */
+type FaultProxy struct {
+	Target string
+
+	lock        sync.Mutex
+	fault       *ProxyFault
+	generation  int
+	injected    time.Time
+	conns       map[*proxyConn]bool
+	connections int
+	faulted     int
+	listener    net.Listener
+}
+
+/*
+ * A client connection and its connection to the target. The fault in effect is re-evaluated whenever a new fault is
+ * injected, and remembered otherwise so that Count applies to whole connections.
+ */
+type proxyConn struct {
+	client     net.Conn
+	target     net.Conn
+	generation int
+	fault      *ProxyFault
+	received   int64
+	total      int64
+	// Values of received and total when the connection picked up its current fault
+	faultReceived int64
+	faultTotal    int64
+	stallUntil    time.Time
+	closeOnce     sync.Once
+}
+
+func NewFaultProxy(target string) *FaultProxy {
+	return &FaultProxy{Target: target, conns: map[*proxyConn]bool{}}
+}
+
+/*
+ * Start a proxy in front of the titan server, and point the API client at it. The client is pointed back at the
+ * server when the proxy is stopped with StopApiProxy.
+ */
+func (e *EndToEndTest) StartApiProxy() (*FaultProxy, error) {
+	proxy := NewFaultProxy(fmt.Sprintf("localhost:%d", e.Port))
+	err := proxy.Start("127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	e.SetApiHost(proxy.Addr())
+	return proxy, nil
+}
+
+func (e *EndToEndTest) StopApiProxy(proxy *FaultProxy) error {
+	e.SetApiHost(fmt.Sprintf("localhost:%d", e.Port))
+	return proxy.Stop()
+}
+
+/*
+ * Start a proxy in front of the in-process SSH server. The titan server can reach it at SshHost on the proxy's port,
+ * which is the only address it listens on.
+ */
+func (e *EndToEndTest) StartSshProxy() (*FaultProxy, error) {
+	host, err := e.HostAddress()
+	if err != nil {
+		return nil, err
+	}
+	proxy := NewFaultProxy(fmt.Sprintf("127.0.0.1:%d", e.SshPort))
+	err = proxy.Start(net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+func (p *FaultProxy) Start(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	p.listener = l
+	go p.accept(l)
+	return nil
+}
+
+/*
+ * Stop accepting connections, and close any that are still open.
+ */
+func (p *FaultProxy) Stop() error {
+	if p.listener == nil {
+		return nil
+	}
+	err := p.listener.Close()
+	p.lock.Lock()
+	conns := p.conns
+	p.conns = map[*proxyConn]bool{}
+	p.lock.Unlock()
+	for c := range conns {
+		c.close(false)
+	}
+	return err
+}
+
+func (p *FaultProxy) Port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+/*
+ * Address (host:port) at which the proxy is listening.
+ */
+func (p *FaultProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+/*
+ * Replace any existing fault with the given one.
+ */
+func (p *FaultProxy) InjectFault(fault ProxyFault) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.fault = &fault
+	p.injected = time.Now()
+	p.generation++
+}
+
+func (p *FaultProxy) ClearFaults() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.fault = nil
+	p.generation++
+}
+
+/*
+ * Total number of connections accepted, and the number of those that have been subject to a fault.
+ */
+func (p *FaultProxy) Connections() (int, int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.connections, p.faulted
+}
+
+func (p *FaultProxy) accept(l net.Listener) {
+	for {
+		client, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go p.serve(client)
+	}
+}
+
+func (p *FaultProxy) serve(client net.Conn) {
+	target, err := net.Dial("tcp", p.Target)
+	if err != nil {
+		resetConn(client)
+		return
+	}
+	c := &proxyConn{client: client, target: target, generation: -1}
+	p.lock.Lock()
+	p.conns[c] = true
+	p.connections++
+	p.lock.Unlock()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		p.pump(c, client, target, false)
+		done <- struct{}{}
+	}()
+	go func() {
+		p.pump(c, target, client, true)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+	c.close(false)
+
+	p.lock.Lock()
+	delete(p.conns, c)
+	p.lock.Unlock()
+}
+
+/*
+ * Get the fault currently in effect for the connection, along with when it was injected.
+ */
+func (p *FaultProxy) faultFor(c *proxyConn) (*ProxyFault, time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if c.generation != p.generation {
+		c.generation = p.generation
+		c.faultReceived = c.received
+		c.faultTotal = c.total
+		c.stallUntil = time.Time{}
+		c.fault = nil
+		if p.fault != nil {
+			c.fault = p.fault
+			p.faulted++
+			if p.fault.Count > 0 {
+				p.fault.Count--
+				if p.fault.Count == 0 {
+					p.fault = nil
+				}
+			}
+		}
+	}
+	return c.fault, p.injected
+}
+
+/*
+ * Copy data in one direction, applying faults to each chunk. Responses are the data flowing from the target back to
+ * the client.
+ */
+func (p *FaultProxy) pump(c *proxyConn, src net.Conn, dst net.Conn, response bool) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			data := buf[:n]
+			fault, injected := p.faultFor(c)
+			if fault != nil {
+				if wait := time.Until(injected.Add(fault.Blackhole)); fault.Blackhole != 0 && wait > 0 {
+					time.Sleep(wait)
+				}
+				if fault.Reset {
+					c.close(true)
+					return
+				}
+				if fault.Latency != 0 {
+					time.Sleep(fault.Latency)
+				}
+				if fault.Bandwidth != 0 {
+					time.Sleep(time.Duration(int64(n) * int64(time.Second) / fault.Bandwidth))
+				}
+				p.lock.Lock()
+				limit := int64(n)
+				received := c.received - c.faultReceived
+				total := c.total - c.faultTotal
+				if response && fault.Truncate != 0 && fault.Truncate-received < limit {
+					limit = fault.Truncate - received
+				}
+				if fault.Cut != 0 && fault.Cut-total < limit {
+					limit = fault.Cut - total
+				}
+				if fault.Stall != 0 && c.stallUntil.IsZero() && total+int64(n) > fault.StallAfter {
+					c.stallUntil = time.Now().Add(fault.Stall)
+				}
+				p.lock.Unlock()
+				if limit < int64(n) {
+					if limit > 0 {
+						_, _ = dst.Write(data[:limit])
+					}
+					c.close(true)
+					return
+				}
+			}
+			p.lock.Lock()
+			c.total += int64(n)
+			if response {
+				c.received += int64(n)
+			}
+			p.lock.Unlock()
+			p.waitForStall(c)
+			if _, werr := dst.Write(data); werr != nil {
+				c.close(false)
+				return
+			}
+		}
+		if err == io.EOF {
+			closeWrite(dst)
+			return
+		}
+		if err != nil {
+			c.close(false)
+			return
+		}
+	}
+}
+
+/*
+ * Wait for any stall of the connection to end. Both directions check this just before forwarding each chunk, so that
+ * a stall started by one direction also holds data flowing in the other.
+ */
+func (p *FaultProxy) waitForStall(c *proxyConn) {
+	p.lock.Lock()
+	stall := time.Until(c.stallUntil)
+	p.lock.Unlock()
+	if stall > 0 {
+		time.Sleep(stall)
+	}
+}
+
+/*
+ * Close both sides of the connection, optionally with a reset instead of an orderly shutdown.
+ */
+func (c *proxyConn) close(reset bool) {
+	c.closeOnce.Do(func() {
+		if reset {
+			resetConn(c.client)
+			resetConn(c.target)
+		} else {
+			_ = c.client.Close()
+			_ = c.target.Close()
+		}
+	})
+}
+
+/*
+ * Pass on an orderly shutdown from one side to the other, while leaving data flowing in the other direction, such as
+ * a reply to a request that was followed by a half-close.
+ */
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	} else {
+		_ = conn.Close()
+	}
+}
+
+/*
+ * Close a connection such that the peer sees a reset (RST) rather than an orderly shutdown (FIN).
+ */
+func resetConn(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	_ = conn.Close()
+}
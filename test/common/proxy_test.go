@@ -0,0 +1,327 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startProxy(t *testing.T, handler http.Handler) (*FaultProxy, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	proxy := NewFaultProxy(strings.TrimPrefix(server.URL, "http://"))
+	if !assert.NoError(t, proxy.Start("127.0.0.1:0")) {
+		t.FailNow()
+	}
+	return proxy, server
+}
+
+func proxyGet(proxy *FaultProxy) (string, error) {
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	res, err := client.Get("http://" + proxy.Addr() + "/")
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	return string(body), err
+}
+
+var proxyContent = strings.Repeat("0123456789", 1000)
+
+func proxyContentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(proxyContent)))
+	_, _ = w.Write([]byte(proxyContent))
+}
+
+func TestProxyForward(t *testing.T) {
+	proxy, server := startProxy(t, http.HandlerFunc(proxyContentHandler))
+	defer server.Close()
+	defer proxy.Stop()
+
+	res, err := proxyGet(proxy)
+	if assert.NoError(t, err) {
+		assert.Equal(t, proxyContent, res)
+	}
+	total, faulted := proxy.Connections()
+	assert.Equal(t, 1, total)
+	assert.Equal(t, 0, faulted)
+}
+
+func TestProxyLatency(t *testing.T) {
+	proxy, server := startProxy(t, http.HandlerFunc(proxyContentHandler))
+	defer server.Close()
+	defer proxy.Stop()
+
+	proxy.InjectFault(ProxyLatency(200 * time.Millisecond))
+	start := time.Now()
+	res, err := proxyGet(proxy)
+	if assert.NoError(t, err) {
+		assert.Equal(t, proxyContent, res)
+		assert.True(t, time.Since(start) >= 400*time.Millisecond)
+	}
+}
+
+func TestProxyReset(t *testing.T) {
+	proxy, server := startProxy(t, http.HandlerFunc(proxyContentHandler))
+	defer server.Close()
+	defer proxy.Stop()
+
+	proxy.InjectFault(ProxyFault{Reset: true, Count: 1})
+	_, err := proxyGet(proxy)
+	assert.Error(t, err)
+	res, err := proxyGet(proxy)
+	if assert.NoError(t, err) {
+		assert.Equal(t, proxyContent, res)
+	}
+	total, faulted := proxy.Connections()
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, faulted)
+}
+
+func TestProxyTruncate(t *testing.T) {
+	proxy, server := startProxy(t, http.HandlerFunc(proxyContentHandler))
+	defer server.Close()
+	defer proxy.Stop()
+
+	proxy.InjectFault(ProxyTruncate(500))
+	_, err := proxyGet(proxy)
+	assert.Error(t, err)
+
+	proxy.ClearFaults()
+	res, err := proxyGet(proxy)
+	if assert.NoError(t, err) {
+		assert.Equal(t, proxyContent, res)
+	}
+}
+
+/*
+ * A client that half-closes after sending its request still gets the whole reply, which is only sent once the target
+ * has seen the end of the request.
+ */
+func TestProxyHalfClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, _ := ioutil.ReadAll(conn)
+		_, _ = conn.Write([]byte("reply to " + string(request) + " " + proxyContent))
+	}()
+	proxy := NewFaultProxy(l.Addr().String())
+	if !assert.NoError(t, proxy.Start("127.0.0.1:0")) {
+		return
+	}
+	defer proxy.Stop()
+
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("request"))
+	if assert.NoError(t, err) && assert.NoError(t, conn.(*net.TCPConn).CloseWrite()) {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply, err := ioutil.ReadAll(conn)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "reply to request "+proxyContent, string(reply))
+		}
+	}
+}
+
+/*
+ * Byte limits count from when a connection picks up the fault, so a fault injected while a keep-alive connection is
+ * open truncates the next response on it, rather than resetting it on the first byte.
+ */
+func TestProxyLimitsOnOpenConnection(t *testing.T) {
+	proxy, server := startProxy(t, http.HandlerFunc(proxyContentHandler))
+	defer server.Close()
+	defer proxy.Stop()
+
+	request := "GET / HTTP/1.1\r\nHost: proxy\r\n\r\n"
+	for _, test := range []struct {
+		fault    ProxyFault
+		received int
+	}{
+		{ProxyTruncate(500), 500},
+		{ProxyFault{Cut: 500}, 500 - len(request)},
+	} {
+		proxy.ClearFaults()
+		conn, err := net.Dial("tcp", proxy.Addr())
+		if !assert.NoError(t, err) {
+			return
+		}
+		reader := bufio.NewReader(conn)
+		_, err = conn.Write([]byte(request))
+		if assert.NoError(t, err) {
+			res, err := http.ReadResponse(reader, nil)
+			if assert.NoError(t, err) {
+				body, _ := ioutil.ReadAll(res.Body)
+				assert.Equal(t, proxyContent, string(body))
+			}
+		}
+
+		proxy.InjectFault(test.fault)
+		_, err = conn.Write([]byte(request))
+		if assert.NoError(t, err) {
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			received, _ := ioutil.ReadAll(reader)
+			assert.Len(t, received, test.received)
+		}
+		_ = conn.Close()
+	}
+}
+
+/*
+ * Polling through a blackholed proxy should stall for the duration of the blackhole, and then recover.
+ */
+func TestApiProxyBlackhole(t *testing.T) {
+	server := httptest.NewServer(&fakeApi{})
+	defer server.Close()
+	e, _ := newRecordingTest("docker-zfs")
+	e.Port = server.Listener.Addr().(*net.TCPAddr).Port
+	proxy, err := e.StartApiProxy()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer e.StopApiProxy(proxy)
+	assert.Equal(t, proxy.Addr(), e.Client.GetConfig().Host)
+
+	proxy.InjectFault(ProxyBlackhole(time.Second))
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = Poll(ctx, "server", 100*time.Millisecond, func(ctx context.Context) (bool, interface{}, error) {
+		_, _, err := e.RepoApi.GetRepository(ctx, "foo")
+		return err == nil, err, nil
+	})
+	if assert.NoError(t, err) {
+		assert.True(t, time.Since(start) >= 900*time.Millisecond)
+	}
+
+	_ = e.StopApiProxy(proxy)
+	assert.Equal(t, fmt.Sprintf("localhost:%d", e.Port), e.Client.GetConfig().Host)
+}
+
+func TestProxyCut(t *testing.T) {
+	proxy, server := startProxy(t, http.HandlerFunc(proxyContentHandler))
+	defer server.Close()
+	defer proxy.Stop()
+
+	proxy.InjectFault(ProxyCut(2000))
+	_, err := proxyGet(proxy)
+	assert.Error(t, err)
+}
+
+func TestProxyThrottle(t *testing.T) {
+	proxy, server := startProxy(t, http.HandlerFunc(proxyContentHandler))
+	defer server.Close()
+	defer proxy.Stop()
+
+	proxy.InjectFault(ProxyThrottle(20000))
+	start := time.Now()
+	res, err := proxyGet(proxy)
+	if assert.NoError(t, err) {
+		assert.Equal(t, proxyContent, res)
+		assert.True(t, time.Since(start) >= 400*time.Millisecond)
+	}
+}
+
+func TestProxyStall(t *testing.T) {
+	proxy, server := startProxy(t, http.HandlerFunc(proxyContentHandler))
+	defer server.Close()
+	defer proxy.Stop()
+
+	proxy.InjectFault(ProxyStall(100, 500*time.Millisecond))
+	start := time.Now()
+	res, err := proxyGet(proxy)
+	if assert.NoError(t, err) {
+		assert.Equal(t, proxyContent, res)
+		assert.True(t, time.Since(start) >= 500*time.Millisecond)
+	}
+}
+
+/*
+ * A stall started by data in one direction also holds data in the other: here the target's greeting starts the stall,
+ * and the client's reply doesn't reach the target until it ends.
+ */
+func TestProxyStallBothDirections(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	received := make(chan time.Time, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write(make([]byte, 200))
+		_, _ = conn.Read(make([]byte, 5))
+		received <- time.Now()
+	}()
+	proxy := NewFaultProxy(l.Addr().String())
+	if !assert.NoError(t, proxy.Start("127.0.0.1:0")) {
+		return
+	}
+	defer proxy.Stop()
+
+	proxy.InjectFault(ProxyStall(100, 500*time.Millisecond))
+	start := time.Now()
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	_, err = conn.Write([]byte("hello"))
+	if assert.NoError(t, err) {
+		select {
+		case at := <-received:
+			assert.True(t, at.Sub(start) >= 450*time.Millisecond, "received after %v", at.Sub(start))
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "reply never reached the target")
+		}
+	}
+}
+
+/*
+ * Faults apply equally to SSH sessions, which fail outright if the connection is cut during the handshake.
+ */
+func TestProxySsh(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+	proxy := NewFaultProxy(fmt.Sprintf("localhost:%d", server.Port()))
+	if !assert.NoError(t, proxy.Start("127.0.0.1:0")) {
+		return
+	}
+	defer proxy.Stop()
+
+	out, err := runSshAt(proxy.Port(), ssh.Password("test"), "cat", "hello\n")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "hello\n", out)
+	}
+	proxy.InjectFault(ProxyCut(1000))
+	_, err = runSshAt(proxy.Port(), ssh.Password("test"), "cat", "hello\n")
+	assert.Error(t, err)
+}
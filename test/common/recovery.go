@@ -0,0 +1,164 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	titan "github.com/titan-data/titan-client-go"
+	"strings"
+	"time"
+)
+
+/*
+ * Point during an operation at which the server is restarted. A point is either a phase, reported by providers that
+ * emit START and END entries for data and metadata sync, or a time after the operation started. Phase points are never
+ * reached for providers that report no phases. Time points only show that the operation was still running when the
+ * server was restarted, not what it was doing, and so should be well within the operation's duration.
+ *
+ * A phase can also be detected outside of the progress stream through Reached, such as by the remote holding the
+ * command the provider runs in that phase (see SshRestartPoint), which keeps the operation in that phase for as long
+ * as the restart takes.
+ */
+type RestartPoint struct {
+	Name string
+	// Returns true if the given phase, once started, means that this point has been reached
+	Phase func(name string) bool
+	// Time after the operation starts at which this point is reached, for points that aren't phases
+	After time.Duration
+	// Returns true once this point has been reached, overriding Phase and After if set
+	Reached func() bool
+}
+
+var RestartAtStart = RestartPoint{
+	Name: "AtStart",
+}
+
+func RestartAfter(after time.Duration) RestartPoint {
+	return RestartPoint{Name: fmt.Sprintf("After%s", after), After: after}
+}
+
+var RestartDuringDataSync = RestartPoint{
+	Name: "DataSync",
+	Phase: func(name string) bool {
+		return !strings.Contains(strings.ToLower(name), "metadata")
+	},
+}
+
+var RestartDuringMetadataSync = RestartPoint{
+	Name: "MetadataSync",
+	Phase: func(name string) bool {
+		return strings.Contains(strings.ToLower(name), "metadata")
+	},
+}
+
+/*
+ * Commands the ssh provider runs during data sync (rsync, in either direction) and metadata sync (writing the commit
+ * metadata on push), for use with SshRestartPoint.
+ */
+const (
+	SshDataSyncCommand     = "^rsync --server "
+	SshMetadataSyncCommand = "^sh -c ['\"]?cat > .*/metadata\\.json"
+)
+
+/*
+ * Restart at the given point once the SSH server has stalled a command (see SshServer.Stall). The stall should be
+ * released once RunWithRestart returns.
+ */
+func SshRestartPoint(point RestartPoint, stall *SshStall) RestartPoint {
+	point.Reached = stall.Stalled
+	return point
+}
+
+/*
+ * Restart points for the nop provider, which reports no phases. Both are within the first quarter of an operation run
+ * with DelayedNopParameters(8), leaving room for slow hosts.
+ */
+var NopRestartPoints = []RestartPoint{RestartAtStart, RestartAfter(2 * time.Second)}
+
+/*
+ * Outcome of an operation that was interrupted by a server restart. The watcher holds every progress entry, from
+ * before and after the restart, and the operation reflects its final state as reported by the server.
+ */
+type RecoveryResult struct {
+	Operation titan.Operation
+	Watcher   *ProgressWatcher
+	Err       error
+}
+
+/*
+ * Nop remote parameters that keep an operation running long enough to reach every restart point.
+ */
+func DelayedNopParameters(delay int) titan.RemoteParameters {
+	return titan.RemoteParameters{
+		Provider:   "nop",
+		Properties: map[string]interface{}{"delay": delay},
+	}
+}
+
+/*
+ * Start an operation, restart the server once it reaches the given point, and wait for the recovered operation to
+ * finish. An error is returned if the operation can't be started, isn't still running once the restart point is
+ * reached, or the server doesn't come back. How the operation itself finishes is reported through the result.
+ */
+func (e *EndToEndTest) RunWithRestart(ctx context.Context, point RestartPoint,
+	start func(ctx context.Context) (titan.Operation, error)) (*RecoveryResult, error) {
+	op, err := start(ctx)
+	if err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	w := NewProgressWatcher()
+	err = e.watchOperation(ctx, op.Id, w, func() bool {
+		return point.reached(w, time.Since(started))
+	})
+	if err != nil && !w.Done() {
+		return nil, err
+	}
+	if w.Done() {
+		return nil, errors.New(fmt.Sprintf("operation %s finished with %s before restart point %s", op.Id, w.State(),
+			point.Name))
+	}
+	current, _, err := e.OperationsApi.GetOperation(ctx, op.Id)
+	if err != nil {
+		return nil, err
+	}
+	if current.State != "RUNNING" {
+		return nil, errors.New(fmt.Sprintf("operation %s was %s rather than running at restart point %s", op.Id,
+			current.State, point.Name))
+	}
+
+	err = e.RestartServer()
+	if err != nil {
+		return nil, err
+	}
+	err = e.WaitForServer(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RecoveryResult{Watcher: w}
+	result.Err = e.WatchOperation(ctx, op.Id, w)
+	result.Operation, _, err = e.OperationsApi.GetOperation(ctx, op.Id)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+func (p RestartPoint) reached(w *ProgressWatcher, elapsed time.Duration) bool {
+	if p.Reached != nil {
+		return p.Reached()
+	}
+	if p.Phase == nil {
+		return elapsed >= p.After
+	}
+	for _, phase := range w.Phases() {
+		if p.Phase(phase.Name) {
+			return true
+		}
+	}
+	return false
+}
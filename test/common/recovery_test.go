@@ -0,0 +1,57 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	titan "github.com/titan-data/titan-client-go"
+	"testing"
+	"time"
+)
+
+func TestRestartPointWithoutPhases(t *testing.T) {
+	w := NewProgressWatcher()
+	w.Add(titan.ProgressEntry{Id: 1, Type: ProgressMessage, Message: "Pushing id to 'origin'"})
+	assert.True(t, RestartAtStart.reached(w, 0))
+	after := RestartAfter(2 * time.Second)
+	assert.Equal(t, "After2s", after.Name)
+	assert.False(t, after.reached(w, time.Second))
+	assert.True(t, after.reached(w, 2*time.Second))
+	assert.False(t, RestartDuringDataSync.reached(w, time.Minute))
+	assert.False(t, RestartDuringMetadataSync.reached(w, time.Minute))
+}
+
+func TestRestartPointPhases(t *testing.T) {
+	w := NewProgressWatcher()
+	w.Add(titan.ProgressEntry{Id: 1, Type: ProgressStart, Message: "Uploading vol"})
+	assert.True(t, RestartDuringDataSync.reached(w, 0))
+	assert.False(t, RestartDuringMetadataSync.reached(w, time.Minute))
+	w.Add(titan.ProgressEntry{Id: 2, Type: ProgressEnd})
+	w.Add(titan.ProgressEntry{Id: 3, Type: ProgressStart, Message: "Pushing Metadata"})
+	assert.True(t, RestartDuringMetadataSync.reached(w, 0))
+}
+
+func TestSshRestartPoint(t *testing.T) {
+	server, err := NewSshServer("test", "test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer server.Stop()
+	w := NewProgressWatcher()
+
+	stall := server.Stall(SshMetadataSyncCommand)
+	defer stall.Release()
+	point := SshRestartPoint(RestartDuringMetadataSync, stall)
+	assert.Equal(t, "MetadataSync", point.Name)
+	assert.False(t, point.reached(w, time.Minute))
+	assert.Nil(t, server.stall("rsync --server -vlogDtprze.iLsfxC . /bar/id"))
+	assert.Equal(t, stall, server.stall("sh -c 'cat > /bar/id/metadata.json'"))
+	assert.True(t, point.reached(w, 0))
+
+	stall = server.Stall(SshDataSyncCommand)
+	defer stall.Release()
+	assert.Nil(t, server.stall("sh -c 'cat > /bar/id/metadata.json'"))
+	assert.Equal(t, stall, server.stall("rsync --server --sender -vlogDtprze.iLsfxC . /bar/id/vol/"))
+	assert.True(t, SshRestartPoint(RestartDuringDataSync, stall).reached(w, 0))
+}
@@ -0,0 +1,349 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+/*
+ * Options for running a new container. These mirror the subset of 'docker run' flags that the harness needs, and
+ * are translated into command line arguments by Args().
+ */
+type RunOptions struct {
+	Name       string
+	Image      string
+	Command    []string
+	Detach     bool
+	Remove     bool
+	Restart    string
+	Privileged bool
+	Pid        string
+	Network    string
+	Volumes    []string
+	Env        []string
+	Ports      []string
+}
+
+/*
+ * Get the 'run' command line arguments for these options, as understood by docker.
+ */
+func (o RunOptions) Args() []string {
+	args := []string{"run"}
+	if o.Detach {
+		args = append(args, "-d")
+	}
+	if o.Remove {
+		args = append(args, "--rm")
+	}
+	if o.Restart != "" {
+		args = append(args, "--restart", o.Restart)
+	}
+	if o.Name != "" {
+		args = append(args, "--name", o.Name)
+	}
+	if o.Privileged {
+		args = append(args, "--privileged")
+	}
+	if o.Pid != "" {
+		args = append(args, fmt.Sprintf("--pid=%s", o.Pid))
+	}
+	if o.Network != "" {
+		args = append(args, fmt.Sprintf("--network=%s", o.Network))
+	}
+	for _, v := range o.Volumes {
+		args = append(args, "-v", v)
+	}
+	for _, e := range o.Env {
+		args = append(args, "-e", e)
+	}
+	for _, p := range o.Ports {
+		args = append(args, "-p", p)
+	}
+	args = append(args, o.Image)
+	return append(args, o.Command...)
+}
+
+/*
+ * Interface to the container runtime used to run the titan server and any supporting containers. All harness
+ * actions go through this interface rather than invoking a CLI directly, so that alternate runtimes can be used,
+ * and so that the harness itself can be tested with a RecordingRuntime.
+ */
+type ContainerRuntime interface {
+	Run(opts RunOptions) error
+	Exec(container string, args ...string) (string, error)
+	ExecStream(container string, stdin io.Reader, stdout io.Writer, args ...string) error
+	Remove(container string) error
+	Restart(container string) error
+	Logs(container string) (string, error)
+	Inspect(container string, format string) (string, error)
+	CreateVolume(name string) error
+	RemoveVolume(name string) error
+}
+
+/*
+ * Create a runtime by name. The name is typically taken from the TITAN_CONTAINER_RUNTIME environment variable, and
+ * defaults to "docker". The other runtime supported is "podman".
+ */
+func NewContainerRuntime(name string) (ContainerRuntime, error) {
+	switch name {
+	case "", "docker":
+		return NewDockerRuntime(), nil
+	case "podman":
+		return NewPodmanRuntime(), nil
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown container runtime '%s'", name))
+	}
+}
+
+func defaultContainerRuntime() (ContainerRuntime, error) {
+	return NewContainerRuntime(os.Getenv("TITAN_CONTAINER_RUNTIME"))
+}
+
+/*
+ * Runtime that drives a docker-compatible command line tool.
+ */
+type cliRuntime struct {
+	command string
+}
+
+func NewDockerRuntime() ContainerRuntime {
+	return &cliRuntime{command: "docker"}
+}
+
+/*
+ * Runtime that drives podman. Podman accepts the same command line as docker for everything the harness does, with
+ * two exceptions. First, the titan launch container runs docker commands against the docker socket mounted into it,
+ * so that mount is pointed at podman's docker-compatible API socket instead, which is taken from TITAN_PODMAN_SOCKET
+ * and defaults to that of the rootful system service. The service must be running (such as through 'systemctl start
+ * podman.socket'), and rootless podman isn't supported, since the server needs privileged access to the ZFS module.
+ * Second, 'podman inspect' also matches images and volumes by name, so containers are inspected explicitly.
+ */
+type podmanRuntime struct {
+	cliRuntime
+	socket string
+}
+
+const dockerSocket = "/var/run/docker.sock"
+const defaultPodmanSocket = "/run/podman/podman.sock"
+
+func NewPodmanRuntime() ContainerRuntime {
+	socket := os.Getenv("TITAN_PODMAN_SOCKET")
+	if socket == "" {
+		socket = defaultPodmanSocket
+	}
+	return &podmanRuntime{cliRuntime: cliRuntime{command: "podman"}, socket: socket}
+}
+
+/*
+ * Get the options to run with podman, with any mount of the docker socket replaced by the podman socket.
+ */
+func (r *podmanRuntime) runOptions(opts RunOptions) RunOptions {
+	volumes := make([]string, len(opts.Volumes))
+	for i, v := range opts.Volumes {
+		if strings.HasPrefix(v, dockerSocket+":") {
+			v = r.socket + strings.TrimPrefix(v, dockerSocket)
+		}
+		volumes[i] = v
+	}
+	opts.Volumes = volumes
+	return opts
+}
+
+func (r *podmanRuntime) Run(opts RunOptions) error {
+	return exec.Command(r.command, r.runOptions(opts).Args()...).Run()
+}
+
+func (r *podmanRuntime) Inspect(container string, format string) (string, error) {
+	out, err := exec.Command(r.command, "container", "inspect", "-f", format, container).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (r *cliRuntime) Run(opts RunOptions) error {
+	return exec.Command(r.command, opts.Args()...).Run()
+}
+
+func (r *cliRuntime) Exec(container string, args ...string) (string, error) {
+	fullArgs := []string{"exec", container}
+	fullArgs = append(fullArgs, args...)
+	out, err := exec.Command(r.command, fullArgs...).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+/*
+ * Execute a command with the given standard input and output, which can be arbitrary binary streams.
+ */
+func (r *cliRuntime) ExecStream(container string, stdin io.Reader, stdout io.Writer, args ...string) error {
+	fullArgs := []string{"exec"}
+	if stdin != nil {
+		fullArgs = append(fullArgs, "-i")
+	}
+	fullArgs = append(fullArgs, container)
+	fullArgs = append(fullArgs, args...)
+	var stderr bytes.Buffer
+	cmd := exec.Command(r.command, fullArgs...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil && stderr.Len() != 0 {
+		return errors.New(fmt.Sprintf("%v: %s", err, strings.TrimSpace(stderr.String())))
+	}
+	return err
+}
+
+func (r *cliRuntime) Remove(container string) error {
+	return exec.Command(r.command, "rm", "-f", container).Run()
+}
+
+func (r *cliRuntime) Restart(container string) error {
+	return exec.Command(r.command, "restart", container).Run()
+}
+
+func (r *cliRuntime) Logs(container string) (string, error) {
+	out, err := exec.Command(r.command, "logs", container).CombinedOutput()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (r *cliRuntime) Inspect(container string, format string) (string, error) {
+	out, err := exec.Command(r.command, "inspect", "-f", format, container).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (r *cliRuntime) CreateVolume(name string) error {
+	return exec.Command(r.command, "volume", "create", name).Run()
+}
+
+func (r *cliRuntime) RemoveVolume(name string) error {
+	return exec.Command(r.command, "volume", "rm", name).Run()
+}
+
+/*
+ * A single call made against a RecordingRuntime. For Run(), Options holds the full set of run options, and Args
+ * holds the equivalent command line. For ExecStream(), Input holds everything read from standard input.
+ */
+type RuntimeCall struct {
+	Method    string
+	Container string
+	Args      []string
+	Options   *RunOptions
+	Input     []byte
+}
+
+/*
+ * Fake runtime that records every call without running anything. Output and errors can be configured per method,
+ * keyed by method name such as "Exec" or "Inspect".
+ */
+type RecordingRuntime struct {
+	Calls   []RuntimeCall
+	Outputs map[string]string
+	Errors  map[string]error
+
+	lock sync.Mutex
+}
+
+func NewRecordingRuntime() *RecordingRuntime {
+	return &RecordingRuntime{
+		Outputs: map[string]string{},
+		Errors:  map[string]error{},
+	}
+}
+
+func (r *RecordingRuntime) record(call RuntimeCall) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Calls = append(r.Calls, call)
+	return r.Outputs[call.Method], r.Errors[call.Method]
+}
+
+/*
+ * Get all the calls made for a particular method.
+ */
+func (r *RecordingRuntime) CallsTo(method string) []RuntimeCall {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ret := []RuntimeCall{}
+	for _, c := range r.Calls {
+		if c.Method == method {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+func (r *RecordingRuntime) Run(opts RunOptions) error {
+	_, err := r.record(RuntimeCall{Method: "Run", Container: opts.Name, Args: opts.Args(), Options: &opts})
+	return err
+}
+
+func (r *RecordingRuntime) Exec(container string, args ...string) (string, error) {
+	return r.record(RuntimeCall{Method: "Exec", Container: container, Args: args})
+}
+
+func (r *RecordingRuntime) ExecStream(container string, stdin io.Reader, stdout io.Writer, args ...string) error {
+	call := RuntimeCall{Method: "ExecStream", Container: container, Args: args}
+	if stdin != nil {
+		input, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		call.Input = input
+	}
+	out, err := r.record(call)
+	if err != nil {
+		return err
+	}
+	if stdout != nil {
+		_, err = io.WriteString(stdout, out)
+	}
+	return err
+}
+
+func (r *RecordingRuntime) Remove(container string) error {
+	_, err := r.record(RuntimeCall{Method: "Remove", Container: container})
+	return err
+}
+
+func (r *RecordingRuntime) Restart(container string) error {
+	_, err := r.record(RuntimeCall{Method: "Restart", Container: container})
+	return err
+}
+
+func (r *RecordingRuntime) Logs(container string) (string, error) {
+	return r.record(RuntimeCall{Method: "Logs", Container: container})
+}
+
+func (r *RecordingRuntime) Inspect(container string, format string) (string, error) {
+	return r.record(RuntimeCall{Method: "Inspect", Container: container, Args: []string{format}})
+}
+
+func (r *RecordingRuntime) CreateVolume(name string) error {
+	_, err := r.record(RuntimeCall{Method: "CreateVolume", Args: []string{name}})
+	return err
+}
+
+func (r *RecordingRuntime) RemoveVolume(name string) error {
+	_, err := r.record(RuntimeCall{Method: "RemoveVolume", Args: []string{name}})
+	return err
+}
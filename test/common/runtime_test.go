@@ -0,0 +1,199 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"testing"
+)
+
+func newRecordingTest(context string) (*EndToEndTest, *RecordingRuntime) {
+	e := NewEndToEndTest(&suite.Suite{}, context)
+	e.Identity = "test"
+	e.Port = 6001
+	runtime := NewRecordingRuntime()
+	e.Runtime = runtime
+	return e, runtime
+}
+
+func TestRunOptionsArgs(t *testing.T) {
+	args := RunOptions{
+		Name:    "test-ssh",
+		Detach:  true,
+		Network: "test",
+		Ports:   []string{"6003:22"},
+		Image:   "ssh:latest",
+		Command: []string{"/bin/sh"},
+	}.Args()
+	assert.Equal(t, []string{"run", "-d", "--name", "test-ssh", "--network=test", "-p", "6003:22",
+		"ssh:latest", "/bin/sh"}, args)
+}
+
+func TestNewContainerRuntime(t *testing.T) {
+	for _, name := range []string{"", "docker"} {
+		runtime, err := NewContainerRuntime(name)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "docker", runtime.(*cliRuntime).command)
+		}
+	}
+	runtime, err := NewContainerRuntime("podman")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "podman", runtime.(*podmanRuntime).command)
+	}
+	_, err = NewContainerRuntime("rkt")
+	assert.Error(t, err)
+}
+
+func TestPodmanRuntimeSocket(t *testing.T) {
+	socket, present := os.LookupEnv("TITAN_PODMAN_SOCKET")
+	defer func() {
+		if present {
+			_ = os.Setenv("TITAN_PODMAN_SOCKET", socket)
+		} else {
+			_ = os.Unsetenv("TITAN_PODMAN_SOCKET")
+		}
+	}()
+
+	_ = os.Unsetenv("TITAN_PODMAN_SOCKET")
+	assert.Equal(t, "/run/podman/podman.sock", NewPodmanRuntime().(*podmanRuntime).socket)
+	_ = os.Setenv("TITAN_PODMAN_SOCKET", "/tmp/podman.sock")
+	assert.Equal(t, "/tmp/podman.sock", NewPodmanRuntime().(*podmanRuntime).socket)
+}
+
+/*
+ * The launch container is given the podman socket in place of the docker socket, and is otherwise run unchanged.
+ */
+func TestPodmanRunOptions(t *testing.T) {
+	e, recording := newRecordingTest("docker-zfs")
+	if !assert.NoError(t, e.RunTitanDocker("launch", true)) {
+		return
+	}
+	opts := *recording.CallsTo("Run")[0].Options
+	podman := &podmanRuntime{cliRuntime: cliRuntime{command: "podman"}, socket: "/run/podman/podman.sock"}
+	translated := podman.runOptions(opts)
+
+	assert.Contains(t, opts.Volumes, "/var/run/docker.sock:/var/run/docker.sock")
+	assert.Contains(t, translated.Volumes, "/run/podman/podman.sock:/var/run/docker.sock")
+	assert.NotContains(t, translated.Volumes, "/var/run/docker.sock:/var/run/docker.sock")
+	assert.Len(t, translated.Volumes, len(opts.Volumes))
+	for _, v := range opts.Volumes {
+		if v != "/var/run/docker.sock:/var/run/docker.sock" {
+			assert.Contains(t, translated.Volumes, v)
+		}
+	}
+	translated.Volumes = opts.Volumes
+	assert.Equal(t, opts, translated)
+}
+
+func TestRunTitanDockerDaemon(t *testing.T) {
+	e, runtime := newRecordingTest("docker-zfs")
+	err := e.RunTitanDocker("launch", true)
+	if assert.NoError(t, err) {
+		calls := runtime.CallsTo("Run")
+		assert.Len(t, calls, 1)
+		opts := calls[0].Options
+		assert.Equal(t, "test-launch", opts.Name)
+		assert.True(t, opts.Detach)
+		assert.Equal(t, "always", opts.Restart)
+		assert.Contains(t, opts.Volumes, "test-data:/var/lib/test/data")
+		assert.Contains(t, opts.Volumes, "/lib:/var/lib/test/system")
+		assert.Contains(t, opts.Env, "TITAN_IDENTITY=test")
+		assert.Equal(t, []string{"/bin/bash", "/titan/launch"}, opts.Command)
+	}
+}
+
+func TestRunTitanDockerTeardown(t *testing.T) {
+	e, runtime := newRecordingTest("docker-zfs")
+	err := e.RunTitanDocker("teardown", false)
+	if assert.NoError(t, err) {
+		opts := runtime.CallsTo("Run")[0].Options
+		assert.Empty(t, opts.Name)
+		assert.True(t, opts.Remove)
+		assert.NotContains(t, opts.Volumes, "/lib:/var/lib/test/system")
+		assert.Contains(t, opts.Volumes, "test-data:/var/lib/test/data")
+	}
+}
+
+func TestRunTitanKubernetesConfig(t *testing.T) {
+	image, present := os.LookupEnv("TITAN_IMAGE")
+	_ = os.Unsetenv("TITAN_IMAGE")
+	defer func() {
+		if present {
+			_ = os.Setenv("TITAN_IMAGE", image)
+		}
+	}()
+
+	e, runtime := newRecordingTest("kubernetes-csi")
+	err := e.RunTitanKubernetes("run", "context=foo", "storageClass=bar")
+	if assert.NoError(t, err) {
+		opts := runtime.CallsTo("Run")[0].Options
+		assert.Equal(t, "test-server", opts.Name)
+		assert.Contains(t, opts.Volumes, "test-data:/var/lib/test")
+		assert.Contains(t, opts.Env, "TITAN_CONFIG=context=foo,storageClass=bar,titanImage=titandata/titan:latest")
+		assert.Equal(t, []string{"6001:5001"}, opts.Ports)
+	}
+}
+
+func TestRunTitanKubernetesImage(t *testing.T) {
+	e, runtime := newRecordingTest("kubernetes-csi")
+	err := e.RunTitanKubernetes("run", "titanImage=foo:bar")
+	if assert.NoError(t, err) {
+		opts := runtime.CallsTo("Run")[0].Options
+		assert.Contains(t, opts.Env, "TITAN_CONFIG=titanImage=foo:bar")
+	}
+}
+
+func TestStopServerDockerZfs(t *testing.T) {
+	e, runtime := newRecordingTest("docker-zfs")
+	err := e.StopServer(false)
+	if assert.NoError(t, err) {
+		assert.Len(t, runtime.Calls, 4)
+		assert.Equal(t, "test-launch", runtime.Calls[0].Container)
+		assert.Equal(t, "test-server", runtime.Calls[1].Container)
+		assert.Equal(t, "Run", runtime.Calls[2].Method)
+		assert.Equal(t, []string{"test-data"}, runtime.Calls[3].Args)
+	}
+}
+
+func TestStopServerKubernetes(t *testing.T) {
+	e, runtime := newRecordingTest("kubernetes-csi")
+	err := e.StopServer(false)
+	if assert.NoError(t, err) {
+		assert.Len(t, runtime.Calls, 2)
+		assert.Equal(t, "Remove", runtime.Calls[0].Method)
+		assert.Equal(t, "test-server", runtime.Calls[0].Container)
+		assert.Equal(t, "RemoveVolume", runtime.Calls[1].Method)
+	}
+}
+
+func TestExecServer(t *testing.T) {
+	e, runtime := newRecordingTest("docker-zfs")
+	runtime.Outputs["Exec"] = "output"
+	out, err := e.ExecServer("zfs", "list")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "output", out)
+		call := runtime.CallsTo("Exec")[0]
+		assert.Equal(t, "test-server", call.Container)
+		assert.Equal(t, []string{"zfs", "list"}, call.Args)
+	}
+}
+
+func TestPathExists(t *testing.T) {
+	e, runtime := newRecordingTest("docker-zfs")
+	assert.True(t, e.PathExists("/var/lib/test"))
+	assert.Equal(t, []string{"ls", "/var/lib/test"}, runtime.CallsTo("Exec")[0].Args)
+	runtime.Errors["Exec"] = errors.New("ls: cannot access '/var/lib/test': No such file or directory")
+	assert.False(t, e.PathExists("/var/lib/test"))
+}
+
+func TestLocalAddresses(t *testing.T) {
+	e, runtime := newRecordingTest("docker-zfs")
+	assert.Equal(t, []string{"127.0.0.1:22"}, e.localAddresses(22))
+	runtime.Outputs["Inspect"] = "172.18.0.1 "
+	assert.Equal(t, []string{"127.0.0.1:0", "172.18.0.1:0"}, e.localAddresses(0))
+	assert.Equal(t, "test-server", runtime.CallsTo("Inspect")[0].Container)
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sort"
+	"strings"
+)
+
+/*
+ * Maximum number of keys that can be deleted in a single DeleteObjects request.
+ */
+const s3DeleteBatchSize = 1000
+
+/*
+ * Helpers for inspecting and cleaning up remote storage in an S3 bucket, whether that's AWS or the FakeS3 server. All
+ * listing is paginated, so prefixes with any number of objects are handled correctly.
+ */
+type S3Bucket struct {
+	Svc    *s3.S3
+	Bucket string
+}
+
+func NewS3Bucket(svc *s3.S3, bucket string) *S3Bucket {
+	return &S3Bucket{Svc: svc, Bucket: bucket}
+}
+
+/*
+ * A single object as seen in a listing.
+ */
+type S3Object struct {
+	Key  string
+	Size int64
+	ETag string
+}
+
+/*
+ * Snapshot of all objects beneath a prefix, keyed by object key.
+ */
+type S3Inventory map[string]S3Object
+
+/*
+ * Differences between two inventories. Objects are considered changed if their size or ETag differ.
+ */
+type S3InventoryDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+/*
+ * Generate a prefix beneath the given one that is unique to this run, so that concurrent or repeated runs against the
+ * same bucket never see each other's objects.
+ */
+func UniqueS3Prefix(base string) string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return strings.TrimSuffix(base, "/") + "/run-" + hex.EncodeToString(suffix)
+}
+
+/*
+ * List all objects beneath the given prefix, in key order.
+ */
+func (b *S3Bucket) List(prefix string) ([]S3Object, error) {
+	ret := []S3Object{}
+	err := b.Svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(b.Bucket), Prefix: aws.String(prefix)},
+		func(res *s3.ListObjectsV2Output, last bool) bool {
+			for _, obj := range res.Contents {
+				ret = append(ret, S3Object{
+					Key:  aws.StringValue(obj.Key),
+					Size: aws.Int64Value(obj.Size),
+					ETag: strings.Trim(aws.StringValue(obj.ETag), "\""),
+				})
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+/*
+ * List the keys of all objects beneath the given prefix.
+ */
+func (b *S3Bucket) Keys(prefix string) ([]string, error) {
+	objects, err := b.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, len(objects))
+	for i, obj := range objects {
+		ret[i] = obj.Key
+	}
+	return ret, nil
+}
+
+/*
+ * Delete all objects beneath the given prefix, in batches, returning the number of objects deleted.
+ */
+func (b *S3Bucket) DeletePrefix(prefix string) (int, error) {
+	keys, err := b.Keys(prefix)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for start := 0; start < len(keys); start += s3DeleteBatchSize {
+		end := start + s3DeleteBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		objects := []*s3.ObjectIdentifier{}
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		res, err := b.Svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(b.Bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if len(res.Errors) != 0 {
+			e := res.Errors[0]
+			return deleted, errors.New(fmt.Sprintf("failed to delete %s: %s", aws.StringValue(e.Key),
+				aws.StringValue(e.Message)))
+		}
+		deleted += len(objects)
+	}
+	return deleted, nil
+}
+
+/*
+ * Take a snapshot of all objects beneath the given prefix.
+ */
+func (b *S3Bucket) Inventory(prefix string) (S3Inventory, error) {
+	objects, err := b.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	ret := S3Inventory{}
+	for _, obj := range objects {
+		ret[obj.Key] = obj
+	}
+	return ret, nil
+}
+
+/*
+ * Get the user metadata of an object, with keys in lower case since S3 doesn't preserve their case.
+ */
+func (b *S3Bucket) Metadata(key string) (map[string]string, error) {
+	res, err := b.Svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(b.Bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]string{}
+	for k, v := range res.Metadata {
+		ret[strings.ToLower(k)] = aws.StringValue(v)
+	}
+	return ret, nil
+}
+
+/*
+ * Compare this inventory against a later one. All lists are sorted.
+ */
+func (i S3Inventory) Diff(after S3Inventory) S3InventoryDiff {
+	diff := S3InventoryDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for key, obj := range after {
+		if before, ok := i[key]; !ok {
+			diff.Added = append(diff.Added, key)
+		} else if before.Size != obj.Size || before.ETag != obj.ETag {
+			diff.Changed = append(diff.Changed, key)
+		}
+	}
+	for key := range i {
+		if _, ok := after[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
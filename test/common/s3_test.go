@@ -0,0 +1,111 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func putS3Objects(t *testing.T, svc *s3.S3, prefix string, count int) {
+	for i := 0; i < count; i++ {
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String(fmt.Sprintf("%s/%04d", prefix, i)),
+			Body:   strings.NewReader("content"),
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+}
+
+func TestS3BucketListPaginated(t *testing.T) {
+	fake, svc := startFakeS3(t)
+	defer fake.Stop()
+	putS3Objects(t, svc, "a", 1205)
+	putS3Objects(t, svc, "b", 3)
+
+	bucket := NewS3Bucket(svc, "bucket")
+	res, err := bucket.List("a/")
+	if assert.NoError(t, err) && assert.Len(t, res, 1205) {
+		assert.Equal(t, "a/0000", res[0].Key)
+		assert.Equal(t, "a/1204", res[1204].Key)
+		assert.Equal(t, int64(7), res[0].Size)
+		assert.Equal(t, md5Hex([]byte("content")), res[0].ETag)
+	}
+}
+
+func TestS3BucketDeletePrefix(t *testing.T) {
+	fake, svc := startFakeS3(t)
+	defer fake.Stop()
+	putS3Objects(t, svc, "a", 2010)
+	putS3Objects(t, svc, "b", 3)
+
+	bucket := NewS3Bucket(svc, "bucket")
+	count, err := bucket.DeletePrefix("a/")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2010, count)
+		assert.Equal(t, []string{"b/0000", "b/0001", "b/0002"}, fake.Keys("bucket"))
+	}
+
+	count, err = bucket.DeletePrefix("a/")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 0, count)
+	}
+}
+
+func TestS3BucketInventoryDiff(t *testing.T) {
+	fake, svc := startFakeS3(t)
+	defer fake.Stop()
+	putS3Objects(t, svc, "a", 3)
+
+	bucket := NewS3Bucket(svc, "bucket")
+	before, err := bucket.Inventory("a/")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, _ = svc.PutObject(&s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("a/0001"),
+		Body: strings.NewReader("changed")})
+	_, _ = svc.PutObject(&s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String("a/new"),
+		Body: strings.NewReader("new")})
+	_, _ = svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("a/0002")})
+	after, err := bucket.Inventory("a/")
+	if assert.NoError(t, err) {
+		diff := before.Diff(after)
+		assert.Equal(t, []string{"a/new"}, diff.Added)
+		assert.Equal(t, []string{"a/0002"}, diff.Removed)
+		assert.Equal(t, []string{"a/0001"}, diff.Changed)
+		assert.Empty(t, after.Diff(after).Added)
+	}
+}
+
+func TestS3BucketMetadata(t *testing.T) {
+	fake, svc := startFakeS3(t)
+	defer fake.Stop()
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("path/id"),
+		Body:     strings.NewReader(""),
+		Metadata: map[string]*string{"Io.titan-Data": aws.String("{}")},
+	})
+	if assert.NoError(t, err) {
+		res, err := NewS3Bucket(svc, "bucket").Metadata("path/id")
+		if assert.NoError(t, err) {
+			assert.Equal(t, map[string]string{"io.titan-data": "{}"}, res)
+		}
+	}
+}
+
+func TestUniqueS3Prefix(t *testing.T) {
+	a := UniqueS3Prefix("path/")
+	b := UniqueS3Prefix("path")
+	assert.True(t, strings.HasPrefix(a, "path/run-"))
+	assert.True(t, strings.HasPrefix(b, "path/run-"))
+	assert.NotEqual(t, a, b)
+}
@@ -0,0 +1,456 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/antihax/optional"
+	titan "github.com/titan-data/titan-client-go"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+/*
+ * Declarative description of an end-to-end workflow, loaded from a YAML file such as:
+ *
+ *	name: push and pull
+ *	steps:
+ *	  - action: createRepo
+ *	    repo: foo
+ *	  - action: createVolume
+ *	    repo: foo
+ *	    volume: vol
+ *	  - action: writeFile
+ *	    repo: foo
+ *	    volume: vol
+ *	    path: testfile
+ *	    content: Hello
+ *	  - action: commit
+ *	    repo: foo
+ *	    commit: id
+ *	    tags: {a: b}
+ *
+ * Each step names an action and the fields it uses. Any step can instead expect the server to reject it by setting
+ * 'expectError' to the code of the API error, such as NoSuchObjectException.
+ */
+type Scenario struct {
+	Name        string         `yaml:"name"`
+	Description string         `yaml:"description"`
+	Steps       []ScenarioStep `yaml:"steps"`
+}
+
+type ScenarioStep struct {
+	Action      string                 `yaml:"action"`
+	Repo        string                 `yaml:"repo"`
+	Volume      string                 `yaml:"volume"`
+	Path        string                 `yaml:"path"`
+	Content     string                 `yaml:"content"`
+	Commit      string                 `yaml:"commit"`
+	Commits     []string               `yaml:"commits"`
+	Tags        map[string]string      `yaml:"tags"`
+	Remote      string                 `yaml:"remote"`
+	Provider    string                 `yaml:"provider"`
+	Properties  map[string]interface{} `yaml:"properties"`
+	Parameters  map[string]interface{} `yaml:"parameters"`
+	Source      string                 `yaml:"sourceCommit"`
+	Last        string                 `yaml:"lastCommit"`
+	ExpectError string                 `yaml:"expectError"`
+}
+
+type scenarioAction func(e *EndToEndTest, ctx context.Context, step ScenarioStep) error
+
+/*
+ * All supported actions, along with the fields each one requires.
+ */
+var scenarioActions = map[string]struct {
+	required []string
+	run      scenarioAction
+}{
+	"createRepo":    {[]string{"repo"}, scenarioCreateRepo},
+	"deleteRepo":    {[]string{"repo"}, scenarioDeleteRepo},
+	"createVolume":  {[]string{"repo", "volume"}, scenarioCreateVolume},
+	"deleteVolume":  {[]string{"repo", "volume"}, scenarioDeleteVolume},
+	"writeFile":     {[]string{"repo", "volume", "path"}, scenarioWriteFile},
+	"commit":        {[]string{"repo", "commit"}, scenarioCommit},
+	"updateCommit":  {[]string{"repo", "commit"}, scenarioUpdateCommit},
+	"deleteCommit":  {[]string{"repo", "commit"}, scenarioDeleteCommit},
+	"checkout":      {[]string{"repo", "commit"}, scenarioCheckout},
+	"addRemote":     {[]string{"repo", "remote", "provider"}, scenarioAddRemote},
+	"deleteRemote":  {[]string{"repo", "remote"}, scenarioDeleteRemote},
+	"push":          {[]string{"repo", "remote", "commit"}, scenarioPush},
+	"pull":          {[]string{"repo", "remote", "commit"}, scenarioPull},
+	"assertFile":    {[]string{"repo", "volume", "path"}, scenarioAssertFile},
+	"assertCommits": {[]string{"repo"}, scenarioAssertCommits},
+	"assertTags":    {[]string{"repo", "commit"}, scenarioAssertTags},
+	"assertStatus":  {[]string{"repo"}, scenarioAssertStatus},
+}
+
+/*
+ * Parse a scenario, validating that every step uses a known action and has the fields that action requires.
+ */
+func ParseScenario(content []byte) (*Scenario, error) {
+	scenario := Scenario{}
+	err := yaml.UnmarshalStrict(content, &scenario)
+	if err != nil {
+		return nil, err
+	}
+	if scenario.Name == "" {
+		return nil, errors.New("scenario must have a name")
+	}
+	for i := range scenario.Steps {
+		step := &scenario.Steps[i]
+		action, ok := scenarioActions[step.Action]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("step %d: unknown action '%s'", i+1, step.Action))
+		}
+		for _, field := range action.required {
+			if step.field(field) == "" {
+				return nil, errors.New(fmt.Sprintf("step %d: action '%s' requires '%s'", i+1, step.Action, field))
+			}
+		}
+		if step.Tags == nil {
+			step.Tags = map[string]string{}
+		}
+		step.Properties = normalizeYaml(step.Properties).(map[string]interface{})
+		step.Parameters = normalizeYaml(step.Parameters).(map[string]interface{})
+	}
+	return &scenario, nil
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario, err := ParseScenario(content)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %v", path, err))
+	}
+	return scenario, nil
+}
+
+/*
+ * Load all scenarios (files ending in .yaml) within a directory, in order of file name.
+ */
+func LoadScenarios(dir string) ([]*Scenario, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	ret := []*Scenario{}
+	for _, f := range files {
+		scenario, err := LoadScenario(f)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, scenario)
+	}
+	return ret, nil
+}
+
+func (s ScenarioStep) field(name string) string {
+	switch name {
+	case "repo":
+		return s.Repo
+	case "volume":
+		return s.Volume
+	case "path":
+		return s.Path
+	case "commit":
+		return s.Commit
+	case "remote":
+		return s.Remote
+	case "provider":
+		return s.Provider
+	}
+	panic(fmt.Sprintf("unknown scenario field '%s'", name))
+}
+
+/*
+ * YAML decodes nested maps with interface{} keys, which can't be serialized as JSON. Convert them to string keys,
+ * so that properties and parameters can be passed through to the API.
+ */
+func normalizeYaml(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		ret := map[string]interface{}{}
+		for k, val := range v {
+			ret[fmt.Sprintf("%v", k)] = normalizeYaml(val)
+		}
+		return ret
+	case map[string]interface{}:
+		ret := map[string]interface{}{}
+		for k, val := range v {
+			ret[k] = normalizeYaml(val)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, val := range v {
+			ret[i] = normalizeYaml(val)
+		}
+		return ret
+	case nil:
+		return map[string]interface{}{}
+	}
+	return value
+}
+
+/*
+ * Run a scenario, with each step as a subtest that depends on the one before it. Everything the scenario creates is
+ * cleaned up afterwards, so that scenarios can share a server. Returns true if every step passed.
+ */
+func (e *EndToEndTest) RunScenario(ctx context.Context, scenario *Scenario) bool {
+	steps := e.Steps()
+	previous := []string{}
+	for i, step := range scenario.Steps {
+		step := step
+		name := fmt.Sprintf("%02d_%s", i+1, step.Action)
+		steps.Add(name, func() {
+			e.NoError(e.RunScenarioStep(ctx, step))
+		}, previous...)
+		previous = []string{name}
+	}
+	ok := steps.Run()
+	return e.NoError(e.Cleanup(ctx)) && ok
+}
+
+/*
+ * Run a single scenario step, checking the result against any expected error.
+ */
+func (e *EndToEndTest) RunScenarioStep(ctx context.Context, step ScenarioStep) error {
+	err := scenarioActions[step.Action].run(e, ctx, step)
+	if step.ExpectError == "" {
+		return err
+	}
+	if err == nil {
+		return errors.New(fmt.Sprintf("expected %s, but %s succeeded", step.ExpectError, step.Action))
+	}
+	if code := apiErrorCode(err); code != step.ExpectError {
+		return errors.New(fmt.Sprintf("expected %s, but got: %v", step.ExpectError, err))
+	}
+	return nil
+}
+
+func scenarioCreateRepo(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	_, err := e.CreateRepository(ctx, titan.Repository{Name: step.Repo, Properties: step.Properties})
+	return err
+}
+
+func scenarioDeleteRepo(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	_, err := e.RepoApi.DeleteRepository(ctx, step.Repo)
+	return err
+}
+
+func scenarioCreateVolume(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	_, err := e.CreateVolume(ctx, step.Repo, titan.Volume{Name: step.Volume, Properties: step.Properties})
+	if err != nil {
+		return err
+	}
+	err = e.WaitForVolume(ctx, step.Repo, step.Volume)
+	if err != nil {
+		return err
+	}
+	_, err = e.VolumeApi.ActivateVolume(ctx, step.Repo, step.Volume)
+	return err
+}
+
+func scenarioDeleteVolume(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	_, err := e.VolumeApi.DeactivateVolume(ctx, step.Repo, step.Volume)
+	if err != nil {
+		return err
+	}
+	_, err = e.VolumeApi.DeleteVolume(ctx, step.Repo, step.Volume)
+	return err
+}
+
+func scenarioWriteFile(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	return e.WriteFile(step.Repo, step.Volume, step.Path, step.Content)
+}
+
+func scenarioCommit(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	_, err := e.CreateCommit(ctx, step.Repo, titan.Commit{
+		Id:         step.Commit,
+		Properties: map[string]interface{}{"tags": step.Tags},
+	})
+	if err != nil {
+		return err
+	}
+	return e.WaitForCommit(ctx, step.Repo, step.Commit)
+}
+
+func scenarioUpdateCommit(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	_, _, err := e.CommitApi.UpdateCommit(ctx, step.Repo, step.Commit, titan.Commit{
+		Id:         step.Commit,
+		Properties: map[string]interface{}{"tags": step.Tags},
+	})
+	return err
+}
+
+func scenarioDeleteCommit(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	_, err := e.CommitApi.DeleteCommit(ctx, step.Repo, step.Commit)
+	return err
+}
+
+/*
+ * Check out a commit. All volumes in the repository are deactivated first and activated again afterwards, the same
+ * way the CLI does it.
+ */
+func scenarioCheckout(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	volumes, _, err := e.VolumeApi.ListVolumes(ctx, step.Repo)
+	if err != nil {
+		return err
+	}
+	for _, v := range volumes {
+		_, err = e.VolumeApi.DeactivateVolume(ctx, step.Repo, v.Name)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = e.CommitApi.CheckoutCommit(ctx, step.Repo, step.Commit)
+	if err != nil {
+		return err
+	}
+	for _, v := range volumes {
+		_, err = e.VolumeApi.ActivateVolume(ctx, step.Repo, v.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scenarioAddRemote(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	_, err := e.CreateRemote(ctx, step.Repo, titan.Remote{
+		Provider:   step.Provider,
+		Name:       step.Remote,
+		Properties: step.Properties,
+	})
+	return err
+}
+
+func scenarioDeleteRemote(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	_, err := e.RemoteApi.DeleteRemote(ctx, step.Repo, step.Remote)
+	return err
+}
+
+/*
+ * Remote parameters for push and pull. The provider defaults to that of the remote if not specified.
+ */
+func (e *EndToEndTest) scenarioParameters(ctx context.Context, step ScenarioStep) (titan.RemoteParameters, error) {
+	provider := step.Provider
+	if provider == "" {
+		remote, _, err := e.RemoteApi.GetRemote(ctx, step.Repo, step.Remote)
+		if err != nil {
+			return titan.RemoteParameters{}, err
+		}
+		provider = remote.Provider
+	}
+	return titan.RemoteParameters{Provider: provider, Properties: step.Parameters}, nil
+}
+
+func scenarioPush(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	params, err := e.scenarioParameters(ctx, step)
+	if err != nil {
+		return err
+	}
+	op, err := e.Push(ctx, step.Repo, step.Remote, step.Commit, params, nil)
+	if err != nil {
+		return err
+	}
+	_, err = e.WaitForOperation(ctx, op.Id)
+	return err
+}
+
+func scenarioPull(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	params, err := e.scenarioParameters(ctx, step)
+	if err != nil {
+		return err
+	}
+	op, err := e.Pull(ctx, step.Repo, step.Remote, step.Commit, params, nil)
+	if err != nil {
+		return err
+	}
+	_, err = e.WaitForOperation(ctx, op.Id)
+	return err
+}
+
+func scenarioAssertFile(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	content, err := e.ReadFile(step.Repo, step.Volume, step.Path)
+	if err != nil {
+		return err
+	}
+	if content != step.Content {
+		return errors.New(fmt.Sprintf("file %s: expected '%s', got '%s'", step.Path, step.Content, content))
+	}
+	return nil
+}
+
+/*
+ * Assert the exact set of commits in the repository, optionally filtered by tags.
+ */
+func scenarioAssertCommits(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	var opts *titan.ListCommitsOpts
+	if len(step.Tags) != 0 {
+		filter := []string{}
+		for k, v := range step.Tags {
+			if v == "" {
+				filter = append(filter, k)
+			} else {
+				filter = append(filter, fmt.Sprintf("%s=%s", k, v))
+			}
+		}
+		opts = &titan.ListCommitsOpts{Tag: optional.NewInterface(filter)}
+	}
+	commits, _, err := e.CommitApi.ListCommits(ctx, step.Repo, opts)
+	if err != nil {
+		return err
+	}
+	actual := []string{}
+	for _, c := range commits {
+		actual = append(actual, c.Id)
+	}
+	expected := append([]string{}, step.Commits...)
+	sort.Strings(actual)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(expected, actual) {
+		return errors.New(fmt.Sprintf("expected commits [%s], got [%s]", strings.Join(expected, ", "),
+			strings.Join(actual, ", ")))
+	}
+	return nil
+}
+
+func scenarioAssertTags(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	commit, _, err := e.CommitApi.GetCommit(ctx, step.Repo, step.Commit)
+	if err != nil {
+		return err
+	}
+	for k, v := range step.Tags {
+		if actual := e.GetTag(commit, k); actual != v {
+			return errors.New(fmt.Sprintf("commit %s tag %s: expected '%s', got '%s'", step.Commit, k, v, actual))
+		}
+	}
+	return nil
+}
+
+func scenarioAssertStatus(e *EndToEndTest, ctx context.Context, step ScenarioStep) error {
+	status, _, err := e.RepoApi.GetRepositoryStatus(ctx, step.Repo)
+	if err != nil {
+		return err
+	}
+	if status.SourceCommit != step.Source {
+		return errors.New(fmt.Sprintf("expected source commit '%s', got '%s'", step.Source, status.SourceCommit))
+	}
+	if status.LastCommit != step.Last {
+		return errors.New(fmt.Sprintf("expected last commit '%s', got '%s'", step.Last, status.LastCommit))
+	}
+	return nil
+}
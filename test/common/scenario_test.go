@@ -0,0 +1,63 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseScenario(t *testing.T) {
+	scenario, err := ParseScenario([]byte(`
+name: test
+steps:
+  - action: createRepo
+    repo: foo
+  - action: addRemote
+    repo: foo
+    remote: origin
+    provider: s3
+    properties:
+      bucket: bucket
+      nested:
+        port: 22
+  - action: push
+    repo: foo
+    remote: origin
+    commit: id
+    expectError: NoSuchObjectException
+`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "test", scenario.Name)
+		assert.Len(t, scenario.Steps, 3)
+		assert.Equal(t, map[string]interface{}{}, scenario.Steps[0].Properties)
+		assert.Equal(t, map[string]string{}, scenario.Steps[0].Tags)
+
+		// Nested properties must be serializable as JSON
+		content, err := json.Marshal(scenario.Steps[1].Properties)
+		if assert.NoError(t, err) {
+			assert.JSONEq(t, `{"bucket":"bucket","nested":{"port":22}}`, string(content))
+		}
+		assert.Equal(t, "NoSuchObjectException", scenario.Steps[2].ExpectError)
+	}
+}
+
+func TestParseScenarioErrors(t *testing.T) {
+	_, err := ParseScenario([]byte("steps: []"))
+	assert.Error(t, err)
+	_, err = ParseScenario([]byte("name: test\nsteps:\n  - action: explode\n"))
+	assert.EqualError(t, err, "step 1: unknown action 'explode'")
+	_, err = ParseScenario([]byte("name: test\nsteps:\n  - action: createVolume\n    repo: foo\n"))
+	assert.EqualError(t, err, "step 1: action 'createVolume' requires 'volume'")
+	_, err = ParseScenario([]byte("name: test\nsteps:\n  - action: createRepo\n    repository: foo\n"))
+	assert.Error(t, err)
+}
+
+func TestLoadScenarios(t *testing.T) {
+	scenarios, err := LoadScenarios("../docker/scenarios")
+	if assert.NoError(t, err) {
+		assert.NotEmpty(t, scenarios)
+	}
+}
@@ -0,0 +1,572 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+/*
+ * A command executed by the SSH server, along with its exit status (or -1 if it could not be run at all).
+ */
+type SshCommand struct {
+	User       string
+	Command    string
+	ExitStatus int
+}
+
+/*
+ * In-process SSH server standing in for a remote host, so that the ssh remote can be tested without a separate
+ * container. Commands are run directly on the local host, without a shell, within a temporary root directory, and
+ * every command is recorded so that tests can assert exactly how the provider touched the remote.
+ *
+ * There is no chroot: commands run as the user running the tests, and absolute paths given to the provider must be
+ * within the root (see Path()). This also means that anything the provider runs on the remote side, such as
+ * 'rsync --server', must be installed on the host running the tests. To keep the server from being a shell on the
+ * host, only the commands the ssh provider needs are run (see sshAllowedCommand), every path they are given must
+ * resolve within the root, the password should be random (see NewSshPassword()), and the server should only listen
+ * on addresses that the titan server needs to reach.
+ *
+ * Both password and public key authentication are supported. Authorized keys are read from
+ * home/<user>/.ssh/authorized_keys under the root on each attempt, just as sshd does, so they can be installed either
+ * through AuthorizeKey() or by writing the file directly.
+ */
+type SshServer struct {
+	User     string
+	Password string
+	Root     string
+
+	lock      sync.Mutex
+	commands  []*SshCommand
+	stalls    []*SshStall
+	config    *ssh.ServerConfig
+	listeners []net.Listener
+}
+
+/*
+ * Holds the first command matching a pattern before it is run, until released, so that tests can act while the
+ * provider is in the middle of a particular step. The held command then fails with status 255 without being run,
+ * since whatever was waiting on it has usually gone away by then. Later matching commands are run normally.
+ */
+type SshStall struct {
+	server   *SshServer
+	pattern  *regexp.Regexp
+	stalled  bool
+	released chan struct{}
+	once     sync.Once
+}
+
+/*
+ * Commands run by the ssh provider: rsync in server mode to transfer data, simple file operations to manage commit
+ * metadata, and writing metadata from stdin through 'sh -c "cat > file"'. Commands aren't given to a shell, but
+ * arguments still can't contain anything a shell would treat as another command, redirection, escape or expansion
+ * (including '~', braces and globs), so that the paths that are checked are exactly the paths that are used. The
+ * arguments of the simple commands are the second group, and the file written by 'cat' is the third.
+ */
+var sshAllowedCommand = regexp.MustCompile("^(?:(rsync --server|mkdir|cat|ls|rm|test)((?: [^;&|<>$`\\\\\\n~{}*?[]*)?)" +
+	"|sh -c ['\"]?cat > ([^;&|<>$`'\"\\\\\\n~{}*?[]+)['\"]?)$")
+
+/*
+ * Generate a random password for the SSH server, so that it can't be reached with well known credentials while the
+ * tests are running.
+ */
+func NewSshPassword() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func NewSshServer(user string, password string) (*SshServer, error) {
+	root, err := ioutil.TempDir("", "titan-ssh")
+	if err != nil {
+		return nil, err
+	}
+	ret := &SshServer{User: user, Password: password, Root: root}
+	err = os.MkdirAll(ret.homeDir(), 0755)
+	if err != nil {
+		_ = os.RemoveAll(root)
+		return nil, err
+	}
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return nil, err
+	}
+	ret.config = &ssh.ServerConfig{
+		PasswordCallback:  ret.checkPassword,
+		PublicKeyCallback: ret.checkPublicKey,
+	}
+	ret.config.AddHostKey(signer)
+	return ret, nil
+}
+
+func (s *SshServer) homeDir() string {
+	return filepath.Join(s.Root, "home", s.User)
+}
+
+/*
+ * Get the host path corresponding to a path on the remote, such as "/bar" for the remote path of an ssh remote.
+ */
+func (s *SshServer) Path(path string) string {
+	return filepath.Join(s.Root, path)
+}
+
+/*
+ * Start serving on the given addresses. If the first has port 0, a free port is picked and used for all of them.
+ */
+func (s *SshServer) Start(addrs ...string) error {
+	listeners, err := listenTcp(addrs...)
+	if err != nil {
+		return err
+	}
+	s.listeners = listeners
+	for _, l := range listeners {
+		l := l
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go s.serveConnection(conn)
+			}
+		}()
+	}
+	return nil
+}
+
+func (s *SshServer) closeListeners() {
+	for _, l := range s.listeners {
+		_ = l.Close()
+	}
+	s.listeners = nil
+}
+
+/*
+ * Stop accepting connections and remove the root directory. Connections that are still open are closed by their
+ * clients, or when the test process exits.
+ */
+func (s *SshServer) Stop() error {
+	s.closeListeners()
+	return os.RemoveAll(s.Root)
+}
+
+func (s *SshServer) Port() int {
+	return s.listeners[0].Addr().(*net.TCPAddr).Port
+}
+
+/*
+ * Add a key to the user's authorized_keys.
+ */
+func (s *SshServer) AuthorizeKey(key ssh.PublicKey) error {
+	dir := filepath.Join(s.homeDir(), ".ssh")
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "authorized_keys"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(ssh.MarshalAuthorizedKey(key))
+	return err
+}
+
+/*
+ * Get all commands executed so far, in the order in which they were started.
+ */
+func (s *SshServer) Commands() []SshCommand {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ret := make([]SshCommand, len(s.commands))
+	for i, c := range s.commands {
+		ret[i] = *c
+	}
+	return ret
+}
+
+func (s *SshServer) ClearCommands() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.commands = nil
+}
+
+/*
+ * Stall the next command that matches the given regular expression. Commands that aren't allowed are never stalled.
+ */
+func (s *SshServer) Stall(pattern string) *SshStall {
+	stall := &SshStall{server: s, pattern: regexp.MustCompile(pattern), released: make(chan struct{})}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stalls = append(s.stalls, stall)
+	return stall
+}
+
+/*
+ * Whether a command has been held, including one that has since been released.
+ */
+func (st *SshStall) Stalled() bool {
+	st.server.lock.Lock()
+	defer st.server.lock.Unlock()
+	return st.stalled
+}
+
+/*
+ * Fail the held command, or stop waiting for one if none has matched yet.
+ */
+func (st *SshStall) Release() {
+	st.once.Do(func() {
+		st.server.lock.Lock()
+		for i, other := range st.server.stalls {
+			if other == st {
+				st.server.stalls = append(st.server.stalls[:i], st.server.stalls[i+1:]...)
+				break
+			}
+		}
+		st.server.lock.Unlock()
+		close(st.released)
+	})
+}
+
+/*
+ * Find and claim the stall that applies to a command, if any.
+ */
+func (s *SshServer) stall(command string) *SshStall {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, st := range s.stalls {
+		if st.pattern.MatchString(command) {
+			s.stalls = append(s.stalls[:i], s.stalls[i+1:]...)
+			st.stalled = true
+			return st
+		}
+	}
+	return nil
+}
+
+func (s *SshServer) checkPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if conn.User() == s.User && string(password) == s.Password {
+		return nil, nil
+	}
+	return nil, errors.New(fmt.Sprintf("password rejected for %s", conn.User()))
+}
+
+func (s *SshServer) checkPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if conn.User() == s.User {
+		content, err := ioutil.ReadFile(filepath.Join(s.homeDir(), ".ssh", "authorized_keys"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for len(content) != 0 {
+			var authorized ssh.PublicKey
+			authorized, _, _, content, err = ssh.ParseAuthorizedKey(content)
+			if err != nil {
+				break
+			}
+			if bytes.Equal(authorized.Marshal(), key.Marshal()) {
+				return nil, nil
+			}
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("public key rejected for %s", conn.User()))
+}
+
+func (s *SshServer) serveConnection(conn net.Conn) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveSession(serverConn.User(), channel, channelRequests)
+	}
+}
+
+/*
+ * Handle the requests for a single session. Environment variables and pseudo-terminal requests are accepted (the
+ * latter is ignored), and the session ends after the first exec or shell request completes.
+ */
+func (s *SshServer) serveSession(user string, channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	env := []string{}
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			var payload struct{ Name, Value string }
+			if ssh.Unmarshal(req.Payload, &payload) == nil {
+				env = append(env, fmt.Sprintf("%s=%s", payload.Name, payload.Value))
+			}
+			_ = req.Reply(true, nil)
+		case "pty-req":
+			_ = req.Reply(true, nil)
+		case "exec", "shell":
+			var payload struct{ Command string }
+			if req.Type == "exec" && ssh.Unmarshal(req.Payload, &payload) != nil {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			status := s.run(user, payload.Command, env, channel)
+			exitStatus := make([]byte, 4)
+			binary.BigEndian.PutUint32(exitStatus, uint32(status))
+			_, _ = channel.SendRequest("exit-status", false, exitStatus)
+			return
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+/*
+ * Run a command with its standard streams connected to the channel, returning the exit status. Commands that the ssh
+ * provider wouldn't run, including interactive shells, or that reference paths outside of the root, fail with status
+ * 126 without being run. Allowed commands are run from their arguments rather than through a shell, and writing a
+ * file through 'sh -c "cat > file"' is done by the server itself.
+ */
+func (s *SshServer) run(user string, command string, env []string, channel ssh.Channel) int {
+	record := &SshCommand{User: user, Command: command, ExitStatus: -1}
+	s.lock.Lock()
+	s.commands = append(s.commands, record)
+	s.lock.Unlock()
+
+	args, file, err := s.checkCommand(command)
+	if err != nil {
+		_, _ = fmt.Fprintf(channel.Stderr(), "%v\n", err)
+		s.lock.Lock()
+		record.ExitStatus = 126
+		s.lock.Unlock()
+		return record.ExitStatus
+	}
+
+	var status int
+	if stall := s.stall(command); stall != nil {
+		<-stall.released
+		_, _ = fmt.Fprintf(channel.Stderr(), "command stalled: %s\n", command)
+		status = 255
+	} else if file != "" {
+		status = s.writeFile(file, channel)
+	} else {
+		status = s.exec(user, args, env, channel)
+	}
+
+	s.lock.Lock()
+	record.ExitStatus = status
+	s.lock.Unlock()
+	return status
+}
+
+/*
+ * Write standard input to a file, as 'cat > file' would.
+ */
+func (s *SshServer) writeFile(path string, channel ssh.Channel) int {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.Root, path)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		_, _ = fmt.Fprintf(channel.Stderr(), "%v\n", err)
+		return 1
+	}
+	_, err = io.Copy(f, channel)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(channel.Stderr(), "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func (s *SshServer) exec(user string, args []string, env []string, channel ssh.Channel) int {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = s.Root
+	cmd.Env = append(append(os.Environ(), fmt.Sprintf("HOME=%s", s.homeDir()), fmt.Sprintf("USER=%s", user)), env...)
+	cmd.Stdout = channel
+	cmd.Stderr = channel.Stderr()
+
+	/*
+	 * Copy stdin ourselves rather than through exec, which would wait for the client to close its side of the channel
+	 * before reporting that the command completed.
+	 */
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return -1
+	}
+	err = cmd.Start()
+	if err != nil {
+		_, _ = fmt.Fprintf(channel.Stderr(), "%v\n", err)
+		return -1
+	}
+	go func() {
+		_, _ = io.Copy(stdin, channel)
+		_ = stdin.Close()
+	}()
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		return 255
+	}
+	return 0
+}
+
+/*
+ * Check that a command is one the ssh provider runs, and that every path it references resolves within the root.
+ * Anything that isn't an option is treated as a path, as is the value of any option given as --name=value. Returns
+ * the arguments to run the command with, after removing quotes as the shell would, or for 'sh -c "cat > file"' the
+ * file to write instead.
+ */
+func (s *SshServer) checkCommand(command string) ([]string, string, error) {
+	match := sshAllowedCommand.FindStringSubmatch(command)
+	if match == nil {
+		return nil, "", errors.New(fmt.Sprintf("command not allowed: %s", command))
+	}
+	if match[3] != "" {
+		if !s.contains(match[3]) {
+			return nil, "", errors.New(fmt.Sprintf("command not allowed: %s is outside of %s", match[3], s.Root))
+		}
+		return nil, match[3], nil
+	}
+	args, err := splitCommand(match[2])
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("command not allowed: %v", err))
+	}
+	for _, arg := range args {
+		path := arg
+		if strings.HasPrefix(arg, "-") {
+			i := strings.Index(arg, "=")
+			if i == -1 {
+				continue
+			}
+			path = arg[i+1:]
+		}
+		if !s.contains(path) {
+			return nil, "", errors.New(fmt.Sprintf("command not allowed: %s is outside of %s", arg, s.Root))
+		}
+	}
+	return append(strings.Fields(match[1]), args...), "", nil
+}
+
+/*
+ * Split arguments on whitespace, removing single and double quotes as the shell would. Escapes and expansions are
+ * rejected by sshAllowedCommand before this, so there is nothing else to interpret.
+ */
+func splitCommand(command string) ([]string, error) {
+	args := []string{}
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	for _, c := range command {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New(fmt.Sprintf("unterminated quote in %s", command))
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+/*
+ * Whether a path resolves within the root. Relative paths are relative to the root, which is where commands are run.
+ */
+func (s *SshServer) contains(path string) bool {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.Root, path)
+	}
+	return resolvesWithin(s.Root, path)
+}
+
+/*
+ * Whether an absolute path resolves within a directory, following any symlinks in the part of it that already exists.
+ */
+func resolvesWithin(dir string, path string) bool {
+	resolved, err := resolvePath(filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	root, err := resolvePath(filepath.Clean(dir))
+	if err != nil {
+		return false
+	}
+	return resolved == root || strings.HasPrefix(resolved, root+string(filepath.Separator))
+}
+
+/*
+ * Resolve the symlinks in the longest existing prefix of a path, and append the rest. A dangling symlink can't be
+ * resolved, and is an error rather than being treated as a path that doesn't exist yet.
+ */
+func resolvePath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil || !os.IsNotExist(err) {
+		return resolved, err
+	}
+	if _, lstatErr := os.Lstat(path); lstatErr == nil {
+		return "", err
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return "", err
+	}
+	resolved, err = resolvePath(parent)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, filepath.Base(path)), nil
+}
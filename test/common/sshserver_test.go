@@ -0,0 +1,279 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startSshServer(t *testing.T) *SshServer {
+	server, err := NewSshServer("test", "test")
+	if !assert.NoError(t, err) || !assert.NoError(t, server.Start("127.0.0.1:0")) {
+		t.FailNow()
+	}
+	return server
+}
+
+func runSsh(server *SshServer, auth ssh.AuthMethod, command string, stdin string) (string, error) {
+	return runSshAt(server.Port(), auth, command, stdin)
+}
+
+func runSshAt(port int, auth ssh.AuthMethod, command string, stdin string) (string, error) {
+	client, err := ssh.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Auth:            []ssh.AuthMethod{auth},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	session.Stdin = strings.NewReader(stdin)
+	out, err := session.CombinedOutput(command)
+	return string(out), err
+}
+
+func TestSshServerPassword(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+
+	_, err := runSsh(server, ssh.Password("test"), "mkdir -p bar", "")
+	assert.NoError(t, err)
+	_, err = runSsh(server, ssh.Password("test"), "sh -c 'cat > bar/file'", "hello\n")
+	assert.NoError(t, err)
+	out, err := runSsh(server, ssh.Password("test"), "cat bar/file", "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "hello\n", out)
+		content, _ := ioutil.ReadFile(server.Path("/bar/file"))
+		assert.Equal(t, "hello\n", string(content))
+	}
+
+	_, err = runSsh(server, ssh.Password("wrong"), "ls", "")
+	assert.Error(t, err)
+}
+
+func TestSshServerExitStatusAndStdin(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+
+	out, err := runSsh(server, ssh.Password("test"), "cat", "from stdin")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "from stdin", out)
+	}
+
+	out, err = runSsh(server, ssh.Password("test"), "test -e missing", "")
+	if assert.Error(t, err) {
+		assert.Equal(t, 1, err.(*ssh.ExitError).ExitStatus())
+	}
+
+	assert.Equal(t, []SshCommand{
+		{User: "test", Command: "cat", ExitStatus: 0},
+		{User: "test", Command: "test -e missing", ExitStatus: 1},
+	}, server.Commands())
+	server.ClearCommands()
+	assert.Empty(t, server.Commands())
+}
+
+func TestSshServerPublicKey(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(key)
+	_, err := runSsh(server, ssh.PublicKeys(signer), "ls", "")
+	assert.Error(t, err)
+
+	if assert.NoError(t, server.AuthorizeKey(signer.PublicKey())) {
+		_, err := runSsh(server, ssh.PublicKeys(signer), "sh -c 'cat > home/test/file'", "hello")
+		if assert.NoError(t, err) {
+			content, _ := ioutil.ReadFile(filepath.Join(server.Root, "home", "test", "file"))
+			assert.Equal(t, "hello", string(content))
+		}
+	}
+}
+
+/*
+ * Only commands that the ssh provider runs are allowed, and nothing can be chained onto them through the shell.
+ */
+func TestSshServerRejectsCommands(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+
+	for _, command := range []string{"", "echo hello", "true", "cat file; echo hello", "ls && echo hello",
+		"ls | sh", "cat $(echo hello)", "cat `echo hello`", "rsync -e ssh", "mkdir bar\necho hello",
+		"sh -c 'cat > bar; echo hello'"} {
+		out, err := runSsh(server, ssh.Password("test"), command, "")
+		if assert.Error(t, err, command) {
+			assert.Equal(t, 126, err.(*ssh.ExitError).ExitStatus())
+			assert.Contains(t, out, "command not allowed")
+		}
+	}
+
+	bar := server.Path("/bar")
+	for _, command := range []string{"rsync --server -vlogDtprze.iLsfxC . " + bar + "/id", "mkdir -p '" + bar + "/id'",
+		"cat " + bar + "/id/metadata.json", "ls -1 " + bar, "rm -rf " + bar + "/id",
+		"sh -c cat > " + bar + "/id/metadata.json", "sh -c 'cat > home/test/file'"} {
+		_, _, err := server.checkCommand(command)
+		assert.NoError(t, err, command)
+	}
+}
+
+/*
+ * Commands are run without a shell, but anything a shell would expand is rejected too, since the paths that are checked
+ * must be the paths that are used.
+ */
+func TestSshServerRejectsExpansions(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+
+	for _, command := range []string{"cat ~root/.bashrc", "ls ~root", "rm -rf ~nobody", "cat {/etc/passwd,x}",
+		"ls ~", "rm -rf *", "cat /etc/pass?d", "cat /etc/[p]asswd", "sh -c 'cat > ~/file'",
+		"sh -c 'cat > {/tmp/x,y}'", "sh -c 'cat > *'"} {
+		out, err := runSsh(server, ssh.Password("test"), command, "")
+		if assert.Error(t, err, command) {
+			assert.Equal(t, 126, err.(*ssh.ExitError).ExitStatus(), command)
+			assert.Contains(t, out, "command not allowed", command)
+		}
+	}
+}
+
+/*
+ * Allowed commands are run from their arguments, with quotes removed, rather than through a shell.
+ */
+func TestSshServerRunsArguments(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+
+	_, err := runSsh(server, ssh.Password("test"), "mkdir -p 'with space'", "")
+	if assert.NoError(t, err) {
+		info, err := os.Stat(server.Path("/with space"))
+		if assert.NoError(t, err) {
+			assert.True(t, info.IsDir())
+		}
+	}
+	_, err = runSsh(server, ssh.Password("test"), "sh -c 'cat > with-quote'", "hello")
+	if assert.NoError(t, err) {
+		content, _ := ioutil.ReadFile(server.Path("/with-quote"))
+		assert.Equal(t, "hello", string(content))
+	}
+
+	args, err := splitCommand(" -p 'a b' \"c\"d  e")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"-p", "a b", "cd", "e"}, args)
+	}
+	_, err = splitCommand(" 'a")
+	assert.Error(t, err)
+}
+
+/*
+ * Every path a command is given must resolve within the root, including through symlinks, since commands run on the
+ * host as the user running the tests.
+ */
+func TestSshServerRejectsPaths(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+	_ = os.Symlink("/etc", server.Path("/etc"))
+	_ = os.Symlink("/missing", server.Path("/dangling"))
+
+	for _, command := range []string{"rm -rf /", "cat /etc/shadow", "sh -c 'cat > /etc/cron.d/x'", "ls ..",
+		"cat ../../../etc/shadow", "cat \"/etc\"/shadow", "cat \\/etc/shadow", "rm -rf /*", "cat etc/shadow",
+		"sh -c 'cat > etc/cron.d/x'", "sh -c 'cat > dangling'", "rsync --server -vlogDtprze.iLsfxC . /tmp/id",
+		"rsync --server --log-file=/tmp/log . bar", "mkdir -p " + server.Root + "/../escape"} {
+		out, err := runSsh(server, ssh.Password("test"), command, "")
+		if assert.Error(t, err, command) {
+			assert.Equal(t, 126, err.(*ssh.ExitError).ExitStatus(), command)
+			assert.Contains(t, out, "command not allowed", command)
+		}
+	}
+	for _, c := range server.Commands() {
+		assert.Equal(t, 126, c.ExitStatus, c.Command)
+	}
+}
+
+func TestNewSshPassword(t *testing.T) {
+	first, err := NewSshPassword()
+	if assert.NoError(t, err) {
+		second, _ := NewSshPassword()
+		assert.Len(t, first, 32)
+		assert.NotEqual(t, first, second)
+	}
+}
+
+func TestSshServerAddresses(t *testing.T) {
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	_ = l.Close()
+	server, err := NewSshServer("test", "test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer server.Stop()
+	if assert.NoError(t, server.Start("127.0.0.1:0", "[::1]:0")) {
+		assert.Len(t, server.listeners, 2)
+		assert.Equal(t, server.Port(), server.listeners[1].Addr().(*net.TCPAddr).Port)
+	}
+}
+
+func TestSshServerStall(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+
+	stall := server.Stall("^mkdir ")
+	defer stall.Release()
+	_, err := runSsh(server, ssh.Password("test"), "ls", "")
+	assert.NoError(t, err)
+	assert.False(t, stall.Stalled())
+
+	done := make(chan error)
+	go func() {
+		_, err := runSsh(server, ssh.Password("test"), "mkdir stalled", "")
+		done <- err
+	}()
+	for i := 0; i < 100 && !stall.Stalled(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, stall.Stalled())
+	select {
+	case <-done:
+		t.Fatal("stalled command finished before being released")
+	case <-time.After(50 * time.Millisecond):
+	}
+	stall.Release()
+	err = <-done
+	if assert.Error(t, err) {
+		assert.Equal(t, 255, err.(*ssh.ExitError).ExitStatus())
+	}
+	_, err = os.Stat(server.Path("/stalled"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = runSsh(server, ssh.Password("test"), "mkdir stalled", "")
+	assert.NoError(t, err)
+}
+
+func TestSshServerStallReleasedUnused(t *testing.T) {
+	server := startSshServer(t)
+	defer server.Stop()
+
+	server.Stall("^mkdir ").Release()
+	_, err := runSsh(server, ssh.Password("test"), "mkdir bar", "")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+type StepResult string
+
+const (
+	StepPassed  StepResult = "passed"
+	StepFailed  StepResult = "failed"
+	StepSkipped StepResult = "skipped"
+)
+
+type step struct {
+	name     string
+	requires []string
+	run      func()
+	result   StepResult
+}
+
+/*
+ * Runner for a sequence of dependent steps within a suite. Steps always run in the order in which they are added, but
+ * each one declares the steps it depends on. When a prerequisite fails (or was itself skipped), the dependent step
+ * is skipped with the reason, rather than failing in confusing ways because the state it expects was never created.
+ *
+ * Each step runs as its own subtest, with the suite's assertions pointed at that subtest for the duration of the
+ * step, so step functions can use the suite (and the EndToEndTest helpers) exactly as test methods do.
+ */
+type Steps struct {
+	suite  *suite.Suite
+	steps  []*step
+	byName map[string]*step
+}
+
+func NewSteps(s *suite.Suite) *Steps {
+	return &Steps{suite: s, byName: map[string]*step{}}
+}
+
+func (e *EndToEndTest) Steps() *Steps {
+	return NewSteps(e.Suite)
+}
+
+/*
+ * Add a step with the given prerequisites. Prerequisites must refer to steps that have already been added, which
+ * also guarantees that there are no cycles.
+ */
+func (s *Steps) Add(name string, run func(), requires ...string) *Steps {
+	if _, ok := s.byName[name]; ok {
+		panic(fmt.Sprintf("duplicate step '%s'", name))
+	}
+	for _, r := range requires {
+		if _, ok := s.byName[r]; !ok {
+			panic(fmt.Sprintf("step '%s' requires unknown step '%s'", name, r))
+		}
+	}
+	st := &step{name: name, requires: requires, run: run}
+	s.steps = append(s.steps, st)
+	s.byName[name] = st
+	return s
+}
+
+/*
+ * Run all steps as subtests of the suite's current test, returning true if every step passed.
+ */
+func (s *Steps) Run() bool {
+	parent := s.suite.T()
+	ok := true
+	for _, st := range s.steps {
+		s.runStep(parent, st)
+		if st.result != StepPassed {
+			ok = false
+		}
+	}
+	return ok
+}
+
+func (s *Steps) runStep(parent *testing.T, st *step) {
+	parent.Run(st.name, func(t *testing.T) {
+		blocked := []string{}
+		for _, r := range st.requires {
+			if result := s.byName[r].result; result != StepPassed {
+				blocked = append(blocked, fmt.Sprintf("%s %s", r, result))
+			}
+		}
+		if len(blocked) != 0 {
+			st.result = StepSkipped
+			t.Skipf("prerequisite %s", strings.Join(blocked, ", "))
+		}
+
+		s.suite.SetT(t)
+		defer func() {
+			s.suite.SetT(parent)
+			if t.Failed() {
+				st.result = StepFailed
+			} else if t.Skipped() {
+				st.result = StepSkipped
+			} else {
+				st.result = StepPassed
+			}
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				t.Errorf("step panicked: %v\n%s", r, debug.Stack())
+			}
+		}()
+		st.run()
+	})
+}
+
+/*
+ * Get the result of each step that has been run, keyed by step name.
+ */
+func (s *Steps) Results() map[string]StepResult {
+	ret := map[string]StepResult{}
+	for _, st := range s.steps {
+		if st.result != "" {
+			ret[st.name] = st.result
+		}
+	}
+	return ret
+}
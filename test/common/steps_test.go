@@ -0,0 +1,35 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+func TestStepsSkipDependents(t *testing.T) {
+	s := &suite.Suite{}
+	s.SetT(t)
+	ran := []string{}
+	steps := NewSteps(s).
+		Add("a", func() { ran = append(ran, "a") }).
+		Add("b", func() { s.T().Skip("not supported") }, "a").
+		Add("c", func() { ran = append(ran, "c") }, "b").
+		Add("d", func() {
+			ran = append(ran, "d")
+			s.Equal(s.T().Name(), t.Name()+"/d")
+		}, "a")
+	assert.False(t, steps.Run())
+	assert.Equal(t, []string{"a", "d"}, ran)
+	assert.Equal(t, map[string]StepResult{"a": StepPassed, "b": StepSkipped, "c": StepSkipped, "d": StepPassed},
+		steps.Results())
+	assert.Equal(t, t, s.T())
+}
+
+func TestStepsUnknownPrerequisite(t *testing.T) {
+	assert.Panics(t, func() {
+		NewSteps(&suite.Suite{}).Add("a", func() {}, "b")
+	})
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"fmt"
+	titan "github.com/titan-data/titan-client-go"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * Kinds of calls made by the stress harness.
+ */
+const (
+	StressCommit       = "commit"
+	StressDeleteCommit = "deleteCommit"
+	StressUpdateCommit = "updateCommit"
+	StressGetCommit    = "getCommit"
+	StressListCommits  = "listCommits"
+	StressCheckout     = "checkout"
+	StressActivate     = "activate"
+	StressDeactivate   = "deactivate"
+)
+
+/*
+ * Code recorded for a call whose outcome is unknown, because it failed with something other than an API error (such
+ * as a dropped connection). The call may or may not have taken effect.
+ */
+const StressUnknown = "unknown"
+
+/*
+ * A single call made by one of the stress clients, along with when it started and returned relative to the start of
+ * the run. Tags are the tags written by commit and updateCommit, or read by getCommit, and Commits are the commits
+ * returned by listCommits. Code is the API error code, or empty if the call succeeded.
+ */
+type StressCall struct {
+	Client  int
+	Kind    string
+	Repo    string
+	Commit  string
+	Volume  string
+	Tags    map[string]string
+	Commits []string
+	Code    string
+	Err     string
+	Start   time.Duration
+	End     time.Duration
+}
+
+func (c StressCall) String() string {
+	target := c.Repo
+	switch c.Kind {
+	case StressActivate, StressDeactivate:
+		target += "/" + c.Volume
+	case StressListCommits:
+	default:
+		target += "/" + c.Commit
+	}
+	ret := fmt.Sprintf("client %d [%v-%v] %s %s", c.Client, c.Start, c.End, c.Kind, target)
+	if c.Kind == StressCommit || c.Kind == StressUpdateCommit {
+		ret += " " + formatModelTags(c.Tags)
+	}
+	switch {
+	case c.Code == StressUnknown:
+		ret += fmt.Sprintf(" -> unknown (%s)", c.Err)
+	case c.Code != "":
+		ret += " -> " + c.Code
+	case c.Kind == StressGetCommit:
+		ret += " -> " + formatModelTags(c.Tags)
+	case c.Kind == StressListCommits:
+		ret += fmt.Sprintf(" -> %v", c.Commits)
+	default:
+		ret += " -> success"
+	}
+	return ret
+}
+
+/*
+ * Parameters of a stress run. Each client makes Calls calls, against the given repositories and volumes, using a
+ * small pool of commit ids (s1, s2, ...) so that clients regularly contend for the same commits.
+ */
+type StressConfig struct {
+	Seed    int64
+	Clients int
+	Calls   int
+	Repos   []string
+	Volumes []string
+	Commits int
+}
+
+/*
+ * The system under stress. Setup creates the repositories, each with the given volumes (inactive), and Call makes a
+ * single call, filling in its outcome. It is called from many goroutines at once.
+ */
+type StressSystem interface {
+	Setup(ctx context.Context, config StressConfig) error
+	Call(ctx context.Context, call *StressCall)
+}
+
+/*
+ * Every call made during a stress run, in the order in which they started.
+ */
+type StressHistory struct {
+	Config StressConfig
+	Calls  []StressCall
+}
+
+/*
+ * Run the configured number of clients concurrently against the system, recording every call they make.
+ */
+func RunStress(ctx context.Context, sys StressSystem, config StressConfig) (*StressHistory, error) {
+	err := sys.Setup(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	lock := sync.Mutex{}
+	history := &StressHistory{Config: config}
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < config.Clients; i++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(config.Seed + int64(client)))
+			for n := 0; n < config.Calls; n++ {
+				call := config.generate(r, client, n)
+				call.Start = time.Since(start)
+				sys.Call(ctx, &call)
+				call.End = time.Since(start)
+				lock.Lock()
+				history.Calls = append(history.Calls, call)
+				lock.Unlock()
+			}
+		}(i + 1)
+	}
+	wg.Wait()
+	sort.SliceStable(history.Calls, func(i, j int) bool {
+		return history.Calls[i].Start < history.Calls[j].Start
+	})
+	return history, nil
+}
+
+/*
+ * Generate a random call. Tags written by each call are unique, so that a lost update shows up as a read returning
+ * tags that should have been overwritten.
+ */
+func (config StressConfig) generate(r *rand.Rand, client int, n int) StressCall {
+	kinds := []struct {
+		kind   string
+		weight int
+	}{
+		{StressCommit, 3}, {StressDeleteCommit, 2}, {StressUpdateCommit, 3}, {StressGetCommit, 3},
+		{StressListCommits, 2}, {StressCheckout, 1}, {StressActivate, 3}, {StressDeactivate, 3},
+	}
+	total := 0
+	for _, k := range kinds {
+		total += k.weight
+	}
+	pick := r.Intn(total)
+	call := StressCall{Client: client, Repo: config.Repos[r.Intn(len(config.Repos))]}
+	for _, k := range kinds {
+		if pick < k.weight {
+			call.Kind = k.kind
+			break
+		}
+		pick -= k.weight
+	}
+	switch call.Kind {
+	case StressActivate, StressDeactivate:
+		call.Volume = config.Volumes[r.Intn(len(config.Volumes))]
+	case StressListCommits:
+	default:
+		call.Commit = fmt.Sprintf("s%d", r.Intn(config.Commits)+1)
+	}
+	if call.Kind == StressCommit || call.Kind == StressUpdateCommit {
+		call.Tags = map[string]string{"writer": fmt.Sprintf("%d-%d", client, n)}
+	}
+	return call
+}
+
+/*
+ * Stress system backed by the titan server. Repositories and volumes are registered for cleanup.
+ */
+type apiStressSystem struct {
+	e *EndToEndTest
+}
+
+func (e *EndToEndTest) StressSystem() StressSystem {
+	return &apiStressSystem{e: e}
+}
+
+func (s *apiStressSystem) Setup(ctx context.Context, config StressConfig) error {
+	for _, repo := range config.Repos {
+		_, err := s.e.CreateRepository(ctx, titan.Repository{Name: repo, Properties: map[string]interface{}{}})
+		if err != nil {
+			return err
+		}
+		for _, v := range config.Volumes {
+			_, err = s.e.CreateVolume(ctx, repo, titan.Volume{Name: v,
+				Properties: map[string]interface{}{}})
+			if err != nil {
+				return err
+			}
+			err = s.e.WaitForVolume(ctx, repo, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *apiStressSystem) Call(ctx context.Context, call *StressCall) {
+	var err error
+	switch call.Kind {
+	case StressCommit:
+		_, _, err = s.e.CommitApi.CreateCommit(ctx, call.Repo, stressCommit(call))
+	case StressDeleteCommit:
+		_, err = s.e.CommitApi.DeleteCommit(ctx, call.Repo, call.Commit)
+	case StressUpdateCommit:
+		_, _, err = s.e.CommitApi.UpdateCommit(ctx, call.Repo, call.Commit, stressCommit(call))
+	case StressGetCommit:
+		commit, _, getErr := s.e.CommitApi.GetCommit(ctx, call.Repo, call.Commit)
+		err = getErr
+		call.Tags = modelTags(commit.Properties["tags"])
+	case StressListCommits:
+		commits, _, listErr := s.e.CommitApi.ListCommits(ctx, call.Repo, nil)
+		err = listErr
+		call.Commits = []string{}
+		for _, c := range commits {
+			call.Commits = append(call.Commits, c.Id)
+		}
+	case StressCheckout:
+		_, err = s.e.CommitApi.CheckoutCommit(ctx, call.Repo, call.Commit)
+	case StressActivate:
+		_, err = s.e.VolumeApi.ActivateVolume(ctx, call.Repo, call.Volume)
+	case StressDeactivate:
+		_, err = s.e.VolumeApi.DeactivateVolume(ctx, call.Repo, call.Volume)
+	default:
+		panic(fmt.Sprintf("unknown stress call '%s'", call.Kind))
+	}
+	if err != nil {
+		call.Code = apiErrorCode(err)
+		if call.Code == "" {
+			call.Code = StressUnknown
+			call.Err = err.Error()
+		}
+	}
+}
+
+func stressCommit(call *StressCall) titan.Commit {
+	return titan.Commit{Id: call.Commit, Properties: map[string]interface{}{"tags": modelProperties(call.Tags)}}
+}
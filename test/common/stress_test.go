@@ -0,0 +1,186 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+ * In-memory stress system. Every call holds the lock for its whole duration, unless racyCommits is set, in which case
+ * commit checks for an existing commit and creates it in separate critical sections, the way a check-then-insert
+ * without a unique index would.
+ */
+type memoryStressSystem struct {
+	lock        sync.Mutex
+	repos       map[string]*stressState
+	racyCommits bool
+}
+
+func (s *memoryStressSystem) Setup(ctx context.Context, config StressConfig) error {
+	s.repos = map[string]*stressState{}
+	for _, repo := range config.Repos {
+		state := newStressState(config.Volumes)
+		s.repos[repo] = &state
+	}
+	return nil
+}
+
+func (s *memoryStressSystem) Call(ctx context.Context, call *StressCall) {
+	s.lock.Lock()
+	repo := s.repos[call.Repo]
+	_, exists := repo.commits[call.Commit]
+	tags := formatModelTags(call.Tags)
+	if call.Kind == StressCommit && s.racyCommits {
+		s.lock.Unlock()
+		time.Sleep(time.Millisecond)
+		s.lock.Lock()
+	}
+	defer s.lock.Unlock()
+	switch call.Kind {
+	case StressCommit:
+		if exists {
+			call.Code = modelObjectExists
+		} else {
+			if _, inserted := repo.commits[call.Commit]; inserted {
+				repo.commits[call.Commit+"-dup"] = tags
+			} else {
+				repo.commits[call.Commit] = tags
+			}
+		}
+	case StressDeleteCommit, StressUpdateCommit, StressGetCommit, StressCheckout:
+		if !exists {
+			call.Code = modelNoSuchObject
+		} else if call.Kind == StressDeleteCommit {
+			delete(repo.commits, call.Commit)
+			delete(repo.commits, call.Commit+"-dup")
+		} else if call.Kind == StressUpdateCommit {
+			repo.commits[call.Commit] = tags
+		} else if call.Kind == StressGetCommit {
+			call.Tags = map[string]string{}
+			if value := strings.Trim(repo.commits[call.Commit], "{}"); value != "" {
+				kv := strings.SplitN(value, "=", 2)
+				call.Tags[kv[0]] = kv[1]
+			}
+		} else {
+			*repo = repo.withNewVolumeSet()
+		}
+	case StressListCommits:
+		call.Commits = []string{}
+		for id := range repo.commits {
+			call.Commits = append(call.Commits, strings.TrimSuffix(id, "-dup"))
+		}
+	case StressActivate:
+		if repo.active[call.Volume] {
+			call.Code = "CommandException"
+		}
+		repo.active[call.Volume] = true
+	case StressDeactivate:
+		repo.active[call.Volume] = false
+	}
+}
+
+var stressTestConfig = StressConfig{
+	Seed:    1,
+	Clients: 4,
+	Calls:   25,
+	Repos:   []string{"foo", "bar"},
+	Volumes: []string{"v1", "v2"},
+	Commits: 3,
+}
+
+func TestStressLinearizable(t *testing.T) {
+	history, err := RunStress(context.Background(), &memoryStressSystem{}, stressTestConfig)
+	if assert.NoError(t, err) {
+		assert.Len(t, history.Calls, 100)
+		assert.Empty(t, CheckStressHistory(history))
+	}
+}
+
+/*
+ * Duplicate commits should be caught, both from listing them and from later calls that can't be explained.
+ */
+func TestStressDuplicateCommits(t *testing.T) {
+	config := stressTestConfig
+	config.Commits = 1
+	history, err := RunStress(context.Background(), &memoryStressSystem{racyCommits: true}, config)
+	if assert.NoError(t, err) {
+		problems := strings.Join(CheckStressHistory(history), "\n")
+		assert.Contains(t, problems, "duplicate commit")
+	}
+}
+
+func stressCall(client int, start int, end int, kind string, target string, tags map[string]string,
+	code string) StressCall {
+	call := StressCall{Client: client, Kind: kind, Repo: "foo", Tags: tags, Code: code,
+		Start: time.Duration(start) * time.Millisecond, End: time.Duration(end) * time.Millisecond}
+	if kind == StressActivate || kind == StressDeactivate {
+		call.Volume = target
+	} else {
+		call.Commit = target
+	}
+	return call
+}
+
+func checkStressCalls(calls ...StressCall) []string {
+	return CheckStressHistory(&StressHistory{
+		Config: StressConfig{Repos: []string{"foo"}, Volumes: []string{"v1"}},
+		Calls:  calls,
+	})
+}
+
+func TestStressLostUpdate(t *testing.T) {
+	create := stressCall(1, 0, 1, StressCommit, "s1", map[string]string{"writer": "1"}, "")
+	first := stressCall(1, 2, 3, StressUpdateCommit, "s1", map[string]string{"writer": "2"}, "")
+	second := stressCall(2, 4, 5, StressUpdateCommit, "s1", map[string]string{"writer": "3"}, "")
+
+	read := stressCall(1, 6, 7, StressGetCommit, "s1", map[string]string{"writer": "3"}, "")
+	assert.Empty(t, checkStressCalls(create, first, second, read))
+
+	read.Tags = map[string]string{"writer": "2"}
+	problems := checkStressCalls(create, first, second, read)
+	if assert.Len(t, problems, 1) {
+		assert.Contains(t, problems[0], "possible lost tag updates")
+		assert.Contains(t, problems[0], "after 3 of 4 calls")
+	}
+
+	// If the updates overlap, either can win
+	second.Start = 2 * time.Millisecond
+	assert.Empty(t, checkStressCalls(create, first, second, read))
+}
+
+func TestStressVolumeRace(t *testing.T) {
+	first := stressCall(1, 0, 1, StressActivate, "v1", nil, "")
+	second := stressCall(2, 2, 3, StressActivate, "v1", nil, "")
+	problems := checkStressCalls(first, second)
+	if assert.Len(t, problems, 1) {
+		assert.Contains(t, problems[0], "possible volume state races")
+	}
+
+	second.Code = "CommandException"
+	assert.Empty(t, checkStressCalls(first, second))
+
+	deactivate := stressCall(3, 0, 5, StressDeactivate, "v1", nil, "")
+	second.Code = ""
+	assert.Empty(t, checkStressCalls(first, deactivate, second))
+}
+
+/*
+ * A call with an unknown outcome may or may not have taken effect, and may do so at any point after it started.
+ */
+func TestStressUnknownOutcome(t *testing.T) {
+	create := stressCall(1, 0, 1, StressCommit, "s1", map[string]string{"writer": "1"}, StressUnknown)
+	missing := stressCall(2, 2, 3, StressGetCommit, "s1", nil, modelNoSuchObject)
+	found := stressCall(2, 4, 5, StressGetCommit, "s1", map[string]string{"writer": "1"}, "")
+	assert.Empty(t, checkStressCalls(create, missing))
+	assert.Empty(t, checkStressCalls(create, found))
+	assert.Empty(t, checkStressCalls(create, missing, found))
+	assert.NotEmpty(t, checkStressCalls(create, found, stressCall(2, 6, 7, StressGetCommit, "s1", nil,
+		modelNoSuchObject)))
+}
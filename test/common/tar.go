@@ -0,0 +1,250 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+ * Write a tar archive containing a single regular file. Any intermediate directories in the name are created by tar
+ * on extraction.
+ */
+func writeTarFile(w io.Writer, name string, content []byte, mode int64) error {
+	tw := tar.NewWriter(w)
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(content)),
+		Mode:     mode,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(content)
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+/*
+ * Read the contents of the first regular file in a tar archive.
+ */
+func readTarFile(r io.Reader) ([]byte, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("no file found in archive")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+/*
+ * Write a tar archive of the contents of a local directory, with all names relative to that directory. Directories,
+ * regular files, and symlinks are preserved, along with their modes and ownership.
+ */
+func tarDirectory(src string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(file)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+/*
+ * Extract a tar archive into a local directory. Only directories, regular files, symlinks, and hard links are
+ * supported. Any entry that would escape the destination directory, whether through its name or through a symlink
+ * extracted before it, is rejected, and an existing symlink at the target is replaced rather than followed. The
+ * modification times of everything but symlinks are restored, as is ownership when running as root.
+ */
+func untarDirectory(r io.Reader, dst string) error {
+	tr := tar.NewReader(r)
+	dirs := []*tar.Header{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := tarTarget(dst, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == dst {
+			continue
+		}
+		err = tarCheckTarget(dst, hdr.Name, target)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			err = os.Remove(target)
+			if err != nil {
+				return err
+			}
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, mode)
+			if err == nil {
+				err = os.Chmod(target, mode)
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			var f *os.File
+			f, err = os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err == nil {
+				_, err = io.Copy(f, tr)
+				closeErr := f.Close()
+				if err == nil {
+					err = closeErr
+				}
+			}
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, target)
+		case tar.TypeLink:
+			var source string
+			source, err = tarTarget(dst, hdr.Linkname)
+			if err == nil {
+				err = tarCheckTarget(dst, hdr.Linkname, source)
+			}
+			if err == nil {
+				err = os.Link(source, target)
+			}
+		default:
+			err = errors.New(fmt.Sprintf("unsupported tar entry type %c for '%s'", hdr.Typeflag, hdr.Name))
+		}
+		if err != nil {
+			return err
+		}
+
+		// Extracting entries within a directory changes its modification time, so restore those last
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+			continue
+		}
+		err = tarRestore(target, hdr)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target, _ := tarTarget(dst, dirs[i].Name)
+		err := tarRestore(target, dirs[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * Check that the parent of a target resolves within the destination directory, once any symlinks within it are
+ * followed.
+ */
+func tarCheckTarget(dst string, name string, target string) error {
+	if !resolvesWithin(dst, filepath.Dir(target)) {
+		return errors.New(fmt.Sprintf("illegal path '%s' in archive, resolves outside of %s", name, dst))
+	}
+	return nil
+}
+
+/*
+ * Restore the ownership and modification time of an extracted entry. Ownership can only be changed by root, and
+ * symlinks keep the time at which they were extracted.
+ */
+func tarRestore(target string, hdr *tar.Header) error {
+	if os.Geteuid() == 0 {
+		err := os.Lchown(target, hdr.Uid, hdr.Gid)
+		if err != nil {
+			return err
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+/*
+ * Resolve a name within a tar archive relative to the given directory.
+ */
+func tarTarget(dir string, name string) (string, error) {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", errors.New(fmt.Sprintf("illegal path '%s' in archive", name))
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))), nil
+}
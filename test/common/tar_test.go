@@ -0,0 +1,183 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var binaryContent = []byte("quote \" dollar $HOME newline \n nul \x00 high \xff")
+
+func TestTarDirectoryRoundTrip(t *testing.T) {
+	src, _ := ioutil.TempDir("", "titan-tar-src")
+	defer os.RemoveAll(src)
+	dst, _ := ioutil.TempDir("", "titan-tar-dst")
+	defer os.RemoveAll(dst)
+
+	_ = os.MkdirAll(filepath.Join(src, "a", "b"), 0750)
+	_ = ioutil.WriteFile(filepath.Join(src, "a", "b", "data"), binaryContent, 0600)
+	_ = os.Symlink("a/b/data", filepath.Join(src, "link"))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	_ = os.Chtimes(filepath.Join(src, "a", "b", "data"), mtime, mtime)
+	_ = os.Chtimes(filepath.Join(src, "a"), mtime, mtime)
+	if os.Geteuid() == 0 {
+		_ = os.Lchown(filepath.Join(src, "a", "b", "data"), 1234, 5678)
+	}
+
+	var buf bytes.Buffer
+	if assert.NoError(t, tarDirectory(src, &buf)) && assert.NoError(t, untarDirectory(&buf, dst)) {
+		content, err := ioutil.ReadFile(filepath.Join(dst, "a", "b", "data"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, binaryContent, content)
+		}
+		info, err := os.Stat(filepath.Join(dst, "a", "b", "data"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+			assert.True(t, mtime.Equal(info.ModTime()), "mtime %v", info.ModTime())
+			if os.Geteuid() == 0 {
+				assert.Equal(t, uint32(1234), info.Sys().(*syscall.Stat_t).Uid)
+				assert.Equal(t, uint32(5678), info.Sys().(*syscall.Stat_t).Gid)
+			}
+		}
+		info, err = os.Stat(filepath.Join(dst, "a"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+			assert.True(t, mtime.Equal(info.ModTime()), "mtime %v", info.ModTime())
+		}
+		link, err := os.Readlink(filepath.Join(dst, "link"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, "a/b/data", link)
+		}
+	}
+}
+
+func TestWriteTarFileModTime(t *testing.T) {
+	before := time.Now().Add(-time.Second)
+	var buf bytes.Buffer
+	if assert.NoError(t, writeTarFile(&buf, "file", []byte("data"), 0644)) {
+		header, err := tar.NewReader(&buf).Next()
+		if assert.NoError(t, err) {
+			assert.True(t, header.ModTime.After(before), "mtime %v is not recent", header.ModTime)
+		}
+	}
+}
+
+func TestUntarRejectsEscape(t *testing.T) {
+	dst, _ := ioutil.TempDir("", "titan-tar-dst")
+	defer os.RemoveAll(dst)
+
+	var buf bytes.Buffer
+	_ = writeTarFile(&buf, "../escape", []byte("bad"), 0644)
+	assert.Error(t, untarDirectory(&buf, dst))
+}
+
+/*
+ * Symlinks extracted from an archive can't be used to write outside of the destination, either by extracting
+ * beneath them or by extracting over them.
+ */
+func TestUntarRejectsSymlinkEscape(t *testing.T) {
+	outside, _ := ioutil.TempDir("", "titan-tar-outside")
+	defer os.RemoveAll(outside)
+
+	for _, entries := range [][]tar.Header{
+		{{Typeflag: tar.TypeSymlink, Name: "a", Linkname: outside}, {Typeflag: tar.TypeReg, Name: "a/passwd"}},
+		{{Typeflag: tar.TypeSymlink, Name: "a", Linkname: outside}, {Typeflag: tar.TypeDir, Name: "a/dir/"}},
+		{{Typeflag: tar.TypeSymlink, Name: "a", Linkname: "../" + filepath.Base(outside)},
+			{Typeflag: tar.TypeReg, Name: "a/passwd"}},
+		{{Typeflag: tar.TypeSymlink, Name: "a", Linkname: outside}, {Typeflag: tar.TypeLink, Name: "b",
+			Linkname: "a/passwd"}},
+	} {
+		dst, _ := ioutil.TempDir("", "titan-tar-dst")
+		assert.Error(t, untarDirectory(tarEntries(entries), dst), entries[1].Name)
+		_ = os.RemoveAll(dst)
+	}
+	files, _ := ioutil.ReadDir(outside)
+	assert.Empty(t, files)
+
+	target := filepath.Join(outside, "passwd")
+	_ = ioutil.WriteFile(target, []byte("root"), 0644)
+	dst, _ := ioutil.TempDir("", "titan-tar-dst")
+	defer os.RemoveAll(dst)
+	err := untarDirectory(tarEntries([]tar.Header{{Typeflag: tar.TypeSymlink, Name: "a", Linkname: target},
+		{Typeflag: tar.TypeReg, Name: "a", Mode: 0644}}), dst)
+	if assert.NoError(t, err) {
+		content, _ := ioutil.ReadFile(target)
+		assert.Equal(t, "root", string(content))
+		info, err := os.Lstat(filepath.Join(dst, "a"))
+		if assert.NoError(t, err) {
+			assert.True(t, info.Mode().IsRegular())
+		}
+	}
+}
+
+func tarEntries(entries []tar.Header) io.Reader {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := range entries {
+		_ = tw.WriteHeader(&entries[i])
+	}
+	_ = tw.Close()
+	return &buf
+}
+
+func TestWriteFileBytesSsh(t *testing.T) {
+	e, _ := newRecordingTest("docker-zfs")
+	if !assert.NoError(t, e.StartSsh()) {
+		return
+	}
+	defer e.StopSsh()
+
+	err := e.WriteFileBytesSsh("/home/test/.ssh/authorized_keys", binaryContent)
+	if assert.NoError(t, err) {
+		content, err := ioutil.ReadFile(filepath.Join(e.Ssh.Root, "home", "test", ".ssh", "authorized_keys"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, binaryContent, content)
+		}
+	}
+}
+
+func TestReadFileBytesSsh(t *testing.T) {
+	e, _ := newRecordingTest("docker-zfs")
+	if !assert.NoError(t, e.StartSsh()) {
+		return
+	}
+	defer e.StopSsh()
+
+	_ = os.MkdirAll(e.SshPath("/bar/id/data/vol"), 0755)
+	_ = ioutil.WriteFile(e.SshPath("/bar/id/data/vol/testfile"), binaryContent, 0644)
+	content, err := e.ReadFileBytesSsh("/bar/id/data/vol/testfile")
+	if assert.NoError(t, err) {
+		assert.Equal(t, binaryContent, content)
+	}
+}
+
+func TestCopyTreeSsh(t *testing.T) {
+	e, _ := newRecordingTest("docker-zfs")
+	if !assert.NoError(t, e.StartSsh()) {
+		return
+	}
+	defer e.StopSsh()
+	src, _ := ioutil.TempDir("", "titan-tar-src")
+	defer os.RemoveAll(src)
+	dst, _ := ioutil.TempDir("", "titan-tar-dst")
+	defer os.RemoveAll(dst)
+
+	_ = os.MkdirAll(filepath.Join(src, "a"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(src, "a", "data"), binaryContent, 0600)
+	if assert.NoError(t, e.CopyTreeInSsh("/bar", src)) && assert.NoError(t, e.CopyTreeOutSsh("/bar", dst)) {
+		content, err := ioutil.ReadFile(filepath.Join(dst, "a", "data"))
+		if assert.NoError(t, err) {
+			assert.Equal(t, binaryContent, content)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+/*
+ * Stream a tar archive produced by the given function into 'tar -x' within the container. All file transfer into and
+ * out of containers goes through tar in this way, so arbitrary content (quotes, newlines, binary data) and whole
+ * directory trees survive intact. This works against any container that has tar installed.
+ */
+func (e *EndToEndTest) copyIn(container string, dir string, archive func(w io.Writer) error) error {
+	r, w := io.Pipe()
+	go func() {
+		_ = w.CloseWithError(archive(w))
+	}()
+	err := e.Runtime.ExecStream(container, r, nil, "tar", "-x", "-f", "-", "-C", dir)
+	_ = r.Close()
+	return err
+}
+
+/*
+ * Run 'tar -c' for the given paths within the container, passing the resulting archive to the given function.
+ */
+func (e *EndToEndTest) copyOut(container string, dir string, extract func(r io.Reader) error, paths ...string) error {
+	r, w := io.Pipe()
+	result := make(chan error, 1)
+	go func() {
+		err := extract(r)
+		// Drain anything left over so that tar doesn't block writing to the pipe
+		_, _ = io.Copy(ioutil.Discard, r)
+		result <- err
+	}()
+	args := append([]string{"tar", "-c", "-f", "-", "-C", dir}, paths...)
+	err := e.Runtime.ExecStream(container, nil, w, args...)
+	_ = w.CloseWithError(err)
+	extractErr := <-result
+	if err != nil {
+		return err
+	}
+	return extractErr
+}
+
+func (e *EndToEndTest) writeFileBytes(container string, dir string, filename string, content []byte) error {
+	return e.copyIn(container, dir, func(w io.Writer) error {
+		return writeTarFile(w, filename, content, 0644)
+	})
+}
+
+func (e *EndToEndTest) readFileBytes(container string, dir string, filename string) ([]byte, error) {
+	var content []byte
+	err := e.copyOut(container, dir, func(r io.Reader) error {
+		var err error
+		content, err = readTarFile(r)
+		return err
+	}, filename)
+	return content, err
+}
+
+func (e *EndToEndTest) copyTreeIn(container string, dir string, src string) error {
+	return e.copyIn(container, dir, func(w io.Writer) error {
+		return tarDirectory(src, w)
+	})
+}
+
+func (e *EndToEndTest) copyTreeOut(container string, dir string, dst string) error {
+	return e.copyOut(container, dir, func(r io.Reader) error {
+		return untarDirectory(r, dst)
+	}, ".")
+}
+
+/*
+ * Write arbitrary content to a file, relative to a particular volume.
+ */
+func (e *EndToEndTest) WriteFileBytes(repo string, volume string, filename string, content []byte) error {
+	mountpoint, err := e.GetVolumePath(repo, volume)
+	if err != nil {
+		return err
+	}
+	return e.writeFileBytes(e.GetContainer("server"), mountpoint, filename, content)
+}
+
+/*
+ * Read the exact contents of a file, relative to a particular volume.
+ */
+func (e *EndToEndTest) ReadFileBytes(repo string, volume string, filename string) ([]byte, error) {
+	mountpoint, err := e.GetVolumePath(repo, volume)
+	if err != nil {
+		return nil, err
+	}
+	return e.readFileBytes(e.GetContainer("server"), mountpoint, filename)
+}
+
+/*
+ * Copy the contents of a local directory into the root of a volume, such as to seed it with real database files.
+ */
+func (e *EndToEndTest) CopyTreeIn(repo string, volume string, src string) error {
+	mountpoint, err := e.GetVolumePath(repo, volume)
+	if err != nil {
+		return err
+	}
+	return e.copyTreeIn(e.GetContainer("server"), mountpoint, src)
+}
+
+/*
+ * Copy the complete contents of a volume into a local directory.
+ */
+func (e *EndToEndTest) CopyTreeOut(repo string, volume string, dst string) error {
+	mountpoint, err := e.GetVolumePath(repo, volume)
+	if err != nil {
+		return err
+	}
+	return e.copyTreeOut(e.GetContainer("server"), mountpoint, dst)
+}
+
+/*
+ * Write arbitrary content to an absolute path on the SSH server. The SSH server runs in-process, so this (and the
+ * other SSH transfer functions) operate directly on the local filesystem beneath its root.
+ */
+func (e *EndToEndTest) WriteFileBytesSsh(filepath string, content []byte) error {
+	err := os.MkdirAll(path.Dir(e.SshPath(filepath)), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(e.SshPath(filepath), content, 0644)
+}
+
+/*
+ * Read the exact contents of an absolute path on the SSH server.
+ */
+func (e *EndToEndTest) ReadFileBytesSsh(filepath string) ([]byte, error) {
+	return ioutil.ReadFile(e.SshPath(filepath))
+}
+
+/*
+ * Copy the contents of a local directory into a directory on the SSH server.
+ */
+func (e *EndToEndTest) CopyTreeInSsh(dir string, src string) error {
+	return copyTree(src, e.SshPath(dir))
+}
+
+/*
+ * Copy the contents of a directory on the SSH server into a local directory.
+ */
+func (e *EndToEndTest) CopyTreeOutSsh(dir string, dst string) error {
+	return copyTree(e.SshPath(dir), dst)
+}
+
+/*
+ * Copy a local directory tree by piping it through tar, so that it gets exactly the same treatment (permissions,
+ * symlinks) as trees copied into and out of containers.
+ */
+func copyTree(src string, dst string) error {
+	r, w := io.Pipe()
+	go func() {
+		_ = w.CloseWithError(tarDirectory(src, w))
+	}()
+	err := untarDirectory(r, dst)
+	_ = r.CloseWithError(err)
+	return err
+}
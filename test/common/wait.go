@@ -0,0 +1,82 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+/*
+ * Default deadlines applied by the Wait* helpers when the caller's context doesn't have one of its own. Server and
+ * SSH startup should be quick, while operations and asynchronous volume or commit creation can take much longer.
+ */
+const serverWaitTimeout = 60 * time.Second
+const defaultWaitTimeout = 10 * time.Minute
+const waitInterval = 1 * time.Second
+
+/*
+ * Error returned when a Wait* helper gives up, either because its deadline expired or the context was cancelled. The
+ * last observed state (such as a VolumeStatus, CommitStatus, or ProgressEntry) is preserved so that the failure
+ * explains what the server was doing at the time.
+ */
+type WaitTimeoutError struct {
+	Object    string
+	LastState interface{}
+	Details   string
+	Err       error
+}
+
+func (w *WaitTimeoutError) Error() string {
+	msg := fmt.Sprintf("timed out waiting for %s: %v (last state: %+v)", w.Object, w.Err, w.LastState)
+	if w.Details != "" {
+		msg = fmt.Sprintf("%s\n%s", msg, w.Details)
+	}
+	return msg
+}
+
+func (w *WaitTimeoutError) Unwrap() error {
+	return w.Err
+}
+
+/*
+ * Apply the given timeout to the context if the caller hasn't specified a deadline.
+ */
+func withDefaultDeadline(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+/*
+ * Generic polling loop, also available to suites with their own Wait* helpers. The check function is invoked until
+ * it reports that it is done or returns an error. Any non-nil state it returns is remembered, and reported as part of
+ * the WaitTimeoutError if the context expires first.
+ */
+func Poll(ctx context.Context, object string, interval time.Duration,
+	check func(ctx context.Context) (bool, interface{}, error)) error {
+	var lastState interface{}
+	for {
+		done, state, err := check(ctx)
+		if state != nil {
+			lastState = state
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return &WaitTimeoutError{Object: object, LastState: lastState, Err: ctx.Err()}
+			}
+			return err
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return &WaitTimeoutError{Object: object, LastState: lastState, Err: ctx.Err()}
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPollDone(t *testing.T) {
+	calls := 0
+	err := Poll(context.Background(), "thing", time.Millisecond, func(ctx context.Context) (bool, interface{}, error) {
+		calls++
+		return calls == 3, calls, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+/*
+ * A timeout reports the last non-nil state seen, even if later checks returned none.
+ */
+func TestPollTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls := 0
+	err := Poll(ctx, "volume vol0", 5*time.Millisecond, func(ctx context.Context) (bool, interface{}, error) {
+		calls++
+		if calls == 1 {
+			return false, "creating", nil
+		}
+		return false, nil, nil
+	})
+	var waitErr *WaitTimeoutError
+	if assert.True(t, errors.As(err, &waitErr)) {
+		assert.Equal(t, "volume vol0", waitErr.Object)
+		assert.Equal(t, "creating", waitErr.LastState)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.Equal(t, "timed out waiting for volume vol0: context deadline exceeded (last state: creating)",
+			err.Error())
+	}
+	assert.Greater(t, calls, 1)
+}
+
+func TestPollError(t *testing.T) {
+	failure := errors.New("failed")
+	calls := 0
+	err := Poll(context.Background(), "thing", time.Hour, func(ctx context.Context) (bool, interface{}, error) {
+		calls++
+		return false, "state", failure
+	})
+	assert.Equal(t, failure, err)
+	assert.Equal(t, 1, calls)
+}
+
+/*
+ * An error from a check that ran into the deadline is reported as a timeout, with the last state.
+ */
+func TestPollErrorAfterTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := Poll(ctx, "thing", time.Millisecond, func(ctx context.Context) (bool, interface{}, error) {
+		<-ctx.Done()
+		return false, "stuck", ctx.Err()
+	})
+	var waitErr *WaitTimeoutError
+	if assert.True(t, errors.As(err, &waitErr)) {
+		assert.Equal(t, "stuck", waitErr.LastState)
+	}
+}
+
+func TestWaitTimeoutErrorDetails(t *testing.T) {
+	err := &WaitTimeoutError{Object: "operation op", LastState: 3, Details: "server logs", Err: context.Canceled}
+	assert.Equal(t, "timed out waiting for operation op: context canceled (last state: 3)\nserver logs", err.Error())
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestWithDefaultDeadline(t *testing.T) {
+	ctx, cancel := withDefaultDeadline(context.Background(), time.Minute)
+	deadline, ok := ctx.Deadline()
+	cancel()
+	if assert.True(t, ok) {
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+	}
+
+	parent, parentCancel := context.WithTimeout(context.Background(), time.Second)
+	defer parentCancel()
+	expected, _ := parent.Deadline()
+	ctx, cancel = withDefaultDeadline(parent, time.Minute)
+	defer cancel()
+	deadline, _ = ctx.Deadline()
+	assert.Equal(t, expected, deadline)
+}
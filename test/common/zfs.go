@@ -0,0 +1,279 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Properties requested from "zfs list", in the order they appear in each line of output.
+ */
+const zfsListProperties = "name,type,used,referenced,origin,mountpoint,mounted"
+
+/*
+ * A filesystem or snapshot within the server's ZFS pool. Sizes are in bytes. Origin is only set for clones, and is the
+ * snapshot they were cloned from. Volumes are mounted by the server rather than ZFS, so their mountpoint is "legacy",
+ * and Mounted is only meaningful for filesystems.
+ */
+type ZfsDataset struct {
+	Name       string
+	Type       string
+	Used       int64
+	Referenced int64
+	Origin     string
+	Mountpoint string
+	Mounted    bool
+}
+
+func (d ZfsDataset) IsSnapshot() bool {
+	return d.Type == "snapshot"
+}
+
+/*
+ * For a snapshot, the filesystem it is a snapshot of, otherwise the parent filesystem.
+ */
+func (d ZfsDataset) Parent() string {
+	if d.IsSnapshot() {
+		return strings.SplitN(d.Name, "@", 2)[0]
+	}
+	return path.Dir(d.Name)
+}
+
+/*
+ * Name of a snapshot without the filesystem, which for titan is the commit id.
+ */
+func (d ZfsDataset) SnapshotName() string {
+	parts := strings.SplitN(d.Name, "@", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+/*
+ * Contents of the server's ZFS pool at a point in time. Volume sets are filesystems beneath <pool>/data, volumes are
+ * filesystems within a volume set, and each commit is a recursive snapshot of its volume set, named after the commit.
+ * Checking out a commit clones the snapshot of each volume into a new volume set.
+ */
+type ZfsState struct {
+	Pool     string
+	Datasets []ZfsDataset
+}
+
+/*
+ * Parse the output of "zfs list -H -p -o <zfsListProperties>", where each line is a tab separated list of values and
+ * "-" marks a property that doesn't apply.
+ */
+func ParseZfsList(pool string, output string) (*ZfsState, error) {
+	state := &ZfsState{Pool: pool, Datasets: []ZfsDataset{}}
+	columns := len(strings.Split(zfsListProperties, ","))
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != columns {
+			return nil, errors.New(fmt.Sprintf("invalid zfs list output '%s', expected %d fields", line, columns))
+		}
+		for i := range fields {
+			if fields[i] == "-" {
+				fields[i] = ""
+			}
+		}
+		used, err := parseZfsSize(fields[2])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid used size in zfs list output '%s'", line))
+		}
+		referenced, err := parseZfsSize(fields[3])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid referenced size in zfs list output '%s'", line))
+		}
+		state.Datasets = append(state.Datasets, ZfsDataset{
+			Name:       fields[0],
+			Type:       fields[1],
+			Used:       used,
+			Referenced: referenced,
+			Origin:     fields[4],
+			Mountpoint: fields[5],
+			Mounted:    fields[6] == "yes",
+		})
+	}
+	return state, nil
+}
+
+func parseZfsSize(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+/*
+ * Get the current state of the pool. Only the docker-zfs context stores data in ZFS.
+ */
+func (e *EndToEndTest) GetZfsState() (*ZfsState, error) {
+	if e.Context != "docker-zfs" {
+		return nil, errors.New(fmt.Sprintf("context %s does not use ZFS", e.Context))
+	}
+	output, err := e.ExecServer("zfs", "list", "-H", "-p", "-r", "-t", "all", "-o", zfsListProperties, e.Identity)
+	if err != nil {
+		return nil, err
+	}
+	return ParseZfsList(e.Identity, output)
+}
+
+/*
+ * Wait until the pool satisfies a condition, such as the reaper having destroyed a deleted commit. The check returns
+ * a description of what it is still waiting for, or an empty string once the condition holds.
+ */
+func (e *EndToEndTest) WaitForZfs(ctx context.Context, object string, check func(s *ZfsState) string) error {
+	ctx, cancel := withDefaultDeadline(ctx, defaultWaitTimeout)
+	defer cancel()
+	return Poll(ctx, object, waitInterval, func(ctx context.Context) (bool, interface{}, error) {
+		state, err := e.GetZfsState()
+		if err != nil {
+			return false, nil, err
+		}
+		waiting := check(state)
+		if waiting == "" {
+			return true, nil, nil
+		}
+		return false, waiting, nil
+	})
+}
+
+/*
+ * Wait for the given datasets or snapshots to be destroyed.
+ */
+func (e *EndToEndTest) WaitForZfsDestroyed(ctx context.Context, names ...string) error {
+	return e.WaitForZfs(ctx, fmt.Sprintf("%s to be destroyed", strings.Join(names, ", ")),
+		func(s *ZfsState) string {
+			remaining := []string{}
+			for _, name := range names {
+				if s.Exists(name) {
+					remaining = append(remaining, name)
+				}
+			}
+			if len(remaining) == 0 {
+				return ""
+			}
+			return fmt.Sprintf("still present: %s", strings.Join(remaining, ", "))
+		})
+}
+
+/*
+ * Get the volume set that a volume currently belongs to. This isn't part of the API, but is the parent directory of
+ * the volume's mountpoint.
+ */
+func (e *EndToEndTest) GetVolumeSet(repo string, volume string) (string, error) {
+	mountpoint, err := e.GetVolumePath(repo, volume)
+	if err != nil {
+		return "", err
+	}
+	root := e.Backend.MountPath() + "/"
+	if !strings.HasPrefix(mountpoint, root) {
+		return "", errors.New(fmt.Sprintf("mountpoint %s of volume %s/%s is not within %s", mountpoint, repo, volume,
+			root))
+	}
+	return path.Dir(strings.TrimPrefix(mountpoint, root)), nil
+}
+
+func (s *ZfsState) Get(name string) (ZfsDataset, bool) {
+	for _, d := range s.Datasets {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return ZfsDataset{}, false
+}
+
+func (s *ZfsState) Exists(name string) bool {
+	_, ok := s.Get(name)
+	return ok
+}
+
+/*
+ * Name of the filesystem for a volume set, or for a volume within it if a volume is given.
+ */
+func (s *ZfsState) VolumeSetName(volumeSet string, volume ...string) string {
+	return strings.Join(append([]string{s.Pool, "data", volumeSet}, volume...), "/")
+}
+
+/*
+ * Name of the snapshot of a volume set, or of a volume within it, for a commit.
+ */
+func (s *ZfsState) CommitName(commit string, volumeSet string, volume ...string) string {
+	return fmt.Sprintf("%s@%s", s.VolumeSetName(volumeSet, volume...), commit)
+}
+
+/*
+ * Names of the filesystems directly within the given one, sorted.
+ */
+func (s *ZfsState) Children(name string) []string {
+	ret := []string{}
+	for _, d := range s.Datasets {
+		if !d.IsSnapshot() && d.Parent() == name && d.Name != name {
+			ret = append(ret, path.Base(d.Name))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (s *ZfsState) VolumeSets() []string {
+	return s.Children(fmt.Sprintf("%s/data", s.Pool))
+}
+
+func (s *ZfsState) Volumes(volumeSet string) []string {
+	return s.Children(s.VolumeSetName(volumeSet))
+}
+
+/*
+ * Names of the snapshots of a filesystem, without the filesystem, sorted.
+ */
+func (s *ZfsState) Snapshots(name string) []string {
+	ret := []string{}
+	for _, d := range s.Datasets {
+		if d.IsSnapshot() && d.Parent() == name {
+			ret = append(ret, d.SnapshotName())
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+/*
+ * Full names of every snapshot for a commit, across all volume sets and the volumes within them, sorted.
+ */
+func (s *ZfsState) CommitSnapshots(commit string) []string {
+	ret := []string{}
+	for _, d := range s.Datasets {
+		if d.IsSnapshot() && d.SnapshotName() == commit {
+			ret = append(ret, d.Name)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+/*
+ * Names of the filesystems cloned from a snapshot, sorted.
+ */
+func (s *ZfsState) Clones(snapshot string) []string {
+	ret := []string{}
+	for _, d := range s.Datasets {
+		if d.Origin == snapshot {
+			ret = append(ret, d.Name)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package common
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+/*
+ * Repository with a single volume, committed as "id" in volume set vs1 and then checked out into vs2.
+ */
+var zfsTestOutput = "test\tfilesystem\t2097152\t24576\t-\t/var/lib/test\tyes\n" +
+	"test/data\tfilesystem\t1048576\t24576\t-\tlegacy\tno\n" +
+	"test/data/vs1\tfilesystem\t524288\t24576\t-\tlegacy\tno\n" +
+	"test/data/vs1@id\tsnapshot\t0\t24576\t-\t-\t-\n" +
+	"test/data/vs1/vol\tfilesystem\t262144\t40960\t-\tlegacy\tyes\n" +
+	"test/data/vs1/vol@id\tsnapshot\t8192\t32768\t-\t-\t-\n" +
+	"test/data/vs2\tfilesystem\t49152\t24576\t-\tlegacy\tno\n" +
+	"test/data/vs2/vol\tfilesystem\t8192\t32768\ttest/data/vs1/vol@id\tlegacy\tyes\n" +
+	"test/db\tfilesystem\t98304\t98304\t-\tlegacy\tyes\n"
+
+func TestParseZfsList(t *testing.T) {
+	state, err := ParseZfsList("test", zfsTestOutput)
+	if assert.NoError(t, err) {
+		assert.Len(t, state.Datasets, 9)
+		vol, ok := state.Get("test/data/vs2/vol")
+		if assert.True(t, ok) {
+			assert.Equal(t, ZfsDataset{
+				Name:       "test/data/vs2/vol",
+				Type:       "filesystem",
+				Used:       8192,
+				Referenced: 32768,
+				Origin:     "test/data/vs1/vol@id",
+				Mountpoint: "legacy",
+				Mounted:    true,
+			}, vol)
+		}
+		snapshot, ok := state.Get("test/data/vs1/vol@id")
+		if assert.True(t, ok) {
+			assert.True(t, snapshot.IsSnapshot())
+			assert.Equal(t, "test/data/vs1/vol", snapshot.Parent())
+			assert.Equal(t, "id", snapshot.SnapshotName())
+			assert.Equal(t, "", snapshot.Origin)
+			assert.Equal(t, "", snapshot.Mountpoint)
+			assert.False(t, snapshot.Mounted)
+		}
+		assert.False(t, state.Exists("test/data/vs3"))
+	}
+}
+
+func TestParseZfsListInvalid(t *testing.T) {
+	_, err := ParseZfsList("test", "test/data\tfilesystem\t1024\n")
+	assert.Error(t, err)
+	_, err = ParseZfsList("test", "test/data\tfilesystem\t1.5M\t24576\t-\tlegacy\tno\n")
+	assert.Error(t, err)
+	state, err := ParseZfsList("test", "\n")
+	if assert.NoError(t, err) {
+		assert.Empty(t, state.Datasets)
+	}
+}
+
+func TestZfsStateLayout(t *testing.T) {
+	state, err := ParseZfsList("test", zfsTestOutput)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"vs1", "vs2"}, state.VolumeSets())
+		assert.Equal(t, []string{"vol"}, state.Volumes("vs1"))
+		assert.Equal(t, []string{}, state.Volumes("vs3"))
+		assert.Equal(t, "test/data/vs1/vol", state.VolumeSetName("vs1", "vol"))
+		assert.Equal(t, "test/data/vs1@id", state.CommitName("id", "vs1"))
+		assert.Equal(t, []string{"id"}, state.Snapshots(state.VolumeSetName("vs1")))
+		assert.Equal(t, []string{"id"}, state.Snapshots(state.VolumeSetName("vs1", "vol")))
+		assert.Equal(t, []string{}, state.Snapshots(state.VolumeSetName("vs2", "vol")))
+		assert.Equal(t, []string{"test/data/vs1/vol@id", "test/data/vs1@id"}, state.CommitSnapshots("id"))
+		assert.Equal(t, []string{}, state.CommitSnapshots("id2"))
+		assert.Equal(t, []string{"test/data/vs2/vol"}, state.Clones(state.CommitName("id", "vs1", "vol")))
+		assert.Equal(t, []string{}, state.Clones(state.CommitName("id", "vs1")))
+	}
+}
+
+func TestGetZfsState(t *testing.T) {
+	e, runtime := newRecordingTest("docker-zfs")
+	runtime.Outputs["Exec"] = zfsTestOutput
+	state, err := e.GetZfsState()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "test", state.Pool)
+		assert.Len(t, state.Datasets, 9)
+		call := runtime.CallsTo("Exec")[0]
+		assert.Equal(t, "test-server", call.Container)
+		assert.Equal(t, []string{"zfs", "list", "-H", "-p", "-r", "-t", "all", "-o", zfsListProperties, "test"},
+			call.Args)
+	}
+
+	e, _ = newRecordingTest("kubernetes-csi")
+	_, err = e.GetZfsState()
+	assert.Error(t, err)
+}
+
+func TestWaitForZfsDestroyed(t *testing.T) {
+	e, runtime := newRecordingTest("docker-zfs")
+	runtime.Outputs["Exec"] = zfsTestOutput
+	assert.NoError(t, e.WaitForZfsDestroyed(context.Background(), "test/data/vs3", "test/data/vs2@id"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := e.WaitForZfsDestroyed(ctx, "test/data/vs3", "test/data/vs1@id")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "still present: test/data/vs1@id")
+	}
+}
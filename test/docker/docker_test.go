@@ -20,6 +20,7 @@ type WorkflowTestSuite struct {
 	ctx context.Context
 
 	volumeMountpoint string
+	volumeSet        string
 	remoteParams     titan.RemoteParameters
 	currentOp        titan.Operation
 }
@@ -43,24 +44,96 @@ func TestDockerLocalSuite(t *testing.T) {
 	suite.Run(t, new(WorkflowTestSuite))
 }
 
-func (s *WorkflowTestSuite) TestLocal_001_GetContext() {
+/*
+ * The workflow runs as a single sequence of steps against one repository. Each step declares the steps that create
+ * the state it depends on, so that an early failure skips only the steps that can't meaningfully run.
+ */
+func (s *WorkflowTestSuite) TestWorkflow() {
+	s.e.Steps().
+		Add("GetContext", s.getContext).
+		Add("EmptyRepoList", s.emptyRepoList).
+		Add("CreateRepository", s.createRepository).
+		Add("GetRepository", s.getRepository, "CreateRepository").
+		Add("ListRepositoryPresent", s.listRepositoryPresent, "CreateRepository").
+		Add("CreateDuplicate", s.createDuplicate, "CreateRepository").
+		Add("CreateVolume", s.createVolume, "CreateRepository").
+		Add("CreateVolumeBadRepo", s.createVolumeBadRepo).
+		Add("CreateVolumeDuplicate", s.createVolumeDuplicate, "CreateVolume").
+		Add("GetVolume", s.getVolume, "CreateVolume").
+		Add("GetBadVolume", s.getBadVolume).
+		Add("ListVolume", s.listVolume, "CreateVolume").
+		Add("MountVolume", s.mountVolume, "CreateVolume").
+		Add("CreateFile", s.createFile, "MountVolume").
+		Add("LastCommitEmpty", s.lastCommitEmpty, "CreateRepository").
+		Add("CreateCommit", s.createCommit, "CreateFile").
+		Add("CommitSnapshots", s.commitSnapshots, "CreateCommit").
+		Add("DuplicateCommit", s.duplicateCommit, "CreateCommit").
+		Add("GetCommit", s.getCommit, "CreateCommit").
+		Add("GetBadCommit", s.getBadCommit, "CreateRepository").
+		Add("UpdateCommit", s.updateCommit, "CreateCommit").
+		Add("CommitStatus", s.commitStatus, "CreateCommit").
+		Add("DeleteBadCommit", s.deleteBadCommit, "CreateRepository").
+		Add("ListCommit", s.listCommit, "CreateCommit").
+		Add("FilterOut", s.filterOut, "UpdateCommit").
+		Add("FilterPresent", s.filterPresent, "UpdateCommit").
+		Add("FilterCompound", s.filterCompound, "UpdateCommit").
+		Add("RepositoryStatus", s.repositoryStatus, "CreateCommit").
+		Add("VolumeStatus", s.volumeStatus, "CreateVolume").
+		Add("WriteNewValue", s.writeNewValue, "CreateFile").
+		Add("Unmount", s.unmount, "MountVolume").
+		Add("UnmountIdempotent", s.unmountIdempotent, "Unmount").
+		Add("Checkout", s.checkout, "CreateCommit", "Unmount").
+		Add("NewMountpoint", s.newMountpoint, "GetVolume", "Checkout").
+		Add("CheckoutClone", s.checkoutClone, "CommitSnapshots", "Checkout").
+		Add("SourceCommit", s.sourceCommit, "Checkout").
+		Add("AddRemote", s.addRemote, "CreateRepository").
+		Add("GetRemote", s.getRemote, "AddRemote").
+		Add("DuplicateRemote", s.duplicateRemote, "AddRemote").
+		Add("ListRemotes", s.listRemotes, "AddRemote").
+		Add("ListRemoteCommits", s.listRemoteCommits, "AddRemote").
+		Add("GetRemoteCommit", s.getRemoteCommit, "AddRemote").
+		Add("DeleteNonExistentRemote", s.deleteNonExistentRemote, "CreateRepository").
+		Add("UpdateRemote", s.updateRemote, "AddRemote").
+		Add("ListEmptyOperations", s.listEmptyOperations).
+		Add("StartPush", s.startPush, "CreateCommit", "UpdateRemote").
+		Add("GetOperation", s.getOperation, "StartPush").
+		Add("ListOperations", s.listOperations, "StartPush").
+		Add("GetPushProgress", s.getPushProgress, "StartPush").
+		Add("ListNotPresent", s.listNotPresent, "GetPushProgress").
+		Add("StartPull", s.startPull, "UpdateRemote").
+		Add("GetPull", s.getPull, "StartPull").
+		Add("ListPullOperation", s.listPullOperation, "StartPull").
+		Add("GetPullProgress", s.getPullProgress, "StartPull").
+		Add("GetPulledCommit", s.getPulledCommit, "GetPullProgress").
+		Add("ListMultipleCommits", s.listMultipleCommits, "CreateCommit", "GetPullProgress").
+		Add("FilterOutCommit", s.filterOutCommit, "UpdateCommit", "GetPullProgress").
+		Add("PushBadCommit", s.pushBadCommit, "UpdateRemote").
+		Add("AbortOperation", s.abortOperation, "CreateCommit", "UpdateRemote").
+		Add("DeleteCommit", s.deleteCommit, "GetPullProgress").
+		Add("DeleteRemote", s.deleteRemote, "UpdateRemote").
+		Add("DeleteVolume", s.deleteVolume, "CreateVolume").
+		Add("DeleteRepository", s.deleteRepository, "CreateRepository").
+		Run()
+}
+
+func (s *WorkflowTestSuite) getContext() {
 	res, _, err := s.e.Client.ContextsApi.GetContext(s.ctx)
 	if s.e.NoError(err) {
 		s.Equal("docker-zfs", res.Provider)
 		s.Len(res.Properties, 1)
-		s.Equal("test", res.Properties["pool"])
+		s.Equal(s.e.Identity, res.Properties["pool"])
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_002_EmptyRepoList() {
+func (s *WorkflowTestSuite) emptyRepoList() {
 	res, _, err := s.e.RepoApi.ListRepositories(s.ctx)
 	if s.e.NoError(err) {
 		s.Len(res, 0)
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_003_CreateRepository() {
-	res, _, err := s.e.RepoApi.CreateRepository(s.ctx, titan.Repository{
+func (s *WorkflowTestSuite) createRepository() {
+	res, err := s.e.CreateRepository(s.ctx, titan.Repository{
 		Name:       "foo",
 		Properties: map[string]interface{}{"a": "b"},
 	})
@@ -71,7 +144,7 @@ func (s *WorkflowTestSuite) TestLocal_003_CreateRepository() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_004_GetRepository() {
+func (s *WorkflowTestSuite) getRepository() {
 	res, _, err := s.e.RepoApi.GetRepository(s.ctx, "foo")
 	if s.e.NoError(err) {
 		s.Equal("foo", res.Name)
@@ -80,7 +153,7 @@ func (s *WorkflowTestSuite) TestLocal_004_GetRepository() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_005_ListRepositoryPresent() {
+func (s *WorkflowTestSuite) listRepositoryPresent() {
 	res, _, err := s.e.RepoApi.ListRepositories(s.ctx)
 	if s.e.NoError(err) {
 		s.Len(res, 1)
@@ -91,16 +164,16 @@ func (s *WorkflowTestSuite) TestLocal_005_ListRepositoryPresent() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_006_CreateDuplicate() {
-	_, _, err := s.e.RepoApi.CreateRepository(s.ctx, titan.Repository{
+func (s *WorkflowTestSuite) createDuplicate() {
+	_, err := s.e.CreateRepository(s.ctx, titan.Repository{
 		Name:       "foo",
 		Properties: map[string]interface{}{},
 	})
 	s.e.APIError(err, "ObjectExistsException")
 }
 
-func (s *WorkflowTestSuite) TestLocal_010_CreateVolume() {
-	res, _, err := s.e.VolumeApi.CreateVolume(s.ctx, "foo", titan.Volume{
+func (s *WorkflowTestSuite) createVolume() {
+	res, err := s.e.CreateVolume(s.ctx, "foo", titan.Volume{
 		Name:       "vol",
 		Properties: map[string]interface{}{"a": "b"},
 	})
@@ -111,40 +184,40 @@ func (s *WorkflowTestSuite) TestLocal_010_CreateVolume() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_011_CreateVolumeBadRepo() {
-	_, _, err := s.e.VolumeApi.CreateVolume(s.ctx, "bar", titan.Volume{
+func (s *WorkflowTestSuite) createVolumeBadRepo() {
+	_, err := s.e.CreateVolume(s.ctx, "bar", titan.Volume{
 		Name:       "vol",
 		Properties: map[string]interface{}{"a": "b"},
 	})
 	s.e.APIError(err, "NoSuchObjectException")
 }
 
-func (s *WorkflowTestSuite) TestLocal_012_CreateVolumeDuplicate() {
-	_, _, err := s.e.VolumeApi.CreateVolume(s.ctx, "foo", titan.Volume{
+func (s *WorkflowTestSuite) createVolumeDuplicate() {
+	_, err := s.e.CreateVolume(s.ctx, "foo", titan.Volume{
 		Name:       "vol",
 		Properties: map[string]interface{}{"a": "b"},
 	})
 	s.e.APIError(err, "ObjectExistsException")
 }
 
-func (s *WorkflowTestSuite) TestLocal_013_GetVolume() {
+func (s *WorkflowTestSuite) getVolume() {
 	res, _, err := s.e.VolumeApi.GetVolume(s.ctx, "foo", "vol")
 	if s.e.NoError(err) {
 		s.Equal("vol", res.Name)
 		s.Len(res.Properties, 1)
 		s.Equal("b", res.Properties["a"])
 		s.volumeMountpoint = res.Config["mountpoint"].(string)
-		idx := strings.Index(s.volumeMountpoint, "/var/lib/test/mnt/")
+		idx := strings.Index(s.volumeMountpoint, s.e.Backend.MountPath()+"/")
 		s.Equal(0, idx)
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_014_GetBadVolume() {
+func (s *WorkflowTestSuite) getBadVolume() {
 	_, _, err := s.e.VolumeApi.GetVolume(s.ctx, "bar", "vol")
 	s.e.APIError(err, "NoSuchObjectException")
 }
 
-func (s *WorkflowTestSuite) TestLocal_015_ListVolume() {
+func (s *WorkflowTestSuite) listVolume() {
 	res, _, err := s.e.VolumeApi.ListVolumes(s.ctx, "foo")
 	if s.e.NoError(err) {
 		s.Len(res, 1)
@@ -152,12 +225,12 @@ func (s *WorkflowTestSuite) TestLocal_015_ListVolume() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_016_MountVolume() {
+func (s *WorkflowTestSuite) mountVolume() {
 	_, err := s.e.VolumeApi.ActivateVolume(s.ctx, "foo", "vol")
 	s.e.NoError(err)
 }
 
-func (s *WorkflowTestSuite) TestLocal_017_CreateFile() {
+func (s *WorkflowTestSuite) createFile() {
 	err := s.e.WriteFile("foo", "vol", "testfile", "Hello")
 	if s.e.NoError(err) {
 		res, err := s.e.ReadFile("foo", "vol", "testfile")
@@ -167,7 +240,7 @@ func (s *WorkflowTestSuite) TestLocal_017_CreateFile() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_020_LastCommitEmpty() {
+func (s *WorkflowTestSuite) lastCommitEmpty() {
 	res, _, err := s.e.RepoApi.GetRepositoryStatus(s.ctx, "foo")
 	if s.e.NoError(err) {
 		s.Empty(res.SourceCommit)
@@ -175,8 +248,8 @@ func (s *WorkflowTestSuite) TestLocal_020_LastCommitEmpty() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_021_CreateCommit() {
-	res, _, err := s.e.CommitApi.CreateCommit(s.ctx, "foo", titan.Commit{
+func (s *WorkflowTestSuite) createCommit() {
+	res, err := s.e.CreateCommit(s.ctx, "foo", titan.Commit{
 		Id: "id",
 		Properties: map[string]interface{}{"tags": map[string]string{
 			"a": "b",
@@ -189,15 +262,31 @@ func (s *WorkflowTestSuite) TestLocal_021_CreateCommit() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_022_DuplicateCommit() {
-	_, _, err := s.e.CommitApi.CreateCommit(s.ctx, "foo", titan.Commit{
+/*
+ * A commit is a recursive snapshot of the volume set, so there should be one of the volume set and one of the volume.
+ */
+func (s *WorkflowTestSuite) commitSnapshots() {
+	vs, err := s.e.GetVolumeSet("foo", "vol")
+	if s.e.NoError(err) {
+		state, err := s.e.GetZfsState()
+		if s.e.NoError(err) {
+			s.Equal([]string{"vol"}, state.Volumes(vs))
+			s.Equal([]string{state.CommitName("id", vs, "vol"), state.CommitName("id", vs)},
+				state.CommitSnapshots("id"))
+			s.volumeSet = vs
+		}
+	}
+}
+
+func (s *WorkflowTestSuite) duplicateCommit() {
+	_, err := s.e.CreateCommit(s.ctx, "foo", titan.Commit{
 		Id:         "id",
 		Properties: map[string]interface{}{},
 	})
 	s.e.APIError(err, "ObjectExistsException")
 }
 
-func (s *WorkflowTestSuite) TestLocal_023_GetCommit() {
+func (s *WorkflowTestSuite) getCommit() {
 	res, _, err := s.e.CommitApi.GetCommit(s.ctx, "foo", "id")
 	if s.e.NoError(err) {
 		s.Equal("id", res.Id)
@@ -205,12 +294,12 @@ func (s *WorkflowTestSuite) TestLocal_023_GetCommit() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_024_GetBadCommit() {
+func (s *WorkflowTestSuite) getBadCommit() {
 	_, _, err := s.e.CommitApi.GetCommit(s.ctx, "foo", "id2")
 	s.e.APIError(err, "NoSuchObjectException")
 }
 
-func (s *WorkflowTestSuite) TestLocal_025_UpdateCommit() {
+func (s *WorkflowTestSuite) updateCommit() {
 	res, _, err := s.e.CommitApi.UpdateCommit(s.ctx, "foo", "id", titan.Commit{
 		Id: "id",
 		Properties: map[string]interface{}{"tags": map[string]string{
@@ -226,7 +315,7 @@ func (s *WorkflowTestSuite) TestLocal_025_UpdateCommit() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_026_CommitStatus() {
+func (s *WorkflowTestSuite) commitStatus() {
 	res, _, err := s.e.CommitApi.GetCommitStatus(s.ctx, "foo", "id")
 	if s.e.NoError(err) {
 		s.NotZero(res.LogicalSize)
@@ -234,12 +323,12 @@ func (s *WorkflowTestSuite) TestLocal_026_CommitStatus() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_027_DeleteBadCommit() {
+func (s *WorkflowTestSuite) deleteBadCommit() {
 	_, err := s.e.CommitApi.DeleteCommit(s.ctx, "foo", "id2")
 	s.e.APIError(err, "NoSuchObjectException")
 }
 
-func (s *WorkflowTestSuite) TestLocal_030_ListCommit() {
+func (s *WorkflowTestSuite) listCommit() {
 	res, _, err := s.e.CommitApi.ListCommits(s.ctx, "foo", nil)
 	if s.e.NoError(err) {
 		s.Len(res, 1)
@@ -247,7 +336,7 @@ func (s *WorkflowTestSuite) TestLocal_030_ListCommit() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_031_FilterOut() {
+func (s *WorkflowTestSuite) filterOut() {
 	res, _, err := s.e.CommitApi.ListCommits(s.ctx, "foo", &titan.ListCommitsOpts{
 		Tag: optional.NewInterface([]string{"a=c"}),
 	})
@@ -256,7 +345,7 @@ func (s *WorkflowTestSuite) TestLocal_031_FilterOut() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_032_FilterPresent() {
+func (s *WorkflowTestSuite) filterPresent() {
 	res, _, err := s.e.CommitApi.ListCommits(s.ctx, "foo", &titan.ListCommitsOpts{
 		Tag: optional.NewInterface([]string{"a=B"}),
 	})
@@ -266,7 +355,7 @@ func (s *WorkflowTestSuite) TestLocal_032_FilterPresent() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_033_FilterCompound() {
+func (s *WorkflowTestSuite) filterCompound() {
 	res, _, err := s.e.CommitApi.ListCommits(s.ctx, "foo", &titan.ListCommitsOpts{
 		Tag: optional.NewInterface([]string{"a=B", "c"}),
 	})
@@ -276,7 +365,7 @@ func (s *WorkflowTestSuite) TestLocal_033_FilterCompound() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_034_RepositoryStatus() {
+func (s *WorkflowTestSuite) repositoryStatus() {
 	res, _, err := s.e.RepoApi.GetRepositoryStatus(s.ctx, "foo")
 	if s.e.NoError(err) {
 		s.Equal("id", res.SourceCommit)
@@ -284,7 +373,7 @@ func (s *WorkflowTestSuite) TestLocal_034_RepositoryStatus() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_040_VolumeStatus() {
+func (s *WorkflowTestSuite) volumeStatus() {
 	res, _, err := s.e.VolumeApi.GetVolumeStatus(s.ctx, "foo", "vol")
 	if s.e.NoError(err) {
 		s.Equal("vol", res.Name)
@@ -295,7 +384,7 @@ func (s *WorkflowTestSuite) TestLocal_040_VolumeStatus() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_041_WriteNewValue() {
+func (s *WorkflowTestSuite) writeNewValue() {
 	err := s.e.WriteFile("foo", "vol", "testfile", "Goodbye")
 	if s.e.NoError(err) {
 		res, err := s.e.ReadFile("foo", "vol", "testfile")
@@ -305,17 +394,17 @@ func (s *WorkflowTestSuite) TestLocal_041_WriteNewValue() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_042_Unmount() {
+func (s *WorkflowTestSuite) unmount() {
 	_, err := s.e.VolumeApi.DeactivateVolume(s.ctx, "foo", "vol")
 	s.e.NoError(err)
 }
 
-func (s *WorkflowTestSuite) TestLocal_043_UnmountIdempotent() {
+func (s *WorkflowTestSuite) unmountIdempotent() {
 	_, err := s.e.VolumeApi.DeactivateVolume(s.ctx, "foo", "vol")
 	s.e.NoError(err)
 }
 
-func (s *WorkflowTestSuite) TestLocal_044_Checkout() {
+func (s *WorkflowTestSuite) checkout() {
 	_, err := s.e.CommitApi.CheckoutCommit(s.ctx, "foo", "id")
 	if s.e.NoError(err) {
 		_, err = s.e.VolumeApi.ActivateVolume(s.ctx, "foo", "vol")
@@ -328,7 +417,7 @@ func (s *WorkflowTestSuite) TestLocal_044_Checkout() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_045_NewMountpoint() {
+func (s *WorkflowTestSuite) newMountpoint() {
 	res, _, err := s.e.VolumeApi.GetVolume(s.ctx, "foo", "vol")
 	if s.e.NoError(err) {
 		s.NotEqual(s.volumeMountpoint, res.Config["mountpoint"])
@@ -336,7 +425,22 @@ func (s *WorkflowTestSuite) TestLocal_045_NewMountpoint() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_046_SourceCommit() {
+/*
+ * Checkout should have created a new volume set, with the volume cloned from the snapshot of the commit.
+ */
+func (s *WorkflowTestSuite) checkoutClone() {
+	vs, err := s.e.GetVolumeSet("foo", "vol")
+	if s.e.NoError(err) {
+		s.NotEqual(s.volumeSet, vs)
+		state, err := s.e.GetZfsState()
+		if s.e.NoError(err) {
+			s.Equal([]string{state.VolumeSetName(vs, "vol")}, state.Clones(state.CommitName("id", s.volumeSet, "vol")))
+			s.Empty(state.Snapshots(state.VolumeSetName(vs, "vol")))
+		}
+	}
+}
+
+func (s *WorkflowTestSuite) sourceCommit() {
 	res, _, err := s.e.RepoApi.GetRepositoryStatus(s.ctx, "foo")
 	if s.e.NoError(err) {
 		s.Equal("id", res.SourceCommit)
@@ -344,8 +448,8 @@ func (s *WorkflowTestSuite) TestLocal_046_SourceCommit() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_050_AddRemote() {
-	res, _, err := s.e.RemoteApi.CreateRemote(s.ctx, "foo", titan.Remote{
+func (s *WorkflowTestSuite) addRemote() {
+	res, err := s.e.CreateRemote(s.ctx, "foo", titan.Remote{
 		Provider:   "nop",
 		Name:       "a",
 		Properties: map[string]interface{}{},
@@ -355,7 +459,7 @@ func (s *WorkflowTestSuite) TestLocal_050_AddRemote() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_051_GetRemote() {
+func (s *WorkflowTestSuite) getRemote() {
 	res, _, err := s.e.RemoteApi.GetRemote(s.ctx, "foo", "a")
 	if s.e.NoError(err) {
 		s.Equal("nop", res.Provider)
@@ -363,8 +467,8 @@ func (s *WorkflowTestSuite) TestLocal_051_GetRemote() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_052_DuplicateRemote() {
-	_, _, err := s.e.RemoteApi.CreateRemote(s.ctx, "foo", titan.Remote{
+func (s *WorkflowTestSuite) duplicateRemote() {
+	_, err := s.e.CreateRemote(s.ctx, "foo", titan.Remote{
 		Provider:   "nop",
 		Name:       "a",
 		Properties: map[string]interface{}{},
@@ -372,7 +476,7 @@ func (s *WorkflowTestSuite) TestLocal_052_DuplicateRemote() {
 	s.e.APIError(err, "ObjectExistsException")
 }
 
-func (s *WorkflowTestSuite) TestLocal_053_ListRemotes() {
+func (s *WorkflowTestSuite) listRemotes() {
 	res, _, err := s.e.RemoteApi.ListRemotes(s.ctx, "foo")
 	if s.e.NoError(err) {
 		s.Len(res, 1)
@@ -380,27 +484,27 @@ func (s *WorkflowTestSuite) TestLocal_053_ListRemotes() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_054_ListRemoteCommits() {
+func (s *WorkflowTestSuite) listRemoteCommits() {
 	res, _, err := s.e.RemoteApi.ListRemoteCommits(s.ctx, "foo", "a", s.remoteParams, nil)
 	if s.e.NoError(err) {
 		s.Len(res, 0)
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_055_GetRemoteCommit() {
+func (s *WorkflowTestSuite) getRemoteCommit() {
 	res, _, err := s.e.RemoteApi.GetRemoteCommit(s.ctx, "foo", "a", "hash", s.remoteParams)
 	if s.e.NoError(err) {
 		s.Equal("hash", res.Id)
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_056_DeleteNonExistentRemote() {
+func (s *WorkflowTestSuite) deleteNonExistentRemote() {
 	_, err := s.e.RemoteApi.DeleteRemote(s.ctx, "foo", "b")
 	s.e.APIError(err, "NoSuchObjectException")
 }
 
-func (s *WorkflowTestSuite) TestLocal_057_UpdateRemote() {
-	_, _, err := s.e.RemoteApi.UpdateRemote(s.ctx, "foo", "a", titan.Remote{
+func (s *WorkflowTestSuite) updateRemote() {
+	_, err := s.e.UpdateRemote(s.ctx, "foo", "a", titan.Remote{
 		Provider:   "nop",
 		Name:       "b",
 		Properties: map[string]interface{}{},
@@ -414,15 +518,15 @@ func (s *WorkflowTestSuite) TestLocal_057_UpdateRemote() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_060_ListEmptyOperations() {
+func (s *WorkflowTestSuite) listEmptyOperations() {
 	res, _, err := s.e.OperationsApi.ListOperations(s.ctx, nil)
 	if s.e.NoError(err) {
 		s.Len(res, 0)
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_061_StartPush() {
-	res, _, err := s.e.OperationsApi.Push(s.ctx, "foo", "b", "id", s.remoteParams, nil)
+func (s *WorkflowTestSuite) startPush() {
+	res, err := s.e.Push(s.ctx, "foo", "b", "id", s.remoteParams, nil)
 	if s.e.NoError(err) {
 		s.Equal("id", res.CommitId)
 		s.Equal("PUSH", res.Type)
@@ -431,7 +535,7 @@ func (s *WorkflowTestSuite) TestLocal_061_StartPush() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_062_GetOperation() {
+func (s *WorkflowTestSuite) getOperation() {
 	res, _, err := s.e.OperationsApi.GetOperation(s.ctx, s.currentOp.Id)
 	if s.e.NoError(err) {
 		s.Equal("id", res.CommitId)
@@ -441,7 +545,7 @@ func (s *WorkflowTestSuite) TestLocal_062_GetOperation() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_063_ListOperations() {
+func (s *WorkflowTestSuite) listOperations() {
 	res, _, err := s.e.OperationsApi.ListOperations(s.ctx, &titan.ListOperationsOpts{Repository: optional.NewString("foo")})
 	if s.e.NoError(err) {
 		s.Len(res, 1)
@@ -449,7 +553,7 @@ func (s *WorkflowTestSuite) TestLocal_063_ListOperations() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_064_GetPushProgress() {
+func (s *WorkflowTestSuite) getPushProgress() {
 	time.Sleep(time.Duration(1) * time.Second)
 	res, _, err := s.e.OperationsApi.GetOperation(s.ctx, s.currentOp.Id)
 	if s.e.NoError(err) {
@@ -464,15 +568,15 @@ func (s *WorkflowTestSuite) TestLocal_064_GetPushProgress() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_065_ListNotPresent() {
+func (s *WorkflowTestSuite) listNotPresent() {
 	res, _, err := s.e.OperationsApi.ListOperations(s.ctx, &titan.ListOperationsOpts{Repository: optional.NewString("foo")})
 	if s.e.NoError(err) {
 		s.Len(res, 0)
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_070_StartPull() {
-	res, _, err := s.e.OperationsApi.Pull(s.ctx, "foo", "b", "id2", s.remoteParams, nil)
+func (s *WorkflowTestSuite) startPull() {
+	res, err := s.e.Pull(s.ctx, "foo", "b", "id2", s.remoteParams, nil)
 	if s.e.NoError(err) {
 		s.Equal("id2", res.CommitId)
 		s.Equal("PULL", res.Type)
@@ -481,7 +585,7 @@ func (s *WorkflowTestSuite) TestLocal_070_StartPull() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_071_GetPull() {
+func (s *WorkflowTestSuite) getPull() {
 	res, _, err := s.e.OperationsApi.GetOperation(s.ctx, s.currentOp.Id)
 	if s.e.NoError(err) {
 		s.Equal("id2", res.CommitId)
@@ -491,7 +595,7 @@ func (s *WorkflowTestSuite) TestLocal_071_GetPull() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_072_ListPullOperation() {
+func (s *WorkflowTestSuite) listPullOperation() {
 	res, _, err := s.e.OperationsApi.ListOperations(s.ctx, &titan.ListOperationsOpts{Repository: optional.NewString("foo")})
 	if s.e.NoError(err) {
 		s.Len(res, 1)
@@ -499,7 +603,7 @@ func (s *WorkflowTestSuite) TestLocal_072_ListPullOperation() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_073_GetPullProgress() {
+func (s *WorkflowTestSuite) getPullProgress() {
 	time.Sleep(time.Duration(1) * time.Second)
 	res, _, err := s.e.OperationsApi.GetOperation(s.ctx, s.currentOp.Id)
 	if s.e.NoError(err) {
@@ -514,21 +618,21 @@ func (s *WorkflowTestSuite) TestLocal_073_GetPullProgress() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_080_GetPulledCommit() {
+func (s *WorkflowTestSuite) getPulledCommit() {
 	res, _, err := s.e.CommitApi.GetCommit(s.ctx, "foo", "id2")
 	if s.e.NoError(err) {
 		s.Equal("id2", res.Id)
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_081_ListMultipleCommits() {
+func (s *WorkflowTestSuite) listMultipleCommits() {
 	res, _, err := s.e.CommitApi.ListCommits(s.ctx, "foo", nil)
 	if s.e.NoError(err) {
 		s.Len(res, 2)
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_082_FilterOutCommit() {
+func (s *WorkflowTestSuite) filterOutCommit() {
 	res, _, err := s.e.CommitApi.ListCommits(s.ctx, "foo", &titan.ListCommitsOpts{Tag: optional.NewInterface([]string{"a=B"})})
 	if s.e.NoError(err) {
 		s.Len(res, 1)
@@ -536,17 +640,17 @@ func (s *WorkflowTestSuite) TestLocal_082_FilterOutCommit() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_083_PushBadCommit() {
-	_, _, err := s.e.OperationsApi.Push(s.ctx, "foo", "b", "id3", s.remoteParams, nil)
+func (s *WorkflowTestSuite) pushBadCommit() {
+	_, err := s.e.Push(s.ctx, "foo", "b", "id3", s.remoteParams, nil)
 	s.e.APIError(err, "NoSuchObjectException")
 }
 
-func (s *WorkflowTestSuite) TestLocal_090_AbortOperation() {
+func (s *WorkflowTestSuite) abortOperation() {
 	params := titan.RemoteParameters{
 		Provider:   "nop",
 		Properties: map[string]interface{}{"delay": 10},
 	}
-	res, _, err := s.e.OperationsApi.Push(s.ctx, "foo", "b", "id", params, nil)
+	res, err := s.e.Push(s.ctx, "foo", "b", "id", params, nil)
 	if !s.e.NoError(err) {
 		return
 	}
@@ -557,13 +661,15 @@ func (s *WorkflowTestSuite) TestLocal_090_AbortOperation() {
 		return
 	}
 
-	time.Sleep(time.Duration(1) * time.Second)
+	w := endtoend.NewProgressWatcher()
+	s.Error(s.e.WatchOperation(s.ctx, res.Id, w))
+	s.Equal(endtoend.ProgressAborted, w.State())
+	s.Empty(w.Violations())
 	res, _, err = s.e.OperationsApi.GetOperation(s.ctx, res.Id)
 	if s.e.NoError(err) {
 		s.Equal("ABORTED", res.State)
-		progress, _, err := s.e.OperationsApi.GetOperationProgress(s.ctx, res.Id, nil)
-		if s.e.NoError(err) {
-			s.Len(progress, 2)
+		progress := w.Entries()
+		if s.Len(progress, 2) {
 			s.Equal("MESSAGE", progress[0].Type)
 			s.Equal("Pushing id to 'b'", progress[0].Message)
 			s.Equal("ABORT", progress[1].Type)
@@ -571,25 +677,57 @@ func (s *WorkflowTestSuite) TestLocal_090_AbortOperation() {
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_100_DeleteCommit() {
-	_, err := s.e.CommitApi.DeleteCommit(s.ctx, "foo", "id2")
-	s.e.NoError(err)
+func (s *WorkflowTestSuite) deleteCommit() {
+	state, err := s.e.GetZfsState()
+	if s.e.NoError(err) {
+		snapshots := state.CommitSnapshots("id2")
+		s.NotEmpty(snapshots)
+		_, err = s.e.CommitApi.DeleteCommit(s.ctx, "foo", "id2")
+		if s.e.NoError(err) {
+			s.e.NoError(s.e.WaitForZfsDestroyed(s.ctx, snapshots...))
+		}
+	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_102_DeleteRemote() {
+func (s *WorkflowTestSuite) deleteRemote() {
 	_, err := s.e.RemoteApi.DeleteRemote(s.ctx, "foo", "b")
 	s.e.NoError(err)
 }
 
-func (s *WorkflowTestSuite) TestLocal_103_DeleteVolume() {
-	_, err := s.e.VolumeApi.DeactivateVolume(s.ctx, "foo", "vol")
+func (s *WorkflowTestSuite) deleteVolume() {
+	vs, err := s.e.GetVolumeSet("foo", "vol")
+	if !s.e.NoError(err) {
+		return
+	}
+	state, err := s.e.GetZfsState()
+	if !s.e.NoError(err) {
+		return
+	}
+	_, err = s.e.VolumeApi.DeactivateVolume(s.ctx, "foo", "vol")
 	if s.e.NoError(err) {
 		_, err = s.e.VolumeApi.DeleteVolume(s.ctx, "foo", "vol")
-		s.e.NoError(err)
+		if s.e.NoError(err) {
+			s.e.NoError(s.e.WaitForZfsDestroyed(s.ctx, state.VolumeSetName(vs, "vol")))
+		}
 	}
 }
 
-func (s *WorkflowTestSuite) TestLocal_104_DeleteRepository() {
-	_, err := s.e.RepoApi.DeleteRepository(s.ctx, "foo")
-	s.e.NoError(err)
+/*
+ * This is the only repository in the suite, so every volume set in the pool belongs to it, and should be destroyed
+ * along with all of its commits.
+ */
+func (s *WorkflowTestSuite) deleteRepository() {
+	state, err := s.e.GetZfsState()
+	if !s.e.NoError(err) {
+		return
+	}
+	datasets := []string{}
+	for _, vs := range state.VolumeSets() {
+		datasets = append(datasets, state.VolumeSetName(vs))
+	}
+	s.NotEmpty(datasets)
+	_, err = s.e.RepoApi.DeleteRepository(s.ctx, "foo")
+	if s.e.NoError(err) {
+		s.e.NoError(s.e.WaitForZfsDestroyed(s.ctx, datasets...))
+	}
 }
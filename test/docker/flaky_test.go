@@ -0,0 +1,134 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package docker
+
+import (
+	"context"
+	"github.com/stretchr/testify/suite"
+	titan "github.com/titan-data/titan-client-go"
+	endtoend "github.com/titan-data/titan-server/test/common"
+	"net/http"
+	"testing"
+	"time"
+)
+
+/*
+ * Runs the API client through a fault injecting proxy, to check how clients and the harness's own polling behave
+ * when the server is slow, drops connections, or stops responding altogether.
+ */
+type FlakyApiTestSuite struct {
+	suite.Suite
+	e     *endtoend.EndToEndTest
+	ctx   context.Context
+	proxy *endtoend.FaultProxy
+}
+
+func (s *FlakyApiTestSuite) SetupSuite() {
+	s.e = endtoend.NewEndToEndTest(&s.Suite, "docker-zfs")
+	s.e.SetupStandardDocker()
+	s.ctx = context.Background()
+}
+
+func (s *FlakyApiTestSuite) TearDownSuite() {
+	if s.proxy != nil {
+		_ = s.e.StopApiProxy(s.proxy)
+	}
+	s.e.TeardownStandardDocker()
+}
+
+func TestFlakyApiSuite(t *testing.T) {
+	suite.Run(t, new(FlakyApiTestSuite))
+}
+
+func (s *FlakyApiTestSuite) TestFlakyApi() {
+	s.e.Steps().
+		Add("StartProxy", s.startProxy).
+		Add("CreateRepository", s.createRepository, "StartProxy").
+		Add("Latency", s.latency, "CreateRepository").
+		Add("Reset", s.reset, "CreateRepository").
+		Add("Truncate", s.truncate, "CreateRepository").
+		Add("BlackholeTimeout", s.blackholeTimeout, "CreateRepository").
+		Add("BlackholeRecovery", s.blackholeRecovery, "CreateRepository").
+		Add("DeleteRepository", s.deleteRepository, "CreateRepository").
+		Run()
+}
+
+func (s *FlakyApiTestSuite) startProxy() {
+	var err error
+	s.proxy, err = s.e.StartApiProxy()
+	s.NoError(err)
+}
+
+func (s *FlakyApiTestSuite) createRepository() {
+	_, err := s.e.CreateRepository(s.ctx, titan.Repository{
+		Name:       "foo",
+		Properties: map[string]interface{}{},
+	})
+	s.e.NoError(err)
+}
+
+func (s *FlakyApiTestSuite) latency() {
+	defer s.proxy.ClearFaults()
+	s.proxy.InjectFault(endtoend.ProxyLatency(500 * time.Millisecond))
+	start := time.Now()
+	res, _, err := s.e.RepoApi.GetRepository(s.ctx, "foo")
+	if s.e.NoError(err) {
+		s.Equal("foo", res.Name)
+		s.True(time.Since(start) >= 500*time.Millisecond)
+	}
+}
+
+/*
+ * The client uses the default transport, which silently retries an idempotent request on a new connection if a reused
+ * one is reset. Closing idle connections first ensures the request goes out on the faulted connection.
+ */
+func (s *FlakyApiTestSuite) reset() {
+	defer s.proxy.ClearFaults()
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	connections, faulted := s.proxy.Connections()
+	s.proxy.InjectFault(endtoend.ProxyFault{Reset: true, Count: 1})
+	_, _, err := s.e.RepoApi.GetRepository(s.ctx, "foo")
+	s.Error(err)
+	_, _, err = s.e.RepoApi.GetRepository(s.ctx, "foo")
+	s.e.NoError(err)
+	total, reset := s.proxy.Connections()
+	s.Equal(faulted+1, reset)
+	s.Equal(connections+2, total)
+}
+
+func (s *FlakyApiTestSuite) truncate() {
+	defer s.proxy.ClearFaults()
+	s.proxy.InjectFault(endtoend.ProxyTruncate(20))
+	_, _, err := s.e.RepoApi.GetRepository(s.ctx, "foo")
+	s.Error(err)
+}
+
+/*
+ * A request with a deadline shorter than the blackhole should fail rather than hang.
+ */
+func (s *FlakyApiTestSuite) blackholeTimeout() {
+	defer s.proxy.ClearFaults()
+	s.proxy.InjectFault(endtoend.ProxyBlackhole(5 * time.Second))
+	ctx, cancel := context.WithTimeout(s.ctx, time.Second)
+	defer cancel()
+	_, _, err := s.e.RepoApi.GetRepository(ctx, "foo")
+	s.Error(err)
+}
+
+/*
+ * Waiting for the server should ride out a blackhole shorter than its own deadline.
+ */
+func (s *FlakyApiTestSuite) blackholeRecovery() {
+	defer s.proxy.ClearFaults()
+	s.proxy.InjectFault(endtoend.ProxyBlackhole(3 * time.Second))
+	start := time.Now()
+	if s.NoError(s.e.WaitForServer(s.ctx)) {
+		s.True(time.Since(start) >= 2*time.Second)
+	}
+}
+
+func (s *FlakyApiTestSuite) deleteRepository() {
+	_, err := s.e.RepoApi.DeleteRepository(s.ctx, "foo")
+	s.e.NoError(err)
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package docker
+
+import (
+	"context"
+	"github.com/stretchr/testify/suite"
+	endtoend "github.com/titan-data/titan-server/test/common"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/*
+ * Runs random sequences of API calls against the server, checking every response and the resulting state against an
+ * in-memory model (see test/common/model.go). A failing sequence is shrunk to a minimal one that still fails, which
+ * is reported along with the seed. Set TITAN_MODEL_SEED to replay a particular seed, and TITAN_MODEL_RUNS and
+ * TITAN_MODEL_STEPS to change the number of sequences and their length.
+ */
+type ModelTestSuite struct {
+	suite.Suite
+	e   *endtoend.EndToEndTest
+	ctx context.Context
+}
+
+const (
+	modelRuns    = 3
+	modelSteps   = 60
+	modelShrinks = 100
+)
+
+func (s *ModelTestSuite) SetupSuite() {
+	s.e = endtoend.NewEndToEndTest(&s.Suite, "docker-zfs")
+	s.e.SetupStandardDocker()
+	s.ctx = context.Background()
+}
+
+func (s *ModelTestSuite) TearDownSuite() {
+	s.e.TeardownStandardDocker()
+}
+
+func TestModelSuite(t *testing.T) {
+	suite.Run(t, new(ModelTestSuite))
+}
+
+func envSetting(name string, value int64) int64 {
+	if v, err := strconv.ParseInt(os.Getenv(name), 10, 64); err == nil {
+		return v
+	}
+	return value
+}
+
+func (s *ModelTestSuite) TestModel() {
+	seed := envSetting("TITAN_MODEL_SEED", time.Now().UnixNano())
+	runs := int(envSetting("TITAN_MODEL_RUNS", modelRuns))
+	steps := int(envSetting("TITAN_MODEL_STEPS", modelSteps))
+	sys := s.e.ModelSystem()
+	for i := 0; i < runs; i++ {
+		s.T().Logf("model run %d with seed %d", i+1, seed+int64(i))
+		failure := endtoend.RunRandomModel(s.ctx, sys, seed+int64(i), steps, modelShrinks)
+		if failure != nil {
+			s.Fail(failure.Error())
+			break
+		}
+	}
+	s.NoError(sys.Reset(s.ctx))
+}
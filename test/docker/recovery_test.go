@@ -0,0 +1,202 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package docker
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	titan "github.com/titan-data/titan-client-go"
+	endtoend "github.com/titan-data/titan-server/test/common"
+	"testing"
+	"time"
+)
+
+/*
+ * Restarts the server while operations are in flight, and checks that they are retried and run to completion once
+ * the server comes back. Nop operations use a delay long enough to reach every restart point, and since the nop
+ * remote reports no phases, they are restarted at fixed times rather than in a particular phase. Operations against
+ * the ssh remote are restarted during data and metadata sync, by stalling the command the provider runs in that
+ * phase until the server has been restarted.
+ */
+type RecoveryTestSuite struct {
+	suite.Suite
+	e   *endtoend.EndToEndTest
+	ctx context.Context
+}
+
+const recoveryDelay = 8
+
+func (s *RecoveryTestSuite) SetupSuite() {
+	s.e = endtoend.NewEndToEndTest(&s.Suite, "docker-zfs")
+	s.e.SetupStandardDocker()
+	s.ctx = context.Background()
+}
+
+func (s *RecoveryTestSuite) TearDownSuite() {
+	s.e.TeardownStandardDocker()
+	s.e.TeardownStandardSsh()
+}
+
+func TestRecoverySuite(t *testing.T) {
+	suite.Run(t, new(RecoveryTestSuite))
+}
+
+func (s *RecoveryTestSuite) TestRecovery() {
+	steps := s.e.Steps().Add("CreateFixture", s.createFixture)
+	for _, point := range endtoend.NopRestartPoints {
+		point := point
+		steps.Add("PushRestart"+point.Name, func() { s.pushRestart(point) }, "CreateFixture")
+	}
+	steps.
+		Add("PullRestartAfter2s", s.pullRestart, "CreateFixture").
+		Add("AddSshRemote", s.addSshRemote, "CreateFixture").
+		Add("SshPushRestartDataSync", s.sshPushRestartDataSync, "AddSshRemote").
+		Add("SshPushRestartMetadataSync", s.sshPushRestartMetadataSync, "AddSshRemote").
+		Add("SshPullRestartDataSync", s.sshPullRestartDataSync, "SshPushRestartDataSync").
+		Add("OperationsCleared", s.operationsCleared, "CreateFixture").
+		Run()
+	s.NoError(s.e.Cleanup(s.ctx))
+}
+
+func (s *RecoveryTestSuite) createFixture() {
+	s.e.Fixture().
+		Repo("foo").
+		Volume("vol", endtoend.FixtureFile{Path: "testfile", Content: []byte("Hello")}).
+		Commit("id").
+		Remote(titan.Remote{Provider: "nop", Name: "origin", Properties: map[string]interface{}{}}).
+		MustBuild(s.ctx)
+}
+
+/*
+ * The recovered operation must complete, having been retried exactly once, with a well formed progress stream that
+ * contains a single final entry.
+ */
+func (s *RecoveryTestSuite) checkRecovered(res *endtoend.RecoveryResult, message string) {
+	s.e.NoError(res.Err)
+	s.Equal("COMPLETE", res.Operation.State)
+	w := res.Watcher
+	s.Equal(endtoend.ProgressComplete, w.State())
+	s.Equal(1, w.Restarts())
+	s.Empty(w.Violations())
+
+	entries := w.Entries()
+	if s.NotEmpty(entries) {
+		s.Equal("MESSAGE", entries[0].Type)
+		s.Equal(message, entries[0].Message)
+	}
+	final := 0
+	for _, p := range entries {
+		switch p.Type {
+		case endtoend.ProgressComplete, endtoend.ProgressFailed, endtoend.ProgressAbort:
+			final++
+		}
+	}
+	s.Equal(1, final, "progress: %+v", entries)
+}
+
+func (s *RecoveryTestSuite) pushRestart(point endtoend.RestartPoint) {
+	res, err := s.e.RunWithRestart(s.ctx, point, func(ctx context.Context) (titan.Operation, error) {
+		return s.e.Push(ctx, "foo", "origin", "id", endtoend.DelayedNopParameters(recoveryDelay), nil)
+	})
+	if s.e.NoError(err) {
+		s.checkRecovered(res, "Pushing id to 'origin'")
+	}
+}
+
+func (s *RecoveryTestSuite) pullRestart() {
+	res, err := s.e.RunWithRestart(s.ctx, endtoend.RestartAfter(2*time.Second),
+		func(ctx context.Context) (titan.Operation, error) {
+			return s.e.Pull(ctx, "foo", "origin", "pulled", endtoend.DelayedNopParameters(recoveryDelay), nil)
+		})
+	if s.e.NoError(err) {
+		s.checkRecovered(res, "Pulling pulled from 'origin'")
+		_, _, err = s.e.CommitApi.GetCommit(s.ctx, "foo", "pulled")
+		s.e.NoError(err)
+	}
+}
+
+func (s *RecoveryTestSuite) addSshRemote() {
+	s.e.SetupStandardSsh()
+	if !s.e.NoError(s.e.MkdirSsh("/recovery")) {
+		return
+	}
+	_, err := s.e.CreateRemote(s.ctx, "foo", titan.Remote{
+		Provider: "ssh",
+		Name:     "ssh",
+		Properties: map[string]interface{}{
+			"address":  s.e.SshHost,
+			"password": s.e.Ssh.Password,
+			"username": "test",
+			"port":     s.e.SshPort,
+			"path":     s.e.SshPath("/recovery"),
+		},
+	})
+	s.e.NoError(err)
+}
+
+func sshParameters() titan.RemoteParameters {
+	return titan.RemoteParameters{Provider: "ssh", Properties: map[string]interface{}{}}
+}
+
+/*
+ * Push a commit to the ssh remote, restarting the server while the given command is stalled, and check that the
+ * recovered push stored the commit.
+ */
+func (s *RecoveryTestSuite) sshPushRestart(commit string, point endtoend.RestartPoint, command string) {
+	stall := s.e.Ssh.Stall(command)
+	defer stall.Release()
+	res, err := s.e.RunWithRestart(s.ctx, endtoend.SshRestartPoint(point, stall),
+		func(ctx context.Context) (titan.Operation, error) {
+			return s.e.Push(ctx, "foo", "ssh", commit, sshParameters(), nil)
+		})
+	if s.e.NoError(err) {
+		s.checkRecovered(res, fmt.Sprintf("Pushing %s to 'ssh'", commit))
+		s.FileExists(s.e.SshPath(fmt.Sprintf("/recovery/%s/metadata.json", commit)))
+	}
+}
+
+func (s *RecoveryTestSuite) sshPushRestartDataSync() {
+	s.sshPushRestart("id", endtoend.RestartDuringDataSync, endtoend.SshDataSyncCommand)
+}
+
+func (s *RecoveryTestSuite) sshPushRestartMetadataSync() {
+	_, err := s.e.CreateCommit(s.ctx, "foo", titan.Commit{Id: "id2", Properties: map[string]interface{}{}})
+	if s.e.NoError(err) {
+		s.sshPushRestart("id2", endtoend.RestartDuringMetadataSync, endtoend.SshMetadataSyncCommand)
+	}
+}
+
+/*
+ * Pull back the commit pushed during data sync, once it has been deleted locally.
+ */
+func (s *RecoveryTestSuite) sshPullRestartDataSync() {
+	_, err := s.e.CommitApi.DeleteCommit(s.ctx, "foo", "id")
+	if !s.e.NoError(err) {
+		return
+	}
+	stall := s.e.Ssh.Stall(endtoend.SshDataSyncCommand)
+	defer stall.Release()
+	res, err := s.e.RunWithRestart(s.ctx, endtoend.SshRestartPoint(endtoend.RestartDuringDataSync, stall),
+		func(ctx context.Context) (titan.Operation, error) {
+			return s.e.Pull(ctx, "foo", "ssh", "id", sshParameters(), nil)
+		})
+	if s.e.NoError(err) {
+		s.checkRecovered(res, "Pulling id from 'ssh'")
+		_, _, err = s.e.CommitApi.GetCommit(s.ctx, "foo", "id")
+		s.e.NoError(err)
+	}
+}
+
+/*
+ * No operation should be left running once everything has recovered.
+ */
+func (s *RecoveryTestSuite) operationsCleared() {
+	res, _, err := s.e.OperationsApi.ListOperations(s.ctx, nil)
+	if s.e.NoError(err) {
+		for _, op := range res {
+			s.NotEqual("RUNNING", op.State, "operation %s still running", op.Id)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package docker
+
+import (
+	"context"
+	"github.com/stretchr/testify/suite"
+	endtoend "github.com/titan-data/titan-server/test/common"
+	"testing"
+)
+
+/*
+ * Runs each of the declarative scenarios in the 'scenarios' directory against a shared server. Scenarios clean up
+ * after themselves, so they can freely reuse the same repository names.
+ */
+type ScenarioTestSuite struct {
+	suite.Suite
+	e   *endtoend.EndToEndTest
+	ctx context.Context
+}
+
+func (s *ScenarioTestSuite) SetupSuite() {
+	s.e = endtoend.NewEndToEndTest(&s.Suite, "docker-zfs")
+	s.e.SetupStandardDocker()
+	s.ctx = context.Background()
+}
+
+func (s *ScenarioTestSuite) TearDownSuite() {
+	s.e.TeardownStandardDocker()
+}
+
+func (s *ScenarioTestSuite) TestScenarios() {
+	scenarios, err := endtoend.LoadScenarios("scenarios")
+	if s.e.NoError(err) {
+		for _, scenario := range scenarios {
+			scenario := scenario
+			s.Run(scenario.Name, func() {
+				s.e.RunScenario(s.ctx, scenario)
+			})
+		}
+	}
+}
+
+func TestScenarioTestSuite(t *testing.T) {
+	suite.Run(t, new(ScenarioTestSuite))
+}
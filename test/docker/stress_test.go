@@ -0,0 +1,58 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package docker
+
+import (
+	"context"
+	"github.com/stretchr/testify/suite"
+	endtoend "github.com/titan-data/titan-server/test/common"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+ * Drives the server from several concurrent clients making commit, checkout, tag update, and volume activation calls
+ * against shared repositories, and checks that the recorded history could have come from the calls being made one
+ * at a time (see test/common/linearize.go). Set TITAN_STRESS_SEED to replay a particular seed, and
+ * TITAN_STRESS_CLIENTS and TITAN_STRESS_CALLS to change the load.
+ */
+type StressTestSuite struct {
+	suite.Suite
+	e   *endtoend.EndToEndTest
+	ctx context.Context
+}
+
+func (s *StressTestSuite) SetupSuite() {
+	s.e = endtoend.NewEndToEndTest(&s.Suite, "docker-zfs")
+	s.e.SetupStandardDocker()
+	s.ctx = context.Background()
+}
+
+func (s *StressTestSuite) TearDownSuite() {
+	s.e.TeardownStandardDocker()
+}
+
+func TestStressSuite(t *testing.T) {
+	suite.Run(t, new(StressTestSuite))
+}
+
+func (s *StressTestSuite) TestStress() {
+	config := endtoend.StressConfig{
+		Seed:    envSetting("TITAN_STRESS_SEED", time.Now().UnixNano()),
+		Clients: int(envSetting("TITAN_STRESS_CLIENTS", 6)),
+		Calls:   int(envSetting("TITAN_STRESS_CALLS", 30)),
+		Repos:   []string{"foo", "bar"},
+		Volumes: []string{"v1", "v2"},
+		Commits: 4,
+	}
+	s.T().Logf("stress run with seed %d", config.Seed)
+	history, err := endtoend.RunStress(s.ctx, s.e.StressSystem(), config)
+	if s.NoError(err) {
+		s.Len(history.Calls, config.Clients*config.Calls)
+		problems := endtoend.CheckStressHistory(history)
+		s.Empty(problems, strings.Join(problems, "\n"))
+	}
+	s.NoError(s.e.Cleanup(s.ctx))
+}
@@ -42,7 +42,7 @@ func (s *TeardownTestSuite) TestTeardown_002_GetRepository() {
 func (s *TeardownTestSuite) TestTeardown_003_Restart() {
 	err := s.e.RestartServer()
 	s.e.NoError(err)
-	err = s.e.WaitForServer()
+	err = s.e.WaitForServer(context.Background())
 	s.e.NoError(err)
 	repo, _, err := s.e.Client.RepositoriesApi.GetRepository(context.Background(), "foo")
 	if s.e.NoError(err) {
@@ -56,7 +56,7 @@ func (s *TeardownTestSuite) TestTeardown_004_RestartTeardown() {
 	s.e.NoError(err)
 	err = s.e.StartServer()
 	s.e.NoError(err)
-	err = s.e.WaitForServer()
+	err = s.e.WaitForServer(context.Background())
 	s.e.NoError(err)
 	_, _, err = s.e.Client.RepositoriesApi.GetRepository(context.Background(), "foo")
 	s.e.APIError(err, "NoSuchObjectException")
@@ -38,6 +38,7 @@ func (s *KubernetesConfigTestSuite) SetupSuite() {
 }
 
 func (s *KubernetesConfigTestSuite) TearDownSuite() {
+	s.e.CollectDiagnosticsOnFailure()
 	err := s.e.StopServer(false)
 	if err != nil {
 		panic(err)
@@ -48,19 +49,29 @@ func TestKubernetesConfigSuite(t *testing.T) {
 	suite.Run(t, new(KubernetesConfigTestSuite))
 }
 
-func (s *KubernetesConfigTestSuite) TestKubernetesConfig_001_StartServer() {
+/*
+ * Start the server with an explicit configuration, and check that it is reported back through the context.
+ */
+func (s *KubernetesConfigTestSuite) TestConfig() {
+	s.e.Steps().
+		Add("StartServer", s.startServer).
+		Add("GetConfiguration", s.getConfiguration, "StartServer").
+		Run()
+}
+
+func (s *KubernetesConfigTestSuite) startServer() {
 	err := s.e.StartServer(
 		fmt.Sprintf("configFile=%s", s.ConfigFile),
 		fmt.Sprintf("context=%s", s.KubeContext),
 		fmt.Sprintf("storageClass=%s", s.StorageClass),
 		fmt.Sprintf("snapshotClass=%s", s.SnapshotClass))
 	if s.e.NoError(err) {
-		err := s.e.WaitForServer()
+		err := s.e.WaitForServer(context.Background())
 		s.e.NoError(err)
 	}
 }
 
-func (s *KubernetesConfigTestSuite) TestKubernetesConfig_002_GetConfiguration() {
+func (s *KubernetesConfigTestSuite) getConfiguration() {
 	res, _, err := s.e.Client.ContextsApi.GetContext(context.Background())
 	if s.e.NoError(err) {
 		s.Len(res.Properties, 5)
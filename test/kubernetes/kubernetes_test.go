@@ -36,19 +36,17 @@ type KubernetesWorkflowTestSuite struct {
 
 func (s *KubernetesWorkflowTestSuite) SetupSuite() {
 	s.e = endtoend.NewEndToEndTest(&s.Suite, "kubernetes-csi")
-	_ = s.e.StopServer(true)
 
 	config := []string{}
 	configEnv := os.Getenv("KUBERNETES_CONFIG")
 	if configEnv != "" {
 		config = strings.Split(configEnv, ",")
 	}
-	_ = s.e.StopServer(true)
 	err := s.e.StartServer(config...)
 	if err != nil {
 		panic(err)
 	}
-	err = s.e.WaitForServer()
+	err = s.e.WaitForServer(context.Background())
 	if err != nil {
 		panic(err)
 	}
@@ -85,6 +83,8 @@ func (s *KubernetesWorkflowTestSuite) SetupSuite() {
 }
 
 func (s *KubernetesWorkflowTestSuite) TearDownSuite() {
+	s.e.CollectDiagnosticsOnFailure()
+	_ = s.e.Cleanup(context.Background())
 	err := s.e.StopServer(false)
 	if err != nil {
 		panic(err)
@@ -95,31 +95,57 @@ func TestKubernetesWorkflowTestSuite(t *testing.T) {
 	suite.Run(t, new(KubernetesWorkflowTestSuite))
 }
 
-func (s *KubernetesWorkflowTestSuite) WaitForPod(name string) error {
-	ready := false
-	for ok := true; ok; ok = !ready {
-		res, err := s.Clientset.CoreV1().Pods(s.namespace).Get(name, apiV1.GetOptions{})
-		if err != nil {
-			return err
-		}
+/*
+ * The workflow runs as a single sequence of steps against one repository, with each step declaring the steps that
+ * create the state it depends on.
+ */
+func (s *KubernetesWorkflowTestSuite) TestWorkflow() {
+	s.e.Steps().
+		Add("GetContext", s.getContext).
+		Add("Kubectl", s.kubectl).
+		Add("CreateRepository", s.createRepository).
+		Add("CreateVolume", s.createVolume, "CreateRepository").
+		Add("LaunchPod", s.launchPod, "CreateVolume").
+		Add("WriteData", s.writeData, "LaunchPod").
+		Add("VolumeStatus", s.volumeStatus, "CreateVolume").
+		Add("CreateCommit", s.createCommit, "WriteData").
+		Add("CommitStatus", s.commitStatus, "CreateCommit").
+		Add("UpdateData", s.updateData, "CreateCommit").
+		Add("DeletePod", s.deletePod, "LaunchPod").
+		Add("Checkout", s.checkout, "CommitStatus", "DeletePod").
+		Add("LaunchNewPod", s.launchNewPod, "Checkout").
+		Add("VerifyContents", s.verifyContents, "LaunchNewPod").
+		Add("DeleteClonedPod", s.deleteClonedPod, "LaunchNewPod").
+		Add("AddRemote", s.addRemote, "CreateRepository").
+		Add("Push", s.push, "CommitStatus", "AddRemote").
+		Add("DeleteCommit", s.deleteCommit, "Push").
+		Add("Pull", s.pull, "DeleteCommit").
+		Add("DeleteVolume", s.deleteVolume, "CreateVolume").
+		Add("DeleteRepository", s.deleteRepository, "CreateRepository").
+		Run()
+}
 
-		if len(res.Status.ContainerStatuses) != 0 {
-			ready = true
-		}
-		for _, container := range res.Status.ContainerStatuses {
-			if container.RestartCount != 0 {
-				return fmt.Errorf("container %s restarted %d times", name, container.RestartCount)
+func (s *KubernetesWorkflowTestSuite) WaitForPod(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(5)*time.Minute)
+	defer cancel()
+	return endtoend.Poll(ctx, fmt.Sprintf("pod %s", name), time.Duration(1)*time.Second,
+		func(ctx context.Context) (bool, interface{}, error) {
+			res, err := s.Clientset.CoreV1().Pods(s.namespace).Get(name, apiV1.GetOptions{})
+			if err != nil {
+				return false, nil, err
 			}
-			if !container.Ready {
-				ready = false
-			}
-		}
 
-		if !ready {
-			time.Sleep(time.Duration(1) * time.Second)
-		}
-	}
-	return nil
+			ready := len(res.Status.ContainerStatuses) != 0
+			for _, container := range res.Status.ContainerStatuses {
+				if container.RestartCount != 0 {
+					return false, res.Status, fmt.Errorf("container %s restarted %d times", name, container.RestartCount)
+				}
+				if !container.Ready {
+					ready = false
+				}
+			}
+			return ready, res.Status, nil
+		})
 }
 
 func (s *KubernetesWorkflowTestSuite) LaunchPod(name string, claim string) error {
@@ -151,53 +177,53 @@ func (s *KubernetesWorkflowTestSuite) LaunchPod(name string, claim string) error
 	return err
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_001_GetContext() {
+func (s *KubernetesWorkflowTestSuite) getContext() {
 	res, _, err := s.e.Client.ContextsApi.GetContext(context.Background())
 	if s.e.NoError(err) {
 		s.Equal("kubernetes-csi", res.Provider)
 	}
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_002_Kubectl() {
+func (s *KubernetesWorkflowTestSuite) kubectl() {
 	_, err := s.e.ExecServer("kubectl", "cluster-info")
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_010_CreateRepository() {
-	_, _, err := s.e.RepoApi.CreateRepository(s.ctx, titan.Repository{
+func (s *KubernetesWorkflowTestSuite) createRepository() {
+	_, err := s.e.CreateRepository(s.ctx, titan.Repository{
 		Name:       "foo",
 		Properties: map[string]interface{}{},
 	})
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_011_CreateVolume() {
-	_, _, err := s.e.VolumeApi.CreateVolume(s.ctx, "foo", titan.Volume{
+func (s *KubernetesWorkflowTestSuite) createVolume() {
+	_, err := s.e.CreateVolume(s.ctx, "foo", titan.Volume{
 		Name:       "vol",
 		Properties: map[string]interface{}{},
 	})
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_012_LaunchPod() {
+func (s *KubernetesWorkflowTestSuite) launchPod() {
 	vol, _, err := s.e.VolumeApi.GetVolume(s.ctx, "foo", "vol")
 	if s.e.NoError(err) {
 		pvc := vol.Config["pvc"].(string)
 		err = s.LaunchPod(s.pod1, pvc)
 		if s.e.NoError(err) {
-			err = s.WaitForPod(s.pod1)
+			err = s.WaitForPod(s.ctx, s.pod1)
 			s.e.NoError(err)
 		}
 	}
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_013_WriteData() {
+func (s *KubernetesWorkflowTestSuite) writeData() {
 	err := exec.Command("kubectl", "exec", s.pod1, "--", "sh", "-c", "echo one > /data/out; sync; sleep 1;").Run()
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_014_VolumeStatus() {
-	err := s.e.WaitForVolume("foo", "vol")
+func (s *KubernetesWorkflowTestSuite) volumeStatus() {
+	err := s.e.WaitForVolume(s.ctx, "foo", "vol")
 	if s.e.NoError(err) {
 		res, _, err := s.e.VolumeApi.GetVolumeStatus(s.ctx, "foo", "vol")
 		if s.e.NoError(err) {
@@ -207,16 +233,16 @@ func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_014_VolumeStatus() {
 	}
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_020_CreateCommit() {
-	_, _, err := s.e.CommitApi.CreateCommit(s.ctx, "foo", titan.Commit{
+func (s *KubernetesWorkflowTestSuite) createCommit() {
+	_, err := s.e.CreateCommit(s.ctx, "foo", titan.Commit{
 		Id:         "id",
 		Properties: map[string]interface{}{},
 	})
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_021_CommitStatus() {
-	err := s.e.WaitForCommit("foo", "id")
+func (s *KubernetesWorkflowTestSuite) commitStatus() {
+	err := s.e.WaitForCommit(s.ctx, "foo", "id")
 	if s.e.NoError(err) {
 		res, _, err := s.e.CommitApi.GetCommitStatus(s.ctx, "foo", "id")
 		if s.e.NoError(err) {
@@ -226,72 +252,72 @@ func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_021_CommitStatus() {
 	}
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_022_UpdateData() {
+func (s *KubernetesWorkflowTestSuite) updateData() {
 	err := exec.Command("kubectl", "exec", s.pod1, "--", "sh", "-c", "echo two > /data/out; sync; sleep 1;").Run()
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_023_DeletePod() {
+func (s *KubernetesWorkflowTestSuite) deletePod() {
 	err := exec.Command("kubectl", "delete", "pod", "--grace-period=0", "--force", s.pod1).Run()
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_030_Checkout() {
+func (s *KubernetesWorkflowTestSuite) checkout() {
 	_, err := s.e.CommitApi.CheckoutCommit(s.ctx, "foo", "id")
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_031_LaunchNewPod() {
+func (s *KubernetesWorkflowTestSuite) launchNewPod() {
 	vol, _, err := s.e.VolumeApi.GetVolume(s.ctx, "foo", "vol")
 	if s.e.NoError(err) {
 		pvc := vol.Config["pvc"].(string)
 		err = s.LaunchPod(s.pod2, pvc)
 		if s.e.NoError(err) {
-			err = s.WaitForPod(s.pod2)
+			err = s.WaitForPod(s.ctx, s.pod2)
 			s.e.NoError(err)
 		}
 	}
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_032_VerifyContents() {
+func (s *KubernetesWorkflowTestSuite) verifyContents() {
 	out, err := exec.Command("kubectl", "exec", s.pod2, "cat", "/data/out").Output()
 	if s.e.NoError(err) {
 		s.Equal("one", strings.TrimSpace(string(out)))
 	}
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_033_DeleteClonedPod() {
+func (s *KubernetesWorkflowTestSuite) deleteClonedPod() {
 	err := exec.Command("kubectl", "delete", "pod", "--grace-period=0", "--force", s.pod2).Run()
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_040_AddRemote() {
-	_, _, err := s.e.RemoteApi.CreateRemote(s.ctx, "foo", s.remote)
+func (s *KubernetesWorkflowTestSuite) addRemote() {
+	_, err := s.e.CreateRemote(s.ctx, "foo", s.remote)
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_041_Push() {
-	op, _, err := s.e.OperationsApi.Push(s.ctx, "foo", "origin", "id", s.remoteParams, nil)
+func (s *KubernetesWorkflowTestSuite) push() {
+	op, err := s.e.Push(s.ctx, "foo", "origin", "id", s.remoteParams, nil)
 	if s.e.NoError(err) {
-		_, err = s.e.WaitForOperation(op.Id)
+		_, err = s.e.WaitForOperation(s.ctx, op.Id)
 		s.e.NoError(err)
 	}
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_042_DeleteCommit() {
+func (s *KubernetesWorkflowTestSuite) deleteCommit() {
 	_, err := s.e.CommitApi.DeleteCommit(s.ctx, "foo", "id")
 	s.e.NoError(err)
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_043_Pull() {
-	op, _, err := s.e.OperationsApi.Pull(s.ctx, "foo", "origin", "id", s.remoteParams, nil)
+func (s *KubernetesWorkflowTestSuite) pull() {
+	op, err := s.e.Pull(s.ctx, "foo", "origin", "id", s.remoteParams, nil)
 	if s.e.NoError(err) {
-		_, err = s.e.WaitForOperation(op.Id)
+		_, err = s.e.WaitForOperation(s.ctx, op.Id)
 		s.e.NoError(err)
 	}
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_070_DeleteVolume() {
+func (s *KubernetesWorkflowTestSuite) deleteVolume() {
 	_, err := s.e.VolumeApi.DeactivateVolume(s.ctx, "foo", "vol")
 	if s.e.NoError(err) {
 		_, err = s.e.VolumeApi.DeleteVolume(s.ctx, "foo", "vol")
@@ -299,7 +325,7 @@ func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_070_DeleteVolume() {
 	}
 }
 
-func (s *KubernetesWorkflowTestSuite) TestKubernetesConfig_071_DeleteRepository() {
+func (s *KubernetesWorkflowTestSuite) deleteRepository() {
 	_, err := s.e.RepoApi.DeleteRepository(s.ctx, "foo")
 	s.e.NoError(err)
 }
@@ -0,0 +1,405 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	titan "github.com/titan-data/titan-client-go"
+	endtoend "github.com/titan-data/titan-server/test/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+ * The nop remote accepts everything and stores nothing.
+ */
+type nopFixture struct{}
+
+func (f *nopFixture) Setup(e *endtoend.EndToEndTest) error {
+	return nil
+}
+
+func (f *nopFixture) Teardown() error {
+	return nil
+}
+
+func (f *nopFixture) Remotes() []titan.Remote {
+	return []titan.Remote{{Provider: "nop", Name: "origin", Properties: map[string]interface{}{}}}
+}
+
+func (f *nopFixture) PushRemote() string {
+	return "origin"
+}
+
+func (f *nopFixture) PullRemote() string {
+	return "origin"
+}
+
+func (f *nopFixture) Parameters(remote string) titan.RemoteParameters {
+	return titan.RemoteParameters{Provider: "nop", Properties: map[string]interface{}{}}
+}
+
+func (f *nopFixture) StoredCommits() ([]string, error) {
+	return nil, nil
+}
+
+func (f *nopFixture) Reset() error {
+	return nil
+}
+
+func (f *nopFixture) Persistent() bool {
+	return false
+}
+
+/*
+ * The ssh remote, backed by the in-process SSH server. Each commit is stored in a directory named after it beneath
+ * the remote path.
+ */
+type sshFixture struct {
+	e    *endtoend.EndToEndTest
+	path string
+}
+
+func (f *sshFixture) Setup(e *endtoend.EndToEndTest) error {
+	f.e = e
+	f.path = "/bar"
+	e.SetupStandardSsh()
+	return e.MkdirSsh(f.path)
+}
+
+func (f *sshFixture) Teardown() error {
+	f.e.TeardownStandardSsh()
+	return nil
+}
+
+func (f *sshFixture) Remotes() []titan.Remote {
+	return []titan.Remote{{
+		Provider: "ssh",
+		Name:     "origin",
+		Properties: map[string]interface{}{
+			"address":  f.e.SshHost,
+			"password": f.e.Ssh.Password,
+			"username": "test",
+			"port":     f.e.SshPort,
+			"path":     f.e.SshPath(f.path),
+		},
+	}}
+}
+
+func (f *sshFixture) PushRemote() string {
+	return "origin"
+}
+
+func (f *sshFixture) PullRemote() string {
+	return "origin"
+}
+
+func (f *sshFixture) Parameters(remote string) titan.RemoteParameters {
+	return titan.RemoteParameters{Provider: "ssh", Properties: map[string]interface{}{}}
+}
+
+func (f *sshFixture) StoredCommits() ([]string, error) {
+	entries, err := ioutil.ReadDir(f.e.SshPath(f.path))
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ret = append(ret, entry.Name())
+		}
+	}
+	return ret, nil
+}
+
+func (f *sshFixture) Reset() error {
+	entries, err := ioutil.ReadDir(f.e.SshPath(f.path))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = os.RemoveAll(filepath.Join(f.e.SshPath(f.path), entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *sshFixture) Persistent() bool {
+	return true
+}
+
+/*
+ * The s3 remote. If S3_LOCATION is set, this runs against a unique prefix beneath that bucket and path using the
+ * credentials from the shared AWS config, and removes it on teardown. Otherwise, it runs against an in-process fake,
+ * which relies on the s3 provider honoring the 'endpoint' property. That is checked up front (see checkEndpoint), and
+ * setup fails if it isn't. Each commit is stored as an object named after it, alongside a prefix of the same
+ * name holding its data.
+ */
+type s3Fixture struct {
+	e          *endtoend.EndToEndTest
+	bucket     string
+	path       string
+	store      *endtoend.S3Bucket
+	fake       *endtoend.FakeS3
+	properties map[string]interface{}
+}
+
+func (f *s3Fixture) Setup(e *endtoend.EndToEndTest) error {
+	err := f.setup(e)
+	if err != nil {
+		return err
+	}
+	if f.fake != nil {
+		return f.checkEndpoint()
+	}
+	return nil
+}
+
+func (f *s3Fixture) setup(e *endtoend.EndToEndTest) error {
+	f.e = e
+	var sess *session.Session
+	location := os.Getenv("S3_LOCATION")
+	if location == "" {
+		f.bucket = "titan-test"
+		f.path = e.Identity
+		var endpoint string
+		var err error
+		f.fake, endpoint, err = e.StartFakeS3(f.bucket)
+		if err != nil {
+			return err
+		}
+		sess, err = session.NewSession(&aws.Config{
+			Credentials:      credentials.NewStaticCredentials(f.fake.AccessKey, f.fake.SecretKey, ""),
+			Endpoint:         aws.String(f.fake.URL()),
+			Region:           aws.String(f.fake.Region),
+			S3ForcePathStyle: aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		f.properties = map[string]interface{}{"endpoint": endpoint}
+	} else {
+		f.bucket = location[:strings.IndexByte(location, '/')]
+		f.path = endtoend.UniqueS3Prefix(location[strings.IndexByte(location, '/')+1:])
+		var err error
+		sess, err = session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+		if err != nil {
+			return err
+		}
+		f.properties = map[string]interface{}{}
+	}
+	f.store = endtoend.NewS3Bucket(s3.New(sess), f.bucket)
+
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return err
+	}
+	f.properties["bucket"] = f.bucket
+	f.properties["path"] = f.path
+	f.properties["accessKey"] = creds.AccessKeyID
+	f.properties["secretKey"] = creds.SecretAccessKey
+	f.properties["region"] = aws.StringValue(sess.Config.Region)
+	return nil
+}
+
+const endpointCheckTimeout = 30 * time.Second
+
+/*
+ * Check that the s3 provider sends requests to the fake, by listing commits through the remote in a scratch
+ * repository. A provider that ignores the 'endpoint' property goes to AWS instead, where the fake's credentials are
+ * rejected, so the fake never sees a request. This is an error rather than a reason to skip, so that the s3 suites
+ * can't silently stop running offline.
+ */
+func (f *s3Fixture) checkEndpoint() error {
+	ctx, cancel := context.WithTimeout(context.Background(), endpointCheckTimeout)
+	defer cancel()
+	repo := "endpoint-check"
+	_, _, err := f.e.RepoApi.CreateRepository(ctx, titan.Repository{Name: repo, Properties: map[string]interface{}{}})
+	if err != nil {
+		return err
+	}
+	defer f.e.RepoApi.DeleteRepository(context.Background(), repo)
+	_, _, err = f.e.RemoteApi.CreateRemote(ctx, repo, f.Remotes()[0])
+	if err != nil {
+		return err
+	}
+	before := f.fake.Requests()
+	_, _, err = f.e.RemoteApi.ListRemoteCommits(ctx, repo, "origin", f.Parameters("origin"), nil)
+	if f.fake.Requests() == before {
+		return errors.New(fmt.Sprintf("the s3 provider did not send any requests to the fake S3 server through "+
+			"the 'endpoint' property (listing commits returned: %v), set S3_LOCATION to test against AWS instead",
+			err))
+	}
+	return nil
+}
+
+func (f *s3Fixture) Teardown() error {
+	if f.fake != nil {
+		return f.fake.Stop()
+	}
+	if f.store == nil {
+		return nil
+	}
+	_, err := f.store.DeletePrefix(f.path + "/")
+	return err
+}
+
+func (f *s3Fixture) Remotes() []titan.Remote {
+	return []titan.Remote{{Provider: "s3", Name: "origin", Properties: f.properties}}
+}
+
+func (f *s3Fixture) PushRemote() string {
+	return "origin"
+}
+
+func (f *s3Fixture) PullRemote() string {
+	return "origin"
+}
+
+func (f *s3Fixture) Parameters(remote string) titan.RemoteParameters {
+	return titan.RemoteParameters{Provider: "s3", Properties: map[string]interface{}{}}
+}
+
+/*
+ * Properties for operations that supply their own keys, pointed at the same endpoint as the remote.
+ */
+func (f *s3Fixture) keyProperties(accessKey interface{}, secretKey interface{}) map[string]interface{} {
+	ret := map[string]interface{}{
+		"accessKey": accessKey,
+		"secretKey": secretKey,
+		"region":    f.properties["region"],
+	}
+	if endpoint, ok := f.properties["endpoint"]; ok {
+		ret["endpoint"] = endpoint
+	}
+	return ret
+}
+
+/*
+ * Snapshot of everything stored beneath the remote path.
+ */
+func (f *s3Fixture) inventory() (endtoend.S3Inventory, error) {
+	return f.store.Inventory(f.path + "/")
+}
+
+func (f *s3Fixture) StoredCommits() ([]string, error) {
+	keys, err := f.store.Keys(f.path + "/")
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	ret := []string{}
+	for _, key := range keys {
+		parts := strings.SplitN(strings.TrimPrefix(key, f.path+"/"), "/", 2)
+		if len(parts) == 2 && !seen[parts[0]] {
+			seen[parts[0]] = true
+			ret = append(ret, parts[0])
+		}
+	}
+	return ret, nil
+}
+
+func (f *s3Fixture) Reset() error {
+	_, err := f.store.DeletePrefix(f.path + "/")
+	return err
+}
+
+func (f *s3Fixture) Persistent() bool {
+	return true
+}
+
+/*
+ * The s3web remote, which is read-only. Commits are pushed through an s3 remote to the same storage, and read back
+ * through the s3web remote. Without S3_LOCATION, a fake web server is run over the fake S3 bucket.
+ *
+ * A fake web server is run either way, holding commits stored directly beneath a separate path (see seed), which
+ * is where faults are injected. This means the fault tests depend on neither AWS nor the s3 provider honoring the
+ * 'endpoint' property, which is why the endpoint check the s3 suite makes isn't made here.
+ */
+type s3webFixture struct {
+	s3Fixture
+	web         *endtoend.FakeWeb
+	webEndpoint string
+	url         string
+	seedPath    string
+}
+
+var seededCommits = []string{"seed1", "seed2"}
+
+func (f *s3webFixture) Setup(e *endtoend.EndToEndTest) error {
+	err := f.s3Fixture.setup(e)
+	if err != nil {
+		return err
+	}
+	source := f.fake
+	if source == nil {
+		source = endtoend.NewFakeS3()
+	}
+	f.web, f.webEndpoint, err = e.StartFakeWeb(source, f.bucket)
+	if err != nil {
+		return err
+	}
+	if f.fake != nil {
+		f.url = fmt.Sprintf("%s/%s", f.webEndpoint, f.path)
+	} else {
+		f.url = fmt.Sprintf("http://%s.s3.amazonaws.com/%s", f.bucket, f.path)
+	}
+	f.seedPath = f.path + "-seeded"
+	return f.seed()
+}
+
+/*
+ * Store commits for the fault tests directly, each with a volume "vol" containing "testfile".
+ */
+func (f *s3webFixture) seed() error {
+	for i, id := range seededCommits {
+		properties := map[string]interface{}{
+			"timestamp": time.Now().Add(time.Duration(i) * time.Second).UTC().Format(time.RFC3339),
+		}
+		err := f.web.PutCommit(f.seedPath, id, properties, map[string]map[string]string{
+			"vol": {"testfile": fmt.Sprintf("Hello from %s", id)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *s3webFixture) Teardown() error {
+	if f.web != nil {
+		_ = f.web.Stop()
+	}
+	return f.s3Fixture.Teardown()
+}
+
+func (f *s3webFixture) Remotes() []titan.Remote {
+	return append(f.s3Fixture.Remotes(), titan.Remote{
+		Provider:   "s3web",
+		Name:       "web",
+		Properties: map[string]interface{}{"url": f.url},
+	})
+}
+
+func (f *s3webFixture) PullRemote() string {
+	return "web"
+}
+
+func (f *s3webFixture) Parameters(remote string) titan.RemoteParameters {
+	if remote == "web" {
+		return titan.RemoteParameters{Provider: "s3web", Properties: map[string]interface{}{}}
+	}
+	return f.s3Fixture.Parameters(remote)
+}
@@ -0,0 +1,14 @@
+/*
+ * Copyright The Titan Project Contributors.
+ */
+package remote
+
+import (
+	"github.com/stretchr/testify/suite"
+	endtoend "github.com/titan-data/titan-server/test/common"
+	"testing"
+)
+
+func TestNopTestSuite(t *testing.T) {
+	suite.Run(t, &endtoend.ConformanceSuite{Fixture: &nopFixture{}})
+}
@@ -4,365 +4,333 @@
 package remote
 
 import (
-	"context"
-	"github.com/antihax/optional"
+	"crypto/rand"
 	"github.com/stretchr/testify/suite"
 	titan "github.com/titan-data/titan-client-go"
 	endtoend "github.com/titan-data/titan-server/test/common"
-	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 )
 
 type SshTestSuite struct {
-	suite.Suite
-	e   *endtoend.EndToEndTest
-	ctx context.Context
-
-	sshHost      string
-	remoteParams titan.RemoteParameters
-	currentOp    titan.Operation
-}
-
-func (s *SshTestSuite) SetupSuite() {
-	s.e = endtoend.NewEndToEndTest(&s.Suite, "docker-zfs")
-	s.e.SetupStandardDocker()
-	s.e.SetupStandardSsh()
-
-	s.ctx = context.Background()
-
-	s.remoteParams = titan.RemoteParameters{
-		Provider:   "ssh",
-		Properties: map[string]interface{}{},
-	}
+	endtoend.ConformanceSuite
+	fixture       *sshFixture
+	keys          map[endtoend.KeyType]*endtoend.KeyPair
+	protectedKeys map[endtoend.KeyType]*endtoend.KeyPair
+	proxy         *endtoend.FaultProxy
 }
 
-func (s *SshTestSuite) TearDownSuite() {
-	s.e.TeardownStandardDocker()
-	s.e.TeardownStandardSsh()
-}
+/*
+ * Size of the file written for the fault injection steps, large enough that transfers can be interrupted partway.
+ */
+const largeFileSize = 4 * 1024 * 1024
 
 func TestSshTestSuite(t *testing.T) {
-	suite.Run(t, new(SshTestSuite))
-}
-
-func (s *SshTestSuite) TestSsh_001_CreateRepository() {
-	_, _, err := s.e.RepoApi.CreateRepository(s.ctx, titan.Repository{
-		Name:       "foo",
-		Properties: map[string]interface{}{},
-	})
-	s.e.NoError(err)
+	s := &SshTestSuite{fixture: &sshFixture{}}
+	s.Fixture = s.fixture
+	s.Extend = s.steps
+	suite.Run(t, s)
+}
+
+func (s *SshTestSuite) steps(steps *endtoend.Steps) {
+	steps.
+		Add("RemoteProperties", s.remoteProperties, "AddRemotes").
+		Add("RemoteCommands", s.remoteCommands, "PushCommit").
+		Add("RemoteFileContents", s.remoteFileContents, "PushCommit").
+		Add("RemoveRemote", s.removeRemote, "OriginalContents").
+		Add("AddRemoteNoPassword", s.addRemoteNoPassword, "RemoveRemote").
+		Add("ListCommitsPassword", s.listCommitsPassword, "AddRemoteNoPassword").
+		Add("ListCommitsNoPassword", s.listCommitsNoPassword, "AddRemoteNoPassword").
+		Add("ListCommitsBadPassword", s.listCommitsBadPassword, "AddRemoteNoPassword").
+		Add("GenerateKeys", s.generateKeys).
+		Add("ListCommitsKey", s.listCommitsKey, "AddRemoteNoPassword", "GenerateKeys").
+		Add("PullCommitKey", s.pullCommitKey, "AddRemoteNoPassword", "GenerateKeys").
+		Add("ListCommitsProtectedKey", s.listCommitsProtectedKey, "AddRemoteNoPassword", "GenerateKeys").
+		Add("CreateLargeCommit", s.createLargeCommit, "OriginalContents").
+		Add("AddFlakyRemote", s.addFlakyRemote, "CreateRepository").
+		Add("PushCut", s.pushCut, "CreateLargeCommit", "AddFlakyRemote").
+		Add("PushRetry", s.pushRetry, "PushCut").
+		Add("PullCut", s.pullCut, "PushRetry").
+		Add("PullStalled", s.pullStalled, "PullCut").
+		Add("PushThrottled", s.pushThrottled, "PullStalled")
 }
 
-func (s *SshTestSuite) TestSsh_002_CreateMountVolume() {
-	_, _, err := s.e.VolumeApi.CreateVolume(s.ctx, "foo", titan.Volume{
-		Name:       "vol",
-		Properties: map[string]interface{}{},
-	})
-	if s.e.NoError(err) {
-		_, err := s.e.VolumeApi.ActivateVolume(s.ctx, "foo", "vol")
-		s.e.NoError(err)
-	}
-}
-
-func (s *SshTestSuite) TestSsh_003_CreateFile() {
-	err := s.e.WriteFile("foo", "vol", "testfile", "Hello")
-	if s.e.NoError(err) {
-		res, err := s.e.ReadFile("foo", "vol", "testfile")
-		if s.e.NoError(err) {
-			s.Equal("Hello", res)
-		}
+func (s *SshTestSuite) TearDownSuite() {
+	if s.proxy != nil {
+		_ = s.proxy.Stop()
 	}
+	s.ConformanceSuite.TearDownSuite()
 }
 
-func (s *SshTestSuite) TestSsh_004_CreateCommit() {
-	res, _, err := s.e.CommitApi.CreateCommit(s.ctx, "foo", titan.Commit{
-		Id: "id",
-		Properties: map[string]interface{}{"tags": map[string]string{
-			"a": "b",
-			"c": "d",
-		}},
-	})
-	if s.e.NoError(err) {
-		s.Equal("id", res.Id)
-		s.Equal("b", s.e.GetTag(res, "a"))
+func (s *SshTestSuite) remoteProperties() {
+	res, _, err := s.E.RemoteApi.GetRemote(s.Ctx, "foo", "origin")
+	if s.E.NoError(err) {
+		s.Equal("origin", res.Name)
+		s.Equal(s.E.SshHost, res.Properties["address"])
+		s.Equal("test", res.Properties["username"])
+		s.Equal(s.E.Ssh.Password, res.Properties["password"])
+		s.Equal(float64(s.E.SshPort), res.Properties["port"])
+		s.Equal(s.E.SshPath("/bar"), res.Properties["path"])
 	}
 }
 
-func (s *SshTestSuite) TestSsh_005_AddRemote() {
-	err := s.e.MkdirSsh("/bar")
-	if s.e.NoError(err) {
-		res, _, err := s.e.RemoteApi.CreateRemote(s.ctx, "foo", titan.Remote{
-			Provider: "ssh",
-			Name:     "origin",
-			Properties: map[string]interface{}{
-				"address":  s.e.SshHost,
-				"password": "test",
-				"username": "test",
-				"port":     22,
-				"path":     "/bar",
-			},
-		})
-		if s.e.NoError(err) {
-			s.Equal("origin", res.Name)
-			s.Equal(s.e.SshHost, res.Properties["address"])
-			s.Equal("test", res.Properties["username"])
-			s.Equal("test", res.Properties["password"])
-			s.Equal(22.0, res.Properties["port"])
-			s.Equal("/bar", res.Properties["path"])
+/*
+ * Every command run on the remote should have been run as the configured user, and the push must have touched the
+ * commit's directory.
+ */
+func (s *SshTestSuite) remoteCommands() {
+	touched := false
+	for _, c := range s.E.Ssh.Commands() {
+		s.Equal("test", c.User)
+		if strings.Contains(c.Command, s.E.SshPath("/bar/id")) {
+			touched = true
 		}
 	}
+	s.True(touched, "no command referenced the commit path: %v", s.E.Ssh.Commands())
 }
 
-func (s *SshTestSuite) TestSsh_010_ListEmptyRemoteCommits() {
-	res, _, err := s.e.RemoteApi.ListRemoteCommits(s.ctx, "foo", "origin", s.remoteParams, nil)
-	if s.e.NoError(err) {
-		s.Len(res, 0)
-	}
-}
-
-func (s *SshTestSuite) TestSsh_011_GetBadRemoteCommit() {
-	_, _, err := s.e.RemoteApi.GetRemoteCommit(s.ctx, "foo", "origin", "id2", s.remoteParams)
-	s.e.APIError(err, "NoSuchObjectException")
-}
-
-func (s *SshTestSuite) TestSsh_020_PushCommit() {
-	res, _, err := s.e.OperationsApi.Push(s.ctx, "foo", "origin", "id", s.remoteParams, nil)
-	if s.e.NoError(err) {
-		_, err = s.e.WaitForOperation(res.Id)
-		s.e.NoError(err)
-	}
-}
-
-func (s *SshTestSuite) TestSsh_021_ListRemoteCommit() {
-	res, _, err := s.e.RemoteApi.ListRemoteCommits(s.ctx, "foo", "origin", s.remoteParams, nil)
-	if s.e.NoError(err) {
-		s.Len(res, 1)
-		s.Equal("id", res[0].Id)
-		s.Equal("b", s.e.GetTag(res[0], "a"))
-	}
-}
-
-func (s *SshTestSuite) TestSsh_022_ListRemoteFilterOut() {
-	res, _, err := s.e.RemoteApi.ListRemoteCommits(s.ctx, "foo", "origin", s.remoteParams,
-		&titan.ListRemoteCommitsOpts{Tag: optional.NewInterface([]string{"e"})})
-	if s.e.NoError(err) {
-		s.Len(res, 0)
-	}
-}
-
-func (s *SshTestSuite) TestSsh_023_ListRemoteFilterInclude() {
-	res, _, err := s.e.RemoteApi.ListRemoteCommits(s.ctx, "foo", "origin", s.remoteParams,
-		&titan.ListRemoteCommitsOpts{Tag: optional.NewInterface([]string{"a=b", "c=d"})})
-	if s.e.NoError(err) {
-		s.Len(res, 1)
-		s.Equal("id", res[0].Id)
-	}
-}
-
-func (s *SshTestSuite) TestSsh_024_RemoteFileContents() {
-	res, err := s.e.ReadFileSsh("/bar/id/data/vol/testfile")
-	if s.e.NoError(err) {
+func (s *SshTestSuite) remoteFileContents() {
+	res, err := s.E.ReadFileSsh("/bar/id/data/vol/testfile")
+	if s.E.NoError(err) {
 		s.Equal("Hello", res)
 	}
 }
 
-func (s *SshTestSuite) TestSsh_030_PushDuplicateCommit() {
-	_, _, err := s.e.OperationsApi.Push(s.ctx, "foo", "origin", "id", s.remoteParams, nil)
-	s.e.APIError(err, "ObjectExistsException")
+func (s *SshTestSuite) removeRemote() {
+	_, err := s.E.RemoteApi.DeleteRemote(s.Ctx, "foo", "origin")
+	s.E.NoError(err)
 }
 
-func (s *SshTestSuite) TestSsh_031_UpdateCommit() {
-	res, _, err := s.e.CommitApi.UpdateCommit(s.ctx, "foo", "id", titan.Commit{
-		Id: "id",
-		Properties: map[string]interface{}{"tags": map[string]string{
-			"a": "B",
-			"c": "e",
-		}},
+func (s *SshTestSuite) addRemoteNoPassword() {
+	res, err := s.E.CreateRemote(s.Ctx, "foo", titan.Remote{
+		Provider: "ssh",
+		Name:     "origin",
+		Properties: map[string]interface{}{
+			"address":  s.E.SshHost,
+			"username": "test",
+			"port":     s.E.SshPort,
+			"path":     s.E.SshPath("/bar"),
+		},
 	})
-	if s.e.NoError(err) {
-		s.Equal("B", s.e.GetTag(res, "a"))
-	}
-}
-
-func (s *SshTestSuite) TestSsh_032_PushMedata() {
-	res, _, err := s.e.OperationsApi.Push(s.ctx, "foo", "origin", "id", s.remoteParams,
-		&titan.PushOpts{MetadataOnly: optional.NewBool(true)})
-	if s.e.NoError(err) {
-		_, err = s.e.WaitForOperation(res.Id)
-		s.e.NoError(err)
+	if s.E.NoError(err) {
+		s.Equal("origin", res.Name)
+		s.Equal(s.E.SshHost, res.Properties["address"])
+		s.Equal("test", res.Properties["username"])
+		s.Nil(res.Properties["password"])
+		s.Equal(float64(s.E.SshPort), res.Properties["port"])
+		s.Equal(s.E.SshPath("/bar"), res.Properties["path"])
 	}
 }
 
-func (s *SshTestSuite) TestSsh_033_RemoteMetadataUpdated() {
-	res, _, err := s.e.RemoteApi.GetRemoteCommit(s.ctx, "foo", "origin", "id", s.remoteParams)
-	if s.e.NoError(err) {
-		s.Equal("id", res.Id)
-		s.Equal("B", s.e.GetTag(res, "a"))
+func (s *SshTestSuite) listCommitsPassword() {
+	res, _, err := s.E.RemoteApi.ListRemoteCommits(s.Ctx, "foo", "origin",
+		titan.RemoteParameters{
+			Provider:   "ssh",
+			Properties: map[string]interface{}{"password": s.E.Ssh.Password},
+		}, nil)
+	if s.E.NoError(err) && s.Len(res, 1) {
+		s.Equal("id", res[0].Id)
 	}
 }
 
-func (s *SshTestSuite) TestSsh_040_DeleteLocalCommit() {
-	_, err := s.e.CommitApi.DeleteCommit(s.ctx, "foo", "id")
-	s.e.NoError(err)
+func (s *SshTestSuite) listCommitsNoPassword() {
+	_, _, err := s.E.RemoteApi.ListRemoteCommits(s.Ctx, "foo", "origin", s.fixture.Parameters("origin"), nil)
+	s.E.APIError(err, "IllegalArgumentException")
 }
 
-func (s *SshTestSuite) TestSsh_041_ListEmptyCommits() {
-	res, _, err := s.e.CommitApi.ListCommits(s.ctx, "foo", nil)
-	if s.e.NoError(err) {
-		s.Len(res, 0)
-	}
+func (s *SshTestSuite) listCommitsBadPassword() {
+	_, _, err := s.E.RemoteApi.ListRemoteCommits(s.Ctx, "foo", "origin",
+		titan.RemoteParameters{
+			Provider:   "ssh",
+			Properties: map[string]interface{}{"password": "r00t"},
+		}, nil)
+	s.E.APIError(err, "CommandException")
 }
 
-func (s *SshTestSuite) TestSsh_042_UpdateFile() {
-	err := s.e.WriteFile("foo", "vol", "testfile", "Goodbye")
-	if s.e.NoError(err) {
-		res, err := s.e.ReadFile("foo", "vol", "testfile")
-		if s.e.NoError(err) {
-			s.Equal("Goodbye", res)
+func (s *SshTestSuite) generateKeys() {
+	s.keys = map[endtoend.KeyType]*endtoend.KeyPair{}
+	s.protectedKeys = map[endtoend.KeyType]*endtoend.KeyPair{}
+	for _, keyType := range endtoend.KeyTypes {
+		key, err := s.E.GenerateSshKey(keyType, "")
+		if s.E.NoError(err) {
+			s.keys[keyType] = key
+		}
+		key, err = s.E.GenerateSshKey(keyType, "secret")
+		if s.E.NoError(err) {
+			s.protectedKeys[keyType] = key
 		}
 	}
 }
 
-func (s *SshTestSuite) TestSsh_043_PullCommit() {
-	res, _, err := s.e.OperationsApi.Pull(s.ctx, "foo", "origin", "id", s.remoteParams, nil)
-	if s.e.NoError(err) {
-		_, err = s.e.WaitForOperation(res.Id)
-		s.e.NoError(err)
+func (s *SshTestSuite) listCommitsKey() {
+	for _, keyType := range endtoend.KeyTypes {
+		key, ok := s.keys[keyType]
+		if !s.True(ok, "no %s key", keyType) {
+			continue
+		}
+		res, _, err := s.E.RemoteApi.ListRemoteCommits(s.Ctx, "foo", "origin", key.RemoteParameters(), nil)
+		if s.E.NoError(err) && s.Len(res, 1, "key type %s", keyType) {
+			s.Equal("id", res[0].Id)
+		}
 	}
 }
 
-func (s *SshTestSuite) TestSsh_044_PullDuplicate() {
-	_, _, err := s.e.OperationsApi.Pull(s.ctx, "foo", "origin", "id", s.remoteParams, nil)
-	s.e.APIError(err, "ObjectExistsException")
-}
-
-func (s *SshTestSuite) TestSsh_045_PullMetadata() {
-	res, _, err := s.e.OperationsApi.Pull(s.ctx, "foo", "origin", "id", s.remoteParams,
-		&titan.PullOpts{MetadataOnly: optional.NewBool(true)})
-	if s.e.NoError(err) {
-		_, err = s.e.WaitForOperation(res.Id)
-		s.e.NoError(err)
+func (s *SshTestSuite) pullCommitKey() {
+	_, err := s.E.CommitApi.DeleteCommit(s.Ctx, "foo", "id")
+	if s.E.NoError(err) && s.Contains(s.keys, endtoend.KeyRSA) {
+		res, err := s.E.Pull(s.Ctx, "foo", "origin", "id", s.keys[endtoend.KeyRSA].RemoteParameters(), nil)
+		if s.E.NoError(err) {
+			_, err = s.E.WaitForOperation(s.Ctx, res.Id)
+			s.E.NoError(err)
+		}
 	}
 }
 
-func (s *SshTestSuite) TestSsh_046_CheckoutCommit() {
-	_, err := s.e.VolumeApi.DeactivateVolume(s.ctx, "foo", "vol")
-	if s.e.NoError(err) {
-		_, err := s.e.CommitApi.CheckoutCommit(s.ctx, "foo", "id")
-		if s.e.NoError(err) {
-			_, err = s.e.VolumeApi.ActivateVolume(s.ctx, "foo", "vol")
-			s.e.NoError(err)
+/*
+ * The ssh provider is only passed the key, and not its passphrase, so it can't use a protected key. That the protected
+ * keys work with the correct passphrase is checked against OpenSSH directly (TestProtectedKeysOpenSsh in test/common).
+ */
+func (s *SshTestSuite) listCommitsProtectedKey() {
+	for _, keyType := range endtoend.KeyTypes {
+		key, ok := s.protectedKeys[keyType]
+		if !s.True(ok, "no protected %s key", keyType) {
+			continue
 		}
+		_, _, err := s.E.RemoteApi.ListRemoteCommits(s.Ctx, "foo", "origin", key.RemoteParameters(), nil)
+		s.E.APIError(err, "CommandException")
 	}
 }
 
-func (s *SshTestSuite) TestSsh_047_OriginalContents() {
-	res, err := s.e.ReadFile("foo", "vol", "testfile")
-	if s.e.NoError(err) {
-		s.Equal("Hello", res)
+func (s *SshTestSuite) createLargeCommit() {
+	content := make([]byte, largeFileSize)
+	_, err := rand.Read(content)
+	if s.NoError(err) && s.E.NoError(s.E.WriteFileBytes("foo", "vol", "large", content)) {
+		_, err = s.E.CreateCommit(s.Ctx, "foo", titan.Commit{
+			Id:         "large",
+			Properties: map[string]interface{}{},
+		})
+		s.E.NoError(err)
 	}
 }
 
-func (s *SshTestSuite) TestSsh_050_RemoveRemote() {
-	_, err := s.e.RemoteApi.DeleteRemote(s.ctx, "foo", "origin")
-	s.e.NoError(err)
-}
-
-func (s *SshTestSuite) TestSsh_051_AddRemoteNoPassword() {
-	res, _, err := s.e.RemoteApi.CreateRemote(s.ctx, "foo", titan.Remote{
+/*
+ * Remote that reaches the SSH server through a fault injecting proxy.
+ */
+func (s *SshTestSuite) addFlakyRemote() {
+	var err error
+	s.proxy, err = s.E.StartSshProxy()
+	if !s.NoError(err) {
+		return
+	}
+	_, err = s.E.CreateRemote(s.Ctx, "foo", titan.Remote{
 		Provider: "ssh",
-		Name:     "origin",
+		Name:     "flaky",
 		Properties: map[string]interface{}{
-			"address":  s.e.SshHost,
+			"address":  s.E.SshHost,
+			"password": s.E.Ssh.Password,
 			"username": "test",
-			"port":     22,
-			"path":     "/bar",
+			"port":     s.proxy.Port(),
+			"path":     s.E.SshPath("/bar"),
 		},
 	})
-	if s.e.NoError(err) {
-		s.Equal("origin", res.Name)
-		s.Equal(s.e.SshHost, res.Properties["address"])
-		s.Equal("test", res.Properties["username"])
-		s.Nil(res.Properties["password"])
-		s.Equal(22.0, res.Properties["port"])
-		s.Equal("/bar", res.Properties["path"])
-	}
+	s.E.NoError(err)
 }
 
-func (s *SshTestSuite) TestSsh_052_ListCommitsPassword() {
-	res, _, err := s.e.RemoteApi.ListRemoteCommits(s.ctx, "foo", "origin",
-		titan.RemoteParameters{
-			Provider:   "ssh",
-			Properties: map[string]interface{}{"password": "test"},
-		}, nil)
-	if s.e.NoError(err) {
-		s.Len(res, 1)
-		s.Equal("id", res[0].Id)
+/*
+ * Run an operation that is expected to fail because of an injected fault, checking that it reports a FAILED entry.
+ */
+func (s *SshTestSuite) expectFailed(op titan.Operation, err error) {
+	if s.E.NoError(err) {
+		w := endtoend.NewProgressWatcher()
+		s.Error(s.E.WatchOperation(s.Ctx, op.Id, w))
+		s.Equal(endtoend.ProgressFailed, w.State())
+		_, faulted := s.proxy.Connections()
+		s.NotZero(faulted)
 	}
 }
 
-func (s *SshTestSuite) TestSsh_053_ListCommitsNoPassword() {
-	_, _, err := s.e.RemoteApi.ListRemoteCommits(s.ctx, "foo", "origin", s.remoteParams, nil)
-	s.e.APIError(err, "IllegalArgumentException")
-}
-
-func (s *SshTestSuite) TestSsh_054_ListCommitsBadPassword() {
-	_, _, err := s.e.RemoteApi.ListRemoteCommits(s.ctx, "foo", "origin",
-		titan.RemoteParameters{
-			Provider:   "ssh",
-			Properties: map[string]interface{}{"password": "r00t"},
-		}, nil)
-	s.e.APIError(err, "CommandException")
-}
-
-func (s *SshTestSuite) TestSsh_060_CopyKey() {
-	key, err := ioutil.ReadFile("id_rsa.pub")
-	if s.e.NoError(err) {
-		err = s.e.WriteFileSsh("/home/test/.ssh/authorized_keys", string(key))
-		s.e.NoError(err)
+func (s *SshTestSuite) expectComplete(op titan.Operation, err error) {
+	if s.E.NoError(err) {
+		w := endtoend.NewProgressWatcher()
+		s.E.NoError(s.E.WatchOperation(s.Ctx, op.Id, w))
+		s.Empty(w.Violations())
 	}
 }
 
-func (s *SshTestSuite) TestSsh_061_ListCommitsKey() {
-	key, err := ioutil.ReadFile("id_rsa")
-	if s.e.NoError(err) {
-		res, _, err := s.e.RemoteApi.ListRemoteCommits(s.ctx, "foo", "origin", titan.RemoteParameters{
-			Provider:   "ssh",
-			Properties: map[string]interface{}{"key": string(key)},
-		}, nil)
-		if s.e.NoError(err) {
-			s.Len(res, 1)
-			s.Equal("id", res[0].Id)
+func (s *SshTestSuite) remoteCommitIds() []string {
+	res, _, err := s.E.RemoteApi.ListRemoteCommits(s.Ctx, "foo", "flaky", s.fixture.Parameters("flaky"), nil)
+	ret := []string{}
+	if s.E.NoError(err) {
+		for _, c := range res {
+			ret = append(ret, c.Id)
 		}
 	}
+	return ret
 }
 
-func (s *SshTestSuite) TestSsh_062_PullCommitKey() {
-	key, err := ioutil.ReadFile("id_rsa")
-	if s.e.NoError(err) {
-		_, err := s.e.CommitApi.DeleteCommit(s.ctx, "foo", "id")
-		if s.e.NoError(err) {
-			res, _, err := s.e.OperationsApi.Pull(s.ctx, "foo", "origin", "id", titan.RemoteParameters{
-				Provider:   "ssh",
-				Properties: map[string]interface{}{"key": string(key)},
-			}, nil)
-			if s.e.NoError(err) {
-				_, err = s.e.WaitForOperation(res.Id)
-				s.e.NoError(err)
-			}
+func (s *SshTestSuite) localCommitIds() []string {
+	res, _, err := s.E.CommitApi.ListCommits(s.Ctx, "foo", nil)
+	ret := []string{}
+	if s.E.NoError(err) {
+		for _, c := range res {
+			ret = append(ret, c.Id)
 		}
 	}
+	return ret
+}
+
+/*
+ * Cutting the connection partway through the transfer must fail the push without leaving a commit on the remote.
+ */
+func (s *SshTestSuite) pushCut() {
+	defer s.proxy.ClearFaults()
+	s.proxy.InjectFault(endtoend.ProxyCut(largeFileSize / 4))
+	s.expectFailed(s.E.Push(s.Ctx, "foo", "flaky", "large", s.fixture.Parameters("flaky"), nil))
+	s.NotContains(s.remoteCommitIds(), "large")
+}
+
+func (s *SshTestSuite) pushRetry() {
+	s.expectComplete(s.E.Push(s.Ctx, "foo", "flaky", "large", s.fixture.Parameters("flaky"), nil))
+	s.Contains(s.remoteCommitIds(), "large")
 }
 
-func (s *SshTestSuite) TestSsh_070_DeleteVolume() {
-	_, err := s.e.VolumeApi.DeactivateVolume(s.ctx, "foo", "vol")
-	if s.e.NoError(err) {
-		_, err = s.e.VolumeApi.DeleteVolume(s.ctx, "foo", "vol")
-		s.e.NoError(err)
+/*
+ * Likewise, a pull that is cut off must fail without creating the local commit, and can then be retried.
+ */
+func (s *SshTestSuite) pullCut() {
+	_, err := s.E.CommitApi.DeleteCommit(s.Ctx, "foo", "large")
+	if !s.E.NoError(err) {
+		return
 	}
+	defer s.proxy.ClearFaults()
+	s.proxy.InjectFault(endtoend.ProxyCut(largeFileSize / 4))
+	s.expectFailed(s.E.Pull(s.Ctx, "foo", "flaky", "large", s.fixture.Parameters("flaky"), nil))
+	s.NotContains(s.localCommitIds(), "large")
 }
 
-func (s *SshTestSuite) TestSsh_071_DeleteRepository() {
-	_, err := s.e.RepoApi.DeleteRepository(s.ctx, "foo")
-	s.e.NoError(err)
+/*
+ * A stall partway through is only a delay, so the retried pull should complete, just not quickly.
+ */
+func (s *SshTestSuite) pullStalled() {
+	defer s.proxy.ClearFaults()
+	s.proxy.InjectFault(endtoend.ProxyStall(largeFileSize/4, 5*time.Second))
+	start := time.Now()
+	s.expectComplete(s.E.Pull(s.Ctx, "foo", "flaky", "large", s.fixture.Parameters("flaky"), nil))
+	s.True(time.Since(start) >= 5*time.Second)
+	s.Contains(s.localCommitIds(), "large")
+}
+
+func (s *SshTestSuite) pushThrottled() {
+	_, err := s.E.CreateCommit(s.Ctx, "foo", titan.Commit{
+		Id:         "throttled",
+		Properties: map[string]interface{}{},
+	})
+	if !s.E.NoError(err) {
+		return
+	}
+	defer s.proxy.ClearFaults()
+	s.proxy.InjectFault(endtoend.ProxyThrottle(largeFileSize / 2))
+	s.expectComplete(s.E.Push(s.Ctx, "foo", "flaky", "throttled", s.fixture.Parameters("flaky"), nil))
+	s.Contains(s.remoteCommitIds(), "throttled")
 }